@@ -0,0 +1,124 @@
+package edb
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+)
+
+// ScanBookmark records the position of a table or index scan, so that a later
+// transaction (e.g. serving the next page of a web UI) can continue right
+// after the last returned row via ResumeScan. Bookmarks marshal to URL-safe
+// text, which is also what RawCursor.ResumeToken returns for use with
+// ScanOptions.ResumeAfter.
+//
+// A zero Key means the scan has not returned anything yet.
+type ScanBookmark struct {
+	Key       []byte // raw key of the last returned row
+	Reverse   bool
+	KeyFormat int // key encoding version the bookmark was made with
+}
+
+const bookmarkFlagReverse = 1
+
+// Bookmark returns a bookmark positioned at the current row, for use with
+// ResumeScan.
+func (c *RawTableCursor) Bookmark() ScanBookmark {
+	return ScanBookmark{
+		Key:       append([]byte(nil), c.k...),
+		Reverse:   c.reverse,
+		KeyFormat: currentKeyFormat,
+	}
+}
+
+// ResumeToken returns an opaque token for ScanOptions.ResumeAfter that
+// continues the scan after the current row. It is the text form of Bookmark,
+// so it can be put into a "next page" link as is.
+func (c *RawTableCursor) ResumeToken() []byte {
+	return c.Bookmark().token()
+}
+
+func (bm ScanBookmark) MarshalText() ([]byte, error) {
+	var flags uint64
+	if bm.Reverse {
+		flags |= bookmarkFlagReverse
+	}
+	var buf []byte
+	buf = appendUvarint(buf, uint64(bm.KeyFormat))
+	buf = appendUvarint(buf, flags)
+	buf = append(buf, bm.Key...)
+	return base64.RawURLEncoding.AppendEncode(nil, buf), nil
+}
+
+// UnmarshalText decodes a bookmark produced by MarshalText. Returns
+// ErrStaleBookmark if the bookmark was made with a different key format,
+// in which case its key would point to a wrong place.
+func (bm *ScanBookmark) UnmarshalText(text []byte) error {
+	buf, err := base64.RawURLEncoding.AppendDecode(nil, text)
+	if err != nil {
+		return fmt.Errorf("invalid scan bookmark: %w", err)
+	}
+	d := makeByteDecoder(buf)
+	format, err := d.Uvarinti()
+	if err != nil {
+		return fmt.Errorf("invalid scan bookmark: %w", err)
+	}
+	flags, err := d.Uvarint()
+	if err != nil {
+		return fmt.Errorf("invalid scan bookmark: %w", err)
+	}
+	key := buf[d.Off():]
+	if len(key) == 0 {
+		key = nil
+	}
+	*bm = ScanBookmark{
+		Key:       key,
+		Reverse:   flags&bookmarkFlagReverse != 0,
+		KeyFormat: format,
+	}
+	return bm.check()
+}
+
+func (bm ScanBookmark) token() []byte {
+	return must(bm.MarshalText())
+}
+
+// resumeKey decodes a token passed to ScanOptions.ResumeAfter into the raw
+// key to continue after.
+func resumeKey(token []byte) ([]byte, error) {
+	var bm ScanBookmark
+	if err := bm.UnmarshalText(token); err != nil {
+		return nil, err
+	}
+	return bm.Key, nil
+}
+
+func (bm ScanBookmark) check() error {
+	if bm.KeyFormat != currentKeyFormat {
+		return fmt.Errorf("scan bookmark uses key format %d, current format is %d: %w", bm.KeyFormat, currentKeyFormat, ErrStaleBookmark)
+	}
+	return nil
+}
+
+// ResumeScan returns options for a scan of tbl that continues after
+// the bookmarked row, in the same direction. A bookmark with no key
+// starts from the beginning (or the end, if reversed).
+func (bm ScanBookmark) ResumeScan(tbl *Table) (ScanOptions, error) {
+	if err := bm.check(); err != nil {
+		return ScanOptions{}, err
+	}
+	if bm.Key != nil {
+		keyVal := reflect.New(tbl.KeyType()).Elem()
+		if err := tbl.keyEnc.decodeVal(bm.Key, keyVal); err != nil {
+			return ScanOptions{}, fmt.Errorf("%s: invalid scan bookmark key: %w", tbl.Name(), err)
+		}
+	}
+	opt := FullScan()
+	if bm.Reverse {
+		opt = opt.Reversed()
+	}
+	if bm.Key != nil {
+		opt = opt.ResumeAfter(bm.token())
+	}
+	return opt, nil
+}
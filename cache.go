@@ -0,0 +1,176 @@
+package edb
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+)
+
+// Cache is an in-process read-through LRU cache of decoded table rows.
+//
+// Writes in any transaction of the database evict the affected keys once
+// the transaction commits, so rolled back or retried batch attempts never
+// invalidate anything. Only read-only transactions are served from the cache;
+// writable ones always read through to the database, so that they observe
+// their own uncommitted changes.
+//
+// Cached rows are shared between callers and must not be modified.
+type Cache struct {
+	db       *DB
+	capacity int
+
+	lock    sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     list.List
+	lastInv uint64 // ID of the last write tx that evicted something
+}
+
+type cacheKey struct {
+	table  *Table
+	keyRaw string
+}
+
+type cacheEntry struct {
+	key    cacheKey
+	rowVal reflect.Value
+}
+
+// NewCache creates a cache holding up to capacity rows of db, and registers
+// it to receive evictions from write transactions.
+func NewCache(db *DB, capacity int) *Cache {
+	if capacity <= 0 {
+		panic("cache capacity must be positive")
+	}
+	c := &Cache{
+		db:       db,
+		capacity: capacity,
+		entries:  make(map[cacheKey]*list.Element),
+	}
+	db.cachesLock.Lock()
+	db.caches = append(db.caches, c)
+	db.cachesLock.Unlock()
+	return c
+}
+
+func GetCached[Row any](c *Cache, txh Txish, key any) *Row {
+	tx := txh.DBTx()
+	tbl := tableOf[Row](tx)
+	rowVal := c.getVal(tx, tbl, reflect.ValueOf(key))
+	if !rowVal.IsValid() {
+		return nil
+	}
+	return rowVal.Interface().(*Row)
+}
+
+// Get returns the row with the given key, from the cache if possible,
+// or nil if the row does not exist.
+func (c *Cache) Get(txh Txish, tbl *Table, key any) any {
+	rowVal := c.getVal(txh.DBTx(), tbl, reflect.ValueOf(key))
+	if !rowVal.IsValid() {
+		return nil
+	}
+	return rowVal.Interface()
+}
+
+func (c *Cache) getVal(tx *Tx, tbl *Table, keyVal reflect.Value) reflect.Value {
+	if tx.db != c.db {
+		panic("cache used with a transaction of another database")
+	}
+	if tx.IsWritable() {
+		return must(tx.getRowValByKeyValOnly(tbl, keyVal))
+	}
+
+	keyVal = tbl.ensureCorrectKeyType(keyVal)
+	ck := cacheKey{tbl, string(tbl.EncodeKeyVal(keyVal))}
+	if rowVal, ok := c.lookup(ck); ok {
+		return rowVal
+	}
+
+	rowVal := must(tx.getRowValByKeyValOnly(tbl, keyVal))
+	if rowVal.IsValid() {
+		c.add(ck, rowVal, uint64(tx.btx.ID()))
+	}
+	return rowVal
+}
+
+func (tx *Tx) getRowValByKeyValOnly(tbl *Table, keyVal reflect.Value) (reflect.Value, error) {
+	rowVal, _, err := tx.getRowValByKeyVal(tbl, keyVal, true)
+	return rowVal, err
+}
+
+func (c *Cache) lookup(ck cacheKey) (reflect.Value, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if el := c.entries[ck]; el != nil {
+		c.lru.MoveToFront(el)
+		return el.Value.(*cacheEntry).rowVal, true
+	}
+	return reflect.Value{}, false
+}
+
+func (c *Cache) add(ck cacheKey, rowVal reflect.Value, txID uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// A read tx started before the latest eviction has committed might have
+	// read a stale row, so don't let it into the cache.
+	if txID < c.lastInv {
+		return
+	}
+	if el := c.entries[ck]; el != nil {
+		el.Value.(*cacheEntry).rowVal = rowVal
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[ck] = c.lru.PushFront(&cacheEntry{ck, rowVal})
+	for c.lru.Len() > c.capacity {
+		el := c.lru.Back()
+		delete(c.entries, el.Value.(*cacheEntry).key)
+		c.lru.Remove(el)
+	}
+}
+
+func (c *Cache) evict(keys []cacheKey, txID uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if txID > c.lastInv {
+		c.lastInv = txID
+	}
+	for _, ck := range keys {
+		if el := c.entries[ck]; el != nil {
+			delete(c.entries, ck)
+			c.lru.Remove(el)
+		}
+	}
+}
+
+// Len returns the number of cached rows.
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
+
+// Purge removes all cached rows.
+func (c *Cache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	clear(c.entries)
+	c.lru.Init()
+}
+
+// invalidateCached records that the given row has changed, evicting it from
+// all caches when the transaction commits.
+func (tx *Tx) invalidateCached(tbl *Table, keyRaw []byte) {
+	if len(tx.caches) == 0 {
+		return
+	}
+	if tx.cacheEvictions == nil {
+		caches, txID := tx.caches, uint64(tx.btx.ID())
+		tx.btx.OnCommit(func() {
+			for _, c := range caches {
+				c.evict(tx.cacheEvictions, txID)
+			}
+		})
+	}
+	tx.cacheEvictions = append(tx.cacheEvictions, cacheKey{tbl, string(keyRaw)})
+}
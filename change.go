@@ -1,6 +1,7 @@
 package edb
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
 )
@@ -71,6 +72,73 @@ func (chg *Change) OldRow() any {
 	return chg.oldRowVal.Interface()
 }
 
+type changeHandler struct {
+	opts map[*Table]ChangeFlags
+	f    func(tx *Tx, chg *Change)
+}
+
+// changeFlags returns the flags of all handlers interested in changes to
+// tbl, combined. A change includes everything any of the handlers asked for.
+func (tx *Tx) changeFlags(tbl *Table) ChangeFlags {
+	var flags ChangeFlags
+	if tx.db.changeHandler != nil {
+		flags |= tbl.changeFlags
+	}
+	for _, h := range tx.changeHandlers {
+		if f := tbl.changeFlags | h.opts[tbl]; f.Contains(ChangeFlagNotify) {
+			flags |= f
+		}
+	}
+	return flags
+}
+
+// notifyChange passes chg to Options.OnChange and the handlers added via
+// OnChange, skipping the ones not interested in its table.
+func (tx *Tx) notifyChange(chg *Change) {
+	tbl := chg.table
+	if f := tx.db.changeHandler; f != nil && tbl.changeFlags.Contains(ChangeFlagNotify) {
+		f(tx, chg)
+	}
+	for _, h := range tx.changeHandlers {
+		if (tbl.changeFlags | h.opts[tbl]).Contains(ChangeFlagNotify) {
+			h.f(tx, chg)
+		}
+	}
+}
+
+// BufferChanges makes the transaction record its changes, so that they can be
+// processed via CollectChanges once the write lock is released. opts are the
+// same as for OnChange; like OnChange, this does not affect other handlers.
+func (tx *Tx) BufferChanges(opts map[*Table]ChangeFlags) {
+	tx.OnChange(opts, (*Tx).bufferChange)
+}
+
+// CollectChanges returns the changes buffered since BufferChanges or
+// the previous CollectChanges call, in the order they were made, and clears
+// the buffer. It can be called after the transaction has been committed.
+//
+// Raw keys and keys are copied, so they stay valid after the transaction.
+// Rows are the same values that an OnChange handler would get.
+func (tx *Tx) CollectChanges() []Change {
+	changes := tx.changeBuf
+	tx.changeBuf = nil
+	return changes
+}
+
+func (tx *Tx) bufferChange(chg *Change) {
+	tx.changeBuf = append(tx.changeBuf, chg.clone())
+}
+
+func (chg *Change) clone() Change {
+	c := *chg
+	c.rawKey = bytes.Clone(chg.rawKey)
+	if chg.keyVal.IsValid() {
+		c.keyVal = reflect.New(chg.keyVal.Type()).Elem()
+		c.keyVal.Set(chg.keyVal)
+	}
+	return c
+}
+
 func (v ChangeFlags) Contains(f ChangeFlags) bool {
 	return (v & f) == f
 }
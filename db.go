@@ -2,6 +2,7 @@ package edb
 
 import (
 	"fmt"
+	"io"
 	"slices"
 	"strings"
 	"sync"
@@ -11,8 +12,6 @@ import (
 	"go.etcd.io/bbolt"
 )
 
-const debugTrackTxns = false
-
 type DB struct {
 	bdb     *bbolt.DB
 	schema  *Schema
@@ -20,20 +19,45 @@ type DB struct {
 	verbose bool
 	strict  bool
 
-	tableStates []*tableState
+	detectTxLeaks     bool
+	trackTxns         bool
+	maxSize           int64
+	now               func() time.Time
+	snapshotWarnAfter time.Duration
+
+	tableStates   []*tableState
+	changeHandler func(tx *Tx, chg *Change)
+
+	indexStatesLock sync.Mutex // guards indexState.Built once Open returns
+
+	caches     []*Cache
+	cachesLock sync.Mutex
 
 	lastSize           atomic.Int64
+	sizeWatches        atomic.Pointer[[]*sizeWatch]
+	sizeWatchesLock    sync.Mutex
 	ReaderCount        atomic.Int64
 	WriterCount        atomic.Int64
 	PendingWriterCount atomic.Int64
 	ReadCount          atomic.Uint64
 	WriteCount         atomic.Uint64
 
-	txns     []*Tx
+	// BatchRetries counts the reruns of successful write transactions. Bolt
+	// batches concurrent writes into a single transaction, and when one of
+	// them fails, reruns the rest; a high rate means write contention.
+	BatchRetries atomic.Uint64
+
+	txns     []*openTx
 	txnsLock sync.Mutex
 
 	closed  atomic.Bool
 	closeWG sync.WaitGroup
+
+	// bgLock guards stopping and bgWG.Add, so that no background goroutine
+	// starts once Close begins waiting for them.
+	bgLock   sync.Mutex
+	stopping bool
+	bgWG     sync.WaitGroup
 }
 
 type Options struct {
@@ -42,7 +66,59 @@ type Options struct {
 	IsTesting bool
 	MmapSize  int
 
+	// NoPersistentFreeList skips writing Bolt's freelist on every commit
+	// (as does IsTesting), which makes commits cheaper, but then the freelist
+	// is only written on Close, and the next startup after a crash has to
+	// rebuild it by scanning the whole file. Leave it off to keep the
+	// freelist written on every commit.
 	NoPersistentFreeList bool
+
+	// AutoUpgradeValueFormat starts a background UpgradeValueFormat after
+	// opening the database, so that rows written in an older value format
+	// are gradually converted to the latest one. Close stops it after the
+	// current batch.
+	AutoUpgradeValueFormat bool
+
+	// OnChange is the default change handler for all transactions, receiving
+	// changes to tables that declare TableBuilder.NotifyChanges.
+	OnChange func(tx *Tx, chg *Change)
+
+	// DetectTxLeaks captures the stack of every transaction started via
+	// BeginRead or BeginUpdate and logs it if the transaction gets garbage
+	// collected without being closed. Capturing stacks is not free, so this
+	// is meant for debugging.
+	DetectTxLeaks bool
+
+	// TrackTransactions keeps a list of open transactions, along with the
+	// stacks that started them, for DescribeOpenTxns. Useful to diagnose
+	// a stuck database; costs a stack capture and a mutex per transaction.
+	TrackTransactions bool
+
+	// MaxSize, if positive, caps the space used by the database: the size of
+	// the file minus the free pages Bolt can reuse. Puts that would go beyond
+	// it panic with ErrDatabaseFull, while deletes keep working, and the
+	// pages they free make room for later puts (Bolt never shrinks the file).
+	// The check is an estimate based on the size of the written data and on
+	// the free page count as of the last write transaction, and does not
+	// account for page overhead.
+	MaxSize int64
+
+	// Now returns the current time, defaults to time.Now. Tests can
+	// override it to control transaction start times, scan deadlines and
+	// the last seen times of tables. Operation timings always use the
+	// monotonic clock, so they stay meaningful under a fake Now.
+	Now func() time.Time
+
+	// SnapshotWarnAfter, if positive, makes snapshots opened via
+	// DB.Snapshot log a warning, with the stack that opened them, once they
+	// have been open for this long. Long-lived read transactions keep
+	// Bolt from reusing freed pages, so the database keeps growing.
+	SnapshotWarnAfter time.Duration
+
+	// MaxBatchDelay and MaxBatchSize override the Bolt defaults (10 ms and
+	// 1000) for batching concurrent write transactions, see bbolt.DB.Batch.
+	MaxBatchDelay time.Duration
+	MaxBatchSize  int
 }
 
 func Open(path string, schema *Schema, opt Options) (*DB, error) {
@@ -64,12 +140,21 @@ func Open(path string, schema *Schema, opt Options) (*DB, error) {
 	if opt.NoPersistentFreeList {
 		bopt.NoFreelistSync = true
 	}
+	if opt.Now == nil {
+		opt.Now = time.Now
+	}
 
 	start := time.Now()
 	bdb, err := bbolt.Open(path, 0666, bopt)
 	if err != nil {
 		return nil, fmt.Errorf("kvdb: %w", err)
 	}
+	if opt.MaxBatchDelay > 0 {
+		bdb.MaxBatchDelay = opt.MaxBatchDelay
+	}
+	if opt.MaxBatchSize > 0 {
+		bdb.MaxBatchSize = opt.MaxBatchSize
+	}
 	if elapsed := time.Since(start); elapsed >= 5*time.Millisecond {
 		if opt.Logf != nil {
 			opt.Logf("db: bbolt open took %d ms", elapsed.Milliseconds())
@@ -83,11 +168,19 @@ func Open(path string, schema *Schema, opt Options) (*DB, error) {
 		verbose:     opt.Verbose,
 		tableStates: make([]*tableState, len(schema.tables)),
 		strict:      opt.IsTesting,
+
+		detectTxLeaks:     opt.DetectTxLeaks,
+		trackTxns:         opt.TrackTransactions,
+		maxSize:           opt.MaxSize,
+		now:               opt.Now,
+		snapshotWarnAfter: opt.SnapshotWarnAfter,
+
+		changeHandler: opt.OnChange,
 	}
 	db.closeWG.Add(1)
 
 	db.Write(func(tx *Tx) {
-		now := time.Now()
+		now := db.now()
 		for i, tbl := range schema.tables {
 			db.tableStates[i] = prepareTable(tx, tbl, now)
 		}
@@ -105,9 +198,20 @@ func Open(path string, schema *Schema, opt Options) (*DB, error) {
 		}
 	})
 
+	if opt.AutoUpgradeValueFormat {
+		db.goBackground(func() {
+			db.UpgradeValueFormat()
+		})
+	}
+
 	return db, nil
 }
 
+// Now returns the current time according to Options.Now.
+func (db *DB) Now() time.Time {
+	return db.now()
+}
+
 func (db *DB) Bolt() *bbolt.DB {
 	return db.bdb
 }
@@ -116,9 +220,74 @@ func (db *DB) Size() int64 {
 	return db.lastSize.Load()
 }
 
+// Backup writes a consistent snapshot of the database to w and returns
+// the number of bytes written. The snapshot is taken within a read
+// transaction, so writes can proceed concurrently. The output is a complete
+// database file that can be opened with Open.
+func (db *DB) Backup(w io.Writer) (int64, error) {
+	var n int64
+	var err error
+	db.Read(func(tx *Tx) {
+		n, err = tx.btx.WriteTo(w)
+	})
+	return n, err
+}
+
+type sizeWatch struct {
+	threshold int64
+	f         func(size int64)
+	fired     atomic.Bool
+}
+
+// SizeWatch arranges for f to be called once the database size reaches
+// threshold, e.g. to alert before hitting Options.MaxSize. The size is
+// sampled whenever a transaction starts. f is called at most once, on
+// a separate goroutine, and Close waits for it to return; once Close has been
+// called, f is no longer called. If the database is already that large, f is
+// called right away.
+func (db *DB) SizeWatch(threshold int64, f func(size int64)) {
+	w := &sizeWatch{threshold: threshold, f: f}
+	db.sizeWatchesLock.Lock()
+	var watches []*sizeWatch
+	if p := db.sizeWatches.Load(); p != nil {
+		watches = append(watches, *p...)
+	}
+	watches = append(watches, w)
+	db.sizeWatches.Store(&watches)
+	db.sizeWatchesLock.Unlock()
+
+	db.updateSize(db.lastSize.Load())
+}
+
+func (db *DB) updateSize(size int64) {
+	db.lastSize.Store(size)
+	p := db.sizeWatches.Load()
+	if p == nil {
+		return
+	}
+	for _, w := range *p {
+		if size >= w.threshold && w.fired.CompareAndSwap(false, true) {
+			db.goBackground(func() {
+				w.f(size)
+			})
+		}
+	}
+}
+
 // Close is safe to call multiple times, but not concurrently.
+//
+// Close first asks background work (see Options.AutoUpgradeValueFormat and
+// SizeWatch) to stop and waits for it, so it can keep using the database
+// until then.
 func (db *DB) Close() {
-	if db.closed.CompareAndSwap(false, true) {
+	db.bgLock.Lock()
+	first := !db.stopping
+	db.stopping = true
+	db.bgLock.Unlock()
+
+	if first {
+		db.bgWG.Wait()
+		db.closed.Store(true)
 		db.doClose()
 	}
 	db.closeWG.Wait()
@@ -128,6 +297,29 @@ func (db *DB) IsClosed() bool {
 	return db.closed.Load()
 }
 
+// isStopping reports whether Close has been called; background work should
+// return when it is.
+func (db *DB) isStopping() bool {
+	db.bgLock.Lock()
+	defer db.bgLock.Unlock()
+	return db.stopping
+}
+
+// goBackground runs f on a new goroutine that Close waits for, unless Close
+// has already been called, in which case f is not run at all.
+func (db *DB) goBackground(f func()) {
+	db.bgLock.Lock()
+	defer db.bgLock.Unlock()
+	if db.stopping {
+		return
+	}
+	db.bgWG.Add(1)
+	go func() {
+		defer db.bgWG.Done()
+		f()
+	}()
+}
+
 func (db *DB) doClose() {
 	defer db.closeWG.Done()
 
@@ -145,19 +337,27 @@ func (db *DB) doClose() {
 	}
 }
 
-func (db *DB) addTx(tx *Tx) {
+// openTx describes a transaction tracked for DescribeOpenTxns. It does not
+// point back to the Tx, so that tracking does not keep leaked transactions
+// from being collected and reported by DetectTxLeaks.
+type openTx struct {
+	startTime time.Time
+	stack     []byte
+}
+
+func (db *DB) addTx(otx *openTx) {
 	db.txnsLock.Lock()
 	defer db.txnsLock.Unlock()
-	db.txns = append(db.txns, tx)
+	db.txns = append(db.txns, otx)
 }
 
-func (db *DB) removeTx(tx *Tx) {
+func (db *DB) removeTx(otx *openTx) {
 	db.txnsLock.Lock()
 	defer db.txnsLock.Unlock()
 
 	found := -1
 	for i, t := range db.txns {
-		if t == tx {
+		if t == otx {
 			found = i
 			break
 		}
@@ -172,8 +372,10 @@ func (db *DB) removeTx(tx *Tx) {
 	db.txns = db.txns[:n-1]
 }
 
+// DescribeOpenTxns lists the open transactions, oldest first, with the stacks
+// of those open for 100 ms or longer. Requires Options.TrackTransactions.
 func (db *DB) DescribeOpenTxns() string {
-	if !debugTrackTxns {
+	if !db.trackTxns {
 		return "OPEN TX TRACKING DISABLED"
 	}
 
@@ -185,11 +387,11 @@ func (db *DB) DescribeOpenTxns() string {
 		return "NO OPEN TRANSACTIONS"
 	}
 
-	slices.SortFunc(txns, func(a, b *Tx) int {
+	slices.SortFunc(txns, func(a, b *openTx) int {
 		return a.startTime.Compare(b.startTime)
 	})
 
-	now := time.Now()
+	now := db.now()
 
 	var buf strings.Builder
 	fmt.Fprintf(&buf, "%d OPEN TRANSACTIONS:\n", len(txns))
@@ -1,13 +1,29 @@
 package edb
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
 	"log/slog"
+	"math"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"go.etcd.io/bbolt"
 )
 
 type (
@@ -78,9 +94,13 @@ func TestDB(t *testing.T) {
 	})
 	db.Read(func(tx *Tx) {
 		deepEqual(t, Lookup[User](tx, usersByEmail, "foo@example.com"), u1)
+		deepEqual(t, ExistsBy[User](tx, usersByEmail, "foo@example.com"), true)
+		deepEqual(t, ExistsBy[User](tx, usersByEmail, "fox@example.com"), false)
 	})
 	db.Read(func(tx *Tx) {
 		deepEqual(t, Lookup[User](tx, usersByName, "foo"), u1)
+		deepEqual(t, ExistsBy[User](tx, usersByName, "foo"), true)
+		deepEqual(t, ExistsBy[User](tx, usersByName, "fo"), false)
 
 		isnil(t, Lookup[User](tx, usersByName, "fo"))
 		isnil(t, Lookup[User](tx, usersByName, "f"))
@@ -96,6 +116,30 @@ func TestDB(t *testing.T) {
 	})
 }
 
+func TestIndexExists(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 1, Name: "foo", Email: "foo@example.com"})
+		Put(tx, &User{ID: 2, Name: "foo", Email: "foo2@example.com"})
+		Put(tx, &User{ID: 3, Name: "bar", Email: "bar@example.com"})
+
+		// break the row data to make sure it is never decoded
+		dataBuck := usersTable.dataBucketIn(usersTable.rootBucketIn(tx.btx))
+		ensure(dataBuck.Put(usersTable.EncodeKey(ID(1)), []byte{0xFF}))
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, IndexExists[User](tx, usersByEmail, "foo@example.com"), true)
+		deepEqual(t, IndexExists[User](tx, usersByEmail, "bar@example.com"), true)
+		deepEqual(t, IndexExists[User](tx, usersByEmail, "fo@example.com"), false)
+		deepEqual(t, IndexExists[User](tx, usersByEmail, ""), false)
+
+		deepEqual(t, IndexExists[User](tx, usersByName, "foo"), true)
+		deepEqual(t, IndexExists[User](tx, usersByName, "bar"), true)
+		deepEqual(t, IndexExists[User](tx, usersByName, "fo"), false)
+		deepEqual(t, IndexExists[User](tx, usersByName, "fooo"), false)
+	})
+}
+
 func TestDBScan(t *testing.T) {
 	u1 := &User{ID: 1, Name: "foo", Email: "foo@example.com"}
 	u2 := &User{ID: 2, Name: "bubble", Email: "bubble@example.com"}
@@ -203,6 +247,74 @@ func TestDBScan(t *testing.T) {
 	})
 }
 
+func TestCursorReset(t *testing.T) {
+	u1 := &User{ID: 1, Name: "foo", Email: "foo@example.com"}
+	u2 := &User{ID: 2, Name: "bar", Email: "bar@example.com"}
+
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, u1)
+		Put(tx, u2)
+	})
+	db.Read(func(tx *Tx) {
+		c := TableScan[User](tx, FullScan().Reversed())
+		deepEqual(t, All(c), []*User{u2, u1})
+		c.Reset()
+		deepEqual(t, All(c), []*User{u2, u1})
+
+		c = IndexScan[User](tx, usersByName, FullScan())
+		isnonnil(t, First(c))
+		c.Reset()
+		deepEqual(t, All(c), []*User{u2, u1})
+	})
+}
+
+func TestTryErrors(t *testing.T) {
+	u1 := &User{ID: 1, Name: "foo", Email: "foo@example.com"}
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, u1)
+	})
+	db.Read(func(tx *Tx) {
+		_, err := TryTableScan[User](tx, ExactScan("foo"))
+		deepEqual(t, errors.Is(err, ErrWrongKeyType), true)
+
+		_, err = TryIndexScan[User](tx, usersByName, ExactScan(42))
+		deepEqual(t, errors.Is(err, ErrWrongKeyType), true)
+
+		_, err = TryIndexScan[User](tx, widgetsByCD, FullScan())
+		deepEqual(t, errors.Is(err, ErrIndexNotOnTable), true)
+
+		_, err = TryIndexScan[User](tx, AddIndex[string]("orphan"), FullScan())
+		deepEqual(t, errors.Is(err, ErrIndexNotOnTable), true)
+
+		_, err = TryTableScan[User](tx, ScanOptions{Method: ScanMethodExactIndexWithIDRange})
+		deepEqual(t, errors.Is(err, ErrUnsupportedScan), true)
+
+		_, _, err = tx.TryGet(usersTable, "foo")
+		deepEqual(t, errors.Is(err, ErrWrongKeyType), true)
+
+		row, err := TryGet[User](tx, "1")
+		isnil(t, row)
+		deepEqual(t, errors.Is(err, ErrKeyType), true)
+		_, err = TryGet[User](tx, nil)
+		deepEqual(t, errors.Is(err, ErrKeyType), true)
+		deepEqual(t, must(TryGet[User](tx, ID(1))), u1)
+		isnil(t, must(TryGet[User](tx, ID(2))))
+
+		row, err = TryLookup[User](tx, usersByEmail, ID(1))
+		isnil(t, row)
+		deepEqual(t, errors.Is(err, ErrKeyType), true)
+		_, err = TryLookup[User](tx, usersByName, nil)
+		deepEqual(t, errors.Is(err, ErrWrongKeyType), true)
+		_, err = TryLookup[User](tx, widgetsByCD, CD{1, 2})
+		deepEqual(t, errors.Is(err, ErrIndexNotOnTable), true)
+		deepEqual(t, must(TryLookup[User](tx, usersByEmail, "foo@example.com")), u1)
+		deepEqual(t, must(TryLookup[User](tx, usersByName, "foo")), u1)
+		isnil(t, must(TryLookup[User](tx, usersByName, "bar")))
+	})
+}
+
 func TestDBCompositeKey(t *testing.T) {
 	u1 := &Widget{Key: AB{1, 43}, Name: "foo", Email: "foo@example.com"}
 	u2 := &Widget{Key: AB{1, 42}, Name: "bubble", Email: "bubble@example.com"}
@@ -276,111 +388,3220 @@ func TestDBCompositeKey(t *testing.T) {
 	})
 }
 
-func TestDBReverseScanBug(t *testing.T) {
-	u3 := &User{ID: 3, Name: "bar", Email: "bar@example.com"}
-	u4 := &User{ID: 4, Name: "bar", Email: "bar2@example.com"}
-	u5 := &User{ID: 5, Name: "bar", Email: "bar3@example.com"}
+func TestDeletePrefix(t *testing.T) {
+	u1 := &Widget{Key: AB{1, 43}, Name: "foo", Email: "foo@example.com"}
+	u3 := &Widget{Key: AB{3, 11}, Name: "bar", Email: "bar@example.com"}
+	u4 := &Widget{Key: AB{2, 11}, Name: "bar", Email: "bar2@example.com"}
+	u5 := &Widget{Key: AB{2, 12}, Name: "bar", Email: "bar3@example.com"}
 
 	db := setup(t, basicSchema)
 	db.Write(func(tx *Tx) {
-		Put(tx, u3)
-		Put(tx, u4)
-		Put(tx, u5)
+		Put(tx, u1, u3, u4, u5)
+		deepEqual(t, DeletePrefix[Widget](tx, 1, AB{2, 0}), 2)
+		deepEqual(t, DeletePrefix[Widget](tx, 1, AB{4, 0}), 0)
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, All(TableScan[Widget](tx, FullScan())), []*Widget{u1, u3})
+		deepEqual(t, All(IndexScan[Widget](tx, widgetsByCD, FullScan())), []*Widget{u3, u1})
+		deepEqual(t, All(IndexScan[Widget](tx, widgetsByAB, FullScan())), []*Widget{u1, u3})
+	})
+}
+
+func TestRangeWithinPrefixIndexScan(t *testing.T) {
+	w1 := &Widget{Key: AB{1, 10}, Name: "foo"}
+	w2 := &Widget{Key: AB{1, 20}, Name: "bar"}
+	w3 := &Widget{Key: AB{2, 30}, Name: "boo"}
+	w4 := &Widget{Key: AB{2, 40}, Name: "bubble"}
+	w5 := &Widget{Key: AB{3, 5}, Name: "x"}
+
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, w1, w2, w3, w4, w5)
+	})
+	db.Read(func(tx *Tx) {
+		// by_CD is (len(Name), B)
+		deepEqual(t, All(ReverseRangeWithinPrefixIndexScan[Widget](tx, widgetsByCD, 1, CD{3, 0}, nil, nil, false, false)), []*Widget{w3, w2, w1})
+		deepEqual(t, All(RangeWithinPrefixIndexScan[Widget](tx, widgetsByCD, 1, CD{3, 0}, nil, nil, false, false)), []*Widget{w1, w2, w3})
+		deepEqual(t, All(ReverseRangeWithinPrefixIndexScan[Widget](tx, widgetsByCD, 1, CD{3, 0}, CD{3, 10}, CD{3, 30}, true, false)), []*Widget{w2, w1})
+		deepEqual(t, All(ReverseRangeWithinPrefixIndexScan[Widget](tx, widgetsByCD, 1, CD{3, 0}, CD{3, 10}, CD{3, 30}, false, true)), []*Widget{w3, w2})
+		deepEqual(t, All(ReverseRangeWithinPrefixIndexScan[Widget](tx, widgetsByCD, 1, CD{3, 0}, nil, CD{3, 25}, false, true)), []*Widget{w2, w1})
+		deepEqual(t, All(ReverseRangeWithinPrefixIndexScan[Widget](tx, widgetsByCD, 1, CD{3, 0}, CD{3, 15}, nil, true, false)), []*Widget{w3, w2})
+		deepEqual(t, All(RangeWithinPrefixIndexScan[Widget](tx, widgetsByCD, 1, CD{3, 0}, CD{3, 15}, nil, true, false)), []*Widget{w2, w3})
+		deepEqual(t, AllLimited(ReverseRangeWithinPrefixIndexScan[Widget](tx, widgetsByCD, 1, CD{3, 0}, nil, nil, false, false), 2), []*Widget{w3, w2})
+		isempty(t, All(ReverseRangeWithinPrefixIndexScan[Widget](tx, widgetsByCD, 1, CD{4, 0}, nil, nil, false, false)))
+		isempty(t, All(ReverseRangeWithinPrefixIndexScan[Widget](tx, widgetsByCD, 1, CD{3, 0}, CD{3, 31}, nil, true, false)))
+
+		// unique index
+		deepEqual(t, All(ReverseRangeWithinPrefixIndexScan[Widget](tx, widgetsByAB, 1, AB{2, 0}, nil, nil, false, false)), []*Widget{w4, w3})
+		deepEqual(t, All(ReverseRangeWithinPrefixIndexScan[Widget](tx, widgetsByAB, 1, AB{1, 0}, AB{1, 10}, AB{1, 20}, false, true)), []*Widget{w2})
 	})
+}
 
+func TestReloadCompositeKey(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Widget{Key: AB{1, 43}, Name: "foo", Email: "foo@example.com"})
+	})
+	w := &Widget{Key: AB{1, 43}, Name: "stale"}
 	db.Read(func(tx *Tx) {
-		rows := All(IndexScan[User](tx, usersByName, ExactScan("bar").Reversed()))
-		deepEqual(t, rows, []*User{u5, u4, u3})
+		deepEqual(t, Reload(tx, w).Name, "foo")
+		deepEqual(t, w.Name, "stale")
+
+		deepEqual(t, ReloadInto(tx, w), true)
+		deepEqual(t, w, &Widget{Key: AB{1, 43}, Name: "foo", Email: "foo@example.com"})
+	})
+	db.Write(func(tx *Tx) {
+		Put(tx, &Widget{Key: AB{1, 43}, Name: "bar"})
+	})
+	db.Read(func(tx *Tx) {
+		// fields missing from the stored row get cleared
+		deepEqual(t, ReloadInto(tx, w), true)
+		deepEqual(t, w, &Widget{Key: AB{1, 43}, Name: "bar"})
+
+		missing := &Widget{Key: AB{2, 43}, Name: "missing"}
+		isnil(t, Reload(tx, missing))
+		deepEqual(t, ReloadInto(tx, missing), false)
+		deepEqual(t, missing.Name, "missing")
 	})
 }
 
-func TestUpdateIndexValue(t *testing.T) {
-	u1 := &User{ID: 1, Name: "foo", Email: "foo@example.com"}
+func TestGetAtVersion(t *testing.T) {
+	type Note struct {
+		ID   ID     `msgpack:"-"`
+		Text string `msgpack:"t"`
+	}
+	notesSchema := func(ver uint64) (*Schema, *Table) {
+		scm := &Schema{}
+		tbl := DefineTable(scm, "notes", func(b *TableBuilder[Note, ID]) {
+			b.SetSchemaVersion(ver)
+			b.Migrate(func(tx *Tx, row *Note, oldVer uint64) {
+				row.Text = strings.ToUpper(row.Text)
+			})
+		})
+		return scm, tbl
+	}
+
+	scm, _ := notesSchema(1)
+	db := setup(t, scm)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Note{ID: 1, Text: "hello"})
+	})
+	path := db.Bolt().Path()
+	db.Close()
+
+	scm, tbl := notesSchema(2)
+	db = must(Open(path, scm, Options{IsTesting: true}))
+	defer db.Close()
+	db.Read(func(tx *Tx) {
+		row, meta := tx.GetAtVersion(tbl, ID(1), true)
+		deepEqual(t, row.(*Note), &Note{ID: 1, Text: "hello"})
+		deepEqual(t, meta.SchemaVer, uint64(1))
+
+		row, meta = tx.GetAtVersion(tbl, ID(1), false)
+		deepEqual(t, row.(*Note), &Note{ID: 1, Text: "HELLO"})
+		deepEqual(t, meta.SchemaVer, uint64(1))
+
+		row, _ = tx.GetAtVersion(tbl, ID(2), true)
+		deepEqual(t, row, nil)
+	})
+}
+
+func TestEmptyIndexKey(t *testing.T) {
+	scm := &Schema{}
+	byEntity := AddIndex[string]("entity")
+	byEntityUnique := AddIndex[string]("entity_u").Unique()
+	AddTable(scm, "events", 1, func(row *Event, ib *IndexBuilder) {
+		ib.Add(byEntity, row.Entity)
+		ib.Add(byEntityUnique, row.Entity)
+	}, nil, []*Index{byEntity, byEntityUnique})
+	db := setup(t, scm)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Event{ID: 1, Entity: ""})
+		Put(tx, &Event{ID: 2, Entity: "a"})
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, Lookup[Event](tx, byEntity, "").ID, ID(1))
+		deepEqual(t, Lookup[Event](tx, byEntityUnique, "").ID, ID(1))
+		deepEqual(t, len(All(ExactIndexScan[Event](tx, byEntity, ""))), 1)
+		deepEqual(t, Lookup[Event](tx, byEntity, "a").ID, ID(2))
+		deepEqual(t, len(All(FullIndexScan[Event](tx, byEntity))), 2)
+	})
+}
 
+func TestTableRangeScan(t *testing.T) {
+	var users []*User
 	db := setup(t, basicSchema)
 	db.Write(func(tx *Tx) {
-		Put(tx, u1)
-		isnonnil(t, Lookup[User](tx, usersByName, "foo"))
-		isnil(t, Lookup[User](tx, usersByName, "bar"))
+		for i := 1; i <= 5; i++ {
+			u := &User{ID: ID(i * 10), Name: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)}
+			Put(tx, u)
+			users = append(users, u)
+		}
+		Put(tx, &Widget{Key: AB{1, 1}, Name: "a"}, &Widget{Key: AB{1, 2}, Name: "b"}, &Widget{Key: AB{2, 1}, Name: "c"})
+	})
+	u10, u20, u30, u40, u50 := users[0], users[1], users[2], users[3], users[4]
 
-		u1.Name = "bar"
-		Put(tx, u1)
-		isnonnil(t, Lookup[User](tx, usersByName, "bar"))
-		isnil(t, Lookup[User](tx, usersByName, "foo"))
+	db.Read(func(tx *Tx) {
+		deepEqual(t, All(RangeTableScan[User](tx, ID(20), ID(40), true, true)), []*User{u20, u30, u40})
+		deepEqual(t, All(RangeTableScan[User](tx, ID(20), ID(40), false, false)), []*User{u30})
+		deepEqual(t, All(RangeTableScan[User](tx, ID(15), ID(45), false, false)), []*User{u20, u30, u40})
+
+		deepEqual(t, All(ReverseRangeTableScan[User](tx, ID(20), ID(40), true, true)), []*User{u40, u30, u20})
+		deepEqual(t, All(ReverseRangeTableScan[User](tx, ID(20), ID(40), false, false)), []*User{u30})
+		deepEqual(t, All(ReverseRangeTableScan[User](tx, ID(20), ID(40), true, false)), []*User{u30, u20})
+		deepEqual(t, All(ReverseRangeTableScan[User](tx, ID(20), ID(40), false, true)), []*User{u40, u30})
+		deepEqual(t, All(ReverseRangeTableScan[User](tx, ID(15), ID(45), true, true)), []*User{u40, u30, u20})
+		deepEqual(t, All(ReverseRangeTableScan[User](tx, nil, ID(30), false, true)), []*User{u30, u20, u10})
+		deepEqual(t, All(ReverseRangeTableScan[User](tx, ID(30), ID(99), true, true)), []*User{u50, u40, u30})
+		deepEqual(t, All(ReverseRangeTableScan[User](tx, ID(30), ID(50), true, false)), []*User{u40, u30})
+		isempty(t, All(ReverseRangeTableScan[User](tx, nil, ID(5), true, true)))
+		isempty(t, All(ReverseRangeTableScan[User](tx, ID(30), ID(30), false, true)))
+
+		keys := func(c Cursor[Widget]) []AB {
+			var result []AB
+			for _, w := range All(c) {
+				result = append(result, w.Key)
+			}
+			return result
+		}
+		deepEqual(t, keys(TableScan[Widget](tx, ExactScan(AB{1, 0}).Prefix(1).Reversed())), []AB{{1, 2}, {1, 1}})
+		deepEqual(t, keys(TableScan[Widget](tx, ExactScan(AB{2, 0}).Prefix(1).Reversed())), []AB{{2, 1}})
+
+		deepEqual(t, keys(PrefixTableScan[Widget](tx, 1, AB{1, 0})), []AB{{1, 1}, {1, 2}})
+		deepEqual(t, keys(ReversePrefixTableScan[Widget](tx, 1, AB{1, 0})), []AB{{1, 2}, {1, 1}})
+		deepEqual(t, keys(PrefixTableScan[Widget](tx, 1, AB{2, 99})), []AB{{2, 1}})
+		isempty(t, keys(PrefixTableScan[Widget](tx, 1, AB{3, 0})))
+		deepEqual(t, keys(PrefixTableScan[Widget](tx, 2, AB{1, 2})), []AB{{1, 2}})
 	})
 }
 
-func TestRawScan(t *testing.T) {
-	var (
-		kb = x("10 12 14 40 44 47")
-		k1 = x("10 12 14 40 44 48")
-		k2 = x("10 12 14 40 44 49")
-		k3 = x("10 12 14 40 44 50")
-		k4 = x("10 12 14 40 44 51")
-		ke = x("10 12 14 40 44 52")
-		p  = x("10 12 14")
-	)
+func TestScanBookmark(t *testing.T) {
 	db := setup(t, basicSchema)
 	db.Write(func(tx *Tx) {
-		tx.KVPutRaw(kubets, k1, []byte{})
-		tx.KVPutRaw(kubets, k2, []byte{})
-		tx.KVPutRaw(kubets, k3, []byte{})
-		tx.KVPutRaw(kubets, k4, []byte{})
+		for i := 1; i <= 5; i++ {
+			Put(tx, &User{ID: ID(i), Name: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)})
+		}
 	})
 
-	o := func(name string, rang RawRange, exp ...[]byte) {
-		t.Helper()
-		t.Run(name, func(t *testing.T) {
+	paginate := func(start ScanBookmark) []ID {
+		var ids []ID
+		token := string(must(start.MarshalText()))
+		for range 10 {
+			var bm ScanBookmark
+			ensure(bm.UnmarshalText([]byte(token)))
+			var done bool
 			db.Read(func(tx *Tx) {
-				slog.Debug(t.Name())
-				tableScan(t, tx, kubets, rang, exp...)
+				opt := must(bm.ResumeScan(usersTable))
+				opt.Limit = 2
+				c := tx.TableScan(usersTable, opt)
+				var n int
+				for c.Next() {
+					ids = append(ids, c.Key().(ID))
+					bm = c.Bookmark()
+					n++
+				}
+				done = n < 2
+			})
+			if done {
+				break
+			}
+			token = string(must(bm.MarshalText()))
+		}
+		return ids
+	}
+	deepEqual(t, paginate(ScanBookmark{KeyFormat: currentKeyFormat}), []ID{1, 2, 3, 4, 5})
+	deepEqual(t, paginate(ScanBookmark{KeyFormat: currentKeyFormat, Reverse: true}), []ID{5, 4, 3, 2, 1})
+
+	stale := string(must(ScanBookmark{Key: []byte{1}, KeyFormat: 0}.MarshalText()))
+	var bm ScanBookmark
+	if err := bm.UnmarshalText([]byte(stale)); !errors.Is(err, ErrStaleBookmark) {
+		t.Errorf("expected ErrStaleBookmark, got %v", err)
+	}
+	if err := bm.UnmarshalText([]byte("!!!")); err == nil {
+		t.Error("expected invalid bookmark to fail")
+	}
+}
+
+func TestResumeToken(t *testing.T) {
+	scm, byEntity := eventSchema(1)
+	scm2, byEntity2 := eventSchema(3)
+	for _, tc := range []struct {
+		scm *Schema
+		idx *Index
+	}{{scm, byEntity}, {scm2, byEntity2}} {
+		db := setup(t, tc.scm)
+		tbl := tc.scm.TableByRow(&Event{})
+		db.Write(func(tx *Tx) {
+			for i := 1; i <= 9; i++ {
+				Put(tx, &Event{ID: ID(i * 10), Entity: string(rune('a' + i%3))})
+			}
+		})
+
+		// pages through the scan two rows at a time, calling between after
+		// each page, and returns the IDs of the visited rows
+		paginate := func(opt ScanOptions, index bool, between func(page int)) []ID {
+			var ids []ID
+			var token []byte
+			for page := 0; page < 20; page++ {
+				var n int
+				db.Read(func(tx *Tx) {
+					opt := opt.ResumeAfter(token)
+					opt.Limit = 2
+					var c RawCursor
+					if index {
+						c = tx.IndexScan(tc.idx, opt)
+					} else {
+						c = tx.TableScan(tbl, opt)
+					}
+					for c.Next() {
+						ids = append(ids, c.Key().(ID))
+						token = c.ResumeToken()
+						n++
+					}
+				})
+				if n < 2 {
+					break
+				}
+				if between != nil {
+					between(page)
+				}
+			}
+			return ids
+		}
+
+		// a: 30 60 90, b: 10 40 70, c: 20 50 80
+		deepEqual(t, paginate(FullScan(), true, nil), []ID{30, 60, 90, 10, 40, 70, 20, 50, 80})
+		deepEqual(t, paginate(FullScan().Reversed(), true, nil), []ID{80, 50, 20, 70, 40, 10, 90, 60, 30})
+		deepEqual(t, paginate(ExactScan("b"), true, nil), []ID{10, 40, 70})
+		deepEqual(t, paginate(ExactScan("b").Reversed(), true, nil), []ID{70, 40, 10})
+		deepEqual(t, paginate(RangeScan("b", "c", true, false), true, nil), []ID{10, 40, 70})
+		deepEqual(t, paginate(FullScan(), false, nil), []ID{10, 20, 30, 40, 50, 60, 70, 80, 90})
+		deepEqual(t, paginate(FullScan().Reversed(), false, nil), []ID{90, 80, 70, 60, 50, 40, 30, 20, 10})
+		deepEqual(t, paginate(RangeScan(ID(25), ID(75), true, true), false, nil), []ID{30, 40, 50, 60, 70})
+
+		// rows inserted or deleted before the current position don't shift pages,
+		// and deleting the row a token points to does not lose the position
+		mutate := func(page int) {
+			db.Write(func(tx *Tx) {
+				switch page {
+				case 0:
+					Put(tx, &Event{ID: 5, Entity: "a"})
+					DeleteByKey[Event](tx, ID(60))
+				case 1:
+					DeleteByKey[Event](tx, ID(40))
+					Put(tx, &Event{ID: 45, Entity: "b"})
+				}
 			})
+		}
+		deepEqual(t, paginate(FullScan(), true, mutate), []ID{30, 60, 90, 10, 45, 70, 20, 50, 80})
+
+		db.Read(func(tx *Tx) {
+			stale := FullScan().ResumeAfter(must(ScanBookmark{Key: []byte{1}, KeyFormat: 0}.MarshalText()))
+			if _, err := tx.TryTableScan(tbl, stale); !errors.Is(err, ErrStaleBookmark) {
+				t.Errorf("expected ErrStaleBookmark, got %v", err)
+			}
+			if _, err := tx.TryIndexScan(tc.idx, FullScan().ResumeAfter([]byte("!!!"))); err == nil {
+				t.Error("expected invalid resume token to fail")
+			}
+		})
+	}
+}
+
+func TestBoolKeys(t *testing.T) {
+	type FlagKey struct {
+		Active bool
+		N      int
+	}
+	type Flag struct {
+		Key  FlagKey `msgpack:"-"`
+		Name string  `msgpack:"n"`
+	}
+	type ActiveName struct {
+		Active bool
+		Name   string
+	}
+	scm := &Schema{}
+	flagsByActive := AddIndex[ActiveName]("active")
+	flags := DefineTable(scm, "flags", func(b *TableBuilder[Flag, FlagKey]) {
+		b.AddIndex(flagsByActive)
+		b.Indexer(func(row *Flag, ib *IndexBuilder) {
+			ib.Add(flagsByActive, ActiveName{row.Key.Active, row.Name})
 		})
+	})
+	db := setup(t, scm)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Flag{Key: FlagKey{true, 1}, Name: "a"})
+		Put(tx, &Flag{Key: FlagKey{false, 2}, Name: "b"})
+		Put(tx, &Flag{Key: FlagKey{true, 3}, Name: "c"})
+		Put(tx, &Flag{Key: FlagKey{false, 4}, Name: "d"})
+	})
+	db.Read(func(tx *Tx) {
+		names := func(c Cursor[Flag]) string {
+			var result []string
+			for _, row := range All(c) {
+				result = append(result, row.Name)
+			}
+			return strings.Join(result, ",")
+		}
+		deepEqual(t, names(FullTableScan[Flag](tx)), "b,d,a,c")
+		deepEqual(t, names(FullIndexScan[Flag](tx, flagsByActive)), "b,d,a,c")
+		deepEqual(t, names(PrefixIndexScan[Flag](tx, flagsByActive, 1, ActiveName{Active: true})), "a,c")
+		deepEqual(t, names(PrefixIndexScan[Flag](tx, flagsByActive, 1, ActiveName{Active: false})), "b,d")
+	})
+
+	s := flags.KeyString(FlagKey{true, 3})
+	deepEqual(t, s, "true|3")
+	deepEqual(t, must(flags.ParseKey(s)), any(FlagKey{true, 3}))
+	if _, err := flags.ParseKey("maybe|3"); err == nil {
+		t.Error("expected ParseKey to fail on invalid bool")
 	}
+}
 
-	o("prefix", RawRange{Prefix: p}, k1, k2, k3, k4)
-	o("prefix reverse", RawRange{Prefix: p, Reverse: true}, k4, k3, k2, k1)
+func TestKeyStringSeparator(t *testing.T) {
+	type Note struct {
+		Key struct {
+			Folder string
+			N      int
+		} `msgpack:"-"`
+	}
+	scm := &Schema{}
+	widgets := DefineTable(scm, "widgets", func(b *TableBuilder[Widget, AB]) {
+		b.KeyStringSeparator("::")
+	})
+	notes := DefineTable(scm, "notes", func(b *TableBuilder[Note, any]) {
+		b.KeyStringSeparator(":")
+	})
 
-	o("prefix + lower inc", RawRange{Prefix: p, Lower: k2, LowerInc: true}, k2, k3, k4)
-	o("prefix + lower exc", RawRange{Prefix: p, Lower: k2, LowerInc: false}, k3, k4)
-	o("prefix + lower inc reverse", RawRange{Prefix: p, Lower: k2, LowerInc: true, Reverse: true}, k4, k3, k2)
-	o("prefix + lower exc reverse", RawRange{Prefix: p, Lower: k2, LowerInc: false, Reverse: true}, k4, k3)
-	o("prefix + upper inc", RawRange{Prefix: p, Upper: k3, UpperInc: true}, k1, k2, k3)
-	o("prefix + upper exc", RawRange{Prefix: p, Upper: k3, UpperInc: false}, k1, k2)
-	o("prefix + upper inc reverse", RawRange{Prefix: p, Upper: k3, UpperInc: true, Reverse: true}, k3, k2, k1)
-	o("prefix + upper exc reverse", RawRange{Prefix: p, Upper: k3, UpperInc: false, Reverse: true}, k2, k1)
+	deepEqual(t, widgets.KeyString(AB{1, 43}), "1::43")
+	deepEqual(t, must(widgets.ParseKey("1::43")), any(AB{1, 43}))
+	if _, err := widgets.ParseKey("1|43"); err == nil {
+		t.Error("expected ParseKey to fail on the default separator")
+	}
+	if _, err := widgets.ParseKey("1::2::3"); err == nil {
+		t.Error("expected ParseKey to fail on extra components")
+	}
 
-	o("lower inc", RawRange{Lower: k2, LowerInc: true}, k2, k3, k4)
-	o("lower exc", RawRange{Lower: k2, LowerInc: false}, k3, k4)
-	o("lower inc reverse", RawRange{Lower: k2, LowerInc: true, Reverse: true}, k4, k3, k2)
-	o("lower exc reverse", RawRange{Lower: k2, LowerInc: false, Reverse: true}, k4, k3)
+	var key Note
+	key.Key.Folder, key.Key.N = "inbox", 3
+	deepEqual(t, notes.KeyString(key.Key), "inbox:3")
+	deepEqual(t, must(notes.ParseKey("inbox:3")), any(key.Key))
 
-	o("upper inc", RawRange{Upper: k3, UpperInc: true}, k1, k2, k3)
-	o("upper exc", RawRange{Upper: k3, UpperInc: false}, k1, k2)
-	o("upper inc reverse", RawRange{Upper: k3, UpperInc: true, Reverse: true}, k3, k2, k1)
-	o("upper exc reverse", RawRange{Upper: k3, UpperInc: false, Reverse: true}, k2, k1)
+	key.Key.Folder = "a:b"
+	s := notes.KeyString(key.Key)
+	deepEqual(t, s, "a:b:3")
+	if _, err := notes.ParseKey(s); err == nil {
+		t.Error("expected ParseKey to fail on a component containing the separator")
+	}
+}
 
-	o("first lower inc", RawRange{Lower: kb, LowerInc: true}, k1, k2, k3, k4)
-	o("first lower exc", RawRange{Lower: kb, LowerInc: false}, k1, k2, k3, k4)
-	o("first lower inc reverse", RawRange{Lower: kb, LowerInc: true, Reverse: true}, k4, k3, k2, k1)
-	o("first lower exc reverse", RawRange{Lower: kb, LowerInc: false, Reverse: true}, k4, k3, k2, k1)
+func TestFloatKeys(t *testing.T) {
+	type Reading struct {
+		ID    ID      `msgpack:"-"`
+		Value float64 `msgpack:"v"`
+	}
+	scm := &Schema{}
+	readingsByValue := AddIndex[float64]("value")
+	DefineTable(scm, "readings", func(b *TableBuilder[Reading, ID]) {
+		b.AddIndex(readingsByValue)
+		b.Indexer(func(row *Reading, ib *IndexBuilder) {
+			ib.Add(readingsByValue, row.Value)
+		})
+	})
+	db := setup(t, scm)
+	values := []float64{2.5, -1, 0, math.Inf(-1), -0.25, 1e10, 0.125, -1e10, math.Inf(1)}
+	db.Write(func(tx *Tx) {
+		for i, v := range values {
+			Put(tx, &Reading{ID: ID(i + 1), Value: v})
+		}
+	})
+	db.Read(func(tx *Tx) {
+		scanned := func(c Cursor[Reading]) []float64 {
+			var result []float64
+			for _, row := range All(c) {
+				result = append(result, row.Value)
+			}
+			return result
+		}
+		deepEqual(t, scanned(FullIndexScan[Reading](tx, readingsByValue)), []float64{math.Inf(-1), -1e10, -1, -0.25, 0, 0.125, 2.5, 1e10, math.Inf(1)})
+		deepEqual(t, scanned(RangeIndexScan[Reading](tx, readingsByValue, -1.0, 2.5, true, false)), []float64{-1, -0.25, 0, 0.125})
+		deepEqual(t, scanned(RangeIndexScan[Reading](tx, readingsByValue, -0.5, 0.5, false, false)), []float64{-0.25, 0, 0.125})
+	})
 
-	o("last upper inc", RawRange{Upper: ke, UpperInc: true}, k1, k2, k3, k4)
-	o("last upper exc", RawRange{Upper: ke, UpperInc: false}, k1, k2, k3, k4)
-	o("last upper inc reverse", RawRange{Upper: ke, UpperInc: true, Reverse: true}, k4, k3, k2, k1)
-	o("last upper exc reverse", RawRange{Upper: ke, UpperInc: false, Reverse: true}, k4, k3, k2, k1)
+	// -0 and NaNs with any payload or sign encode canonically
+	enc := func(f float64) []byte { return readingsByValue.keyEnc.encode(nil, reflect.ValueOf(f)) }
+	deepEqual(t, enc(math.Copysign(0, -1)), enc(0))
+	negNaN := math.Float64frombits(math.Float64bits(math.NaN()) | 1<<63 | 2)
+	deepEqual(t, enc(negNaN), enc(math.NaN()))
+	if bytes.Compare(enc(math.NaN()), enc(math.Inf(1))) <= 0 {
+		t.Error("expected NaN to sort after +Inf")
+	}
+
+	raw := readingsByValue.keyEnc.encode(nil, reflect.ValueOf(-0.25))
+	s := readingsByValue.keyTupleToString(must(decodeTuple(raw)))
+	deepEqual(t, s, "-0.25")
+	deepEqual(t, must(readingsByValue.ParseNakedIndexKey(s)), any(-0.25))
+	if _, err := readingsByValue.ParseNakedIndexKey("abc"); err == nil {
+		t.Error("expected ParseNakedIndexKey to fail on invalid float")
+	}
 }
 
-func setup(t testing.TB, schema *Schema) *DB {
-	t.Helper()
+func TestIndexEntriesForKey(t *testing.T) {
+	u1 := &Widget{Key: AB{1, 43}, Name: "foo", Email: "foo@example.com"}
 
-	dbFile := must(os.CreateTemp("", "db_test_*.db"))
-	t.Logf("DB: %s", dbFile.Name())
-	dbFile.Close()
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, u1)
+	})
+	db.Read(func(tx *Tx) {
+		entries := tx.IndexEntriesForKey(widgetsTable, AB{1, 43})
+		deepEqual(t, len(entries), 2)
+		deepEqual(t, entries[0].Index, widgetsByCD)
+		deepEqual[any](t, entries[0].Key, CD{3, 43})
+		deepEqual(t, entries[1].Index, widgetsByAB)
+		deepEqual[any](t, entries[1].Key, AB{1, 43})
+		isempty(t, tx.IndexEntriesForKey(widgetsTable, AB{2, 43}))
+	})
+}
 
-	db := must(Open(dbFile.Name(), schema, Options{
-		IsTesting: true,
-	}))
+func TestDecodeIndexKeyBytes(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Widget{Key: AB{1, 43}, Name: "foo", Email: "foo@example.com"})
+	})
+	db.Read(func(tx *Tx) {
+		entries := tx.IndexEntriesForKey(widgetsTable, AB{1, 43})
+		deepEqual(t, len(entries), 2)
+
+		key, err := DecodeIndexKeyBytes(reflect.TypeOf(CD{}), entries[0].KeyRaw, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deepEqual[any](t, key, CD{3, 43})
+
+		key, err = DecodeIndexKeyBytes(reflect.TypeOf(AB{}), entries[1].KeyRaw, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deepEqual[any](t, key, AB{1, 43})
+
+		_, err = DecodeIndexKeyBytes(reflect.TypeOf(AB{}), entries[0].KeyRaw, true)
+		deepEqual(t, err != nil, true)
+	})
+}
+
+func TestPendingIndexes(t *testing.T) {
+	db := setup(t, basicSchema)
+	isempty(t, db.PendingIndexes())
+
+	db.tableState(widgetsTable).indexStates[1].Built = false
+	deepEqual(t, db.PendingIndexes(), []*Index{widgetsByAB})
+
+	// safe to poll while another goroutine reindexes (run with -race)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for len(db.PendingIndexes()) > 0 {
+			runtime.Gosched()
+		}
+	}()
+	db.Write(func(tx *Tx) {
+		tx.Reindex(widgetsTable, widgetsByAB)
+	})
+	<-done
+	isempty(t, db.PendingIndexes())
+}
+
+func TestRenameField(t *testing.T) {
+	type NoteV1 struct {
+		ID    ID     `msgpack:"-"`
+		Title string `msgpack:"t"`
+	}
+	type Note struct {
+		ID    ID     `msgpack:"-"`
+		Title string `msgpack:"title"`
+		Body  string `msgpack:"body"`
+	}
+	scm1 := &Schema{}
+	DefineTable(scm1, "notes", func(b *TableBuilder[NoteV1, ID]) {})
+	scm2 := &Schema{}
+	notes := DefineTable(scm2, "notes", func(b *TableBuilder[Note, ID]) {
+		b.SetSchemaVersion(2)
+		b.RenameField("t", "title", 2)
+	})
+
+	db := setup(t, scm1)
+	db.Write(func(tx *Tx) {
+		Put(tx, &NoteV1{ID: 1, Title: "hello"})
+		Put(tx, &NoteV1{ID: 2, Title: "world"})
+	})
+
+	path := db.Bolt().Path()
+	db.Close()
+	db = must(Open(path, scm2, Options{IsTesting: true}))
+	db.Write(func(tx *Tx) {
+		deepEqual(t, Get[Note](tx, ID(1)), &Note{ID: 1, Title: "hello"})
+		Put(tx, &Note{ID: 2, Title: "world", Body: "rewritten"})
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, Get[Note](tx, ID(1)), &Note{ID: 1, Title: "hello"})
+
+		// the rewritten row is stored at the latest version under the new tag
+		row, meta := tx.GetAtVersion(notes, ID(2), true)
+		deepEqual(t, meta.SchemaVer, uint64(2))
+		deepEqual(t, row, any(&Note{ID: 2, Title: "world", Body: "rewritten"}))
+	})
+
+	// renames chain across versions
+	scm3 := &Schema{}
+	DefineTable(scm3, "notes", func(b *TableBuilder[Note, ID]) {
+		b.SetSchemaVersion(3)
+		b.RenameField("title", "body", 3)
+		b.RenameField("t", "title", 2)
+	})
+	db.Close()
+	db = must(Open(path, scm3, Options{IsTesting: true}))
+	defer db.Close()
+	db.Read(func(tx *Tx) {
+		deepEqual(t, Get[Note](tx, ID(1)), &Note{ID: 1, Body: "hello"})
+		deepEqual(t, Get[Note](tx, ID(2)), &Note{ID: 2, Body: "rewritten"})
+	})
+
+	// the upgrade pass rewrites renamed rows at the latest version
+	deepEqual(t, db.UpgradeValueFormat(), 2)
+	deepEqual(t, db.UpgradeValueFormat(), 0)
+	db.Read(func(tx *Tx) {
+		notes := scm3.TableByRow(&Note{})
+		for _, id := range []ID{1, 2} {
+			row, meta := tx.GetAtVersion(notes, id, true)
+			deepEqual(t, meta.SchemaVer, uint64(3))
+			deepEqual(t, row, any(Get[Note](tx, id)))
+		}
+		deepEqual(t, Get[Note](tx, ID(1)), &Note{ID: 1, Body: "hello"})
+	})
+}
+
+func TestReindexWithProgress(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= 2500; i++ {
+			Put(tx, &User{ID: ID(i), Name: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)})
+		}
+	})
+
+	// lose all email entries and one name entry
+	db.Write(func(tx *Tx) {
+		rootB := usersTable.rootBucketIn(tx.btx)
+		ensure(rootB.DeleteBucket(usersByEmail.shardBucketName(0).Raw()))
+		must(rootB.CreateBucket(usersByEmail.shardBucketName(0).Raw()))
+		c := usersByName.shardBucketIn(rootB, 0).Cursor()
+		c.First()
+		ensure(c.Delete())
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, tx.IndexKeyCount(usersByEmail), 0)
+		deepEqual(t, tx.IndexKeyCount(usersByName), 2499)
+	})
+
+	db.Write(func(tx *Tx) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		tx.SetContext(ctx)
+		err := tx.ReindexWithProgress(usersTable, usersByEmail, nil)
+		deepEqual(t, err, context.Canceled)
+		tx.SetContext(context.Background())
+
+		var reported []int64
+		ensure(tx.ReindexWithProgress(usersTable, usersByEmail, func(done int64) {
+			reported = append(reported, done)
+		}))
+		deepEqual(t, reported, []int64{1000, 2000, 2500})
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, tx.IndexKeyCount(usersByEmail), 2500)
+		deepEqual(t, tx.IndexKeyCount(usersByName), 2499)
+		deepEqual(t, Lookup[User](tx, usersByEmail, "u42@example.com").ID, ID(42))
+		isempty(t, tx.FindUniqueViolations(usersByEmail))
+	})
+
+	db.Write(func(tx *Tx) {
+		if err := tx.ReindexWithProgress(usersTable, widgetsByAB, nil); !errors.Is(err, ErrIndexNotOnTable) {
+			t.Errorf("got %v, wanted ErrIndexNotOnTable", err)
+		}
+	})
+
+	// with a changed indexer, recorded keys are updated, so that later
+	// updates remove the new entries
+	type Label struct {
+		ID   ID     `msgpack:"-"`
+		Name string `msgpack:"n"`
+	}
+	labelsSchema := func(f func(string) string) (*Schema, *Table, *Index) {
+		scm := &Schema{}
+		byName := AddIndex[string]("name")
+		tbl := DefineTable(scm, "labels", func(b *TableBuilder[Label, ID]) {
+			b.AddIndex(byName)
+			b.Indexer(func(row *Label, ib *IndexBuilder) {
+				ib.Add(byName, f(row.Name))
+			})
+		})
+		return scm, tbl, byName
+	}
+	scm, _, _ := labelsSchema(strings.ToLower)
+	db = setup(t, scm)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Label{ID: 1, Name: "Foo"})
+	})
+	path := db.Bolt().Path()
+	db.Close()
+
+	scm, labels, labelsByName := labelsSchema(strings.ToUpper)
+	db = must(Open(path, scm, Options{IsTesting: true}))
+	defer db.Close()
+	db.Write(func(tx *Tx) {
+		ensure(tx.ReindexWithProgress(labels, labelsByName, nil))
+	})
+	db.Write(func(tx *Tx) {
+		isnil(t, Lookup[Label](tx, labelsByName, "foo"))
+		deepEqual(t, Lookup[Label](tx, labelsByName, "FOO").ID, ID(1))
+		Put(tx, &Label{ID: 1, Name: "Bar"})
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, tx.IndexKeyCount(labelsByName), 1)
+		deepEqual(t, Lookup[Label](tx, labelsByName, "BAR").ID, ID(1))
+	})
+}
+
+func TestSavepoint(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 1, Name: "foo", Email: "foo@example.com"})
+	})
+
+	errInvalid := errors.New("invalid")
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 2, Name: "bar", Email: "bar@example.com"})
+
+		err := tx.Savepoint(func(tx *Tx) error {
+			Put(tx, &User{ID: 3, Name: "boz", Email: "boz@example.com"})
+			// swap emails, so that restored rows must take back unique entries
+			Put(tx, &User{ID: 1, Name: "foo", Email: "new@example.com"})
+			Put(tx, &User{ID: 2, Name: "bar2", Email: "foo@example.com"})
+			Put(tx, &User{ID: 2, Name: "bar3", Email: "foo@example.com"})
+			DeleteByKey[User](tx, ID(3))
+			deepEqual(t, Lookup[User](tx, usersByEmail, "foo@example.com").ID, ID(2))
+			return errInvalid
+		})
+		deepEqual(t, err, errInvalid)
+
+		// a successful savepoint keeps its changes, a failed nested one doesn't
+		ensure(tx.Savepoint(func(tx *Tx) error {
+			Put(tx, &User{ID: 4, Name: "qux", Email: "qux@example.com"})
+			err := tx.Savepoint(func(tx *Tx) error {
+				DeleteByKey[User](tx, ID(4))
+				DeleteByKey[User](tx, ID(1))
+				return errInvalid
+			})
+			deepEqual(t, err, errInvalid)
+			return nil
+		}))
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, Get[User](tx, ID(1)), &User{ID: 1, Name: "foo", Email: "foo@example.com"})
+		deepEqual(t, Get[User](tx, ID(2)), &User{ID: 2, Name: "bar", Email: "bar@example.com"})
+		isnil(t, Get[User](tx, ID(3)))
+		deepEqual(t, Get[User](tx, ID(4)).Name, "qux")
+
+		deepEqual(t, Lookup[User](tx, usersByEmail, "foo@example.com").ID, ID(1))
+		deepEqual(t, Lookup[User](tx, usersByEmail, "bar@example.com").ID, ID(2))
+		isnil(t, Lookup[User](tx, usersByEmail, "new@example.com"))
+		isnil(t, Lookup[User](tx, usersByName, "bar3"))
+		isempty(t, tx.VerifyIndexes(usersTable))
+		deepEqual(t, tx.IndexKeyCount(usersByName), 3)
+	})
+
+	// a row that only lost its unique index entry gets it back
+	db.Write(func(tx *Tx) {
+		err := tx.Savepoint(func(tx *Tx) error {
+			Put(tx, &User{ID: 5, Name: "five", Email: "qux@example.com"})
+			deepEqual(t, Lookup[User](tx, usersByEmail, "qux@example.com").ID, ID(5))
+			return errInvalid
+		})
+		deepEqual(t, err, errInvalid)
+		deepEqual(t, Lookup[User](tx, usersByEmail, "qux@example.com").ID, ID(4))
+		isempty(t, tx.VerifyIndexes(usersTable))
+
+		func() {
+			defer func() {
+				if e := recover(); e == nil {
+					t.Errorf("Reindex within a savepoint did not panic")
+				}
+			}()
+			tx.Savepoint(func(tx *Tx) error {
+				tx.Reindex(usersTable, usersByEmail)
+				return nil
+			})
+		}()
+	})
+}
+
+func TestSingletonKeys(t *testing.T) {
+	scm := &Schema{}
+	settings := AddKVMap(scm, "settings")
+	counter := AddSingletonKey[int](settings, "counter")
+	title := AddSingletonKey[string](settings, "title")
+	db := setup(t, scm)
+
+	db.Read(func(tx *Tx) {
+		deepEqual(t, SGetOr(tx, title, "Untitled"), "Untitled")
+		deepEqual(t, SGetOr(tx, counter, 0), 0)
+	})
+
+	incr := func(cur *int) *int {
+		n := 1
+		if cur != nil {
+			n = *cur + 1
+		}
+		return &n
+	}
+	for range 3 {
+		db.Write(func(tx *Tx) {
+			SUpdate(tx, counter, incr)
+		})
+	}
+	db.Write(func(tx *Tx) {
+		SUpdate(tx, counter, func(cur *int) *int {
+			deepEqual(t, *cur, 3)
+			return nil // leaves the value as is
+		})
+		hello := "Hello"
+		SPut(tx, title, &hello)
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, SGetOr(tx, counter, 0), 3)
+		deepEqual(t, SGetOr(tx, title, "Untitled"), "Hello")
+	})
+}
+
+func TestTxContext(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= 2500; i++ {
+			Put(tx, &User{ID: ID(i), Name: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)})
+		}
+	})
+
+	// canceling mid-reindex rolls the transaction back
+	ctx, cancel := context.WithCancel(context.Background())
+	err := db.TxContext(ctx, true, func(tx *Tx) error {
+		return tx.ReindexWithProgress(usersTable, usersByEmail, func(done int64) {
+			if done == 1000 {
+				cancel()
+			}
+		})
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("reindex: got %v, wanted context.Canceled", err)
+	}
+	db.Read(func(tx *Tx) {
+		deepEqual(t, tx.IndexKeyCount(usersByEmail), 2500)
+	})
+
+	ctx, cancel = context.WithCancel(context.Background())
+	err = db.TxContext(ctx, true, func(tx *Tx) error {
+		cancel()
+		tx.Reindex(usersTable, usersByEmail)
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Reindex: got %v, wanted context.Canceled", err)
+	}
+
+	// schema migration re-indexing stops too, leaving the index pending
+	is := db.tableState(usersTable).indexStates[usersByEmail.pos]
+	ctx, cancel = context.WithCancel(context.Background())
+	err = db.TxContext(ctx, true, func(tx *Tx) error {
+		is.Built = false
+		cancel()
+		db.tableState(usersTable).migrate(tx)
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("migrate: got %v, wanted context.Canceled", err)
+	}
+	deepEqual(t, db.PendingIndexes(), []*Index{usersByEmail})
+	db.setIndexBuilt(is)
+	db.Read(func(tx *Tx) {
+		deepEqual(t, tx.IndexKeyCount(usersByEmail), 2500)
+	})
+
+	var called bool
+	err = db.TxContext(ctx, true, func(tx *Tx) error {
+		called = true
+		return nil
+	})
+	deepEqual(t, err, context.Canceled)
+	deepEqual(t, called, false)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	err = db.TxContext(ctx, true, func(tx *Tx) error {
+		DeleteByKey[User](tx, ID(1))
+		cancel()
+		DeleteAll(tx.TableScan(usersTable, FullScan()))
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("DeleteAll: got %v, wanted context.Canceled", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var rows int
+	err = db.TxContext(ctx, false, func(tx *Tx) error {
+		c := tx.TableScan(usersTable, FullScan())
+		for c.Next() {
+			if rows++; rows == 100 {
+				cancel()
+			}
+		}
+		return c.Err()
+	})
+	deepEqual(t, err, context.Canceled)
+	if rows >= 2500 {
+		t.Errorf("scanned %d rows after cancellation", rows)
+	}
+	db.Read(func(tx *Tx) {
+		deepEqual(t, tx.FastCount(usersTable), 2500)
+	})
+}
+
+func TestVerifyIndexes(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 1, Name: "foo", Email: "foo@example.com"})
+		Put(tx, &User{ID: 2, Name: "bar", Email: "bar@example.com"})
+		Put(tx, &User{ID: 3, Name: "boz", Email: "boz@example.com"})
+	})
+	db.Read(func(tx *Tx) {
+		isempty(t, tx.VerifyIndexes(usersTable))
+	})
+
+	var nameKey []byte
+	db.Write(func(tx *Tx) {
+		tx.UnsafeDeleteByKeyRawSkippingIndex(usersTable, usersTable.EncodeKey(ID(2)))
+
+		rootB := usersTable.rootBucketIn(tx.btx)
+		c := usersByName.shardBucketIn(rootB, 0).Cursor()
+		k, _ := c.Last() // foo, user 1
+		nameKey = bytes.Clone(k)
+		ensure(c.Delete())
+	})
+	db.Read(func(tx *Tx) {
+		errs := tx.VerifyIndexes(usersTable)
+		var summary []string
+		for _, e := range errs {
+			summary = append(summary, fmt.Sprintf("%s %s %v", e.Index.ShortName(), e.Kind, usersTable.DecodeKeyVal(e.KeyRaw).Interface()))
+		}
+		deepEqual(t, summary, []string{
+			"Email dangling 2",
+			"Name dangling 2",
+			"Name missing 1",
+		})
+		deepEqual(t, errs[2].IndexKeyRaw, nameKey)
+	})
+
+	db.Write(func(tx *Tx) {
+		tx.Reindex(usersTable, nil)
+	})
+	db.Read(func(tx *Tx) {
+		isempty(t, tx.VerifyIndexes(usersTable))
+	})
+}
+
+func TestSwapKeys(t *testing.T) {
+	u1 := &Widget{Key: AB{1, 43}, Name: "foo", Email: "foo@example.com"}
+	u2 := &Widget{Key: AB{2, 11}, Name: "barbar", Email: "bar@example.com"}
+
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, u1, u2)
+		if err := tx.SwapKeys(widgetsTable, AB{1, 43}, AB{2, 11}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.SwapKeys(widgetsTable, AB{1, 43}, AB{3, 3}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("SwapKeys with missing row: got %v, wanted ErrNotFound", err)
+		}
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, Get[Widget](tx, AB{1, 43}).Name, "barbar")
+		deepEqual(t, Get[Widget](tx, AB{2, 11}).Name, "foo")
+		deepEqual(t, Lookup[Widget](tx, widgetsByCD, CD{3, 11}).Email, "foo@example.com")
+		deepEqual(t, Lookup[Widget](tx, widgetsByCD, CD{6, 43}).Email, "bar@example.com")
+		isempty(t, All(IndexScan[Widget](tx, widgetsByCD, ExactScan(CD{3, 43}))))
+	})
+}
+
+func TestCache(t *testing.T) {
+	db := setup(t, basicSchema)
+	cache := NewCache(db, 1)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Widget{Key: AB{1, 1}, Name: "foo"}, &Widget{Key: AB{2, 2}, Name: "bar"})
+	})
+
+	db.Read(func(tx *Tx) {
+		deepEqual(t, GetCached[Widget](cache, tx, AB{1, 1}).Name, "foo")
+		isnil(t, GetCached[Widget](cache, tx, AB{3, 3}))
+	})
+	deepEqual(t, cache.Len(), 1)
+
+	err := db.Tx(true, func(tx *Tx) error {
+		Put(tx, &Widget{Key: AB{1, 1}, Name: "rolled back"})
+		return errors.New("fail")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	deepEqual(t, cache.Len(), 1)
+
+	db.Write(func(tx *Tx) {
+		Put(tx, &Widget{Key: AB{1, 1}, Name: "foo2"})
+		deepEqual(t, GetCached[Widget](cache, tx, AB{1, 1}).Name, "foo2")
+	})
+	deepEqual(t, cache.Len(), 0)
+
+	db.Read(func(tx *Tx) {
+		deepEqual(t, GetCached[Widget](cache, tx, AB{1, 1}).Name, "foo2")
+		deepEqual(t, GetCached[Widget](cache, tx, AB{2, 2}).Name, "bar")
+	})
+	deepEqual(t, cache.Len(), 1)
+}
+
+func TestAfterCommit(t *testing.T) {
+	db := setup(t, basicSchema)
+	var log []string
+	ensure(db.Tx(true, func(tx *Tx) error {
+		Put(tx, &User{ID: 1, Email: "foo@example.com"})
+		tx.AfterCommit(func() { log = append(log, "first") })
+		tx.AfterCommit(func() { log = append(log, "second") })
+		deepEqual(t, log, []string(nil))
+		return nil
+	}))
+	deepEqual(t, log, []string{"first", "second"})
+
+	log = nil
+	err := db.Tx(true, func(tx *Tx) error {
+		Put(tx, &User{ID: 2, Email: "bar@example.com"})
+		tx.AfterCommit(func() { log = append(log, "rolled back") })
+		return errors.New("fail")
+	})
+	deepEqual(t, err != nil, true)
+	err = db.Tx(true, func(tx *Tx) error {
+		tx.AfterCommit(func() { log = append(log, "check phase") })
+		return errors.New("fail")
+	})
+	deepEqual(t, err != nil, true)
+	deepEqual(t, log, []string(nil))
+
+	db.Write(func(tx *Tx) {
+		tx.AfterCommit(func() { log = append(log, "write") })
+	})
+	deepEqual(t, log, []string{"write"})
+
+	// a batch failed by another writer is retried, and only the callbacks
+	// of the final attempt run
+	log = nil
+	db.Bolt().MaxBatchSize = 2
+	db.Bolt().MaxBatchDelay = 10 * time.Second
+	var attempts int
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ensure(db.Tx(true, func(tx *Tx) error {
+			attempts++
+			Put(tx, &User{ID: 3, Email: "boz@example.com"})
+			tx.AfterCommit(func() { log = append(log, "retried") })
+			return nil
+		}))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	err = db.Tx(true, func(tx *Tx) error {
+		Put(tx, &User{ID: 4, Email: "fail@example.com"})
+		return errors.New("fail")
+	})
+	deepEqual(t, err != nil, true)
+	wg.Wait()
+	deepEqual(t, attempts, 2)
+	deepEqual(t, log, []string{"retried"})
+}
+
+func TestPutAllValidated(t *testing.T) {
+	errNoName := errors.New("name required")
+	validate := func(w *Widget) error {
+		if w.Name == "" {
+			return errNoName
+		}
+		return nil
+	}
+
+	db := setup(t, basicSchema)
+	err := db.Tx(true, func(tx *Tx) error {
+		_, err := PutAllValidated(tx, []*Widget{{Key: AB{1, 1}, Name: "foo"}, {Key: AB{2, 2}}}, validate)
+		return err
+	})
+	deepEqual(t, errors.Is(err, errNoName), true)
+	db.Read(func(tx *Tx) {
+		isempty(t, All(FullTableScan[Widget](tx)))
+	})
+
+	db.Write(func(tx *Tx) {
+		modified, err := PutAllValidated(tx, []*Widget{{Key: AB{1, 1}, Name: "foo"}, {Key: AB{2, 2}, Name: "bar"}}, validate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deepEqual(t, modified, true)
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, len(All(FullTableScan[Widget](tx))), 2)
+	})
+}
+
+func TestSchemaDescribe(t *testing.T) {
+	desc := basicSchema.Describe()
+	var widgets TableDescriptor
+	for _, td := range desc.Tables {
+		if td.Name == "Widgets" {
+			widgets = td
+		}
+	}
+	deepEqual(t, widgets, TableDescriptor{
+		Name:               "Widgets",
+		RowType:            "edb.Widget",
+		KeyType:            "edb.AB",
+		KeyStringSeparator: "|",
+		SchemaVersion:      1,
+		ValueCodec:         "msgpack",
+		Indices: []IndexDescriptor{
+			{Name: "by_CD", KeyType: "edb.CD"},
+			{Name: "by_AB", KeyType: "edb.AB", Unique: true},
+		},
+	})
+	deepEqual(t, desc.KVTables[0].Name, "kubets")
+
+	type Doc struct {
+		ID      ID        `json:"-"`
+		Title   string    `json:"title"`
+		Expires time.Time `json:"expires"`
+	}
+	scm := &Schema{}
+	docsByTitle := AddIndex[string]("title", Covering(func(row any) []byte { return []byte(row.(*Doc).Title) }))
+	DefineTable(scm, "docs", func(b *TableBuilder[Doc, ID]) {
+		b.SetSchemaVersion(2)
+		b.ValueCodec(JSON)
+		b.CompressValues()
+		b.AutoKey()
+		b.KeyStringSeparator("/")
+		b.ExpireAfter(func(row *Doc) time.Time { return row.Expires })
+		b.HideExpired()
+		b.RenameField("t", "title", 2)
+		b.AddIndex(docsByTitle)
+	})
+	deepEqual(t, scm.Describe().Tables[0], TableDescriptor{
+		Name:               "docs",
+		RowType:            "edb.Doc",
+		KeyType:            "edb.ID",
+		KeyStringSeparator: "/",
+		SchemaVersion:      2,
+		ValueCodec:         "json",
+		CompressValues:     true,
+		AutoKey:            true,
+		Expires:            true,
+		HideExpired:        true,
+		FieldRenames:       []FieldRenameDescriptor{{OldName: "t", NewName: "title", SinceVersion: 2}},
+		Indices: []IndexDescriptor{
+			{Name: "title", KeyType: "string", Covering: true},
+		},
+	})
+
+	var decoded SchemaDescriptor
+	if err := json.Unmarshal(desc.JSON(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	deepEqual(t, decoded, desc)
+}
+
+func TestGroupScan(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 1, Name: "foo", Email: "a@example.com"})
+		Put(tx, &User{ID: 2, Name: "bar", Email: "b@example.com"})
+		Put(tx, &User{ID: 3, Name: "foo", Email: "c@example.com"})
+	})
+	db.Read(func(tx *Tx) {
+		var groups []string
+		GroupScan(tx, usersByName, FullScan(), func(u *User) string { return u.Name }, func(acc *int, u *User) {
+			*acc++
+		}, func(name string, count int) {
+			groups = append(groups, fmt.Sprintf("%s=%d", name, count))
+		})
+		deepEqual(t, groups, []string{"bar=1", "foo=2"})
+	})
+}
+
+func TestUpsert(t *testing.T) {
+	db := setup(t, basicSchema)
+	rename := func(name string) func(u *User) *User {
+		return func(u *User) *User {
+			if u == nil {
+				u = &User{Email: "foo@example.com"}
+			}
+			u.Name = name
+			return u
+		}
+	}
+	db.Write(func(tx *Tx) {
+		u, created := Upsert(tx, ID(1), rename("foo"))
+		deepEqual(t, created, true)
+		deepEqual(t, u, &User{ID: 1, Name: "foo", Email: "foo@example.com"})
+		deepEqual(t, tx.GetMeta(usersTable, ID(1)).ModCount, uint64(1))
+
+		u, created = Upsert(tx, ID(1), rename("bar"))
+		deepEqual(t, created, false)
+		deepEqual(t, u.Name, "bar")
+		deepEqual(t, tx.GetMeta(usersTable, ID(1)).ModCount, uint64(2))
+
+		// no-op: same data does not bump the mod count
+		u, created = Upsert(tx, ID(1), rename("bar"))
+		deepEqual(t, created, false)
+		deepEqual(t, tx.GetMeta(usersTable, ID(1)).ModCount, uint64(2))
+
+		u, created = Upsert(tx, ID(2), func(*User) *User { return nil })
+		isnil(t, u)
+		deepEqual(t, created, false)
+		deepEqual(t, Exists[User](tx, ID(2)), false)
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, Get[User](tx, ID(1)), &User{ID: 1, Name: "bar", Email: "foo@example.com"})
+		deepEqual(t, Lookup[User](tx, usersByName, "bar").ID, ID(1))
+	})
+}
+
+func TestPutIfUnchanged(t *testing.T) {
+	db := setup(t, basicSchema)
+	var meta ValueMeta
+	db.Write(func(tx *Tx) {
+		var err error
+		meta, err = tx.PutIfUnchanged(usersTable, reflect.ValueOf(&User{ID: 1, Name: "foo", Email: "foo@example.com"}), ValueMeta{})
+		ensure(err)
+		deepEqual(t, meta.ModCount, uint64(1))
+
+		_, err = tx.PutIfUnchanged(usersTable, reflect.ValueOf(&User{ID: 1, Name: "dup", Email: "foo@example.com"}), ValueMeta{})
+		deepEqual(t, errors.Is(err, ErrConflict), true)
+	})
+
+	// read in one transaction, concurrent write, stale write in another
+	var u *User
+	db.Read(func(tx *Tx) {
+		u = Get[User](tx, ID(1))
+		meta = tx.GetMeta(usersTable, ID(1))
+	})
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 1, Name: "other", Email: "foo@example.com"})
+	})
+	db.Write(func(tx *Tx) {
+		u.Name = "bar"
+		current, err := tx.PutIfUnchanged(usersTable, reflect.ValueOf(u), meta)
+		deepEqual(t, errors.Is(err, ErrConflict), true)
+		deepEqual(t, current.ModCount, uint64(2))
+		deepEqual(t, Get[User](tx, ID(1)).Name, "other")
+
+		newMeta, err := tx.PutIfUnchanged(usersTable, reflect.ValueOf(u), current)
+		ensure(err)
+		deepEqual(t, newMeta.ModCount, uint64(3))
+		deepEqual(t, Get[User](tx, ID(1)).Name, "bar")
+	})
+}
+
+func TestInsertAutoKey(t *testing.T) {
+	type Ticket struct {
+		ID    ID     `msgpack:"-"`
+		Title string `msgpack:"t"`
+	}
+	type Comment struct {
+		ID   ID     `msgpack:"-"`
+		Text string `msgpack:"t"`
+	}
+	scm := &Schema{}
+	DefineTable(scm, "tickets", func(b *TableBuilder[Ticket, ID]) {
+		b.AutoKey()
+	})
+	DefineTable(scm, "comments", func(b *TableBuilder[Comment, ID]) {})
+	db := setup(t, scm)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Ticket{ID: 10, Title: "imported"})
+		t1 := &Ticket{Title: "first"}
+		deepEqual(t, Insert(tx, t1), uint64(11))
+		deepEqual(t, t1.ID, ID(11))
+		deepEqual(t, Insert(tx, &Ticket{Title: "second"}), uint64(12))
+		DeleteByKey[Ticket](tx, ID(12))
+	})
+
+	path := db.Bolt().Path()
+	db.Close()
+	db = must(Open(path, scm, Options{IsTesting: true}))
+	defer db.Close()
+	db.Write(func(tx *Tx) {
+		deepEqual(t, Insert(tx, &Ticket{Title: "third"}), uint64(13))
+		deepEqual(t, Get[Ticket](tx, ID(13)).Title, "third")
+		deepEqual(t, len(AllTableRows[Ticket](tx)), 3)
+
+		Put(tx, &Ticket{ID: 14, Title: "explicit"})
+		Put(tx, &Ticket{ID: 15, Title: "explicit too"})
+		deepEqual(t, Insert(tx, &Ticket{Title: "fourth"}), uint64(16))
+		deepEqual(t, Get[Ticket](tx, ID(14)).Title, "explicit")
+		deepEqual(t, Get[Ticket](tx, ID(15)).Title, "explicit too")
+	})
+
+	func() {
+		defer func() {
+			if e := recover(); e == nil {
+				t.Error("expected Insert to panic without AutoKey")
+			}
+		}()
+		db.Write(func(tx *Tx) {
+			Insert(tx, &Comment{Text: "foo"})
+		})
+	}()
+}
+
+func TestFindUniqueViolations(t *testing.T) {
+	type Member struct {
+		ID    ID     `msgpack:"-"`
+		Email string `msgpack:"e"`
+	}
+	memberSchema := func(unique bool) (*Schema, *Index) {
+		scm := &Schema{}
+		membersByEmail := AddIndex[string]("email")
+		if unique {
+			membersByEmail = membersByEmail.Unique()
+		}
+		DefineTable(scm, "members", func(b *TableBuilder[Member, ID]) {
+			b.AddIndex(membersByEmail)
+			b.Indexer(func(row *Member, ib *IndexBuilder) {
+				ib.Add(membersByEmail, row.Email)
+			})
+		})
+		return scm, membersByEmail
+	}
+	violations := func(db *DB, idx *Index) []string {
+		var result []string
+		db.Read(func(tx *Tx) {
+			for _, raw := range tx.FindUniqueViolations(idx) {
+				result = append(result, must(DecodeIndexKeyBytes(reflect.TypeFor[string](), raw, true)).(string))
+			}
+		})
+		return result
+	}
+
+	scm, membersByEmail := memberSchema(false)
+	db := setup(t, scm)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Member{ID: 1, Email: "b@example.com"})
+		Put(tx, &Member{ID: 2, Email: "a@example.com"})
+		Put(tx, &Member{ID: 3, Email: "b@example.com"})
+		Put(tx, &Member{ID: 4, Email: "c@example.com"})
+		Put(tx, &Member{ID: 5, Email: "a@example.com"})
+	})
+	deepEqual(t, violations(db, membersByEmail), []string{"a@example.com", "b@example.com"})
+
+	db.Write(func(tx *Tx) {
+		Put(tx, &Member{ID: 5, Email: "e@example.com"})
+	})
+	deepEqual(t, violations(db, membersByEmail), []string{"b@example.com"})
+
+	// converting to unique rebuilds the index, the violation is still reported
+	path := db.Bolt().Path()
+	db.Close()
+	scm, membersByEmail = memberSchema(true)
+	db = must(Open(path, scm, Options{IsTesting: true}))
+	defer db.Close()
+	deepEqual(t, violations(db, membersByEmail), []string{"b@example.com"})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, Lookup[Member](tx, membersByEmail, "e@example.com").ID, ID(5))
+		deepEqual(t, Lookup[Member](tx, membersByEmail, "c@example.com").ID, ID(4))
+	})
+
+	db.Write(func(tx *Tx) {
+		DeleteByKey[Member](tx, ID(3))
+	})
+	isempty(t, violations(db, membersByEmail))
+}
+
+func TestExportImportJSON(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 2, Name: "bar", Email: "bar@example.com"})
+		Put(tx, &User{ID: 1, Name: "foo", Email: "foo@example.com"})
+		Put(tx, &Widget{Key: AB{1, 43}, Name: "w", Email: "w@example.com"})
+	})
+	var users, widgets bytes.Buffer
+	db.Read(func(tx *Tx) {
+		ensure(tx.ExportJSON(usersTable, &users))
+		ensure(tx.ExportJSON(widgetsTable, &widgets))
+	})
+	deepEqual(t, users.String(), `{"key":1,"row":{"ID":1,"Email":"foo@example.com","Name":"foo"}}`+"\n"+
+		`{"key":2,"row":{"ID":2,"Email":"bar@example.com","Name":"bar"}}`+"\n")
+
+	db2 := setup(t, basicSchema)
+	db2.Write(func(tx *Tx) {
+		deepEqual(t, must(tx.ImportJSON(usersTable, &users)), 2)
+		deepEqual(t, must(tx.ImportJSON(widgetsTable, &widgets)), 1)
+	})
+	db2.Read(func(tx *Tx) {
+		deepEqual(t, Lookup[User](tx, usersByEmail, "bar@example.com"), &User{ID: 2, Name: "bar", Email: "bar@example.com"})
+		deepEqual(t, Get[Widget](tx, AB{1, 43}), &Widget{Key: AB{1, 43}, Name: "w", Email: "w@example.com"})
+	})
+
+	db2.Write(func(tx *Tx) {
+		n, err := tx.ImportJSON(usersTable, strings.NewReader(`{"key":3,"row":{"Name":"x"}}`+"\n"+`{"key":"bad"}`))
+		deepEqual(t, n, 1)
+		if err == nil {
+			t.Error("expected ImportJSON to fail on a bad key")
+		}
+	})
+}
+
+func TestExportImportTable(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 2, Name: "bar", Email: "bar@example.com"})
+		Put(tx, &User{ID: 1, Name: "foo", Email: "foo@example.com"})
+	})
+	var users bytes.Buffer
+	db.Read(func(tx *Tx) {
+		ensure(tx.ExportTable(usersTable, &users))
+	})
+	deepEqual(t, users.String(), `{"key":1,"ver":1,"row":{"ID":1,"Email":"foo@example.com","Name":"foo"}}`+"\n"+
+		`{"key":2,"ver":1,"row":{"ID":2,"Email":"bar@example.com","Name":"bar"}}`+"\n")
+
+	db2 := setup(t, basicSchema)
+	db2.Write(func(tx *Tx) {
+		deepEqual(t, must(tx.ImportTable(usersTable, &users)), 2)
+	})
+	db2.Read(func(tx *Tx) {
+		deepEqual(t, All(FullTableScan[User](tx)), []*User{
+			{ID: 1, Name: "foo", Email: "foo@example.com"},
+			{ID: 2, Name: "bar", Email: "bar@example.com"},
+		})
+		deepEqual(t, Lookup[User](tx, usersByEmail, "bar@example.com").ID, ID(2))
+	})
+
+	type Note struct {
+		ID   ID     `msgpack:"-"`
+		Text string `msgpack:"t"`
+	}
+	notesSchema := func(ver uint64) (*Schema, *Table) {
+		scm := &Schema{}
+		tbl := DefineTable(scm, "notes", func(b *TableBuilder[Note, ID]) {
+			b.SetSchemaVersion(ver)
+			b.Migrate(func(tx *Tx, row *Note, oldVer uint64) {
+				row.Text = strings.ToUpper(row.Text)
+			})
+		})
+		return scm, tbl
+	}
+
+	scm1, notes1 := notesSchema(1)
+	db = setup(t, scm1)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Note{ID: 1, Text: "hello"})
+	})
+	var notes bytes.Buffer
+	db.Read(func(tx *Tx) {
+		ensure(tx.ExportTable(notes1, &notes))
+	})
+	exported := notes.String()
+
+	scm2, notes2 := notesSchema(2)
+	db2 = setup(t, scm2)
+	db2.Write(func(tx *Tx) {
+		deepEqual(t, must(tx.ImportTable(notes2, &notes)), 1)
+	})
+	db2.Read(func(tx *Tx) {
+		row, meta := tx.GetAtVersion(notes2, ID(1), true)
+		deepEqual(t, row.(*Note), &Note{ID: 1, Text: "HELLO"})
+		deepEqual(t, meta.SchemaVer, uint64(2))
+	})
+
+	// rows from a newer schema cannot be imported
+	db.Write(func(tx *Tx) {
+		n, err := tx.ImportTable(notes1, strings.NewReader(strings.Replace(exported, `"ver":1`, `"ver":2`, 1)))
+		deepEqual(t, n, 0)
+		if err == nil {
+			t.Error("expected ImportTable to fail on a newer schema version")
+		}
+	})
+}
+
+func TestValueCodec(t *testing.T) {
+	type Setting struct {
+		ID    ID     `msgpack:"-" json:"-"`
+		Value string `msgpack:"v" json:"value"`
+	}
+	settingsSchema := func(enc encodingMethod) (*Schema, *Table) {
+		scm := &Schema{}
+		tbl := DefineTable(scm, "settings", func(b *TableBuilder[Setting, ID]) {
+			b.ValueCodec(enc)
+		})
+		AddTable[User](scm, "users", 1, nil, nil, nil)
+		return scm, tbl
+	}
+	stored := func(tx *Tx, tbl *Table, key ID) value {
+		var vle value
+		decodeTableValue(&vle, tbl, tbl.EncodeKey(key), tx.getRawByRawKey(tbl, tbl.EncodeKey(key)))
+		return vle
+	}
+
+	scm, settings := settingsSchema(MsgPack)
+	db := setup(t, scm)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Setting{ID: 1, Value: "old"})
+		Put(tx, &User{ID: 1, Name: "foo"})
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, stored(tx, settings, 1).Flags, vfDefault)
+	})
+	path := db.Bolt().Path()
+	db.Close()
+
+	scm, settings = settingsSchema(JSON)
+	db = must(Open(path, scm, Options{IsTesting: true}))
+	defer db.Close()
+	db.Write(func(tx *Tx) {
+		// rows written with the previous codec still decode
+		deepEqual(t, Get[Setting](tx, ID(1)), &Setting{ID: 1, Value: "old"})
+		Put(tx, &Setting{ID: 2, Value: "new"})
+	})
+	db.Read(func(tx *Tx) {
+		vle := stored(tx, settings, 2)
+		deepEqual(t, vle.Flags, vfDefault|vfJSON)
+		deepEqual(t, string(vle.Data), `{"value":"new"}`)
+		deepEqual(t, stored(tx, settings, 1).Flags, vfDefault)
+
+		deepEqual(t, Get[Setting](tx, ID(1)), &Setting{ID: 1, Value: "old"})
+		deepEqual(t, Get[Setting](tx, ID(2)), &Setting{ID: 2, Value: "new"})
+		deepEqual(t, Get[User](tx, ID(1)).Name, "foo")
+	})
+
+	// upgrading converts to the table's codec
+	deepEqual(t, db.UpgradeValueFormat(), 1)
+	db.Read(func(tx *Tx) {
+		vle := stored(tx, settings, 1)
+		deepEqual(t, vle.Flags, vfDefault|vfJSON)
+		deepEqual(t, vle.ModCount, uint64(1))
+		deepEqual(t, string(vle.Data), `{"value":"old"}`)
+		deepEqual(t, Get[Setting](tx, ID(1)), &Setting{ID: 1, Value: "old"})
+	})
+}
+
+func TestCompressValues(t *testing.T) {
+	type Doc struct {
+		ID   ID     `msgpack:"-"`
+		Body string `msgpack:"b"`
+	}
+	type PlainDoc Doc
+	scm := &Schema{}
+	docsByBody := AddIndex[string]("body")
+	docs := DefineTable(scm, "docs", func(b *TableBuilder[Doc, ID]) {
+		b.CompressValues()
+		b.AddIndex(docsByBody)
+		b.Indexer(func(row *Doc, ib *IndexBuilder) {
+			ib.Add(docsByBody, row.Body[:min(len(row.Body), 8)])
+		})
+	})
+	plainDocs := DefineTable(scm, "plain_docs", func(b *TableBuilder[PlainDoc, ID]) {})
+	db := setup(t, scm)
+
+	big := &Doc{ID: 1, Body: strings.Repeat("hello world ", 1000)}
+	small := &Doc{ID: 2, Body: "hi"}
+	stored := func(tx *Tx, tbl *Table, key ID) value {
+		var vle value
+		decodeTableValue(&vle, tbl, tbl.EncodeKey(key), tx.getRawByRawKey(tbl, tbl.EncodeKey(key)))
+		return vle
+	}
+	db.Write(func(tx *Tx) {
+		tx.Put(docs, big)
+		tx.Put(docs, small)
+		tx.Put(plainDocs, (*PlainDoc)(big))
+	})
+	db.Read(func(tx *Tx) {
+		compressed, plain := stored(tx, docs, 1), stored(tx, plainDocs, 1)
+		deepEqual(t, compressed.Flags, vfDefault|vfGzip)
+		deepEqual(t, plain.Flags, vfDefault)
+		if len(compressed.Data) >= len(plain.Data)/10 {
+			t.Errorf("compressed data is %d bytes, uncompressed is %d bytes", len(compressed.Data), len(plain.Data))
+		}
+		deepEqual(t, stored(tx, docs, 2).Flags, vfDefault)
+
+		deepEqual(t, Get[Doc](tx, ID(1)), big)
+		deepEqual(t, Get[Doc](tx, ID(2)), small)
+		deepEqual(t, Lookup[Doc](tx, docsByBody, "hello wo"), big)
+	})
+
+	// rewriting the same row is a no-op
+	db.Write(func(tx *Tx) {
+		_, meta := tx.Put(docs, &Doc{ID: 1, Body: big.Body})
+		deepEqual(t, meta.ModCount, uint64(1))
+	})
+	db.UpgradeValueFormat()
+	db.Read(func(tx *Tx) {
+		deepEqual(t, Get[Doc](tx, ID(1)), big)
+	})
+}
+
+func TestExpireAfter(t *testing.T) {
+	type Session struct {
+		ID      ID        `msgpack:"-"`
+		Token   string    `msgpack:"t"`
+		Expires time.Time `msgpack:"e"`
+	}
+	type HiddenSession Session
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	scm := &Schema{}
+	sessionsByToken := AddIndex[string]("token").Unique()
+	sessions := DefineTable(scm, "sessions", func(b *TableBuilder[Session, ID]) {
+		b.AddIndex(sessionsByToken)
+		b.Indexer(func(row *Session, ib *IndexBuilder) {
+			ib.Add(sessionsByToken, row.Token)
+		})
+		b.ExpireAfter(func(row *Session) time.Time { return row.Expires })
+	})
+	hiddenByToken := AddIndex[string]("token").Unique()
+	hidden := DefineTable(scm, "hidden_sessions", func(b *TableBuilder[HiddenSession, ID]) {
+		b.AddIndex(hiddenByToken)
+		b.Indexer(func(row *HiddenSession, ib *IndexBuilder) {
+			ib.Add(hiddenByToken, row.Token)
+		})
+		b.ExpireAfter(func(row *HiddenSession) time.Time { return row.Expires })
+		b.HideExpired()
+	})
+	db := setupOpt(t, scm, Options{Now: func() time.Time { return now }})
+
+	rows := []*Session{
+		{ID: 1, Token: "past", Expires: now.Add(-time.Hour)},
+		{ID: 2, Token: "future", Expires: now.Add(time.Hour)},
+		{ID: 3, Token: "never"},
+		{ID: 4, Token: "exact", Expires: now},
+	}
+	db.Write(func(tx *Tx) {
+		for _, row := range rows {
+			Put(tx, row)
+			Put(tx, (*HiddenSession)(row))
+		}
+	})
+	db.Read(func(tx *Tx) {
+		var vle value
+		decodeTableValue(&vle, sessions, sessions.EncodeKey(ID(2)), tx.getRawByRawKey(sessions, sessions.EncodeKey(ID(2))))
+		deepEqual(t, vle.Flags, vfDefault|vfExpires)
+		deepEqual(t, vle.Expiry, uint64(now.Add(time.Hour).UnixMilli()))
+		decodeTableValue(&vle, sessions, sessions.EncodeKey(ID(3)), tx.getRawByRawKey(sessions, sessions.EncodeKey(ID(3))))
+		deepEqual(t, vle.Flags, vfDefault)
+
+		// expired rows are still visible without HideExpired
+		deepEqual(t, len(All(TableScan[Session](tx, FullScan()))), 4)
+		deepEqual(t, Lookup[Session](tx, sessionsByToken, "past").ID, ID(1))
+
+		isnil(t, Get[HiddenSession](tx, ID(1)))
+		deepEqual(t, Get[HiddenSession](tx, ID(2)).Token, "future")
+		deepEqual(t, Exists[HiddenSession](tx, ID(4)), false)
+		isnil(t, Lookup[HiddenSession](tx, hiddenByToken, "past"))
+		deepEqual(t, Lookup[HiddenSession](tx, hiddenByToken, "never").ID, ID(3))
+		deepEqual(t, AllKeys[ID](tx.TableScan(hidden, FullScan())), []ID{2, 3})
+		deepEqual(t, AllKeys[ID](tx.IndexScan(hiddenByToken, FullScan())), []ID{2, 3})
+
+		// FastCount includes hidden rows until they get purged
+		deepEqual(t, tx.FastCount(hidden), 4)
+		deepEqual(t, Count(tx.TableScan(hidden, FullScan())), 2)
+	})
+
+	db.Write(func(tx *Tx) {
+		deepEqual(t, PurgeExpired[Session](tx, now), 2)
+		deepEqual(t, tx.PurgeExpired(hidden, now.Add(2*time.Hour)), 3)
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, AllKeys[ID](tx.TableScan(sessions, FullScan())), []ID{2, 3})
+		deepEqual(t, tx.IndexKeyCount(sessionsByToken), 2)
+		isnil(t, Lookup[Session](tx, sessionsByToken, "past"))
+		isnil(t, Lookup[Session](tx, sessionsByToken, "exact"))
+		deepEqual(t, Lookup[Session](tx, sessionsByToken, "future").ID, ID(2))
+		deepEqual(t, AllKeys[ID](tx.TableScan(hidden, FullScan())), []ID{3})
+		deepEqual(t, tx.IndexKeyCount(hiddenByToken), 1)
+	})
+
+	// changing only the expiry time rewrites the row
+	db.Write(func(tx *Tx) {
+		Put(tx, &Session{ID: 3, Token: "never", Expires: now.Add(-time.Minute)})
+		deepEqual(t, tx.PurgeExpired(sessions, now), 1)
+	})
+}
+
+func TestIndexGroups(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 1, Name: "foo", Email: "u1@example.com"})
+		Put(tx, &User{ID: 2, Name: "bar", Email: "u2@example.com"})
+		Put(tx, &User{ID: 3, Name: "foo", Email: "u3@example.com"})
+		Put(tx, &User{ID: 4, Name: "boz", Email: "u4@example.com"})
+		Put(tx, &User{ID: 5, Name: "foo", Email: "u5@example.com"})
+	})
+	db.Read(func(tx *Tx) {
+		var groups []string
+		for key, rows := range IndexGroups[User](tx, usersByName, FullScan()) {
+			var ids []string
+			for _, u := range rows {
+				ids = append(ids, fmt.Sprint(u.ID))
+			}
+			groups = append(groups, fmt.Sprintf("%v: %s", key, strings.Join(ids, ",")))
+		}
+		deepEqual(t, groups, []string{"bar: 2", "boz: 4", "foo: 1,3,5"})
+
+		groups = nil
+		for key, rows := range IndexGroups[User](tx, usersByName, FullScan().Reversed()) {
+			groups = append(groups, fmt.Sprintf("%v: %d", key, len(rows)))
+			if key == "boz" {
+				break
+			}
+		}
+		deepEqual(t, groups, []string{"foo: 3", "boz: 1"})
+
+		// consume only the first row of every group
+		groups = nil
+		IndexGroupsLazy(tx, usersByName, FullScan(), func(key any, rows iter.Seq[*User]) bool {
+			for u := range rows {
+				groups = append(groups, fmt.Sprintf("%v: %v", key, u.ID))
+				break
+			}
+			return true
+		})
+		deepEqual(t, groups, []string{"bar: 2", "boz: 4", "foo: 1"})
+	})
+}
+
+func TestScanBuilder(t *testing.T) {
+	var users []*User
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= 6; i++ {
+			u := &User{ID: ID(i), Name: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)}
+			users = append(users, u)
+			Put(tx, u)
+		}
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, All(TableScan[User](tx, NewScan().Range(ID(2), ID(5)).ExcUpper().Offset(1).Options())), users[2:4])
+		deepEqual(t, All(TableScan[User](tx, NewScan().Reverse().Limit(2).Options())), []*User{users[5], users[4]})
+		deepEqual(t, All(IndexScan[User](tx, usersByName, NewScan().Offset(4).Limit(5).Options())), users[4:])
+
+		c := TableScan[User](tx, NewScan().Limit(1).Options())
+		deepEqual(t, All(c), users[:1])
+		c.Reset()
+		deepEqual(t, All(c), users[:1])
+	})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected conflicting options to panic")
+		}
+	}()
+	NewScan().Exact(ID(1)).Range(ID(1), ID(2))
+}
+
+func TestScanDeadline(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= 3; i++ {
+			Put(tx, &User{ID: ID(i), Name: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)})
+		}
+	})
+	db.Read(func(tx *Tx) {
+		c := TableScan[User](tx, FullScan().Deadline(time.Now().Add(-time.Second)))
+		isempty(t, All(c))
+		deepEqual(t, c.Err(), context.DeadlineExceeded)
+
+		ic := IndexScan[User](tx, usersByName, FullScan().Deadline(time.Now().Add(-time.Second)))
+		isempty(t, All(ic))
+		deepEqual(t, ic.Err(), context.DeadlineExceeded)
+
+		c = TableScan[User](tx, NewScan().Deadline(time.Now().Add(time.Hour)).Options())
+		deepEqual(t, len(All(c)), 3)
+		deepEqual(t, c.Err(), nil)
+	})
+}
+
+func TestScanContext(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= 3; i++ {
+			Put(tx, &User{ID: ID(i), Name: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)})
+		}
+	})
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	db.Read(func(tx *Tx) {
+		deepEqual(t, TxContext(tx), context.Background())
+
+		ctxh := WithContext(tx, canceled)
+		deepEqual(t, TxContext(ctxh), canceled)
+		c := TableScan[User](ctxh, FullScan())
+		isempty(t, All(c))
+		deepEqual(t, c.Err(), context.Canceled)
+		c = IndexScan[User](ctxh, usersByName, FullScan())
+		isempty(t, All(c))
+		deepEqual(t, c.Err(), context.Canceled)
+
+		// other handles of the same transaction are unaffected
+		deepEqual(t, len(All(TableScan[User](tx, FullScan()))), 3)
+
+		tx.SetContext(canceled)
+		isempty(t, All(TableScan[User](tx, FullScan())))
+		tx.SetContext(nil)
+		deepEqual(t, len(All(TableScan[User](tx, FullScan()))), 3)
+	})
+}
+
+func TestWarm(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 1, Name: "foo", Email: "foo@example.com"})
+	})
+	stats, err := db.Warm(usersTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deepEqual(t, stats.Tables, 1)
+	db.Read(func(tx *Tx) {
+		deepEqual(t, stats.Bytes, warmBucket(usersTable.rootBucketIn(tx.btx)))
+	})
+	if stats.Bytes == 0 {
+		t.Error("expected Warm to read some bytes")
+	}
+
+	stats, err = db.Warm()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deepEqual(t, stats.Tables, len(basicSchema.Tables()))
+}
+
+func TestArchiveOlderThan(t *testing.T) {
+	type Entry struct {
+		ID   ID        `msgpack:"-"`
+		Time time.Time `msgpack:"t"`
+	}
+	scm := &Schema{}
+	entriesByTime := AddIndex[time.Time]("time")
+	entries := DefineTable(scm, "entries", func(b *TableBuilder[Entry, ID]) {
+		b.AddIndex(entriesByTime)
+		b.Indexer(func(row *Entry, ib *IndexBuilder) {
+			ib.Add(entriesByTime, row.Time)
+		})
+	})
+	db := setup(t, scm)
+	archive := setup(t, scm)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= 5; i++ {
+			Put(tx, &Entry{ID: ID(i), Time: base.Add(time.Duration(i) * time.Hour)})
+		}
+	})
+
+	ids := func(db *DB) []ID {
+		var result []ID
+		db.Read(func(tx *Tx) {
+			for _, e := range All(FullIndexScan[Entry](tx, entriesByTime)) {
+				result = append(result, e.ID)
+			}
+		})
+		return result
+	}
+
+	n, err := db.ArchiveOlderThan(entries, entriesByTime, base.Add(3*time.Hour), archive)
+	ensure(err)
+	deepEqual(t, n, 2)
+	deepEqual(t, ids(db), []ID{3, 4, 5})
+	deepEqual(t, ids(archive), []ID{1, 2})
+
+	n, err = db.ArchiveOlderThan(entries, entriesByTime, base.Add(3*time.Hour), archive)
+	ensure(err)
+	deepEqual(t, n, 0)
+
+	// rows updated after being copied are kept
+	key := func(id ID) []byte { return entries.EncodeKey(id) }
+	var values [][]byte
+	db.Read(func(tx *Tx) {
+		values = [][]byte{bytes.Clone(tx.getRawByRawKey(entries, key(3))), bytes.Clone(tx.getRawByRawKey(entries, key(4)))}
+	})
+	db.Write(func(tx *Tx) {
+		Put(tx, &Entry{ID: 3, Time: base.Add(10 * time.Hour)})
+		deepEqual(t, tx.deleteUnchangedRows(entries, [][]byte{key(3), key(4)}, values), 1)
+	})
+	deepEqual(t, ids(db), []ID{5, 3})
+
+	_, err = db.ArchiveOlderThan(entries, entriesByTime, "wrong", archive)
+	if !errors.Is(err, ErrWrongKeyType) {
+		t.Errorf("expected ErrWrongKeyType, got %v", err)
+	}
+}
+
+func TestOpTimings(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 1, Name: "foo", Email: "foo@example.com"})
+		tx.TimeOps()
+		Put(tx, &User{ID: 2, Name: "bar", Email: "bar@example.com"})
+		Get[User](tx, ID(1))
+		Lookup[User](tx, usersByEmail, "bar@example.com")
+		All(TableScan[User](tx, FullScan()))
+
+		var ops []string
+		for _, t := range tx.OpTimings() {
+			ops = append(ops, fmt.Sprintf("%s %s %d", t.Op, t.Target, t.Rows))
+		}
+		deepEqual(t, ops, []string{"put Users 0", "get Users 0", "lookup Users.Email 0", "scan Users 2"})
+	})
+}
+
+func TestNowOption(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	db := setupOpt(t, basicSchema, Options{Now: func() time.Time { return now }})
+	deepEqual(t, db.Now(), now)
+	deepEqual(t, db.tableState(usersTable).LastSeen, now)
+
+	db.Write(func(tx *Tx) {
+		tx.TimeOps()
+		for i := 1; i <= 3; i++ {
+			Put(tx, &User{ID: ID(i), Name: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)})
+		}
+		// durations are measured by the real monotonic clock, not Options.Now
+		deepEqual(t, len(tx.OpTimings()), 3)
+		for _, op := range tx.OpTimings() {
+			if op.Duration <= 0 {
+				t.Errorf("%s %s: got duration %v, wanted positive", op.Op, op.Target, op.Duration)
+			}
+		}
+	})
+	db.Read(func(tx *Tx) {
+		tx.TimeOps()
+		deepEqual(t, len(All(FullTableScan[User](tx))), 3)
+		deepEqual(t, len(tx.OpTimings()), 1)
+		if d := tx.OpTimings()[0].Duration; d <= 0 || d > time.Minute {
+			t.Errorf("table scan: got duration %v, wanted a small positive one", d)
+		}
+
+		opt := FullScan().Deadline(now.Add(time.Minute))
+		c := TableScan[User](tx, opt)
+		deepEqual(t, len(All(c)), 3)
+		deepEqual(t, c.Err(), nil)
+
+		now = now.Add(time.Hour)
+		c = TableScan[User](tx, opt)
+		isempty(t, All(c))
+		deepEqual(t, c.Err(), context.DeadlineExceeded)
+	})
+}
+
+func TestCursorSeq(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= 5; i++ {
+			Put(tx, &User{ID: ID(i), Name: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)})
+		}
+	})
+	db.Read(func(tx *Tx) {
+		var names []string
+		for row := range FullTableScan[User](tx).Seq() {
+			names = append(names, row.Name)
+		}
+		deepEqual(t, names, []string{"u1", "u2", "u3", "u4", "u5"})
+
+		c := IndexScan[User](tx, usersByName, FullScan())
+		var keys []any
+		for key, row := range c.Seq2() {
+			keys = append(keys, key)
+			if row.Name == "u2" {
+				break
+			}
+		}
+		deepEqual(t, keys, []any{ID(1), ID(2)})
+		deepEqual(t, c.Row().Name, "u2")
+		deepEqual(t, c.Next(), true)
+		deepEqual(t, c.Row().Name, "u3")
+	})
+}
+
+func TestCursorSkip(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= 5; i++ {
+			Put(tx, &User{ID: ID(i), Name: fmt.Sprintf("u%d", 6-i), Email: fmt.Sprintf("u%d@example.com", i)})
+		}
+	})
+	names := func(rows []*User) []string {
+		var result []string
+		for _, row := range rows {
+			result = append(result, row.Name)
+		}
+		return result
+	}
+	db.Read(func(tx *Tx) {
+		deepEqual(t, names(All(IndexScan[User](tx, usersByName, FullScan()).Skip(2))), []string{"u3", "u4", "u5"})
+		deepEqual(t, names(AllLimited(IndexScan[User](tx, usersByName, FullScan()).Skip(1), 2)), []string{"u2", "u3"})
+		deepEqual(t, names(All(IndexScan[User](tx, usersByName, FullScan().Reversed()).Skip(3))), []string{"u2", "u1"})
+		deepEqual(t, names(All(IndexScan[User](tx, usersByName, FullScan()).Skip(10))), []string(nil))
+		deepEqual(t, names(All(IndexScan[User](tx, usersByName, FullScan()).Skip(0))), []string{"u1", "u2", "u3", "u4", "u5"})
+
+		deepEqual(t, Skip(tx.IndexScan(usersByName, FullScan()), 10), 5)
+
+		c := IndexScan[User](tx, usersByName, FullScan()).Skip(4)
+		deepEqual(t, c.Next(), true)
+		deepEqual(t, c.Row().Name, "u5")
+		deepEqual(t, c.Next(), false)
+	})
+}
+
+func TestAggregates(t *testing.T) {
+	type Order struct {
+		ID       ID      `msgpack:"-"`
+		Customer string  `msgpack:"c"`
+		Amount   int     `msgpack:"a"`
+		Weight   float64 `msgpack:"w"`
+	}
+	scm := &Schema{}
+	byCustomer := AddIndex[string]("customer")
+	AddTable(scm, "orders", 1, func(row *Order, ib *IndexBuilder) {
+		ib.Add(byCustomer, row.Customer)
+	}, nil, []*Index{byCustomer})
+	db := setup(t, scm)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Order{ID: 1, Customer: "alice", Amount: 10, Weight: 1.5})
+		Put(tx, &Order{ID: 2, Customer: "bob", Amount: 7, Weight: 2})
+		Put(tx, &Order{ID: 3, Customer: "alice", Amount: 25, Weight: 0.5})
+		Put(tx, &Order{ID: 4, Customer: "alice", Amount: 5, Weight: 3})
+		Put(tx, &Order{ID: 5, Customer: "carol", Amount: 25, Weight: 1})
+	})
+	amount := func(o *Order) int { return o.Amount }
+	db.Read(func(tx *Tx) {
+		deepEqual(t, Sum(IndexScan[Order](tx, byCustomer, ExactScan("alice")), amount), 40)
+		deepEqual(t, Sum(IndexScan[Order](tx, byCustomer, ExactScan("alice")), func(o *Order) float64 { return o.Weight }), 5.0)
+		deepEqual(t, Sum(IndexScan[Order](tx, byCustomer, ExactScan("dave")), amount), 0)
+
+		deepEqual(t, MinBy(IndexScan[Order](tx, byCustomer, ExactScan("alice")), amount).ID, ID(4))
+		deepEqual(t, MaxBy(FullTableScan[Order](tx), amount).ID, ID(3)) // first on ties
+		isnil(t, MaxBy(IndexScan[Order](tx, byCustomer, ExactScan("dave")), amount))
+
+		deepEqual(t, GroupCount(FullTableScan[Order](tx), func(o *Order) string { return o.Customer }), map[string]int{"alice": 3, "bob": 1, "carol": 1})
+
+		// helpers consume the rest of the cursor
+		c := FullTableScan[Order](tx)
+		c.Next()
+		deepEqual(t, Sum(c, amount), 62)
+		deepEqual(t, c.Next(), false)
+	})
+}
+
+func TestDeleteByKeyRawMissing(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 1, Name: "foo", Email: "foo@example.com"})
+		deepEqual(t, tx.DeleteByKeyRaw(usersTable, usersTable.EncodeKey(ID(2))), false)
+		deepEqual(t, tx.UnsafeDeleteByKeyRawSkippingIndex(usersTable, usersTable.EncodeKey(ID(2))), false)
+		deepEqual(t, tx.DeleteByKeyRaw(usersTable, usersTable.EncodeKey(ID(1))), true)
+	})
+}
+
+func TestCursorCombinators(t *testing.T) {
+	var users []*User
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= 4; i++ {
+			u := &User{ID: ID(i), Name: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)}
+			users = append(users, u)
+			Put(tx, u)
+		}
+	})
+	isEven := func(u *User) bool { return u.ID%2 == 0 }
+	db.Read(func(tx *Tx) {
+		deepEqual(t, Filter(FullTableScan[User](tx), isEven), []*User{users[1], users[3]})
+		isempty(t, Filter(FullTableScan[User](tx), func(*User) bool { return false }))
+		deepEqual(t, Select(FullTableScan[User](tx), isEven), users[1])
+		deepEqual(t, Select(FullTableScan[User](tx), nil), users[0])
+		isnil(t, Select(FullTableScan[User](tx), func(*User) bool { return false }))
+		deepEqual(t, AllLimited(FullTableScan[User](tx), 3), users[:3])
+		deepEqual(t, AllLimited(FullTableScan[User](tx), 0), users)
+		deepEqual(t, First(FullReverseTableScan[User](tx)), users[3])
+
+		deepEqual(t, FindFirst(tx, usersByName, "u2", nil, isEven), users[1])
+		deepEqual(t, FindFirst(tx, usersByName, "u3", "u4", isEven), users[3])
+		isnil(t, FindFirst(tx, usersByName, "u3", "u3", isEven))
+		deepEqual(t, FindFirst[User](tx, usersByName, nil, nil, nil), users[0])
+	})
+}
+
+func TestDBReverseScanBug(t *testing.T) {
+	u3 := &User{ID: 3, Name: "bar", Email: "bar@example.com"}
+	u4 := &User{ID: 4, Name: "bar", Email: "bar2@example.com"}
+	u5 := &User{ID: 5, Name: "bar", Email: "bar3@example.com"}
+
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, u3)
+		Put(tx, u4)
+		Put(tx, u5)
+	})
+
+	db.Read(func(tx *Tx) {
+		rows := All(IndexScan[User](tx, usersByName, ExactScan("bar").Reversed()))
+		deepEqual(t, rows, []*User{u5, u4, u3})
+	})
+}
+
+func TestUpdateIndexValue(t *testing.T) {
+	u1 := &User{ID: 1, Name: "foo", Email: "foo@example.com"}
+
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, u1)
+		isnonnil(t, Lookup[User](tx, usersByName, "foo"))
+		isnil(t, Lookup[User](tx, usersByName, "bar"))
+
+		u1.Name = "bar"
+		Put(tx, u1)
+		isnonnil(t, Lookup[User](tx, usersByName, "bar"))
+		isnil(t, Lookup[User](tx, usersByName, "foo"))
+	})
+}
+
+func TestTrackTransactions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := setupOpt(t, basicSchema, Options{
+		TrackTransactions: true,
+		Now:               func() time.Time { return now },
+	})
+	deepEqual(t, db.DescribeOpenTxns(), "NO OPEN TRANSACTIONS")
+
+	tx := db.BeginRead()
+	now = now.Add(time.Second)
+	desc := db.DescribeOpenTxns()
+	if !strings.HasPrefix(desc, "1 OPEN TRANSACTIONS:") || !strings.Contains(desc, "open for 1000 ms:") || !strings.Contains(desc, "TestTrackTransactions") {
+		t.Errorf("DescribeOpenTxns = %q, wanted one transaction with a stack", desc)
+	}
+	tx.Close()
+	deepEqual(t, db.DescribeOpenTxns(), "NO OPEN TRANSACTIONS")
+
+	other := setup(t, basicSchema)
+	deepEqual(t, other.DescribeOpenTxns(), "OPEN TX TRACKING DISABLED")
+}
+
+func TestBatchRetries(t *testing.T) {
+	db := setupOpt(t, basicSchema, Options{
+		MaxBatchDelay: 250 * time.Millisecond,
+		MaxBatchSize:  2,
+	})
+	deepEqual(t, db.Bolt().MaxBatchSize, 2)
+	start := db.BatchRetries.Load()
+
+	// both transactions land in one batch, and whichever runs second fails
+	// after writing, so Bolt rolls back the batch, reruns the first one
+	// (a retry) and calls the failed one once more on its own (not a retry)
+	failure := errors.New("failure")
+	var started atomic.Int32
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var order int32
+			errs[i] = db.Tx(true, func(tx *Tx) error {
+				if order == 0 {
+					order = started.Add(1)
+				}
+				Put(tx, &User{ID: ID(i + 1), Name: "foo", Email: fmt.Sprintf("foo%d@example.com", i)})
+				if order == 2 {
+					return failure
+				}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	ok := 0
+	if errs[0] == failure {
+		ok = 1
+	}
+	deepEqual(t, errs[1-ok], failure)
+	deepEqual(t, errs[ok], nil)
+	deepEqual(t, db.BatchRetries.Load()-start, uint64(1))
+	db.Read(func(tx *Tx) {
+		isnil(t, Get[User](tx, ID(2-ok)))
+		deepEqual(t, Get[User](tx, ID(ok+1)).Name, "foo")
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	var logged sync.Mutex
+	var messages []string
+	db := setupOpt(t, basicSchema, Options{
+		SnapshotWarnAfter: 10 * time.Millisecond,
+		Logf: func(format string, args ...any) {
+			logged.Lock()
+			defer logged.Unlock()
+			messages = append(messages, fmt.Sprintf(format, args...))
+		},
+	})
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 1, Name: "foo", Email: "foo@example.com"})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	snap := must(db.Snapshot(ctx))
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 1, Name: "bar", Email: "bar@example.com"})
+	})
+
+	// reads see the data as of opening, from another goroutine too
+	done := make(chan *User)
+	go func() {
+		done <- Lookup[User](snap, usersByEmail, "foo@example.com")
+	}()
+	deepEqual(t, (<-done).Name, "foo")
+	deepEqual(t, TxContext(snap), ctx)
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		logged.Lock()
+		n := len(messages)
+		logged.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	logged.Lock()
+	if len(messages) != 1 || !strings.Contains(messages[0], "snapshot open for over 10ms") {
+		t.Errorf("logged %q, wanted a long snapshot warning", messages)
+	}
+	logged.Unlock()
+
+	// cancellation stops scans, but keeps the snapshot open until Close
+	cancel()
+	c := TableScan[User](snap, FullScan())
+	deepEqual(t, c.Next(), false)
+	deepEqual(t, c.Err(), context.Canceled)
+	select {
+	case <-snap.Done():
+		t.Fatal("snapshot closed by context cancellation")
+	default:
+	}
+	snap.Close()
+	<-snap.Done()
+	func() {
+		defer func() {
+			if e := recover(); e != ErrSnapshotClosed {
+				t.Errorf("got panic %v, wanted ErrSnapshotClosed", e)
+			}
+		}()
+		Get[User](snap, ID(1))
+	}()
+	snap.Close() // no-op
+
+	if _, err := db.Snapshot(ctx); err != context.Canceled {
+		t.Errorf("Snapshot with a canceled context = %v, wanted context.Canceled", err)
+	}
+
+	// a closed snapshot does not hold up writes or closing the database
+	snap = must(db.Snapshot(context.Background()))
+	snap.Close()
+	db.Write(func(tx *Tx) {
+		Put(tx, &User{ID: 2, Name: "boz", Email: "boz@example.com"})
+	})
+}
+
+func TestBackup(t *testing.T) {
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= 100; i++ {
+			Put(tx, &User{ID: ID(i), Name: fmt.Sprintf("u%d", i), Email: fmt.Sprintf("u%d@example.com", i)})
+		}
+		Put(tx, &Widget{Key: AB{1, 43}, Name: "w", Email: "w@example.com"})
+	})
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	f := must(os.Create(path))
+	n, err := db.Backup(f)
+	ensure(err)
+	ensure(f.Close())
+	deepEqual(t, n, must(os.Stat(path)).Size())
+
+	backup := must(Open(path, basicSchema, Options{IsTesting: true}))
+	defer backup.Close()
+	backup.Read(func(tx *Tx) {
+		deepEqual(t, tx.FastCount(usersTable), 100)
+		deepEqual(t, tx.FastCount(widgetsTable), 1)
+		deepEqual(t, Lookup[User](tx, usersByEmail, "u42@example.com").ID, ID(42))
+	})
+}
+
+func TestUpgradeValueFormat(t *testing.T) {
+	u1 := &User{ID: 1, Name: "foo", Email: "foo@example.com"}
+
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		Put(tx, u1)
+	})
+	deepEqual(t, db.UpgradeValueFormat(), 0)
+	db.Read(func(tx *Tx) {
+		deepEqual(t, Get[User](tx, 1), u1)
+	})
+
+	// enabling compression on an existing table
+	type Doc struct {
+		ID   ID     `msgpack:"-"`
+		Body string `msgpack:"b"`
+	}
+	docsSchema := func(compress bool) (*Schema, *Table) {
+		scm := &Schema{}
+		tbl := DefineTable(scm, "docs", func(b *TableBuilder[Doc, ID]) {
+			if compress {
+				b.CompressValues()
+			}
+		})
+		return scm, tbl
+	}
+	stored := func(tx *Tx, tbl *Table, key ID) value {
+		var vle value
+		decodeTableValue(&vle, tbl, tbl.EncodeKey(key), tx.getRawByRawKey(tbl, tbl.EncodeKey(key)))
+		return vle
+	}
+	big := &Doc{ID: 1, Body: strings.Repeat("hello world ", 1000)}
+	small := &Doc{ID: 2, Body: "hi"}
+
+	scm, docs := docsSchema(false)
+	db = setup(t, scm)
+	db.Write(func(tx *Tx) {
+		Put(tx, big)
+		Put(tx, small)
+		Put(tx, big)                           // no-op
+		Put(tx, &Doc{ID: 2, Body: small.Body}) // no-op
+	})
+	path := db.Bolt().Path()
+	db.Close()
+
+	scm, docs = docsSchema(true)
+	db = must(Open(path, scm, Options{IsTesting: true}))
+	deepEqual(t, db.UpgradeValueFormat(), 1)
+	db.Read(func(tx *Tx) {
+		vle := stored(tx, docs, 1)
+		deepEqual(t, vle.Flags, vfDefault|vfGzip)
+		deepEqual(t, vle.ModCount, uint64(1))
+		deepEqual(t, stored(tx, docs, 2).Flags, vfDefault) // too small to compress
+		deepEqual(t, Get[Doc](tx, ID(1)), big)
+		deepEqual(t, Get[Doc](tx, ID(2)), small)
+	})
+	deepEqual(t, db.UpgradeValueFormat(), 0)
+	db.Close()
+
+	// and disabling it again
+	scm, docs = docsSchema(false)
+	db = must(Open(path, scm, Options{IsTesting: true}))
+	defer db.Close()
+	deepEqual(t, db.UpgradeValueFormat(), 1)
+	db.Read(func(tx *Tx) {
+		deepEqual(t, stored(tx, docs, 1).Flags, vfDefault)
+		deepEqual(t, Get[Doc](tx, ID(1)), big)
+	})
+
+	var vle value
+	raw := appendValue(nil, vfDefault, 1, 1, 0, []byte{0x80}, []byte{0})
+	ensure(vle.decode(raw))
+	raw[0] = 0
+	if err := vle.decode(raw); err == nil {
+		t.Errorf("** decode of value with format version 0 succeeded")
+	}
+}
+
+func TestAutoUpgradeValueFormatClose(t *testing.T) {
+	type Doc struct {
+		ID   ID     `msgpack:"-"`
+		Body string `msgpack:"b"`
+	}
+	docsSchema := func(compress bool) *Schema {
+		scm := &Schema{}
+		DefineTable(scm, "docs", func(b *TableBuilder[Doc, ID]) {
+			if compress {
+				b.CompressValues()
+			}
+		})
+		return scm
+	}
+
+	const n = 3 * valueFormatUpgradeBatchSize
+	db := setup(t, docsSchema(false))
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= n; i++ {
+			Put(tx, &Doc{ID: ID(i), Body: strings.Repeat("hello world ", 100)})
+		}
+	})
+	path := db.Bolt().Path()
+	db.Close()
+
+	// closing right away must wait for the upgrade instead of crashing it
+	var logged atomic.Int64
+	for i := 0; i < 3; i++ {
+		db = must(Open(path, docsSchema(true), Options{
+			IsTesting:              true,
+			AutoUpgradeValueFormat: true,
+			Logf: func(format string, args ...any) {
+				logged.Add(1)
+			},
+		}))
+		db.Close()
+	}
+
+	db = must(Open(path, docsSchema(true), Options{IsTesting: true}))
+	defer db.Close()
+	remaining := db.UpgradeValueFormat()
+	if remaining < 0 || remaining > n {
+		t.Errorf("** UpgradeValueFormat = %d, wanted at most %d", remaining, n)
+	}
+	if remaining < n && logged.Load() == 0 {
+		t.Errorf("** background upgrade not logged via Logf")
+	}
+	deepEqual(t, db.UpgradeValueFormat(), 0)
+}
+
+func TestDetectTxLeaks(t *testing.T) {
+	t.Run("alone", func(t *testing.T) {
+		testDetectTxLeaks(t, false)
+	})
+	t.Run("tracked", func(t *testing.T) {
+		testDetectTxLeaks(t, true)
+	})
+}
+
+func testDetectTxLeaks(t *testing.T, track bool) {
+	leaks := make(chan string, 1)
+	db := setupOpt(t, basicSchema, Options{
+		DetectTxLeaks:     true,
+		TrackTransactions: track,
+		Logf: func(format string, args ...any) {
+			select {
+			case leaks <- fmt.Sprintf(format, args...):
+			default:
+			}
+		},
+	})
+
+	closed := db.BeginRead()
+	closed.Close()
+
+	btx := db.BeginRead().btx
+	defer btx.Rollback()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		runtime.GC()
+		select {
+		case msg := <-leaks:
+			if !strings.Contains(msg, "leaked read transaction") || !strings.Contains(msg, "testDetectTxLeaks") {
+				t.Fatalf("unexpected leak report: %s", msg)
+			}
+			if track && !strings.HasPrefix(db.DescribeOpenTxns(), "1 OPEN TRANSACTIONS:") {
+				t.Errorf("** DescribeOpenTxns = %q, wanted the leaked transaction", db.DescribeOpenTxns())
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Fatal("leaked transaction not reported")
+}
+
+func TestSizeWatchClose(t *testing.T) {
+	db := setup(t, basicSchema)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	db.SizeWatch(0, func(size int64) {
+		close(started)
+		<-release
+	})
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		db.Close()
+		close(closed)
+	}()
+	for !db.isStopping() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Close is waiting for the first watch, so a new one must not start
+	var fired atomic.Bool
+	db.SizeWatch(0, func(size int64) {
+		fired.Store(true)
+	})
+	close(release)
+	<-closed
+	if fired.Load() {
+		t.Errorf("** size watch fired while closing")
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	const maxSize = 256 * 1024
+	db := setupOpt(t, basicSchema, Options{MaxSize: maxSize})
+
+	watched := make(chan int64, 1)
+	db.SizeWatch(maxSize/2, func(size int64) {
+		watched <- size
+	})
+
+	catch := func(f func()) (err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				err = e.(error)
+			}
+		}()
+		f()
+		return nil
+	}
+
+	name := strings.Repeat("x", 4096)
+	var err error
+	var n int
+	for n = 1; n <= 100 && err == nil; n++ {
+		err = catch(func() {
+			db.Write(func(tx *Tx) {
+				Put(tx, &User{ID: ID(n), Name: name, Email: fmt.Sprintf("u%d@example.com", n)})
+			})
+		})
+	}
+	if !errors.Is(err, ErrDatabaseFull) {
+		t.Fatalf("expected ErrDatabaseFull, got %v after %d puts", err, n)
+	}
+	// the check does not account for page overhead, so allow some slack
+	db.Read(func(tx *Tx) {
+		st := db.Bolt().Stats()
+		used := tx.btx.Size() - int64(st.FreePageN+st.PendingPageN)*int64(db.Bolt().Info().PageSize)
+		if used > maxSize+64*1024 {
+			t.Errorf("database uses %d bytes", used)
+		}
+	})
+	select {
+	case size := <-watched:
+		if size < maxSize/2 {
+			t.Errorf("size watch fired at %d bytes", size)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("size watch did not fire")
+	}
+
+	err = catch(func() {
+		db.Write(func(tx *Tx) {
+			tx.KVPutRaw(kubets, []byte("k"), make([]byte, maxSize))
+		})
+	})
+	if !errors.Is(err, ErrDatabaseFull) {
+		t.Errorf("expected ErrDatabaseFull from KVPutRaw, got %v", err)
+	}
+
+	// deletes free pages for later puts, even though the file doesn't shrink
+	db.Write(func(tx *Tx) {
+		for i := 1; i < n; i++ {
+			DeleteByKey[User](tx, ID(i))
+		}
+	})
+	db.Write(func(tx *Tx) {
+		for i := 1; i < n/2; i++ {
+			Put(tx, &User{ID: ID(i), Name: name, Email: fmt.Sprintf("u%d@example.com", i)})
+		}
+	})
+}
+
+func TestMultiTableScan(t *testing.T) {
+	type Note struct {
+		ID   ID     `msgpack:"-"`
+		Text string `msgpack:"t"`
+	}
+	type ArchivedNote struct {
+		ID   ID     `msgpack:"-"`
+		Text string `msgpack:"t"`
+	}
+	scm := &Schema{}
+	notesTable := DefineTable(scm, "notes", func(b *TableBuilder[Note, ID]) {})
+	archivedTable := DefineTable(scm, "archived_notes", func(b *TableBuilder[ArchivedNote, ID]) {})
+
+	db := setup(t, scm)
+	db.Write(func(tx *Tx) {
+		Put(tx, &Note{ID: 2, Text: "b"}, &Note{ID: 300, Text: "e"})
+		Put(tx, &ArchivedNote{ID: 1, Text: "a"}, &ArchivedNote{ID: 3, Text: "c"}, &ArchivedNote{ID: 4, Text: "d"})
+	})
+
+	scan := func(tx *Tx, opt ScanOptions, max int) []string {
+		var result []string
+		MultiTableScan(tx, []*Table{notesTable, archivedTable}, opt, func(tbl *Table, key any, row any) bool {
+			var text string
+			switch row := row.(type) {
+			case *Note:
+				text = row.Text
+			case *ArchivedNote:
+				text = row.Text
+			}
+			result = append(result, fmt.Sprintf("%s/%v/%s", tbl.Name(), key, text))
+			return len(result) < max
+		})
+		return result
+	}
+	db.Read(func(tx *Tx) {
+		deepEqual(t, scan(tx, FullScan(), 100), []string{"archived_notes/1/a", "notes/2/b", "archived_notes/3/c", "archived_notes/4/d", "notes/300/e"})
+		deepEqual(t, scan(tx, FullScan().Reversed(), 100), []string{"notes/300/e", "archived_notes/4/d", "archived_notes/3/c", "notes/2/b", "archived_notes/1/a"})
+		deepEqual(t, scan(tx, RangeScan(ID(2), ID(3), true, true), 100), []string{"notes/2/b", "archived_notes/3/c"})
+		deepEqual(t, scan(tx, FullScan(), 2), []string{"archived_notes/1/a", "notes/2/b"})
+	})
+}
+
+type Event struct {
+	ID     ID     `msgpack:"-"`
+	Entity string `msgpack:"e"`
+}
+
+func eventSchema(shards int) (*Schema, *Index) {
+	scm := &Schema{}
+	eventsByEntity := AddIndex[string]("entity", IndexShards(shards))
+	AddTable(scm, "events", 1, func(row *Event, ib *IndexBuilder) {
+		ib.Add(eventsByEntity, row.Entity)
+	}, nil, []*Index{eventsByEntity})
+	return scm, eventsByEntity
+}
+
+func TestShardedIndex(t *testing.T) {
+	scm, byEntity := eventSchema(4)
+	db := setup(t, scm)
+
+	var all []ID
+	db.Write(func(tx *Tx) {
+		for i := 1; i <= 40; i++ {
+			entity := "a"
+			if i%3 == 0 {
+				entity = "b"
+			}
+			Put(tx, &Event{ID: ID(i), Entity: entity})
+		}
+		Put(tx, &Event{ID: 41, Entity: "c"})
+		DeleteByKey[Event](tx, ID(41))
+		Put(tx, &Event{ID: 40, Entity: "b"}) // moves from "a" to "b"
+	})
+	for i := 1; i <= 40; i++ {
+		if i%3 != 0 && i != 40 {
+			all = append(all, ID(i))
+		}
+	}
+	for i := 1; i <= 40; i++ {
+		if i%3 == 0 || i == 40 {
+			all = append(all, ID(i))
+		}
+	}
+	ids := func(c Cursor[Event]) []ID {
+		var result []ID
+		for c.Next() {
+			result = append(result, c.Row().ID)
+		}
+		return result
+	}
+	reversed := func(ids []ID) []ID {
+		ids = slices.Clone(ids)
+		slices.Reverse(ids)
+		return ids
+	}
+	check := func(db *DB) {
+		t.Helper()
+		db.Read(func(tx *Tx) {
+			deepEqual(t, ids(FullIndexScan[Event](tx, byEntity)), all)
+			deepEqual(t, ids(IndexScan[Event](tx, byEntity, FullScan().Reversed())), reversed(all))
+			deepEqual(t, ids(ExactIndexScan[Event](tx, byEntity, "b")), all[26:])
+			deepEqual(t, ids(ReverseExactIndexScan[Event](tx, byEntity, "b")), reversed(all[26:]))
+			deepEqual(t, ids(RangeIndexScan[Event](tx, byEntity, "a", "a", true, true)), all[:26])
+			deepEqual(t, ids(IndexScan[Event](tx, byEntity, NewScan().Reverse().Limit(3).Options())), reversed(all)[:3])
+			isempty(t, ids(ExactIndexScan[Event](tx, byEntity, "c")))
+			deepEqual(t, Lookup[Event](tx, byEntity, "a").ID, ID(1))
+			deepEqual(t, Lookup[Event](tx, byEntity, "b").ID, ID(3))
+			isnil(t, Lookup[Event](tx, byEntity, "c"))
+			deepEqual(t, tx.TableStats(byEntity.Table()).IndexRows, int64(40))
+		})
+	}
+	check(db)
+
+	// changing the number of shards rebuilds the index
+	path := db.Bolt().Path()
+	db.Close()
+	for _, shards := range []int{1, 3} {
+		scm, byEntity = eventSchema(shards)
+		db = must(Open(path, scm, Options{IsTesting: true}))
+		check(db)
+		db.Close()
+	}
+}
+
+type Delta struct {
+	ID     int64 `msgpack:"-"`
+	Amount int   `msgpack:"a"`
+}
+
+func deltaSchema() (*Schema, *Table, *Index) {
+	scm := &Schema{}
+	deltasByAmount := AddIndex[int]("amount")
+	tbl := DefineTable(scm, "deltas", func(b *TableBuilder[Delta, int64]) {
+		b.AddIndex(deltasByAmount)
+		b.Indexer(func(row *Delta, ib *IndexBuilder) {
+			ib.Add(deltasByAmount, row.Amount)
+		})
+	})
+	return scm, tbl, deltasByAmount
+}
+
+func deltaIDs(c Cursor[Delta]) []int64 {
+	var result []int64
+	for _, row := range All(c) {
+		result = append(result, row.ID)
+	}
+	return result
+}
+
+func putDeltas(db *DB) {
+	db.Write(func(tx *Tx) {
+		for _, id := range []int64{3, -1, -3, 2, 1, -2, math.MinInt64, math.MaxInt64} {
+			Put(tx, &Delta{ID: id, Amount: int(-id)})
+		}
+	})
+}
+
+func checkSignedKeyOrder(t *testing.T, db *DB, byAmount *Index) {
+	t.Helper()
+	db.Read(func(tx *Tx) {
+		deepEqual(t, deltaIDs(TableScan[Delta](tx, FullScan())), []int64{math.MinInt64, -3, -2, -1, 1, 2, 3, math.MaxInt64})
+		deepEqual(t, deltaIDs(TableScan[Delta](tx, RangeScan(int64(-2), int64(1), true, true))), []int64{-2, -1, 1})
+		deepEqual(t, deltaIDs(TableScan[Delta](tx, FullScan().Reversed())), []int64{math.MaxInt64, 3, 2, 1, -1, -2, -3, math.MinInt64})
+		deepEqual(t, deltaIDs(RangeIndexScan[Delta](tx, byAmount, -1, 2, true, true)), []int64{1, -1, -2})
+		deepEqual(t, Lookup[Delta](tx, byAmount, -3).ID, int64(3))
+		deepEqual(t, Get[Delta](tx, int64(-3)).Amount, 3)
+	})
+}
+
+func TestSignedKeyOrder(t *testing.T) {
+	scm, _, byAmount := deltaSchema()
+	db := setup(t, scm)
+	putDeltas(db)
+	checkSignedKeyOrder(t, db, byAmount)
+}
+
+func TestUpgradeLegacySignedKeys(t *testing.T) {
+	scm, tbl, byAmount := deltaSchema()
+	db := setup(t, scm)
+	putDeltas(db)
+
+	// flipping sign bits is its own inverse, so this reverts to format 0
+	db.Write(func(tx *Tx) {
+		upgradeLegacyTableKeys(tbl, tbl.rootBucketIn(tx.btx))
+		ts := tx.db.tableState(tbl)
+		ts.KeyFormat = 0
+		ts.save(tx)
+	})
+	db.Read(func(tx *Tx) {
+		isnil(t, Get[Delta](tx, int64(-3)))
+	})
+
+	path := db.Bolt().Path()
+	db.Close()
+	db = must(Open(path, scm, Options{IsTesting: true}))
+	defer db.Close()
+	checkSignedKeyOrder(t, db, byAmount)
+	db.Read(func(tx *Tx) {
+		deepEqual(t, tx.db.tableState(tbl).KeyFormat, currentKeyFormat)
+		deepEqual(t, tx.TableStats(tbl).IndexRows, int64(8))
+	})
+}
+
+func BenchmarkShardedIndexPut(b *testing.B) {
+	for _, shards := range []int{1, 8} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			scm, _ := eventSchema(shards)
+			db := setup(b, scm)
+			b.ResetTimer()
+			for i := 0; i < b.N; i += 1000 {
+				db.Write(func(tx *Tx) {
+					for j := i; j < i+1000 && j < b.N; j++ {
+						Put(tx, &Event{ID: ID(j + 1), Entity: "a"})
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestMultiValuedIndex(t *testing.T) {
+	type Article struct {
+		ID   ID       `msgpack:"-"`
+		Tags []string `msgpack:"t"`
+	}
+	scm := &Schema{}
+	byTag := AddIndex[string]("tag")
+	AddTable(scm, "articles", 1, func(row *Article, ib *IndexBuilder) {
+		for _, tag := range row.Tags {
+			ib.Add(byTag, tag)
+		}
+	}, nil, []*Index{byTag})
+	db := setup(t, scm)
+
+	tagged := func(tx *Tx, tag string) []ID {
+		var ids []ID
+		for _, a := range All(IndexScan[Article](tx, byTag, ExactScan(tag))) {
+			ids = append(ids, a.ID)
+		}
+		return ids
+	}
+	db.Write(func(tx *Tx) {
+		Put(tx, &Article{ID: 1, Tags: []string{"go", "db", "bolt"}})
+		Put(tx, &Article{ID: 2, Tags: []string{"db"}})
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, tagged(tx, "go"), []ID{1})
+		deepEqual(t, tagged(tx, "db"), []ID{1, 2})
+		deepEqual(t, tagged(tx, "bolt"), []ID{1})
+		deepEqual(t, tx.IndexKeyCount(byTag), 4)
+	})
+	db.Write(func(tx *Tx) {
+		Put(tx, &Article{ID: 1, Tags: []string{"db", "mmap"}})
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, tagged(tx, "go"), []ID(nil))
+		deepEqual(t, tagged(tx, "bolt"), []ID(nil))
+		deepEqual(t, tagged(tx, "db"), []ID{1, 2})
+		deepEqual(t, tagged(tx, "mmap"), []ID{1})
+		deepEqual(t, tx.IndexKeyCount(byTag), 3)
+	})
+	db.Write(func(tx *Tx) {
+		DeleteByKey[Article](tx, ID(1))
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, tagged(tx, "db"), []ID{2})
+		deepEqual(t, tx.IndexKeyCount(byTag), 1)
+	})
+}
+
+func TestCoveringIndex(t *testing.T) {
+	type Item struct {
+		ID    ID     `msgpack:"-"`
+		Code  string `msgpack:"c"`
+		Group string `msgpack:"g"`
+		Price int    `msgpack:"p"`
+	}
+	price := Covering(func(row any) []byte {
+		return []byte(strconv.Itoa(row.(*Item).Price))
+	})
+	scm := &Schema{}
+	byCode := AddIndex[string]("code", price).Unique()
+	byGroup := AddIndex[string]("group", price, IndexShards(2))
+	tbl := AddTable(scm, "items", 1, func(row *Item, ib *IndexBuilder) {
+		ib.Add(byCode, row.Code)
+		ib.Add(byGroup, row.Group)
+	}, nil, []*Index{byCode, byGroup})
+	db := setup(t, scm)
+
+	covered := func(tx *Tx, idx *Index) []string {
+		var result []string
+		c := tx.IndexScan(idx, FullScan())
+		for c.Next() {
+			result = append(result, fmt.Sprintf("%v=%s", c.Key(), c.CoveredValue()))
+		}
+		return result
+	}
+	db.Write(func(tx *Tx) {
+		Put(tx, &Item{ID: 1, Code: "a", Group: "x", Price: 10})
+		Put(tx, &Item{ID: 2, Code: "b", Group: "x", Price: 20})
+		Put(tx, &Item{ID: 3, Code: "c", Group: "y", Price: 30})
+	})
+	db.Write(func(tx *Tx) {
+		Put(tx, &Item{ID: 2, Code: "b", Group: "y", Price: 25})
+	})
+	db.Write(func(tx *Tx) {
+		deepEqual(t, covered(tx, byCode), []string{"1=10", "2=25", "3=30"})
+		deepEqual(t, covered(tx, byGroup), []string{"1=10", "2=25", "3=30"})
+		deepEqual(t, Lookup[Item](tx, byCode, "b").Price, 25)
+		deepEqual(t, len(All(IndexScan[Item](tx, byGroup, ExactScan("y")))), 2)
+
+		// covered values are read from the index alone
+		dataBuck := tbl.dataBucketIn(tbl.rootBucketIn(tx.btx))
+		ensure(dataBuck.Delete(tbl.EncodeKey(ID(1))))
+		c := tx.IndexScan(byCode, ExactScan("a"))
+		deepEqual(t, c.Next(), true)
+		deepEqual(t, string(c.CoveredValue()), "10")
+		deepEqual(t, c.RawRow(), []byte(nil))
+	})
+
+	// dropping the option rebuilds the index without covered values
+	path := db.Bolt().Path()
+	db.Close()
+	scm2 := &Schema{}
+	byCode2 := AddIndex[string]("code").Unique()
+	AddTable(scm2, "items", 1, func(row *Item, ib *IndexBuilder) {
+		ib.Add(byCode2, row.Code)
+	}, nil, []*Index{byCode2})
+	db = must(Open(path, scm2, Options{IsTesting: true}))
+	defer db.Close()
+	db.Read(func(tx *Tx) {
+		deepEqual(t, covered(tx, byCode2), []string{"2=", "3="})
+		deepEqual(t, Lookup[Item](tx, byCode2, "c").Price, 30)
+	})
+}
+
+func TestFastCount(t *testing.T) {
+	scm, byEntity := eventSchema(4)
+	db := setup(t, scm)
+	tbl := scm.TableByRow(&Event{})
+	db.Write(func(tx *Tx) {
+		deepEqual(t, tx.FastCount(tbl), 0)
+		deepEqual(t, tx.IndexKeyCount(byEntity), 0)
+		for i := 1; i <= 50; i++ {
+			Put(tx, &Event{ID: ID(i), Entity: strconv.Itoa(i % 7)})
+		}
+		DeleteByKey[Event](tx, ID(10))
+	})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, tx.FastCount(tbl), 49)
+		deepEqual(t, tx.FastCount(tbl), CountAll(tx, tbl))
+		deepEqual(t, tx.FastCount(tbl), len(All(TableScan[Event](tx, FullScan()))))
+		deepEqual(t, tx.IndexKeyCount(byEntity), 49)
+
+		s := tx.TableStats(tbl)
+		deepEqual(t, s.Rows, int64(49))
+		deepEqual(t, s.IndexRows, int64(49))
+		if s.TotalSize() <= 0 || s.TotalAlloc() < s.TotalSize() {
+			t.Errorf("TotalSize = %d, TotalAlloc = %d, wanted 0 < size <= alloc", s.TotalSize(), s.TotalAlloc())
+		}
+	})
+}
+
+func TestPutBatch(t *testing.T) {
+	scm, byEntity := eventSchema(4)
+	db := setupOpt(t, scm, Options{})
+	var log []string
+	db.Write(func(tx *Tx) {
+		tx.OnChange(map[*Table]ChangeFlags{scm.TableByRow(&Event{}): ChangeFlagNotify | ChangeFlagIncludeKey}, func(tx *Tx, chg *Change) {
+			log = append(log, fmt.Sprintf("%s %v", chg.Op(), chg.Key()))
+		})
+		Put(tx, &Event{ID: 2, Entity: "a"})
+		tbl := scm.TableByRow(&Event{})
+		var rows []reflect.Value
+		for i := 1; i <= 4; i++ {
+			rows = append(rows, reflect.ValueOf(&Event{ID: ID(i), Entity: "b"}))
+		}
+		deepEqual(t, tx.PutBatch(tbl, rows), true)
+		deepEqual(t, tx.PutBatch(tbl, rows[:2]), false)
+	})
+	deepEqual(t, log, []string{"put 2", "put 1", "put 2", "put 3", "put 4"})
+	db.Read(func(tx *Tx) {
+		deepEqual(t, len(All(IndexScan[Event](tx, byEntity, ExactScan("a")))), 0)
+		deepEqual(t, len(All(IndexScan[Event](tx, byEntity, ExactScan("b")))), 4)
+	})
+}
+
+func BenchmarkPutBatch(b *testing.B) {
+	const n = 10000
+	scm, _ := eventSchema(8)
+	tbl := scm.TableByRow(&Event{})
+	rows := make([]reflect.Value, n)
+	for i := range rows {
+		rows[i] = reflect.ValueOf(&Event{ID: ID(i + 1), Entity: strconv.Itoa(i % 100)})
+	}
+	b.Run("per-row", func(b *testing.B) {
+		db := setup(b, scm)
+		for i := 0; i < b.N; i++ {
+			db.Write(func(tx *Tx) {
+				for _, row := range rows {
+					tx.PutVal(tbl, row)
+				}
+			})
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		db := setup(b, scm)
+		for i := 0; i < b.N; i++ {
+			db.Write(func(tx *Tx) {
+				tx.PutBatch(tbl, rows)
+			})
+		}
+	})
+}
+
+// boltSeekLastLinear is the straightforward version of boltSeekLast, used as
+// a reference in tests and benchmarks.
+func boltSeekLastLinear(c *bbolt.Cursor, prefix []byte) ([]byte, []byte) {
+	k, _ := c.Seek(prefix)
+	for k != nil && bytes.HasPrefix(k, prefix) {
+		k, _ = c.Next()
+	}
+	if k == nil {
+		return c.Last()
+	}
+	return c.Prev()
+}
+
+func TestBoltSeekLast(t *testing.T) {
+	db := setup(t, &Schema{})
+	keys := [][]byte{
+		{0x01}, {0x01, 0x00}, {0x01, 0x05}, {0x01, 0xFF}, {0x01, 0xFF, 0x05},
+		{0x02}, {0x02, 0x00, 0x01}, {0x05, 0xFF}, {0xFF, 0xFF, 0x01},
+	}
+	ensure(db.Bolt().Update(func(btx *bbolt.Tx) error {
+		b := must(btx.CreateBucket([]byte("seek")))
+		for _, k := range keys {
+			ensure(b.Put(k, []byte{1}))
+		}
+		return nil
+	}))
+	prefixes := [][]byte{
+		{0x00}, {0x01}, {0x01, 0x05}, {0x01, 0xFF}, {0x01, 0xFF, 0xFF}, {0x02},
+		{0x02, 0x00}, {0x03}, {0x05, 0xFF}, {0xFF}, {0xFF, 0xFF}, {0xFF, 0xFF, 0xFF},
+	}
+	ensure(db.Bolt().View(func(btx *bbolt.Tx) error {
+		c := btx.Bucket([]byte("seek")).Cursor()
+		for _, prefix := range prefixes {
+			orig := bytes.Clone(prefix)
+			expected, _ := boltSeekLastLinear(c, prefix)
+			actual, _ := boltSeekLast(c, prefix)
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("boltSeekLast(%x) = %x, wanted %x", prefix, actual, expected)
+			}
+			deepEqual(t, prefix, orig)
+		}
+		return nil
+	}))
+}
+
+func BenchmarkBoltSeekLast(b *testing.B) {
+	db := setup(b, &Schema{})
+	ensure(db.Bolt().Update(func(btx *bbolt.Tx) error {
+		bk := must(btx.CreateBucket([]byte("seek")))
+		for i := range 100000 {
+			ensure(bk.Put(binary.BigEndian.AppendUint32([]byte{0x01}, uint32(i)), []byte{1}))
+		}
+		ensure(bk.Put([]byte{0x02}, []byte{1}))
+		return nil
+	}))
+	for _, impl := range []struct {
+		name string
+		f    func(c *bbolt.Cursor, prefix []byte) ([]byte, []byte)
+	}{{"linear", boltSeekLastLinear}, {"increment", boltSeekLast}} {
+		b.Run(impl.name, func(b *testing.B) {
+			ensure(db.Bolt().View(func(btx *bbolt.Tx) error {
+				c := btx.Bucket([]byte("seek")).Cursor()
+				for i := 0; i < b.N; i++ {
+					impl.f(c, []byte{0x01})
+				}
+				return nil
+			}))
+		})
+	}
+}
+
+func TestNotifyChanges(t *testing.T) {
+	type Note struct {
+		ID   ID     `msgpack:"-"`
+		Text string `msgpack:"t"`
+	}
+	scm := &Schema{}
+	notesTable := DefineTable(scm, "notes", func(b *TableBuilder[Note, ID]) {
+		b.NotifyChanges(ChangeFlagIncludeKey)
+	})
+	type Draft Note
+	draftsTable := DefineTable(scm, "drafts", func(b *TableBuilder[Draft, ID]) {})
+
+	var log []string
+	db := setupOpt(t, scm, Options{
+		OnChange: func(tx *Tx, chg *Change) {
+			log = append(log, fmt.Sprintf("%s %s %v", chg.Table().Name(), chg.Op(), chg.Key()))
+		},
+	})
+	db.Write(func(tx *Tx) {
+		Put(tx, &Note{ID: 1, Text: "foo"})
+		Put(tx, &Note{ID: 2, Text: "bar"})
+		DeleteByKey[Note](tx, ID(1))
+	})
+	deepEqual(t, log, []string{"notes put 1", "notes put 2", "notes delete 1"})
+
+	log = nil
+	db.Write(func(tx *Tx) {
+		var txLog []string
+		tx.OnChange(map[*Table]ChangeFlags{notesTable: ChangeFlagIncludeRow}, func(tx *Tx, chg *Change) {
+			txLog = append(txLog, chg.Row().(*Note).Text)
+		})
+		Put(tx, &Note{ID: 3, Text: "boz"})
+		deepEqual(t, txLog, []string{"boz"})
+	})
+	deepEqual(t, log, []string{"notes put 3"})
+
+	// buffering doesn't hide changes from the global handler either, which
+	// doesn't get changes to tables it's not subscribed to
+	log = nil
+	tx := db.BeginUpdate()
+	defer tx.Close()
+	tx.BufferChanges(map[*Table]ChangeFlags{draftsTable: ChangeFlagNotify})
+	Put(tx, &Note{ID: 4, Text: "fubar"})
+	Put(tx, &Draft{ID: 1, Text: "draft"})
+	ensure(tx.Commit())
+	deepEqual(t, log, []string{"notes put 4"})
+	deepEqual(t, len(tx.CollectChanges()), 2)
+}
+
+func TestCollectChanges(t *testing.T) {
+	db := setup(t, basicSchema)
+	tx := db.BeginUpdate()
+	tx.BufferChanges(map[*Table]ChangeFlags{
+		usersTable:   ChangeFlagNotify | ChangeFlagIncludeKey | ChangeFlagIncludeRow,
+		widgetsTable: ChangeFlagNotify,
+	})
+	Put(tx, &User{ID: 1, Email: "foo@example.com"})
+	Put(tx, &User{ID: 2, Email: "bar@example.com"})
+	Put(tx, &Widget{Key: AB{1, 2}, Name: "w"})
+	DeleteByKey[User](tx, ID(1))
+	Put(tx, &User{ID: 3, Email: "boz@example.com"})
+	Put(tx, &Post{ID: "p", Content: "not tracked"})
+	ensure(tx.Commit())
+	tx.Close()
+
+	changes := tx.CollectChanges()
+	var log []string
+	for _, chg := range changes {
+		s := fmt.Sprintf("%s %s %x", chg.Table().Name(), chg.Op(), chg.RawKey())
+		if chg.HasKey() {
+			s += fmt.Sprintf(" %v", chg.Key())
+		}
+		if chg.HasRow() {
+			s += " " + chg.Row().(*User).Email
+		}
+		log = append(log, s)
+	}
+	uk := func(id ID) []byte { return usersTable.EncodeKey(id) }
+	deepEqual(t, log, []string{
+		fmt.Sprintf("Users put %x 1 foo@example.com", uk(1)),
+		fmt.Sprintf("Users put %x 2 bar@example.com", uk(2)),
+		fmt.Sprintf("Widgets put %x", widgetsTable.EncodeKey(AB{1, 2})),
+		fmt.Sprintf("Users delete %x 1 foo@example.com", uk(1)),
+		fmt.Sprintf("Users put %x 3 boz@example.com", uk(3)),
+	})
+	deepEqual(t, len(tx.CollectChanges()), 0)
+}
+
+func TestRawScan(t *testing.T) {
+	var (
+		kb = x("10 12 14 40 44 47")
+		k1 = x("10 12 14 40 44 48")
+		k2 = x("10 12 14 40 44 49")
+		k3 = x("10 12 14 40 44 50")
+		k4 = x("10 12 14 40 44 51")
+		ke = x("10 12 14 40 44 52")
+		p  = x("10 12 14")
+	)
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		tx.KVPutRaw(kubets, k1, []byte{})
+		tx.KVPutRaw(kubets, k2, []byte{})
+		tx.KVPutRaw(kubets, k3, []byte{})
+		tx.KVPutRaw(kubets, k4, []byte{})
+	})
+
+	o := func(name string, rang RawRange, exp ...[]byte) {
+		t.Helper()
+		t.Run(name, func(t *testing.T) {
+			db.Read(func(tx *Tx) {
+				slog.Debug(t.Name())
+				tableScan(t, tx, kubets, rang, exp...)
+			})
+		})
+	}
+
+	o("prefix", RawRange{Prefix: p}, k1, k2, k3, k4)
+	o("prefix reverse", RawRange{Prefix: p, Reverse: true}, k4, k3, k2, k1)
+
+	o("prefix + lower inc", RawRange{Prefix: p, Lower: k2, LowerInc: true}, k2, k3, k4)
+	o("prefix + lower exc", RawRange{Prefix: p, Lower: k2, LowerInc: false}, k3, k4)
+	o("prefix + lower inc reverse", RawRange{Prefix: p, Lower: k2, LowerInc: true, Reverse: true}, k4, k3, k2)
+	o("prefix + lower exc reverse", RawRange{Prefix: p, Lower: k2, LowerInc: false, Reverse: true}, k4, k3)
+	o("prefix + upper inc", RawRange{Prefix: p, Upper: k3, UpperInc: true}, k1, k2, k3)
+	o("prefix + upper exc", RawRange{Prefix: p, Upper: k3, UpperInc: false}, k1, k2)
+	o("prefix + upper inc reverse", RawRange{Prefix: p, Upper: k3, UpperInc: true, Reverse: true}, k3, k2, k1)
+	o("prefix + upper exc reverse", RawRange{Prefix: p, Upper: k3, UpperInc: false, Reverse: true}, k2, k1)
+
+	o("lower inc", RawRange{Lower: k2, LowerInc: true}, k2, k3, k4)
+	o("lower exc", RawRange{Lower: k2, LowerInc: false}, k3, k4)
+	o("lower inc reverse", RawRange{Lower: k2, LowerInc: true, Reverse: true}, k4, k3, k2)
+	o("lower exc reverse", RawRange{Lower: k2, LowerInc: false, Reverse: true}, k4, k3)
+
+	o("upper inc", RawRange{Upper: k3, UpperInc: true}, k1, k2, k3)
+	o("upper exc", RawRange{Upper: k3, UpperInc: false}, k1, k2)
+	o("upper inc reverse", RawRange{Upper: k3, UpperInc: true, Reverse: true}, k3, k2, k1)
+	o("upper exc reverse", RawRange{Upper: k3, UpperInc: false, Reverse: true}, k2, k1)
+
+	o("first lower inc", RawRange{Lower: kb, LowerInc: true}, k1, k2, k3, k4)
+	o("first lower exc", RawRange{Lower: kb, LowerInc: false}, k1, k2, k3, k4)
+	o("first lower inc reverse", RawRange{Lower: kb, LowerInc: true, Reverse: true}, k4, k3, k2, k1)
+	o("first lower exc reverse", RawRange{Lower: kb, LowerInc: false, Reverse: true}, k4, k3, k2, k1)
+
+	o("last upper inc", RawRange{Upper: ke, UpperInc: true}, k1, k2, k3, k4)
+	o("last upper exc", RawRange{Upper: ke, UpperInc: false}, k1, k2, k3, k4)
+	o("last upper inc reverse", RawRange{Upper: ke, UpperInc: true, Reverse: true}, k4, k3, k2, k1)
+	o("last upper exc reverse", RawRange{Upper: ke, UpperInc: false, Reverse: true}, k4, k3, k2, k1)
+}
+
+func TestRawScanAllFFPrefix(t *testing.T) {
+	var (
+		k0 = x("fe ff ff")
+		k1 = x("ff ff")
+		k2 = x("ff ff 00")
+		k3 = x("ff ff 7f")
+		k4 = x("ff ff ff ff")
+		p  = x("ff ff")
+	)
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		for _, k := range [][]byte{k0, k1, k2, k3, k4} {
+			tx.KVPutRaw(kubets, k, []byte{})
+		}
+	})
+	db.Read(func(tx *Tx) {
+		tableScan(t, tx, kubets, RawRange{Prefix: p}, k1, k2, k3, k4)
+		tableScan(t, tx, kubets, RawRange{Prefix: p, Reverse: true}, k4, k3, k2, k1)
+		tableScan(t, tx, kubets, RawRange{Prefix: x("ff ff ff"), Reverse: true}, k4)
+		tableScan(t, tx, kubets, RawRange{Prefix: x("ff ff ff ff ff"), Reverse: true})
+		tableScan(t, tx, kubets, RawRange{Prefix: p, Upper: k3, UpperInc: false, Reverse: true}, k2, k1)
+		deepEqual(t, p, x("ff ff")) // prefix must not be mutated
+	})
+}
+
+func setup(t testing.TB, schema *Schema) *DB {
+	t.Helper()
+	return setupOpt(t, schema, Options{})
+}
+
+func setupOpt(t testing.TB, schema *Schema, opt Options) *DB {
+	t.Helper()
+
+	dbFile := must(os.CreateTemp("", "db_test_*.db"))
+	t.Logf("DB: %s", dbFile.Name())
+	dbFile.Close()
+
+	opt.IsTesting = true
+	db := must(Open(dbFile.Name(), schema, opt))
 	t.Cleanup(db.Close)
 	return db
 }
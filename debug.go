@@ -81,11 +81,13 @@ func (tx *Tx) dumpIndex(w *strings.Builder, prefix string, f DumpFlags, idx *Ind
 	fmt.Fprintf(w, "%s (0x%x)%s\n", prefix, is.IndexOrdinal, map[bool]string{false: " PENDING", true: ""}[is.Built])
 
 	if f.Contains(DumpIndexRows) {
-		c := idx.bucketIn(rootB).Cursor()
 		var rowPos int
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			rowPos++
-			tx.dumpIndexRow(w, prefix, f, idx, rowPos, k, v)
+		for shard := range idx.ShardCount() {
+			c := idx.shardBucketIn(rootB, shard).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				rowPos++
+				tx.dumpIndexRow(w, prefix, f, idx, rowPos, k, v)
+			}
 		}
 	}
 }
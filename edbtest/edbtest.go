@@ -0,0 +1,174 @@
+// Package edbtest provides helpers for testing code built on edb.
+package edbtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/edb"
+)
+
+// RunAllScanModes exercises forward and reverse table scans of tbl in all
+// supported modes (full, exact, prefix of a struct key, bounded and half-open
+// ranges with inclusive and exclusive bounds), comparing each result against
+// the expected subset of the full table scan. Modes that fail with
+// edb.ErrUnsupportedScan are reported as skipped subtests.
+//
+// The table should hold a few rows with distinct keys for meaningful coverage.
+func RunAllScanModes(t *testing.T, db *edb.DB, tbl *edb.Table) {
+	tx := db.BeginRead()
+	defer tx.Close()
+
+	keys := edb.AllUntypedKeys(tx.TableScan(tbl, edb.FullScan()))
+	raws := make([][]byte, len(keys))
+	for i, k := range keys {
+		raws[i] = tbl.EncodeKey(k)
+	}
+	if len(keys) == 0 {
+		t.Fatalf("%s: table is empty, nothing to scan", tbl.Name())
+	}
+
+	run := func(name string, opt edb.ScanOptions, match func(raw []byte) bool) {
+		t.Helper()
+		for _, reverse := range []bool{false, true} {
+			opt, name := opt, name
+			if reverse {
+				opt, name = opt.Reversed(), "reverse/"+name
+			}
+			t.Run(name, func(t *testing.T) {
+				var expected []string
+				for i, raw := range raws {
+					if match(raw) {
+						expected = append(expected, fmt.Sprint(keys[i]))
+					}
+				}
+				if reverse {
+					for i, j := 0, len(expected)-1; i < j; i, j = i+1, j-1 {
+						expected[i], expected[j] = expected[j], expected[i]
+					}
+				}
+
+				actual, err := scanKeys(tx, tbl, opt)
+				if errors.Is(err, edb.ErrUnsupportedScan) {
+					t.Skip(err)
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if a, e := strings.Join(actual, " "), strings.Join(expected, " "); a != e {
+					t.Errorf("%s %s: got [%s], wanted [%s]", tbl.Name(), opt.LogString(), a, e)
+				}
+			})
+		}
+	}
+
+	run("full", edb.FullScan(), func([]byte) bool { return true })
+
+	picks := pickIndices(len(keys))
+	for _, i := range picks {
+		rawI := raws[i]
+		run(fmt.Sprintf("exact/%v", keys[i]), edb.ExactScan(keys[i]), func(raw []byte) bool {
+			return bytes.Equal(raw, rawI)
+		})
+	}
+
+	// a prefix scan matches keys whose leading fields equal those of the pick
+	if kt := tbl.KeyType(); kt.Kind() == reflect.Struct {
+		for els := 1; els < kt.NumField(); els++ {
+			for _, i := range picks {
+				keyI := reflect.ValueOf(keys[i])
+				opt := edb.ExactScan(keys[i])
+				opt.Els = els
+				run(fmt.Sprintf("prefix%d/%v", els, keys[i]), opt, func(raw []byte) bool {
+					key := reflect.ValueOf(tbl.DecodeKeyVal(raw).Interface())
+					for f := range els {
+						if !reflect.DeepEqual(key.Field(f).Interface(), keyI.Field(f).Interface()) {
+							return false
+						}
+					}
+					return true
+				})
+			}
+		}
+	}
+
+	for _, lowerInc := range []bool{true, false} {
+		for _, upperInc := range []bool{true, false} {
+			for _, i := range picks {
+				for _, j := range picks {
+					if i > j {
+						continue
+					}
+					lo, hi := raws[i], raws[j]
+					run(fmt.Sprintf("range/%s%v:%v%s", lowerBracket(lowerInc), keys[i], keys[j], upperBracket(upperInc)), edb.RangeScan(keys[i], keys[j], lowerInc, upperInc), func(raw []byte) bool {
+						return aboveLower(raw, lo, lowerInc) && belowUpper(raw, hi, upperInc)
+					})
+				}
+			}
+			for _, i := range picks {
+				bound := raws[i]
+				if !upperInc {
+					run(fmt.Sprintf("lower/%s%v:", lowerBracket(lowerInc), keys[i]), edb.LowerBoundScan(keys[i], lowerInc), func(raw []byte) bool {
+						return aboveLower(raw, bound, lowerInc)
+					})
+				}
+				if !lowerInc {
+					run(fmt.Sprintf("upper/:%v%s", keys[i], upperBracket(upperInc)), edb.UpperBoundScan(keys[i], upperInc), func(raw []byte) bool {
+						return belowUpper(raw, bound, upperInc)
+					})
+				}
+			}
+		}
+	}
+}
+
+func scanKeys(tx *edb.Tx, tbl *edb.Table, opt edb.ScanOptions) ([]string, error) {
+	c, err := tx.TryTableScan(tbl, opt)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, k := range edb.AllUntypedKeys(c) {
+		keys = append(keys, fmt.Sprint(k))
+	}
+	return keys, nil
+}
+
+// pickIndices returns first, middle and last positions.
+func pickIndices(n int) []int {
+	switch n {
+	case 1:
+		return []int{0}
+	case 2:
+		return []int{0, 1}
+	default:
+		return []int{0, n / 2, n - 1}
+	}
+}
+
+func aboveLower(raw, lower []byte, inc bool) bool {
+	c := bytes.Compare(raw, lower)
+	return c > 0 || (c == 0 && inc)
+}
+
+func belowUpper(raw, upper []byte, inc bool) bool {
+	c := bytes.Compare(raw, upper)
+	return c < 0 || (c == 0 && inc)
+}
+
+func lowerBracket(inc bool) string {
+	if inc {
+		return "["
+	}
+	return "("
+}
+
+func upperBracket(inc bool) string {
+	if inc {
+		return "]"
+	}
+	return ")"
+}
@@ -0,0 +1,40 @@
+package edbtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/andreyvit/edb"
+)
+
+type (
+	Key struct {
+		A int
+		B string
+	}
+
+	Thing struct {
+		Key  Key    `msgpack:"-"`
+		Name string `msgpack:"n"`
+	}
+)
+
+var (
+	schema      = &edb.Schema{}
+	thingsTable = edb.AddTable[Thing](schema, "things", 1, nil, nil, nil)
+)
+
+func TestRunAllScanModes(t *testing.T) {
+	db, err := edb.Open(filepath.Join(t.TempDir(), "test.db"), schema, edb.Options{IsTesting: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Write(func(tx *edb.Tx) {
+		for _, k := range []Key{{1, "a"}, {1, "b"}, {2, "a"}, {3, "x"}, {10, "z"}} {
+			edb.Put(tx, &Thing{Key: k, Name: k.B})
+		}
+	})
+	RunAllScanModes(t, db, thingsTable)
+}
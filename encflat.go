@@ -1,10 +1,12 @@
 package edb
 
 import (
+	"bytes"
 	"encoding"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
 	"sync"
@@ -95,6 +97,8 @@ func (fe *flatEncoder) finalize() []byte {
 	return fe.tupleEncoder.finalize(fe.buf)
 }
 
+const flatSignBit = 1 << 63
+
 var flatEncodings sync.Map
 
 type flatEncoding struct {
@@ -112,6 +116,7 @@ type flatComponent struct {
 	Getters     []func(v reflect.Value, init bool) reflect.Value
 	Decode      func(b []byte, v reflect.Value) error
 	Encode      func(fe *flatEncoder, v reflect.Value)
+	Signed      bool // signed integer, encoded with the sign bit flipped
 }
 
 func (fc *flatComponent) valueIn(val reflect.Value, init bool) reflect.Value {
@@ -146,6 +151,34 @@ func flatEncodingOf(typ reflect.Type) *flatEncoding {
 	return enc
 }
 
+func (enc *flatEncoding) hasSignedInts() bool {
+	for _, fc := range enc.components {
+		if fc.Signed {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeLegacyKey converts a key encoded before signed integers had their
+// sign bit flipped into the current encoding. Returns a new slice.
+func (enc *flatEncoding) upgradeLegacyKey(raw []byte) ([]byte, error) {
+	raw = bytes.Clone(raw)
+	tup, err := decodeTuple(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(tup) != len(enc.components) {
+		return nil, dataErrf(raw, 0, nil, "wrong number of components: got %d, wanted %d", len(tup), len(enc.components))
+	}
+	for i, fc := range enc.components {
+		if fc.Signed && len(tup[i]) == 8 {
+			tup[i][0] ^= 0x80
+		}
+	}
+	return raw, nil
+}
+
 func (enc *flatEncoding) encode(buf []byte, val reflect.Value) []byte {
 	fe := flatEncoder{buf: buf}
 	enc.encodeInto(&fe, val)
@@ -237,7 +270,7 @@ func (enc *flatEncoding) tupleToStrings(tup tuple) []string {
 			if err != nil {
 				panic(fmt.Errorf("invalid component %d: %w - in %v", i, err, tup))
 			}
-			result[i] = fmt.Sprint(val.Interface())
+			result[i] = fmt.Sprint(val.Elem().Interface())
 		}
 		// log.Printf("i=%d fc=%T %v => %q", i, fc, fc, result[i])
 	}
@@ -340,6 +373,42 @@ func enumerateFlatComponents(typ reflect.Type, f func(fc *flatComponent)) {
 				return string(b), nil
 			},
 		})
+	case reflect.Bool:
+		f(&flatComponent{
+			Type: typ,
+			Encode: func(fe *flatEncoder, v reflect.Value) {
+				var b byte
+				if v.Bool() {
+					b = 1
+				}
+				fe.buf = appendUint8(fe.buf, b)
+			},
+			Decode: func(b []byte, v reflect.Value) error {
+				if len(b) == 1 && b[0] <= 1 {
+					v.SetBool(b[0] == 1)
+					return nil
+				} else {
+					return fmt.Errorf("invalid bool data: %x", b)
+				}
+			},
+			RawParser: func(buf []byte, s string) ([]byte, error) {
+				v, err := strconv.ParseBool(s)
+				if err != nil {
+					return nil, err
+				}
+				var b byte
+				if v {
+					b = 1
+				}
+				return appendUint8(buf, b), nil
+			},
+			RawStringer: func(b []byte) (string, error) {
+				if len(b) != 1 || b[0] > 1 {
+					return "", fmt.Errorf("invalid bool data: %x", b)
+				}
+				return strconv.FormatBool(b[0] == 1), nil
+			},
+		})
 	case reflect.Uint, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8, reflect.Uintptr:
 		f(&flatComponent{
 			Type: typ,
@@ -366,15 +435,45 @@ func enumerateFlatComponents(typ reflect.Type, f func(fc *flatComponent)) {
 				return appendUint64(buf, v), nil
 			},
 		})
-	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8:
+	case reflect.Float64, reflect.Float32:
 		f(&flatComponent{
 			Type: typ,
 			Encode: func(fe *flatEncoder, v reflect.Value) {
-				fe.buf = appendUint64(fe.buf, uint64(v.Int()))
+				fe.buf = appendUint64(fe.buf, encodeOrderedFloat(v.Float()))
 			},
 			Decode: func(b []byte, v reflect.Value) error {
 				if len(b) == 8 {
-					value := int64(binary.BigEndian.Uint64(b))
+					v.SetFloat(decodeOrderedFloat(binary.BigEndian.Uint64(b)))
+					return nil
+				} else {
+					return fmt.Errorf("invalid float length: got %d bytes, valued %d", len(b), 8)
+				}
+			},
+			RawParser: func(buf []byte, s string) ([]byte, error) {
+				v, err := strconv.ParseFloat(s, 64)
+				if err != nil {
+					return nil, err
+				}
+				return appendUint64(buf, encodeOrderedFloat(v)), nil
+			},
+			RawStringer: func(b []byte) (string, error) {
+				if len(b) != 8 {
+					return "", fmt.Errorf("invalid float length: got %d bytes, valued %d", len(b), 8)
+				}
+				return strconv.FormatFloat(decodeOrderedFloat(binary.BigEndian.Uint64(b)), 'g', -1, 64), nil
+			},
+		})
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8:
+		// flipping the sign bit makes negative values sort before positive ones
+		f(&flatComponent{
+			Type:   typ,
+			Signed: true,
+			Encode: func(fe *flatEncoder, v reflect.Value) {
+				fe.buf = appendUint64(fe.buf, uint64(v.Int())^flatSignBit)
+			},
+			Decode: func(b []byte, v reflect.Value) error {
+				if len(b) == 8 {
+					value := int64(binary.BigEndian.Uint64(b) ^ flatSignBit)
 					v.Set(reflect.ValueOf(value).Convert(typ))
 					return nil
 				} else {
@@ -386,7 +485,7 @@ func enumerateFlatComponents(typ reflect.Type, f func(fc *flatComponent)) {
 				if err != nil {
 					return nil, err
 				}
-				return appendUint64(buf, uint64(v)), nil
+				return appendUint64(buf, uint64(v)^flatSignBit), nil
 			},
 		})
 	case reflect.Ptr:
@@ -465,6 +564,32 @@ func enumerateFlatComponents(typ reflect.Type, f func(fc *flatComponent)) {
 	}
 }
 
+// encodeOrderedFloat maps IEEE-754 bits onto uint64 so that they sort
+// numerically: positive values get the sign bit set, negative values
+// get all bits inverted (so that larger magnitudes sort first).
+//
+// Values that compare equal must encode the same, so -0 is stored as +0,
+// and all NaNs are stored as a single positive NaN sorting after +Inf.
+func encodeOrderedFloat(f float64) uint64 {
+	if f == 0 {
+		f = 0
+	} else if math.IsNaN(f) {
+		f = math.NaN()
+	}
+	u := math.Float64bits(f)
+	if u&flatSignBit != 0 {
+		return ^u
+	}
+	return u | flatSignBit
+}
+
+func decodeOrderedFloat(u uint64) float64 {
+	if u&flatSignBit != 0 {
+		return math.Float64frombits(u &^ flatSignBit)
+	}
+	return math.Float64frombits(^u)
+}
+
 func pathPrefix(p string) string {
 	if p == "" {
 		return ""
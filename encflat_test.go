@@ -18,10 +18,17 @@ func TestMarshalFlat(t *testing.T) {
 		decodeBase any
 	}{
 		{"test", "74657374 01", ""},
-		{0x42, "0000000000000042 01", 0},
-		{Foo{0x42, "test"}, "0000000000000042 74657374 08 02", Foo{}},
-		{&Foo{0x42, "test"}, "0000000000000042 74657374 08 02", &Foo{}},
+		{0x42, "8000000000000042 01", 0},
+		{-1, "7fffffffffffffff 01", 0},
+		{uint(0x42), "0000000000000042 01", uint(0)},
+		{Foo{0x42, "test"}, "8000000000000042 74657374 08 02", Foo{}},
+		{&Foo{0x42, "test"}, "8000000000000042 74657374 08 02", &Foo{}},
 		{[]byte("test"), "74657374 01", []byte(nil)},
+		{true, "01 01", false},
+		{false, "00 01", true},
+		{1.5, "bff8000000000000 01", 0.0},
+		{-1.5, "4007ffffffffffff 01", 0.0},
+		{float32(0.5), "bfe0000000000000 01", float32(0)},
 		// {[4]byte{'t', 'e', 's', 't'}, "74657374 01", [4]byte{}},
 	}
 	for _, test := range tests {
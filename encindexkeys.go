@@ -1,8 +1,8 @@
 package edb
 
 import (
-	"bytes"
 	"encoding/binary"
+	"slices"
 
 	"go.etcd.io/bbolt"
 )
@@ -33,26 +33,17 @@ func decodeIndexKeys(data []byte, f func(ord uint64, key []byte)) {
 }
 
 type indexDiffer struct {
-	newRows indexRows
+	newRows indexRows // sorted by finalize
 }
 
+// checkOldKey reports whether the old entry is still present among the new
+// rows. Old entries may come in any order: data written by older versions
+// was sorted by the index position in code rather than by ordinal.
 func (d *indexDiffer) checkOldKey(oldOrd uint64, oldKey []byte) bool {
-	// Look for a new row that's >= old row.
-	for len(d.newRows) > 0 {
-		newOrd := d.newRows[0].IndexOrd
-		if oldOrd < newOrd {
-			return false
-		} else if oldOrd == newOrd {
-			c := bytes.Compare(oldKey, d.newRows[0].KeyRaw)
-			if c < 0 {
-				return false
-			} else if c == 0 {
-				return true // found exact match
-			}
-		}
-		d.newRows = d.newRows[1:] // shift to next new row and compare again
-	}
-	return false // no more new rows, so remaining old rows have been deleted
+	_, found := slices.BinarySearchFunc(d.newRows, oldKey, func(row IndexRow, key []byte) int {
+		return -compareIndexRow(oldOrd, key, &row)
+	})
+	return found
 }
 
 func findRemovedIndexKeys(oldData []byte, newRows indexRows, removed func(ord uint64, key []byte)) {
@@ -64,7 +55,7 @@ func findRemovedIndexKeys(oldData []byte, newRows indexRows, removed func(ord ui
 	})
 }
 
-func prepareToDeleteIndexEntries(tableBuck *bbolt.Bucket, ts *tableState) func(ord uint64, key []byte) {
+func prepareToDeleteIndexEntries(tableBuck *bbolt.Bucket, ts *tableState, tableKeyRaw []byte) func(ord uint64, key []byte) {
 	var idxOrd uint64
 	var idxBuck *bbolt.Bucket
 
@@ -72,7 +63,7 @@ func prepareToDeleteIndexEntries(tableBuck *bbolt.Bucket, ts *tableState) func(o
 		if idxOrd != ord {
 			idxOrd = ord
 			if idx := ts.indexByOrdinal(ord); idx != nil {
-				idxBuck = nonNil(tableBuck.Bucket(idx.buck.Raw()))
+				idxBuck = idx.shardBucketIn(tableBuck, idx.shardFor(tableKeyRaw))
 			} else {
 				idxBuck = nil
 			}
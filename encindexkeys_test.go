@@ -28,6 +28,9 @@ func TestIndexDiffing(t *testing.T) {
 		{"1:a 2:a 2:b", "2:a 2:b", "1:a"},
 		{"1:a 2:a 2:b", "1:a 2:b", "2:a"},
 		{"1:a 2:a 2:b", "1:a 2:a 2:b", ""},
+		{"1:a 1:b 1:c", "1:b 1:d", "1:a 1:c"},
+		{"2:a 1:a", "1:a 2:a", ""},
+		{"2:b 2:c 1:a", "1:a 2:c", "2:b"},
 	}
 	for _, tt := range tests {
 		oldKeys := parseIndexKeys(tt.old)
@@ -65,3 +65,91 @@ func (enc encodingMethod) DecodeValue(buf []byte, objPtrVal reflect.Value) error
 		panic("unsupported encoding")
 	}
 }
+
+// RenameFields rewrites the top-level field names of an encoded struct,
+// mapping each old name in renames to its new name. Fields whose new name is
+// already present are dropped, so that the newer data wins.
+func (enc encodingMethod) RenameFields(buf []byte, renames map[string]string) ([]byte, error) {
+	switch enc {
+	case MsgPack:
+		var r bytes.Reader
+		r.Reset(buf)
+		dec := msgpack.GetDecoder()
+		defer msgpack.PutDecoder(dec)
+		dec.ResetDict(&r, nil)
+
+		n, err := dec.DecodeMapLen()
+		if err != nil {
+			return nil, dataErrf(buf, 0, err, "failed to decode msgpack map")
+		} else if n < 0 {
+			return buf, nil
+		}
+		names := make([]string, n)
+		values := make([]msgpack.RawMessage, n)
+		present := make(map[string]bool, n)
+		for i := range n {
+			names[i], err = dec.DecodeString()
+			if err != nil {
+				return nil, dataErrf(buf, len(buf)-r.Len(), err, "failed to decode msgpack field name")
+			}
+			values[i], err = dec.DecodeRaw()
+			if err != nil {
+				return nil, dataErrf(buf, len(buf)-r.Len(), err, "failed to decode msgpack field %q", names[i])
+			}
+			present[names[i]] = true
+		}
+
+		bb := bytesBuilder{make([]byte, 0, len(buf))}
+		e := msgpack.GetEncoder()
+		defer msgpack.PutEncoder(e)
+		e.ResetDict(&bb, nil)
+		var count int
+		for i, name := range names {
+			if newName, ok := renames[name]; ok {
+				if present[newName] {
+					names[i] = ""
+					continue
+				}
+				names[i] = newName
+			}
+			count++
+		}
+		err = e.EncodeMapLen(count)
+		for i, name := range names {
+			if name == "" || err != nil {
+				continue
+			}
+			err = e.EncodeString(name)
+			if err == nil {
+				err = e.Encode(values[i])
+			}
+		}
+		if err != nil {
+			panic(fmt.Errorf("failed to re-encode renamed msgpack fields: %w", err))
+		}
+		return bb.Buf, nil
+	case JSON:
+		var fields map[string]json.RawMessage
+		err := json.Unmarshal(buf, &fields)
+		if err != nil {
+			return nil, dataErrf(buf, 0, err, "failed to decode JSON object")
+		} else if fields == nil {
+			return buf, nil
+		}
+		for oldName, newName := range renames {
+			if v, ok := fields[oldName]; ok {
+				delete(fields, oldName)
+				if _, ok := fields[newName]; !ok {
+					fields[newName] = v
+				}
+			}
+		}
+		raw, err := json.Marshal(fields)
+		if err != nil {
+			panic(fmt.Errorf("failed to re-encode renamed JSON fields: %w", err))
+		}
+		return raw, nil
+	default:
+		panic("unsupported encoding")
+	}
+}
@@ -1,8 +1,12 @@
 package edb
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"time"
 )
 
 const (
@@ -18,15 +22,19 @@ const (
 	vfVerBit2
 	vfVerBit3
 	vfCompressionBit0
+	vfExpiryBit
+	vfEncodingBit0
 
 	vfVerMask       = (vfVerBit0 | vfVerBit1 | vfVerBit2 | vfVerBit3)
 	vfVer1          = vfVerBit0
 	vfGzip          = vfCompressionBit0
-	vfSupportedMask = (vfVer1 | vfGzip)
+	vfExpires       = vfExpiryBit    // header has an expiry field after mod count
+	vfJSON          = vfEncodingBit0 // data is JSON rather than MsgPack
+	vfSupportedMask = (vfVer1 | vfGzip | vfExpires | vfJSON)
 	vfDefault       = vfVer1
 
 	minValueSize       = 5
-	maxValueHeaderSize = binary.MaxVarintLen64 * 5
+	maxValueHeaderSize = binary.MaxVarintLen64 * 6
 	maxSchemaVersion   = 32768 // just a sanity value, can be increased
 )
 
@@ -34,7 +42,15 @@ func (vf valueFlags) ver() valueFlags {
 	return vf & vfVerMask
 }
 
+// formatVer returns the value format version encoded in the version bits.
+func (vf valueFlags) formatVer() uint64 {
+	return uint64(vf & vfVerMask)
+}
+
 func (vf valueFlags) encoding() encodingMethod {
+	if vf&vfJSON != 0 {
+		return JSON
+	}
 	return MsgPack
 }
 
@@ -42,6 +58,7 @@ type value struct {
 	Flags     valueFlags
 	SchemaVer uint64
 	ModCount  uint64
+	Expiry    uint64 // Unix milliseconds, only valid with vfExpires
 	Data      []byte
 	Index     []byte
 }
@@ -53,6 +70,18 @@ func (vle value) ValueMeta() ValueMeta {
 	}
 }
 
+// isExpired returns whether the value has an expiry time that is not after
+// the given Unix millisecond timestamp.
+func (vle *value) isExpired(nowMs uint64) bool {
+	return vle.Flags&vfExpires != 0 && vle.Expiry <= nowMs
+}
+
+// unixMilli converts t into the representation of value expiry times,
+// clamping times before the Unix epoch to zero.
+func unixMilli(t time.Time) uint64 {
+	return uint64(max(t.UnixMilli(), 0))
+}
+
 func reserveValueHeader(buf []byte) []byte {
 	if len(buf) != 0 {
 		panic("value must be written to an empty buffer")
@@ -60,13 +89,16 @@ func reserveValueHeader(buf []byte) []byte {
 	return buf[:maxValueHeaderSize]
 }
 
-func putValueHeader(buf []byte, flags valueFlags, schemaVer uint64, modCount uint64, indexOff int) []byte {
+func putValueHeader(buf []byte, flags valueFlags, schemaVer uint64, modCount uint64, expiry uint64, indexOff int) []byte {
 	if indexOff > len(buf) {
 		panic(fmt.Errorf("invalid indexOff=%d", indexOff)) // sanity check
 	}
 	if (flags &^ vfSupportedMask) != 0 {
 		panic(fmt.Errorf("invalid flags %x", flags))
 	}
+	if ver := flags.formatVer(); ver == 0 || ver > valueFormatVerLatest {
+		panic(fmt.Errorf("invalid value format version %d", ver))
+	}
 	dataSize := indexOff - maxValueHeaderSize
 	indexSize := len(buf) - indexOff
 
@@ -77,6 +109,10 @@ func putValueHeader(buf []byte, flags valueFlags, schemaVer uint64, modCount uin
 	off += n
 	n = binary.PutUvarint(buf[off:], uint64(modCount))
 	off += n
+	if flags&vfExpires != 0 {
+		n = binary.PutUvarint(buf[off:], expiry)
+		off += n
+	}
 	n = binary.PutUvarint(buf[off:], uint64(dataSize))
 	off += n
 	n = binary.PutUvarint(buf[off:], uint64(indexSize))
@@ -95,6 +131,50 @@ func putValueHeader(buf []byte, flags valueFlags, schemaVer uint64, modCount uin
 	}
 }
 
+// appendValue encodes a complete value from already encoded data and index
+// key records. Used when rewriting a value without decoding the row.
+func appendValue(buf []byte, flags valueFlags, schemaVer, modCount, expiry uint64, data, index []byte) []byte {
+	buf = ensureCapacity(buf, maxValueHeaderSize+len(data)+len(index))
+	buf = reserveValueHeader(buf)
+	buf = appendRaw(buf, data)
+	indexOff := len(buf)
+	buf = appendRaw(buf, index)
+	return putValueHeader(buf, flags, schemaVer, modCount, expiry, indexOff)
+}
+
+// compressValueData gzips data, returning nil if that doesn't make it
+// smaller.
+func compressValueData(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	zw := gzipWriterPool.Get().(*gzip.Writer)
+	zw.Reset(&buf)
+	must(zw.Write(data))
+	ensure(zw.Close())
+	zw.Reset(nil)
+	gzipWriterPool.Put(zw)
+	if buf.Len() >= len(data) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// plainData returns the row data, decompressing it if needed.
+func (vle *value) plainData() ([]byte, error) {
+	if vle.Flags&vfGzip == 0 {
+		return vle.Data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(vle.Data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzipped data: %w", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzipped data: %w", err)
+	}
+	return data, nil
+}
+
 func (vle *value) decode(data []byte) error {
 	orig := data
 	if len(data) < minValueSize {
@@ -109,6 +189,9 @@ func (vle *value) decode(data []byte) error {
 		return dataErrf(orig, len(data)-len(orig), nil, "invalid value: unsupported flags %x", v)
 	}
 	vle.Flags, data = valueFlags(v), data[n:]
+	if ver := vle.Flags.formatVer(); ver == 0 || ver > valueFormatVerLatest {
+		return dataErrf(orig, len(data)-len(orig), nil, "invalid value: unsupported format version %d", ver)
+	}
 
 	v, n = binary.Uvarint(data)
 	if n <= 0 || v > maxSchemaVersion {
@@ -122,6 +205,15 @@ func (vle *value) decode(data []byte) error {
 	}
 	vle.ModCount, data = v, data[n:]
 
+	vle.Expiry = 0
+	if vle.Flags&vfExpires != 0 {
+		v, n = binary.Uvarint(data)
+		if n <= 0 {
+			return dataErrf(orig, len(data)-len(orig), nil, "invalid value: bad expiry")
+		}
+		vle.Expiry, data = v, data[n:]
+	}
+
 	dataSize, n := binary.Uvarint(data)
 	if n <= 0 {
 		return dataErrf(orig, len(data)-len(orig), nil, "invalid value: bad data size")
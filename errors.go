@@ -1,11 +1,49 @@
 package edb
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 )
 
+var (
+	// ErrWrongKeyType is returned (or wrapped by a panic) when a key, index
+	// value or scan bound has a type that does not match the table or index.
+	ErrWrongKeyType = errors.New("wrong key type")
+
+	// ErrKeyType is another name for ErrWrongKeyType, returned by TryGet,
+	// TryLookup and the Try scan variants; either can be used with errors.Is.
+	ErrKeyType = ErrWrongKeyType
+
+	// ErrIndexNotOnTable is returned (or wrapped by a panic) when an index is
+	// used with a table it does not belong to, or has not been added to any table.
+	ErrIndexNotOnTable = errors.New("index not on table")
+
+	// ErrNotFound is returned by operations that require an existing row.
+	ErrNotFound = errors.New("not found")
+
+	// ErrDatabaseFull is wrapped by the panic raised when a write would grow
+	// the database beyond Options.MaxSize.
+	ErrDatabaseFull = errors.New("database full")
+
+	// ErrUnsupportedScan is returned (or wrapped by a panic) when a scan
+	// method cannot be used with the given table or index.
+	ErrUnsupportedScan = errors.New("unsupported scan")
+
+	// ErrStaleBookmark is returned when a ScanBookmark was made with an older
+	// key encoding, and so cannot be used to resume a scan.
+	ErrStaleBookmark = errors.New("stale scan bookmark")
+
+	// ErrConflict is returned by PutIfUnchanged when the row has been modified
+	// since the expected ValueMeta was obtained.
+	ErrConflict = errors.New("conflicting modification")
+
+	// ErrSnapshotClosed is returned (or wrapped by a panic) when using
+	// a Snapshot after it has been closed, including by its context.
+	ErrSnapshotClosed = errors.New("snapshot closed")
+)
+
 type DataError struct {
 	Data []byte
 	Off  int
@@ -2,9 +2,13 @@ package edb
 
 import (
 	"bytes"
+	"cmp"
 	"fmt"
 	"reflect"
+	"slices"
 	"sort"
+
+	"go.etcd.io/bbolt"
 )
 
 type IndexRow struct {
@@ -21,6 +25,7 @@ type IndexBuilder struct {
 	rows    indexRows
 	rowsBuf indexRows
 	key     []byte
+	row     any // passed to Covering functions
 }
 
 func makeIndexBuilder(ts *tableState, keyRaw []byte) IndexBuilder {
@@ -33,13 +38,17 @@ func makeIndexBuilder(ts *tableState, keyRaw []byte) IndexBuilder {
 	}
 }
 
+// Add records an index entry for the row being indexed. Zero values (like
+// an empty string) are indexed and can be looked up like any other value;
+// the tuple encoding keeps an empty component distinct from a missing one.
+// To leave such rows out of the index, skip the call to Add.
 func (b *IndexBuilder) Add(idx *Index, value any) *IndexRow {
 	valueVal := reflect.ValueOf(value)
 	if idx.table != b.ts.table {
-		panic(fmt.Errorf("%s: attempted to add entry to another table's index %s", b.ts.table.Name(), idx.FullName()))
+		panic(fmt.Errorf("%s: attempted to add entry to another table's index %s: %w", b.ts.table.Name(), idx.FullName(), ErrIndexNotOnTable))
 	}
 	if at, et := valueVal.Type(), idx.keyType(); at != et {
-		panic(fmt.Errorf("%s: attempted to add index entry with incorrect type %v, expected %v", idx.FullName(), at, et))
+		panic(fmt.Errorf("%s: attempted to add index entry with incorrect type %v, expected %v: %w", idx.FullName(), at, et, ErrWrongKeyType))
 	}
 
 	keyBuf := keyBytesPool.Get().([]byte)
@@ -48,15 +57,22 @@ func (b *IndexBuilder) Add(idx *Index, value any) *IndexRow {
 
 	var valueRaw []byte
 	var valueBuf []byte
-	if idx.isUnique {
+	if idx.isUnique || idx.covering != nil {
 		valueBuf = indexValueBytesPool.Get().([]byte)
 		valueEnc := flatEncoder{buf: valueBuf}
-		valueEnc.begin()
-		valueEnc.append(b.key)
+		if idx.isUnique {
+			valueEnc.begin()
+			valueEnc.append(b.key)
+		}
+		if idx.covering != nil {
+			valueEnc.begin()
+			valueEnc.append(idx.covering(b.row))
+		}
 		valueRaw = valueEnc.finalize()
 	} else {
 		valueRaw = emptyIndexValue
-
+	}
+	if !idx.isUnique {
 		keyEnc.begin()
 		keyEnc.buf = appendRaw(keyEnc.buf, b.key)
 	}
@@ -86,9 +102,206 @@ type indexRows []IndexRow
 func (a indexRows) Len() int      { return len(a) }
 func (a indexRows) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 func (a indexRows) Less(i, j int) bool {
-	lp, rp := a[i].Index.pos, a[j].Index.pos
-	if lp != rp {
-		return lp < rp
+	return compareIndexRow(a[i].IndexOrd, a[i].KeyRaw, &a[j]) < 0
+}
+
+func compareIndexRow(ord uint64, key []byte, row *IndexRow) int {
+	if ord != row.IndexOrd {
+		return cmp.Compare(ord, row.IndexOrd)
+	}
+	return bytes.Compare(key, row.KeyRaw)
+}
+
+// IndexEntry describes a single index entry referencing a table row.
+type IndexEntry struct {
+	Index  *Index
+	Key    any
+	KeyRaw []byte
+}
+
+// IndexEntriesForKey returns the index entries recorded for the row with
+// the given key, in index order, or nil if the row does not exist. Entries
+// of indices that no longer exist in the schema are omitted.
+func (tx *Tx) IndexEntriesForKey(tbl *Table, key any) []IndexEntry {
+	keyVal := tbl.ensureCorrectKeyType(reflect.ValueOf(key))
+	keyRaw := tbl.encodeKeyVal(nil, keyVal, true)
+	valueRaw := tx.getRawByRawKey(tbl, keyRaw)
+	if valueRaw == nil {
+		return nil
+	}
+
+	var vle value
+	decodeTableValue(&vle, tbl, keyRaw, valueRaw)
+
+	ts := tx.db.tableState(tbl)
+	var entries []IndexEntry
+	decodeIndexKeys(vle.Index, func(ord uint64, indexKeyRaw []byte) {
+		idx := ts.indexByOrdinal(ord)
+		if idx == nil {
+			return
+		}
+		tup := decodeIndexKey(indexKeyRaw, idx)
+		if !idx.isUnique {
+			_, tup = extractUniqueIndexKey(tup)
+		}
+		entries = append(entries, IndexEntry{
+			Index:  idx,
+			Key:    idx.DecodeIndexKeyVal(tup).Interface(),
+			KeyRaw: bytes.Clone(indexKeyRaw),
+		})
+	})
+	return entries
+}
+
+// FindUniqueViolations returns the index keys of idx that are shared by more
+// than one row, in ascending order. Keys are encoded the way unique indices
+// store them, so DecodeIndexKeyBytes with unique set to true decodes them.
+//
+// Run it before marking an existing index Unique, and resolve the reported
+// collisions first: when a unique index is rebuilt, rows sharing a key
+// overwrite each other's index entries. The check goes over the index keys
+// recorded in the rows themselves, so on a unique index it finds the rows
+// whose entries have been overwritten this way.
+func (tx *Tx) FindUniqueViolations(idx *Index) [][]byte {
+	ord := tx.db.tableState(idx.table).indexOrdinal(idx)
+	return findUniqueViolations(tx.btx, idx, ord, idx.isUnique)
+}
+
+// findUniqueViolations implements FindUniqueViolations for the index with
+// the given ordinal, whose keys recorded in rows are in the unique format
+// if storedUnique is set. It does not need the table state, so it can run
+// while the table is being prepared.
+func findUniqueViolations(btx *bbolt.Tx, idx *Index, ord uint64, storedUnique bool) [][]byte {
+	tbl := idx.table
+	counts := make(map[string]int)
+	dataBuck := tbl.dataBucketIn(tbl.rootBucketIn(btx))
+	c := dataBuck.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var vle value
+		decodeTableValue(&vle, tbl, k, v)
+		decodeIndexKeys(vle.Index, func(o uint64, indexKeyRaw []byte) {
+			if o != ord {
+				return
+			}
+			if !storedUnique {
+				_, tup := extractUniqueIndexKey(decodeIndexKey(indexKeyRaw, idx))
+				indexKeyRaw = tup.encode(nil)
+			}
+			counts[string(indexKeyRaw)]++
+		})
+	}
+
+	var result [][]byte
+	for k, n := range counts {
+		if n > 1 {
+			result = append(result, []byte(k))
+		}
+	}
+	slices.SortFunc(result, bytes.Compare)
+	return result
+}
+
+// IndexErrorKind tells what VerifyIndexes found wrong with an index entry.
+type IndexErrorKind int
+
+const (
+	// IndexEntryDangling is an entry that no live row produces, e.g. one
+	// pointing to a deleted row, or a stale entry of an updated row.
+	IndexEntryDangling IndexErrorKind = iota + 1
+
+	// IndexEntryMissing is an entry that a live row produces, but that is
+	// absent from the index (or has a different value).
+	IndexEntryMissing
+)
+
+func (k IndexErrorKind) String() string {
+	switch k {
+	case IndexEntryDangling:
+		return "dangling"
+	case IndexEntryMissing:
+		return "missing"
+	default:
+		return fmt.Sprintf("IndexErrorKind(%d)", int(k))
+	}
+}
+
+// IndexError describes an inconsistency between an index and the table rows.
+type IndexError struct {
+	Index       *Index
+	Kind        IndexErrorKind
+	IndexKeyRaw []byte
+	KeyRaw      []byte // key of the row the entry points to or belongs to
+}
+
+func (e IndexError) Error() string {
+	return fmt.Sprintf("%s: %s entry %x for row %s", e.Index.FullName(), e.Kind, e.IndexKeyRaw, e.Index.table.RawKeyString(e.KeyRaw))
+}
+
+// VerifyIndexes checks that the built indices of tbl contain exactly the
+// entries produced by indexing the current rows, and returns the dangling and
+// missing entries, grouped by index. Nothing is modified, so this is safe to
+// run on a production database; use Reindex to fix the reported problems.
+//
+// The expected entries of all rows are kept in memory while checking.
+func (tx *Tx) VerifyIndexes(tbl *Table) []IndexError {
+	type expectedEntry struct {
+		valueRaw []byte
+		keyRaw   []byte
+		seen     bool
+	}
+	ts := tx.db.tableState(tbl)
+	rootB := tbl.rootBucketIn(tx.btx)
+	expected := make([]map[string]*expectedEntry, len(tbl.indices))
+	for i := range expected {
+		expected[i] = make(map[string]*expectedEntry)
+	}
+
+	c := tbl.dataBucketIn(rootB).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		rowVal, _, err := decodeTableRow(tbl, k, v, tx)
+		if err != nil {
+			panic(err)
+		}
+		ib := makeIndexBuilder(ts, k)
+		ib.row = rowVal.Interface()
+		tbl.indexer(ib.row, &ib)
+		for _, ir := range ib.rows {
+			expected[ir.Index.pos][string(ir.KeyRaw)] = &expectedEntry{
+				valueRaw: bytes.Clone(ir.ValueRaw),
+				keyRaw:   bytes.Clone(k),
+			}
+		}
+		ib.release(tx)
+	}
+
+	var result []IndexError
+	for _, idx := range tbl.indices {
+		if !tx.db.isIndexBuilt(ts.indexStates[idx.pos]) {
+			continue
+		}
+		exp := expected[idx.pos]
+		for shard := range idx.ShardCount() {
+			c := idx.shardBucketIn(rootB, shard).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if e := exp[string(k)]; e != nil && bytes.Equal(e.valueRaw, v) {
+					e.seen = true
+					continue
+				}
+				_, keyRaw := decodeIndexRow(idx, k, v)
+				result = append(result, IndexError{idx, IndexEntryDangling, bytes.Clone(k), bytes.Clone(keyRaw)})
+			}
+		}
+
+		var missing []IndexError
+		for k, e := range exp {
+			if !e.seen {
+				missing = append(missing, IndexError{idx, IndexEntryMissing, []byte(k), e.keyRaw})
+			}
+		}
+		slices.SortFunc(missing, func(a, b IndexError) int {
+			return bytes.Compare(a.IndexKeyRaw, b.IndexKeyRaw)
+		})
+		result = append(result, missing...)
 	}
-	return bytes.Compare(a[i].KeyRaw, a[j].KeyRaw) < 0
+	return result
 }
@@ -22,23 +22,15 @@
 //
 //   - Performant.
 //
-//   - Automatically rotates the files when they reach a certain size.
+//   - Automatically rotates the files when they reach a certain size or age.
 //
 // TODO:
 //
-//   - Trigger rotation based on time (say, each day gets a new segment).
-//     Basically limit how old in-progress segments can be.
-//
-//   - Allow to rotate a file without writing a new record. (Otherwise
-//     rarely-used journals will never get archived.)
-//
 //   - Give work-in-progress file a prefixed name (W*).
 //
-//   - Auto-commit every N seconds, after K bytes, after M records.
-//
 //   - Option for millisecond timestamp precision?
 //
-//   - Reading API. (Search based on time and record ordinals.)
+//   - Search based on record ordinals when reading.
 //
 //   - Use mmap for reading.
 //
@@ -56,6 +48,13 @@
 // records; so bit 0 of the first byte of an item indicates whether it's
 // a record or a commit.
 //
+// When a segment larger than 64 KiB gets rotated, an index file (segment file
+// name + ".idx") is written next to it, listing commit boundaries with their
+// record ordinals, timestamps and checksum states, so that FindByTime can
+// start reading in the middle of the segment. Index files are optional:
+// missing, corrupted or stale ones are ignored, and the segment is scanned
+// from the start instead.
+//
 // Timestamps are 32-bit unix times and have 1 second precision. (Rationale
 // is that the primary use of timestamps is to search logs by time, and that
 // does not require a higher precision. For high-frequency logs, with 1-second
@@ -69,6 +68,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"iter"
 	"log/slog"
 	"math"
 	"os"
@@ -83,20 +83,30 @@ import (
 )
 
 var (
-	ErrIncompatible       = fmt.Errorf("incompatible journal")
-	ErrUnsupportedVersion = fmt.Errorf("unsupported journal version")
-	errCorruptedFile      = fmt.Errorf("corrupted journal segment file")
-	errFileGone           = fmt.Errorf("journal segment is gone")
+	ErrIncompatible        = fmt.Errorf("incompatible journal")
+	ErrUnsupportedVersion  = fmt.Errorf("unsupported journal version")
+	ErrFailed              = fmt.Errorf("journal failed to fsync earlier")
+	ErrTimestampOutOfRange = fmt.Errorf("timestamp out of journal range")
+	errCorruptedFile       = fmt.Errorf("corrupted journal segment file")
+	errFileGone            = fmt.Errorf("journal segment is gone")
 )
 
 type Options struct {
-	FileName         string // e.g. "mydb-*.bin"
-	MaxFileSize      int64  // new segment after this size
+	FileName         string        // e.g. "mydb-*.bin"
+	MaxFileSize      int64         // new segment after this size
+	MaxSegmentAge    time.Duration // new segment after this time, if non-zero
 	DebugName        string
 	Now              func() time.Time
 	JournalInvariant [32]byte
 	SegmentInvariant [32]byte
 
+	// Auto-commit after the given time since the first uncommitted record,
+	// or once uncommitted records reach the given total size or count.
+	// Zero values disable the corresponding trigger.
+	AutoCommitInterval time.Duration
+	AutoCommitBytes    int64
+	AutoCommitRecords  int
+
 	Context context.Context
 	Logger  *slog.Logger
 	OnLoad  func()
@@ -143,6 +153,7 @@ type recordHeader struct {
 type Journal struct {
 	context          context.Context
 	maxFileSize      int64
+	maxSegmentAge    time.Duration
 	fileNamePrefix   string
 	fileNameSuffix   string
 	debugName        string
@@ -156,9 +167,14 @@ type Journal struct {
 	journalInvariant [32]byte
 	segmentInvariant [32]byte
 
-	writeLock sync.Mutex
-	writeErr  error
-	segWriter *segmentWriter
+	autoCommitInterval time.Duration
+	autoCommitBytes    int64
+	autoCommitRecords  int
+
+	writeLock   sync.Mutex
+	writeErr    error
+	segWriter   *segmentWriter
+	commitTimer *time.Timer
 }
 
 func New(dir string, o Options) *Journal {
@@ -184,6 +200,7 @@ func New(dir string, o Options) *Journal {
 	return &Journal{
 		context:          o.Context,
 		maxFileSize:      o.MaxFileSize,
+		maxSegmentAge:    o.MaxSegmentAge,
 		fileNamePrefix:   prefix,
 		fileNameSuffix:   suffix,
 		debugName:        o.DebugName,
@@ -194,19 +211,30 @@ func New(dir string, o Options) *Journal {
 		journalInvariant: o.JournalInvariant,
 		segmentInvariant: o.SegmentInvariant,
 		logger:           o.Logger,
+
+		autoCommitInterval: o.AutoCommitInterval,
+		autoCommitBytes:    o.AutoCommitBytes,
+		autoCommitRecords:  o.AutoCommitRecords,
 	}
 }
 
 func (j *Journal) Now() uint32 {
-	v := j.now().Unix()
-	if v < 0 {
-		panic("time travel disallowed")
+	ts, err := j.tryNow()
+	if err != nil {
+		panic(err)
 	}
-	u := uint64(v)
-	if u&0xFFFF_FFFF_0000_0000 != 0 {
-		panic("time travel disallowed both ways")
+	return ts
+}
+
+// tryNow returns the current time as a journal timestamp, failing with
+// ErrTimestampOutOfRange if the clock is before 1970 or after 2106.
+func (j *Journal) tryNow() (uint32, error) {
+	t := j.now()
+	v := t.Unix()
+	if v < 0 || uint64(v)&0xFFFF_FFFF_0000_0000 != 0 {
+		return 0, fmt.Errorf("%v: current time %v: %w", j.debugName, t, ErrTimestampOutOfRange)
 	}
-	return uint32(u)
+	return uint32(v), nil
 }
 
 func (j *Journal) String() string {
@@ -242,6 +270,12 @@ func (j *Journal) prepareToWrite_locked() error {
 		return fmt.Errorf("%v: not a directory", j.debugName)
 	}
 
+	if failed, err := j.IsFailed(); err != nil {
+		return err
+	} else if failed {
+		return fmt.Errorf("%v: %w, remove %s after checking the data", j.debugName, ErrFailed, j.filePath(j.failedSentinelName()))
+	}
+
 	var failedName string
 retry:
 	lastName := j.findLastFile(dirf)
@@ -288,6 +322,10 @@ func (j *Journal) FinishWriting() error {
 
 func (j *Journal) finishWriting_locked() error {
 	j.writable = false
+	if j.commitTimer != nil {
+		j.commitTimer.Stop()
+		j.commitTimer = nil
+	}
 	var err error
 	if j.segWriter != nil {
 		err = j.segWriter.close()
@@ -305,18 +343,41 @@ func (j *Journal) fail(err error) error {
 
 	j.finishWriting_locked()
 
-	if j.writeErr != nil {
+	if j.writeErr == nil {
 		j.writeErr = err
 	}
 	return err
 }
 
+// fsyncFailed enters a failed mode that's preserved across restarts by
+// creating a sentinel file. After a failed fsync, we cannot know what data
+// made it to disk, so the journal refuses to be written to until an operator
+// checks it and removes the sentinel.
 func (j *Journal) fsyncFailed(err error) {
-	// TODO: enter a TOTALLY FAILED mode that's preserved across restarts
-	// (e.g. by creating a sentinel file)
+	msg := fmt.Sprintf("%s: fsync failed at %s: %v\n", j.debugName, j.now().UTC().Format(time.RFC3339), err)
+	if werr := os.WriteFile(j.filePath(j.failedSentinelName()), []byte(msg), 0o666); werr != nil {
+		j.logger.LogAttrs(j.context, slog.LevelError, "journal: failed to create failure sentinel", slog.String("journal", j.debugName), slog.Any("err", werr))
+	}
 	j.fail(err)
 }
 
+// IsFailed reports whether the journal has the failure sentinel file, which
+// is created when an fsync fails and prevents writing to the journal.
+func (j *Journal) IsFailed() (bool, error) {
+	_, err := os.Stat(j.filePath(j.failedSentinelName()))
+	if err == nil {
+		return true, nil
+	} else if os.IsNotExist(err) {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+func (j *Journal) failedSentinelName() string {
+	return j.fileNamePrefix + "FAILED" + j.fileNameSuffix
+}
+
 func (j *Journal) filePath(name string) string {
 	return filepath.Join(j.dir, name)
 }
@@ -350,6 +411,9 @@ func (j *Journal) findLastFile(dirf fs.ReadDirFile) string {
 			if !strings.HasSuffix(name, j.fileNameSuffix) {
 				continue
 			}
+			if name == j.failedSentinelName() || j.isSegmentIndexName(name) {
+				continue
+			}
 			if name > lastName {
 				lastName = name
 			}
@@ -362,16 +426,21 @@ func (j *Journal) WriteRecord(timestamp uint32, data []byte) error {
 	if len(data) == 0 {
 		return nil
 	}
+	// a bad clock is not a journal failure, so don't go through j.fail
+	now, err := j.tryNow()
+	if err != nil {
+		return err
+	}
 	if timestamp == 0 {
-		timestamp = j.Now()
+		timestamp = now
 	}
 
 	j.writeLock.Lock()
 	defer j.writeLock.Unlock()
 
-	err := j.ensurePreparedToWrite_locked()
+	err = j.ensurePreparedToWrite_locked()
 	if err != nil {
-		return nil
+		return err
 	}
 
 	var seg uint32
@@ -381,13 +450,13 @@ func (j *Journal) WriteRecord(timestamp uint32, data []byte) error {
 		seg = 1
 		rec = 1
 		prevChecksum = 0
-	} else if j.segWriter.shouldRotate(len(data)) {
+	} else if j.segWriter.shouldRotate(len(data), now) {
 		if j.verbose {
-			j.logger.Debug("rotating segment", "journal", j.debugName, "segment", j.segWriter.seg, "segment_size", j.segWriter.size, "data_size", len(data))
+			j.logger.Debug("rotating segment", "journal", j.debugName, "segment", j.segWriter.seg, "segment_size", j.segWriter.size, "segment_start", j.segWriter.startTS, "data_size", len(data))
 		}
 		seg = j.segWriter.seg + 1
 		rec = j.segWriter.nextRec
-		j.segWriter.close()
+		j.segWriter.rotate()
 		prevChecksum = j.segWriter.checksum() // close might do a commit
 		j.segWriter = nil
 	}
@@ -403,7 +472,60 @@ func (j *Journal) WriteRecord(timestamp uint32, data []byte) error {
 		j.segWriter = sw
 	}
 
-	return j.fail(j.segWriter.writeRecord(timestamp, data))
+	err = j.segWriter.writeRecord(timestamp, data)
+	if err != nil {
+		return j.fail(err)
+	}
+	if j.segWriter.shouldAutoCommit() {
+		return j.fail(j.segWriter.commit())
+	}
+	if j.autoCommitInterval > 0 && j.commitTimer == nil {
+		j.commitTimer = time.AfterFunc(j.autoCommitInterval, j.autoCommit)
+	}
+	return nil
+}
+
+// autoCommit runs on commitTimer after AutoCommitInterval.
+func (j *Journal) autoCommit() {
+	j.writeLock.Lock()
+	defer j.writeLock.Unlock()
+	j.commitTimer = nil
+	if j.segWriter != nil {
+		j.fail(j.segWriter.commit())
+	}
+}
+
+// Rotate commits and closes the current segment, so that it can be archived,
+// and starts a new empty segment, so the rotation survives reopening the
+// journal. Does nothing if no records have been written to the current
+// segment (including when there is no segment yet).
+func (j *Journal) Rotate() error {
+	now, err := j.tryNow()
+	if err != nil {
+		return err
+	}
+
+	j.writeLock.Lock()
+	defer j.writeLock.Unlock()
+
+	err = j.ensurePreparedToWrite_locked()
+	if err != nil {
+		return err
+	}
+	sw := j.segWriter
+	if sw == nil || sw.size == segmentHeaderSize {
+		return nil
+	}
+	if j.verbose {
+		j.logger.Debug("rotating segment on request", "journal", j.debugName, "segment", sw.seg, "segment_size", sw.size)
+	}
+	err = sw.rotate()
+	j.segWriter = nil
+	if err != nil {
+		return j.fail(err)
+	}
+	j.segWriter, err = startSegment(j, sw.seg+1, now, sw.nextRec, sw.checksum())
+	return j.fail(err)
 }
 
 func (j *Journal) Commit() error {
@@ -416,13 +538,21 @@ func (j *Journal) Commit() error {
 type segmentWriter struct {
 	j           *Journal
 	f           *os.File
+	name        string
 	seg         uint32
+	startTS     uint32
 	ts          uint32
 	nextRec     uint64
 	size        int64
 	hash        xxhash.Digest
 	uncommitted bool
 	modified    bool
+
+	uncommittedRecords int
+	uncommittedBytes   int64
+
+	index       []segmentIndexEntry
+	indexedSize int64 // offset of the last index entry
 }
 
 func startSegment(j *Journal, seg, ts uint32, rec uint64, prevChecksum uint64) (*segmentWriter, error) {
@@ -437,13 +567,16 @@ func startSegment(j *Journal, seg, ts uint32, rec uint64, prevChecksum uint64) (
 	defer closeAndDeleteUnlessOK(f, &ok)
 
 	sw := &segmentWriter{
-		j:        j,
-		f:        f,
-		seg:      seg,
-		ts:       ts,
-		nextRec:  rec,
-		size:     segmentHeaderSize,
-		modified: true,
+		j:           j,
+		f:           f,
+		name:        name,
+		seg:         seg,
+		startTS:     ts,
+		ts:          ts,
+		nextRec:     rec,
+		size:        segmentHeaderSize,
+		modified:    true,
+		indexedSize: segmentHeaderSize,
 	}
 	sw.hash.Reset()
 
@@ -509,13 +642,16 @@ func continueSegment(j *Journal, fileName string) (*segmentWriter, error) {
 
 	ok = true
 	return &segmentWriter{
-		j:       j,
-		f:       f,
-		seg:     sr.seg,
-		ts:      sr.ts,
-		nextRec: sr.rec + 1,
-		size:    sr.committedSize,
-		hash:    sr.hash,
+		j:           j,
+		f:           f,
+		name:        fileName,
+		seg:         sr.seg,
+		startTS:     sr.startTS,
+		ts:          sr.ts,
+		nextRec:     sr.rec + 1,
+		size:        sr.committedSize,
+		hash:        sr.hash,
+		indexedSize: sr.committedSize,
 	}, nil
 }
 
@@ -547,6 +683,8 @@ func (sw *segmentWriter) writeRecord(ts uint32, data []byte) error {
 
 	sw.uncommitted = true
 	sw.modified = true
+	sw.uncommittedRecords++
+	sw.uncommittedBytes += int64(len(h) + len(data))
 	sw.nextRec++
 	sw.size += int64(len(h) + len(data))
 
@@ -562,6 +700,8 @@ func (sw *segmentWriter) commit() error {
 		return nil
 	}
 	sw.uncommitted = false
+	sw.uncommittedRecords = 0
+	sw.uncommittedBytes = 0
 	sw.modified = true
 	sw.size += 8
 
@@ -574,22 +714,47 @@ func (sw *segmentWriter) commit() error {
 		return err
 	}
 
+	sw.addIndexEntry()
 	return nil
 }
 
+func (sw *segmentWriter) shouldAutoCommit() bool {
+	j := sw.j
+	if j.autoCommitRecords > 0 && sw.uncommittedRecords >= j.autoCommitRecords {
+		return true
+	}
+	if j.autoCommitBytes > 0 && sw.uncommittedBytes >= j.autoCommitBytes {
+		return true
+	}
+	return false
+}
+
 func (sw *segmentWriter) close() error {
 	if sw.f == nil {
 		return nil
 	}
 	err := sw.commit()
+	f := sw.f
+	sw.f = nil // fsyncFailed closes the writer again
 	if sw.modified {
-		err := mmap.Fdatasync(sw.f, nil)
+		err := mmap.Fdatasync(f, nil)
 		if err != nil {
 			sw.j.fsyncFailed(err)
 		}
 	}
-	sw.f.Close()
-	sw.f = nil
+	f.Close()
+	return err
+}
+
+// rotate closes the segment for good and saves its index file.
+func (sw *segmentWriter) rotate() error {
+	if sw.f == nil {
+		return nil
+	}
+	err := sw.close()
+	if err == nil {
+		sw.writeIndex()
+	}
 	return err
 }
 
@@ -597,8 +762,120 @@ func (sw *segmentWriter) checksum() uint64 {
 	return sw.hash.Sum64()
 }
 
-func (sw *segmentWriter) shouldRotate(size int) bool {
-	return sw.size+int64(size) > sw.j.maxFileSize
+func (sw *segmentWriter) shouldRotate(size int, now uint32) bool {
+	if sw.size+int64(size) > sw.j.maxFileSize {
+		return true
+	}
+	if age := sw.j.maxSegmentAge; age > 0 && now > sw.startTS {
+		return time.Duration(now-sw.startTS)*time.Second >= age
+	}
+	return false
+}
+
+// Record is a committed journal record, as returned by Read.
+type Record struct {
+	Segment   uint32 // ordinal of the segment containing the record
+	Ordinal   uint64 // record ordinal, continues across segments
+	Timestamp uint32 // unix time
+	Data      []byte
+}
+
+// Read returns an iterator over the committed records of all segments, in
+// order. Records not followed by a commit are skipped, the same way recovery
+// truncates them, and so are corrupted segments. Yields ErrIncompatible or
+// ErrUnsupportedVersion (and stops) when it encounters a segment written
+// with different options or by a newer version, and ctx.Err() when ctx gets
+// canceled.
+//
+// Data of each record is a fresh slice owned by the caller.
+func (j *Journal) Read(ctx context.Context) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		names, err := j.segmentFileNames()
+		if err != nil {
+			yield(Record{}, err)
+			return
+		}
+		for _, name := range names {
+			if !j.readSegment(ctx, name, yield) {
+				return
+			}
+		}
+	}
+}
+
+// readSegment yields the committed records of a single segment file,
+// returning false if iteration should stop.
+func (j *Journal) readSegment(ctx context.Context, fileName string, yield func(Record, error) bool) bool {
+	f, err := j.openFile(fileName, false)
+	if os.IsNotExist(err) {
+		return true
+	} else if err != nil {
+		return yield(Record{}, err)
+	}
+	defer f.Close()
+
+	sr, err := newSegmentReader(j, f, fileName)
+	if err == errCorruptedFile {
+		return true
+	} else if err != nil {
+		yield(Record{}, fmt.Errorf("%v: %s: %w", j.debugName, fileName, err))
+		return false
+	}
+
+	// records only become visible once a commit follows them
+	var pending []Record
+	flush := func() bool {
+		var n int
+		for n < len(pending) && pending[n].Ordinal <= sr.committedRec {
+			if !yield(pending[n], nil) {
+				return false
+			}
+			n++
+		}
+		pending = pending[:copy(pending, pending[n:])]
+		return true
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			yield(Record{}, err)
+			return false
+		}
+		err := sr.next()
+		if err == io.EOF || err == errCorruptedFile {
+			return flush()
+		} else if err != nil {
+			yield(Record{}, fmt.Errorf("%v: %s: %w", j.debugName, fileName, err))
+			return false
+		}
+		pending = append(pending, Record{
+			Segment:   sr.seg,
+			Ordinal:   sr.rec,
+			Timestamp: sr.ts,
+			Data:      append([]byte(nil), sr.data...),
+		})
+		if !flush() {
+			return false
+		}
+	}
+}
+
+func (j *Journal) segmentFileNames() ([]string, error) {
+	ents, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, ent := range ents {
+		if !ent.Type().IsRegular() {
+			continue
+		}
+		name := ent.Name()
+		if strings.HasPrefix(name, j.fileNamePrefix) && strings.HasSuffix(name, j.fileNameSuffix) && name != j.failedSentinelName() && !j.isSegmentIndexName(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
 }
 
 type segmentReader struct {
@@ -607,6 +884,7 @@ type segmentReader struct {
 	r             *bufio.Reader
 	hash          xxhash.Digest
 	seg           uint32
+	startTS       uint32
 	rec           uint64
 	ts            uint32
 	size          int64
@@ -644,6 +922,7 @@ func newSegmentReader(j *Journal, f *os.File, fileName string) (*segmentReader,
 		f:             f,
 		r:             bufio.NewReader(f),
 		seg:           seg,
+		startTS:       ts,
 		rec:           rec - 1,
 		ts:            ts,
 		size:          0,
@@ -1,6 +1,11 @@
 package journal_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -133,6 +138,281 @@ func TestJournal_fullFlow(t *testing.T) {
 	)
 }
 
+func TestJournal_segmentAge(t *testing.T) {
+	j := journaltest.Writable(t, journal.Options{
+		MaxSegmentAge: 24 * time.Hour,
+	})
+	ensure(j.WriteRecord(0, []byte("a")))
+	j.Advance(23 * time.Hour)
+	ensure(j.WriteRecord(0, []byte("b")))
+	deepEq(t, len(j.FileNames()), 1)
+
+	j.Advance(1 * time.Hour)
+	ensure(j.WriteRecord(0, []byte("c")))
+	ensure(j.FinishWriting())
+	deepEq(t, j.FileNames(), []string{
+		"j0000000001-20240101T000000-000000000001.wal",
+		"j0000000002-20240102T000000-000000000003.wal",
+	})
+
+	// an old in-progress segment rotates on the next write after reopening
+	j.Advance(30 * time.Hour)
+	j.StartWriting()
+	ensure(j.WriteRecord(0, []byte("d")))
+	ensure(j.FinishWriting())
+	deepEq(t, j.FileNames(), []string{
+		"j0000000001-20240101T000000-000000000001.wal",
+		"j0000000002-20240102T000000-000000000003.wal",
+		"j0000000003-20240103T060000-000000000004.wal",
+	})
+}
+
+func TestJournal_Rotate(t *testing.T) {
+	j := journaltest.Writable(t, journal.Options{})
+	ensure(j.Rotate())
+	deepEq(t, len(j.FileNames()), 0)
+
+	ensure(j.WriteRecord(0, []byte("a")))
+	ensure(j.Rotate())
+	ensure(j.Rotate()) // the new segment is empty, so this does nothing
+	j.Advance(5 * time.Second)
+
+	// the rotation persists across reopening
+	ensure(j.FinishWriting())
+	ensure(j.WriteRecord(0, []byte("b")))
+	ensure(j.FinishWriting())
+	deepEq(t, j.FileNames(), []string{
+		"j0000000001-20240101T000000-000000000001.wal",
+		"j0000000002-20240101T000000-000000000002.wal",
+	})
+
+	var recs []string
+	for rec, err := range j.Read(context.Background()) {
+		ensure(err)
+		recs = append(recs, fmt.Sprintf("%d/%d:%s", rec.Segment, rec.Ordinal, rec.Data))
+	}
+	deepEq(t, recs, []string{"1/1:a", "2/2:b"})
+}
+
+func TestJournal_autoCommit(t *testing.T) {
+	committed := func(j *journaltest.TestJournal) []string {
+		var result []string
+		for rec, err := range j.Read(context.Background()) {
+			ensure(err)
+			result = append(result, string(rec.Data))
+		}
+		return result
+	}
+
+	t.Run("records", func(t *testing.T) {
+		j := journaltest.Writable(t, journal.Options{AutoCommitRecords: 2})
+		ensure(j.WriteRecord(0, []byte("a")))
+		deepEq(t, len(committed(j)), 0)
+		ensure(j.WriteRecord(0, []byte("b")))
+		ensure(j.WriteRecord(0, []byte("c")))
+		deepEq(t, committed(j), []string{"a", "b"})
+		j.Eq(j.FileNames()[0],
+			shdr("1.. 80_00_92_65 1.../rec 0.../prev", "2d 84 3b 7e 1d d5 01 39"),
+			"#2 #0 'a",
+			"#2 #0 'b",
+			"b5 64 e6 f7 f1 88 ff eb",
+			"#2 #0 'c",
+		)
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		j := journaltest.Writable(t, journal.Options{AutoCommitBytes: 10})
+		ensure(j.WriteRecord(0, []byte("hello")))
+		deepEq(t, len(committed(j)), 0)
+		ensure(j.WriteRecord(0, []byte("world")))
+		deepEq(t, committed(j), []string{"hello", "world"})
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		j := journaltest.Writable(t, journal.Options{AutoCommitInterval: 10 * time.Millisecond})
+		ensure(j.WriteRecord(0, []byte("a")))
+		deadline := time.Now().Add(5 * time.Second)
+		for len(committed(j)) == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		deepEq(t, committed(j), []string{"a"})
+	})
+}
+
+func TestJournal_Read(t *testing.T) {
+	j := journaltest.Writable(t, journal.Options{
+		MaxFileSize: 165,
+	})
+	ensure(j.WriteRecord(0, []byte("hello")))
+	ensure(j.WriteRecord(0, []byte("w")))
+	j.Advance(1000 * time.Second)
+	ensure(j.WriteRecord(0, []byte("orld")))
+	ensure(j.Commit())
+	ensure(j.WriteRecord(0, []byte("foo")))
+	ensure(j.WriteRecord(0, []byte("boooooooo")))
+	j.Advance(10 * time.Second)
+	ensure(j.WriteRecord(0, []byte("wooo")))
+	ensure(j.FinishWriting())
+
+	files := j.FileNames()
+	deepEq(t, len(files), 2)
+
+	read := func(j *journal.Journal) ([]string, error) {
+		var result []string
+		for rec, err := range j.Read(context.Background()) {
+			if err != nil {
+				return result, err
+			}
+			result = append(result, fmt.Sprintf("%d/%d@%d:%s", rec.Segment, rec.Ordinal, int64(rec.Timestamp)-journaltest.Start.Unix(), rec.Data))
+		}
+		return result, nil
+	}
+	all := []string{
+		"1/1@0:hello",
+		"1/2@0:w",
+		"1/3@1000:orld",
+		"1/4@1000:foo",
+		"2/5@1000:boooooooo",
+		"2/6@1010:wooo",
+	}
+	deepEq(t, must(read(j.Journal)), all)
+
+	// uncommitted tail is skipped
+	ensure(os.WriteFile(filepath.Join(j.Dir, files[1]), append(j.Data(files[1]), journaltest.Expand("#4 #0 'xx")...), 0o644))
+	deepEq(t, must(read(j.Journal)), all)
+
+	// early break
+	var n int
+	for range j.Read(context.Background()) {
+		n++
+		if n == 2 {
+			break
+		}
+	}
+	deepEq(t, n, 2)
+
+	// cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, err := range j.Read(ctx) {
+		deepEq(t, err, context.Canceled)
+		break
+	}
+
+	// incompatible journal
+	other := journal.New(j.Dir, journal.Options{FileName: "j*.wal", JournalInvariant: [32]byte{1}})
+	recs, err := read(other)
+	deepEq(t, len(recs), 0)
+	if !errors.Is(err, journal.ErrIncompatible) {
+		t.Errorf("** got %v, wanted ErrIncompatible", err)
+	}
+}
+
+func TestJournal_WriteRecord_prepareError(t *testing.T) {
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file")
+	ensure(os.WriteFile(notDir, []byte("x"), 0o644))
+
+	for _, path := range []string{filepath.Join(dir, "missing"), notDir} {
+		j := journal.New(path, journal.Options{FileName: "j*.wal"})
+		if err := j.WriteRecord(0, []byte("hello")); err == nil {
+			t.Errorf("%s: WriteRecord succeeded, wanted an error", path)
+		}
+		// the failure sticks, later writes don't pretend to succeed
+		if err := j.WriteRecord(0, []byte("world")); err == nil {
+			t.Errorf("%s: second WriteRecord succeeded, wanted an error", path)
+		}
+	}
+}
+
+func TestJournal_WriteRecord_timestampOutOfRange(t *testing.T) {
+	j := journaltest.Writable(t, journal.Options{})
+	ensure(j.WriteRecord(0, []byte("a")))
+
+	for _, d := range []time.Duration{
+		time.Unix(-1, 0).Sub(journaltest.Start),    // 1969
+		time.Unix(1<<32, 0).Sub(journaltest.Start), // 2106
+	} {
+		j.Advance(d)
+		if err := j.WriteRecord(0, []byte("b")); !errors.Is(err, journal.ErrTimestampOutOfRange) {
+			t.Errorf("** got %v, wanted ErrTimestampOutOfRange", err)
+		}
+		// explicit timestamps still need the clock to decide on rotation
+		if err := j.WriteRecord(42, []byte("b")); !errors.Is(err, journal.ErrTimestampOutOfRange) {
+			t.Errorf("** got %v, wanted ErrTimestampOutOfRange", err)
+		}
+		j.Advance(-d)
+	}
+
+	// the journal keeps working once the clock is back
+	ensure(j.WriteRecord(0, []byte("c")))
+	ensure(j.FinishWriting())
+	var recs []string
+	for rec, err := range j.Read(context.Background()) {
+		ensure(err)
+		recs = append(recs, string(rec.Data))
+	}
+	deepEq(t, recs, []string{"a", "c"})
+}
+
+func TestJournal_FindByTime(t *testing.T) {
+	j := journaltest.Writable(t, journal.Options{
+		MaxFileSize:       200 * 1024,
+		AutoCommitRecords: 1,
+	})
+	base := uint32(journaltest.Start.Unix())
+	data := []byte(strings.Repeat("x", 1000))
+	for i := range 500 {
+		ensure(j.WriteRecord(base+uint32(i/2)*10, data)) // two records per timestamp
+	}
+	ensure(j.FinishWriting())
+
+	var recs []journal.Record
+	for rec, err := range j.Read(context.Background()) {
+		ensure(err)
+		recs = append(recs, rec)
+	}
+	deepEq(t, recs[len(recs)-1].Segment, uint32(3))
+
+	var idxNames []string
+	for _, name := range j.FileNames() {
+		if strings.HasSuffix(name, ".wal.idx") {
+			idxNames = append(idxNames, name)
+		}
+	}
+	deepEq(t, len(idxNames), 2) // all segments but the last one
+
+	check := func() {
+		t.Helper()
+		for i := -1; i <= 251; i += 3 {
+			for _, tm := range []time.Time{
+				journaltest.Start.Add(time.Duration(i) * 10 * time.Second),
+				journaltest.Start.Add(time.Duration(i)*10*time.Second + 500*time.Millisecond),
+			} {
+				seg, rec, err := j.FindByTime(tm)
+
+				var eseg, erec uint64
+				var eerr error = journal.ErrRecordNotFound
+				for _, r := range recs {
+					if !time.Unix(int64(r.Timestamp), 0).Before(tm) {
+						eseg, erec, eerr = uint64(r.Segment), r.Ordinal, nil
+						break
+					}
+				}
+				if seg != eseg || rec != erec || err != eerr {
+					t.Errorf("** FindByTime(%v) = %d, %d, %v, wanted %d, %d, %v", tm, seg, rec, err, eseg, erec, eerr)
+				}
+			}
+		}
+	}
+	check()
+
+	// missing and corrupted index files are ignored
+	ensure(os.Remove(filepath.Join(j.Dir, idxNames[0])))
+	ensure(os.WriteFile(filepath.Join(j.Dir, idxNames[1]), []byte("garbage"), 0o644))
+	check()
+}
+
 func shdr(inside, check string) string {
 	return magic + " " + header1 + " " +
 		inside + " " + header2 + " " + check
@@ -1,7 +1,12 @@
 package journal
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestParseName(t *testing.T) {
@@ -27,3 +32,88 @@ func TestFormatName(t *testing.T) {
 		t.Errorf("name = %q, expected %q", name, exp)
 	}
 }
+
+func TestFsyncFailedSentinel(t *testing.T) {
+	dir := t.TempDir()
+	j := New(dir, Options{FileName: "j*.wal"})
+	ensure(j.WriteRecord(0, []byte("a")))
+	ensure(j.FinishWriting())
+	if failed, err := j.IsFailed(); failed || err != nil {
+		t.Fatalf("IsFailed = %v, %v, wanted false", failed, err)
+	}
+
+	j.fsyncFailed(errors.New("simulated"))
+	if failed, err := j.IsFailed(); !failed || err != nil {
+		t.Fatalf("IsFailed = %v, %v, wanted true", failed, err)
+	}
+	if err := j.Rotate(); err == nil {
+		t.Errorf("Rotate succeeded after fsync failure")
+	}
+
+	// survives restarts
+	j = New(dir, Options{FileName: "j*.wal"})
+	j.StartWriting()
+	if err := j.Rotate(); !errors.Is(err, ErrFailed) {
+		t.Errorf("Rotate = %v, wanted ErrFailed", err)
+	}
+
+	// cleared by the operator
+	ensure(os.Remove(filepath.Join(dir, "jFAILED.wal")))
+	j = New(dir, Options{FileName: "j*.wal"})
+	ensure(j.WriteRecord(0, []byte("b")))
+	ensure(j.FinishWriting())
+	var n int
+	for _, err := range j.Read(context.Background()) {
+		ensure(err)
+		n++
+	}
+	if n != 2 {
+		t.Errorf("read %d records, wanted 2", n)
+	}
+}
+
+func ensure(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestFindByTimeUsesIndex(t *testing.T) {
+	dir := t.TempDir()
+	j := New(dir, Options{FileName: "j*.wal", MaxFileSize: 200 * 1024, AutoCommitRecords: 1})
+	data := make([]byte, 1000)
+	for i := range 300 {
+		ensure(j.WriteRecord(uint32(1000+i), data))
+	}
+	ensure(j.FinishWriting())
+
+	names, err := j.segmentFileNames()
+	ensure(err)
+	if len(names) != 2 {
+		t.Fatalf("segments = %v, wanted 2", names)
+	}
+	fi, err := os.Stat(filepath.Join(dir, names[0]))
+	ensure(err)
+	if entries := j.loadSegmentIndex(names[0], fi.Size()); len(entries) == 0 {
+		t.Fatalf("no index entries for %s", names[0])
+	}
+
+	// corrupt the first record; the index allows to skip over it
+	f, err := os.OpenFile(filepath.Join(dir, names[0]), os.O_RDWR, 0)
+	ensure(err)
+	_, err = f.WriteAt([]byte{0xFF}, segmentHeaderSize+10)
+	ensure(err)
+	ensure(f.Close())
+
+	seg, rec, err := j.FindByTime(time.Unix(1150, 0))
+	if seg != 1 || rec != 151 || err != nil {
+		t.Errorf("FindByTime = %d, %d, %v, wanted 1, 151, nil", seg, rec, err)
+	}
+
+	// without the index, the corrupted segment is skipped entirely
+	ensure(os.Remove(filepath.Join(dir, names[0]+segmentIndexSuffix)))
+	seg, rec, err = j.FindByTime(time.Unix(1150, 0))
+	if seg != 2 || err != nil {
+		t.Errorf("FindByTime = %d, %d, %v, wanted segment 2", seg, rec, err)
+	}
+}
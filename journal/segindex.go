@@ -0,0 +1,304 @@
+package journal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+var ErrRecordNotFound = fmt.Errorf("journal record not found")
+
+const (
+	indexMagic = 0x5844494e52554f4a // "JOURNIDX" as little-endian uint64
+
+	// segmentIndexSuffix is appended to a segment file name to get the name
+	// of its index file.
+	segmentIndexSuffix = ".idx"
+
+	// segmentIndexInterval is the minimum distance between index entries.
+	// Segments smaller than this don't get an index file at all.
+	segmentIndexInterval = 64 * 1024
+)
+
+// segmentIndexEntry describes a commit boundary inside a segment, with enough
+// state to start reading (and verifying) the segment from there.
+type segmentIndexEntry struct {
+	rec    uint64 // ordinal of the first record after offset
+	ts     uint32 // timestamp of the last record before offset
+	offset int64
+	hash   []byte // marshaled xxhash state at offset
+}
+
+func (j *Journal) isSegmentIndexName(name string) bool {
+	return strings.HasSuffix(name, j.fileNameSuffix+segmentIndexSuffix)
+}
+
+// addIndexEntry records the current position if enough data has been
+// committed since the last index entry. Must be called right after a commit.
+func (sw *segmentWriter) addIndexEntry() {
+	if sw.size-sw.indexedSize < segmentIndexInterval {
+		return
+	}
+	hash, err := sw.hash.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	sw.index = append(sw.index, segmentIndexEntry{
+		rec:    sw.nextRec,
+		ts:     sw.ts,
+		offset: sw.size,
+		hash:   hash,
+	})
+	sw.indexedSize = sw.size
+}
+
+// writeIndex saves the index file of a closed segment. The index is only an
+// optimization, so failures are logged rather than returned.
+//
+// A segment continued after reopening the journal only has index entries
+// for the part written after reopening; that's still a valid index.
+func (sw *segmentWriter) writeIndex() {
+	if len(sw.index) == 0 {
+		return
+	}
+	j := sw.j
+	buf := appendSegmentIndex(nil, sw.size, sw.index)
+	err := os.WriteFile(j.filePath(sw.name+segmentIndexSuffix), buf, 0o666)
+	if err != nil {
+		j.logger.LogAttrs(j.context, slog.LevelWarn, "journal: failed to write segment index", slog.String("journal", j.debugName), slog.String("file", sw.name), slog.Any("err", err))
+	}
+}
+
+// loadSegmentIndex returns the index entries of the given segment, or nil
+// if the index file is missing, corrupted or stale.
+func (j *Journal) loadSegmentIndex(fileName string, segSize int64) []segmentIndexEntry {
+	buf, err := os.ReadFile(j.filePath(fileName + segmentIndexSuffix))
+	if err != nil {
+		if j.verbose && !os.IsNotExist(err) {
+			j.logger.Debug("cannot read segment index", "journal", j.debugName, "file", fileName, "err", err)
+		}
+		return nil
+	}
+	size, entries, ok := decodeSegmentIndex(buf)
+	if !ok {
+		if j.verbose {
+			j.logger.Debug("corrupted segment index", "journal", j.debugName, "file", fileName)
+		}
+		return nil
+	}
+	if size != segSize {
+		if j.verbose {
+			j.logger.Debug("stale segment index", "journal", j.debugName, "file", fileName, "indexed_size", size, "size", segSize)
+		}
+		return nil
+	}
+	return entries
+}
+
+// Index files:
+//
+//   - file = magic:64 segmentSize:uvarint count:uvarint entry* checksum:64
+//   - entry = rec:uvarint ts:uvarint offset:uvarint hashLen:uvarint hash
+func appendSegmentIndex(b []byte, segSize int64, entries []segmentIndexEntry) []byte {
+	b = binary.LittleEndian.AppendUint64(b, indexMagic)
+	b = binary.AppendUvarint(b, uint64(segSize))
+	b = binary.AppendUvarint(b, uint64(len(entries)))
+	for _, e := range entries {
+		b = binary.AppendUvarint(b, e.rec)
+		b = binary.AppendUvarint(b, uint64(e.ts))
+		b = binary.AppendUvarint(b, uint64(e.offset))
+		b = binary.AppendUvarint(b, uint64(len(e.hash)))
+		b = append(b, e.hash...)
+	}
+	return binary.LittleEndian.AppendUint64(b, xxhash.Sum64(b))
+}
+
+func decodeSegmentIndex(buf []byte) (segSize int64, entries []segmentIndexEntry, ok bool) {
+	if len(buf) < 16 || binary.LittleEndian.Uint64(buf) != indexMagic {
+		return 0, nil, false
+	}
+	body := buf[:len(buf)-8]
+	if xxhash.Sum64(body) != binary.LittleEndian.Uint64(buf[len(body):]) {
+		return 0, nil, false
+	}
+	b := body[8:]
+
+	uvarint := func() uint64 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			ok = false
+			return 0
+		}
+		b = b[n:]
+		return v
+	}
+
+	ok = true
+	size := uvarint()
+	count := uvarint()
+	if !ok || size > math.MaxInt64 || count > uint64(len(b)) {
+		return 0, nil, false
+	}
+	segSize = int64(size)
+
+	prevOffset := int64(segmentHeaderSize)
+	entries = make([]segmentIndexEntry, 0, count)
+	for range count {
+		rec := uvarint()
+		ts := uvarint()
+		offset := uvarint()
+		hashLen := uvarint()
+		if !ok || ts > math.MaxUint32 || offset > uint64(segSize) || int64(offset) < prevOffset || hashLen > uint64(len(b)) {
+			return 0, nil, false
+		}
+		hash := b[:hashLen]
+		b = b[hashLen:]
+
+		var d xxhash.Digest
+		if d.UnmarshalBinary(hash) != nil {
+			return 0, nil, false
+		}
+		entries = append(entries, segmentIndexEntry{
+			rec:    rec,
+			ts:     uint32(ts),
+			offset: int64(offset),
+			hash:   hash,
+		})
+		prevOffset = int64(offset)
+	}
+	if len(b) != 0 {
+		return 0, nil, false
+	}
+	return segSize, entries, true
+}
+
+// seek continues reading the segment from the given index entry, as if all
+// records before it have been read and verified. Returns errCorruptedFile
+// without changing anything if the entry does not fit the segment.
+func (sr *segmentReader) seek(e segmentIndexEntry) error {
+	var hash xxhash.Digest
+	if e.rec <= sr.rec || hash.UnmarshalBinary(e.hash) != nil {
+		return errCorruptedFile
+	}
+	_, err := sr.f.Seek(e.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	sr.r.Reset(sr.f)
+	sr.hash = hash
+	sr.recordsInSeg += int(e.rec - sr.rec - 1)
+	sr.rec = e.rec - 1
+	sr.ts = e.ts
+	sr.size = e.offset
+	sr.committedRec = sr.rec
+	sr.committedTS = sr.ts
+	sr.committedSize = sr.size
+	return nil
+}
+
+// FindByTime returns the segment and record ordinals of the first committed
+// record with a timestamp at or after t, or ErrRecordNotFound if there's
+// no such record.
+//
+// The segment is picked based on file names, and the index files written
+// when segments get rotated allow to skip most of the segment. Segments
+// without a valid index file are scanned from the start.
+func (j *Journal) FindByTime(t time.Time) (segment, record uint64, err error) {
+	v := t.Unix()
+	if t.Nanosecond() > 0 {
+		v++ // timestamps have 1 second precision
+	}
+	if v > math.MaxUint32 {
+		return 0, 0, ErrRecordNotFound
+	}
+	ts := uint32(max(v, 0))
+
+	names, err := j.segmentFileNames()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// earlier segments only have records older than this one's first record
+	var start int
+	for i, name := range names {
+		_, segTS, _, err := parseSegmentName(j.fileNamePrefix, j.fileNameSuffix, name)
+		if err == nil && segTS <= ts {
+			start = i
+		}
+	}
+
+	for _, name := range names[start:] {
+		seg, rec, err := j.findInSegment(name, ts)
+		if err != nil {
+			return 0, 0, err
+		}
+		if rec != 0 {
+			return uint64(seg), rec, nil
+		}
+	}
+	return 0, 0, ErrRecordNotFound
+}
+
+// findInSegment returns the first committed record of the given segment file
+// with a timestamp of at least ts, or rec == 0 if there's none.
+func (j *Journal) findInSegment(fileName string, ts uint32) (seg uint32, rec uint64, err error) {
+	f, err := j.openFile(fileName, false)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sr, err := newSegmentReader(j, f, fileName)
+	if err == errCorruptedFile {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, fmt.Errorf("%v: %s: %w", j.debugName, fileName, err)
+	}
+
+	// entry.ts is the timestamp of the last record before entry.offset,
+	// so everything before the last entry with entry.ts < ts is too old
+	if entries := j.loadSegmentIndex(fileName, fi.Size()); len(entries) > 0 {
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].ts >= ts })
+		if i > 0 {
+			err := sr.seek(entries[i-1])
+			if err != nil && err != errCorruptedFile {
+				return 0, 0, fmt.Errorf("%v: %s: %w", j.debugName, fileName, err)
+			}
+		}
+	}
+
+	// records only count once a commit follows them
+	var candidate uint64
+	for {
+		err := sr.next()
+		if err == io.EOF || err == errCorruptedFile {
+			if candidate != 0 && candidate <= sr.committedRec {
+				return sr.seg, candidate, nil
+			}
+			return 0, 0, nil
+		} else if err != nil {
+			return 0, 0, fmt.Errorf("%v: %s: %w", j.debugName, fileName, err)
+		}
+		if candidate != 0 && candidate <= sr.committedRec {
+			return sr.seg, candidate, nil
+		}
+		if candidate == 0 && sr.ts >= ts {
+			candidate = sr.rec
+		}
+	}
+}
@@ -61,7 +61,28 @@ func dump(buf *strings.Builder, fc *FmtContext, m AnyMap) {
 			buf.WriteString(vt.FormatValue(fc, v))
 		case ValueKindMap:
 			dump(buf, fc, m.GetAnyMap(k))
+		case ValueKindList:
+			dumpList(buf, fc, vt.ItemType(), m.GetAnyList(k))
 		}
 	}
 	buf.WriteByte('}')
 }
+
+func dumpList(buf *strings.Builder, fc *FmtContext, itemType AnyType, l AnyList) {
+	if l.IsMissing() {
+		buf.WriteString("<missing>")
+		return
+	}
+	if itemType == nil {
+		itemType = TUnknownUint64
+	}
+	buf.WriteByte('[')
+	for i, n := 0, l.Len(); i < n; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(itemType.FormatValue(fc, l.At(i)))
+	}
+	buf.WriteByte(']')
+}
@@ -0,0 +1,128 @@
+package kvo
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota + 1
+	ChangeRemoved
+	ChangeModified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	default:
+		return "ChangeKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// Change describes a single differing key between two records.
+//
+// Path holds the keys (prop codes for modeled maps) leading from the root map
+// to the changed value. For word values, Old and New hold the values
+// themselves; for other kinds they are raw references into the respective
+// records and are only meaningful when compared against zero.
+type Change struct {
+	Path []uint64
+	Kind ChangeKind
+	Old  uint64
+	New  uint64
+}
+
+func (c Change) Key() uint64 {
+	return c.Path[len(c.Path)-1]
+}
+
+func (c Change) String() string {
+	var buf strings.Builder
+	for i, k := range c.Path {
+		if i > 0 {
+			buf.WriteByte('.')
+		}
+		buf.WriteString(strconv.FormatUint(k, 10))
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(c.Kind.String())
+	return buf.String()
+}
+
+// Diff computes per-key differences between two records of the same root
+// type, recursing into nested maps. Keys of a map that was added or removed
+// as a whole are reported individually. Changes are ordered by path.
+func Diff(old, new ImmutableRecord) []Change {
+	var changes []Change
+	diffMaps(&changes, nil, old.Root(), new.Root())
+	return changes
+}
+
+func diffMaps(changes *[]Change, path []uint64, old, new ImmutableMap) {
+	oldKeys, newKeys := old.obj.MapKeys(), new.obj.MapKeys()
+	i, j := 0, 0
+	for i < len(oldKeys) || j < len(newKeys) {
+		var key uint64
+		switch {
+		case j == len(newKeys) || (i < len(oldKeys) && oldKeys[i] < newKeys[j]):
+			key = oldKeys[i]
+			i++
+		case i == len(oldKeys) || newKeys[j] < oldKeys[i]:
+			key = newKeys[j]
+			j++
+		default:
+			key = oldKeys[i]
+			i++
+			j++
+		}
+		diffValues(changes, append(path, key), old, new, key)
+	}
+}
+
+func diffValues(changes *[]Change, path []uint64, old, new ImmutableMap, key uint64) {
+	typ := new.KeyModel(key)
+	if typ == nil {
+		typ = old.KeyModel(key)
+	}
+	if typ != nil && typ.ValueKind() == ValueKindMap {
+		diffMaps(changes, path, old.GetMap(key), new.GetMap(key))
+		return
+	}
+
+	ov, nv := old.Get(key), new.Get(key)
+	var kind ChangeKind
+	switch {
+	case ov == 0:
+		kind = ChangeAdded
+	case nv == 0:
+		kind = ChangeRemoved
+	case typ == nil || typ.ValueKind() == ValueKindWord:
+		if ov == nv {
+			return
+		}
+		kind = ChangeModified
+	default:
+		if objectValuesEqual(old.rec, ov, new.rec, nv) {
+			return
+		}
+		kind = ChangeModified
+	}
+	*changes = append(*changes, Change{slices.Clone(path), kind, ov, nv})
+}
+
+func objectValuesEqual(oldRec ImmutableRecordData, ov uint64, newRec ImmutableRecordData, nv uint64) bool {
+	if isInlineData(ov) || isInlineData(nv) {
+		return ov == nv
+	}
+	oo, okind, osize := oldRec.object(int(ov))
+	no, nkind, nsize := newRec.object(int(nv))
+	return okind == nkind && osize == nsize && slices.Equal(oo, no)
+}
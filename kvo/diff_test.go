@@ -0,0 +1,39 @@
+package kvo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	m := NewRecord(TDoodad)
+	m.Set(KFoo, 0x42)
+	fillMap(m.UpdateMap(KBar))
+	fillGizmo(m.UpdateMap(KQux), 1000, 1)
+	old := m.rec.PackedRecord()
+
+	eq(t, len(Diff(old, old)), 0)
+
+	m = UpdateRecord(old)
+	m.Set(KFoo, 0)                                          // removed
+	m.UpdateMap(KBar).Set(300, 1)                           // added
+	m.UpdateMap(KQux).UpdateMap(KWobble).Set(KWibble, 2001) // nested modification
+	fillGizmo(m.UpdateMap(KWaldo).UpdateMap(7), 3000, 0)    // whole map added
+	new := m.rec.PackedRecord()
+
+	eq(t, diffString(Diff(old, new)), "100 removed, 101.300 added, 102.114.113 modified, 108.7.113 added")
+	eq(t, diffString(Diff(new, old)), "100 added, 101.300 removed, 102.114.113 modified, 108.7.113 removed")
+
+	changes := Diff(old, new)
+	eq(t, changes[2].Old, uint64(1001))
+	eq(t, changes[2].New, uint64(2001))
+	eq(t, changes[2].Key(), KWibble)
+}
+
+func diffString(changes []Change) string {
+	var items []string
+	for _, c := range changes {
+		items = append(items, c.String())
+	}
+	return strings.Join(items, ", ")
+}
@@ -3,6 +3,7 @@ package kvo
 import (
 	"strconv"
 	"strings"
+	"unsafe"
 )
 
 const (
@@ -25,6 +26,58 @@ func unpackKindOffsetSize(packed uint64) (kind byte, off, size uint32) {
 	return
 }
 
+const (
+	inlineDataFlag     uint64 = 1 << 63
+	inlineDataMaxLen          = 7
+	inlineDataLenShift        = 56
+)
+
+// packInlineData encodes up to 7 bytes into a single valueOrRef word,
+// returning false if the data is too long to be inlined.
+func packInlineData(b []byte) (uint64, bool) {
+	if len(b) > inlineDataMaxLen {
+		return 0, false
+	}
+	v := inlineDataFlag | uint64(len(b))<<inlineDataLenShift
+	for i, c := range b {
+		v |= uint64(c) << (8 * i)
+	}
+	return v, true
+}
+
+func isInlineData(v uint64) bool {
+	return v&inlineDataFlag != 0
+}
+
+func unpackInlineData(v uint64) []byte {
+	n := int(v>>inlineDataLenShift) & 0x7
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(v >> (8 * i))
+	}
+	return b
+}
+
+// bytesToWords copies b into a zero-padded slice of words.
+func bytesToWords(b []byte) []uint64 {
+	words := make([]uint64, (len(b)+7)/8)
+	if len(b) > 0 {
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), len(words)*8), b)
+	}
+	return words
+}
+
+// wordsToBytes returns the first n bytes of words, without copying.
+func wordsToBytes(words []uint64, n int) []byte {
+	if n == 0 {
+		return nil
+	}
+	if n > len(words)*8 {
+		panic("out of bounds")
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), n)
+}
+
 func HexString(data []uint64) string {
 	var buf strings.Builder
 	for i, w := range data {
@@ -1,6 +1,7 @@
 package kvo
 
 import (
+	"bytes"
 	"fmt"
 	"sort"
 	"unsafe"
@@ -91,6 +92,22 @@ func (m ImmutableMap) GetAnyMap(key uint64) AnyMap {
 	return m.GetMap(key)
 }
 
+// GetBytes returns a copy of the string or binary data stored under the key,
+// or nil if the key is missing.
+func (m ImmutableMap) GetBytes(key uint64) []byte {
+	raw := m.Get(key)
+	if raw == 0 {
+		return nil
+	} else if isInlineData(raw) {
+		return unpackInlineData(raw)
+	} else {
+		return bytes.Clone(m.rec.objectBytes(int(raw)))
+	}
+}
+func (m ImmutableMap) GetString(key uint64) string {
+	return string(m.GetBytes(key))
+}
+
 func (m ImmutableMap) Items() func(yield func(k, v uint64) bool) {
 	return m.items
 }
@@ -103,11 +120,42 @@ func (m ImmutableMap) items(yield func(k, v uint64) bool) {
 	}
 }
 
-// TODO
-type List struct {
-	itemModel *Model
-	rec       ImmutableRecord
-	obj       ImmutableObjectData
+func (m ImmutableMap) GetList(key uint64) ImmutableList {
+	valueType := m.KeyModel(key)
+	raw := m.Get(key)
+	if raw == 0 {
+		return ImmutableList{valueType, m.rec, nil}
+	} else {
+		return ImmutableList{valueType, m.rec, m.rec.Object(int(raw))}
+	}
+}
+func (m ImmutableMap) GetAnyList(key uint64) AnyList {
+	return m.GetList(key)
+}
+
+// ImmutableList is a read-only view of an array object. A missing list
+// behaves like an empty one.
+type ImmutableList struct {
+	typ AnyType
+	rec ImmutableRecordData
+	obj ImmutableObjectData // nil if object missing
+}
+
+func (l ImmutableList) IsMissing() bool    { return l.obj == nil }
+func (l ImmutableList) Type() AnyType      { return l.typ }
+func (l ImmutableList) Len() int           { return len(l.obj) }
+func (l ImmutableList) At(i int) uint64    { return l.obj[i] }
+func (l ImmutableList) Packable() Packable { return l.rec }
+
+func (l ImmutableList) Items() func(yield func(i int, v uint64) bool) {
+	return l.items
+}
+func (l ImmutableList) items(yield func(i int, v uint64) bool) {
+	for i, v := range l.obj {
+		if !yield(i, v) {
+			break
+		}
+	}
 }
 
 // ImmutableRecordData provides for random access to an immutable tree of
@@ -129,7 +177,7 @@ type List struct {
 //		  inlineStringOrData -> alwaysOne:1 zeros:4 byteCount:3 inlineBytes:[7]byte
 //		  objectRef -> alwaysZero:1 zeroes:31 objectIndex:32
 //
-// Note that only maps are implements so far.
+// Note that only maps and arrays are implemented so far.
 type ImmutableRecordData []uint64
 
 var emptyImmutableRecordData = ImmutableRecordData{0}
@@ -192,6 +240,14 @@ func (r ImmutableRecordData) object(i int) (ImmutableObjectData, byte, uint32) {
 	return ImmutableObjectData(r[start:end]), kind, size
 }
 
+func (r ImmutableRecordData) objectBytes(i int) []byte {
+	o, kind, size := r.object(i)
+	if kind != objectKindString && kind != objectKindRaw {
+		panic(fmt.Sprintf("object %d is not a string or binary data (kind %d)", i, kind))
+	}
+	return wordsToBytes(o, int(size))
+}
+
 // ImmutableObjectData represents a single object within ImmutableRecordData.
 // It can be a map, an array or a set. Could even be a string or a blob, but
 // we'd lack the exact size info, and those are better represented as []byte.
@@ -34,10 +34,18 @@ type AnyMap interface {
 	Keys() []uint64
 	Get(key uint64) uint64
 	GetAnyMap(key uint64) AnyMap
+	GetAnyList(key uint64) AnyList
 	Packable() Packable
 	Dump() string
 }
 
+type AnyList interface {
+	Type() AnyType
+	IsMissing() bool
+	Len() int
+	At(i int) uint64
+}
+
 type FmtContext struct {
 	// data map[any]any
 }
@@ -1,6 +1,10 @@
 package kvo
 
-import "sort"
+import (
+	"bytes"
+	"slices"
+	"sort"
+)
 
 var (
 	tombstone = &mutableObjectData{nil, nil, 0, -1, objectKindTombstone}
@@ -67,6 +71,30 @@ func (rec *MutableRecord) addMap(typ AnyType) MutableMap {
 	return MutableMap{rec, o, typ}
 }
 
+func (rec *MutableRecord) addList(typ AnyType) MutableList {
+	i := len(rec.objects)
+	o := &mutableObjectData{nil, nil, 0, i, objectKindArray}
+	rec.objects = append(rec.objects, o)
+	return MutableList{rec, o, typ}
+}
+
+func (rec *MutableRecord) addData(kind byte, b []byte) *mutableObjectData {
+	i := len(rec.objects)
+	o := &mutableObjectData{bytesToWords(b), nil, uint32(len(b)), i, kind}
+	rec.objects = append(rec.objects, o)
+	return o
+}
+
+func (rec *MutableRecord) objectBytes(i int) []byte {
+	if existing := rec.objects[i]; existing != nil {
+		if existing == tombstone {
+			panic("unreachable: tombstone encountered")
+		}
+		return wordsToBytes(existing.data, int(existing.size))
+	}
+	return rec.original.objectBytes(i)
+}
+
 func (rec *MutableRecord) updateMap(i int, typ AnyType) MutableMap {
 	o, _ := rec.lookupObject(i)
 	return MutableMap{rec, o, typ}
@@ -230,6 +258,51 @@ func (m MutableMap) Set(key, value uint64) {
 	}
 }
 
+// SetString stores a string under the key. Strings of up to 7 bytes are
+// stored inline, longer ones are allocated as separate string objects.
+func (m MutableMap) SetString(key uint64, s string) {
+	m.setData(key, objectKindString, []byte(s))
+}
+
+// SetBytes is like SetString, but stores binary data. Note that nil and empty
+// slices are both stored as empty data; use Set(key, 0) to delete the key.
+func (m MutableMap) SetBytes(key uint64, b []byte) {
+	m.setData(key, objectKindRaw, b)
+}
+
+func (m MutableMap) setData(key uint64, kind byte, b []byte) {
+	ensureCanAccessKey(m.typ, key)
+	old := m.obj.MapGet(key)
+	value, ok := packInlineData(b)
+	if !ok {
+		value = m.rec.addData(kind, b).Ref()
+	}
+	if m.obj.MapSet(key, value) {
+		m.rec.markModified()
+	}
+	if old != 0 && !isInlineData(old) && old != value {
+		m.rec.objects[old] = tombstone // replaced object is no longer referenced
+	}
+}
+
+// GetBytes returns a copy of the string or binary data stored under the key,
+// or nil if the key is missing.
+func (m MutableMap) GetBytes(key uint64) []byte {
+	ensureCanAccessKey(m.typ, key)
+	value := m.obj.MapGet(key)
+	if value == 0 {
+		return nil
+	} else if isInlineData(value) {
+		return unpackInlineData(value)
+	} else {
+		return bytes.Clone(m.rec.objectBytes(int(value)))
+	}
+}
+
+func (m MutableMap) GetString(key uint64) string {
+	return string(m.GetBytes(key))
+}
+
 func (m MutableMap) GetAnyMap(key uint64) AnyMap {
 	var valueType AnyType
 	if m.typ != nil {
@@ -252,8 +325,30 @@ func (m MutableMap) GetAnyMap(key uint64) AnyMap {
 	}
 }
 
+func (m MutableMap) GetAnyList(key uint64) AnyList {
+	var valueType AnyType
+	if m.typ != nil {
+		valueType = m.typ.MapValueType(key)
+		if valueType == nil {
+			reportCannotAccessKey(m.typ, key)
+		}
+	}
+
+	value := m.obj.MapGet(key)
+	if value == 0 {
+		return ImmutableList{valueType, m.rec.original, nil}
+	} else {
+		updated, orig := m.rec.getObject(int(value))
+		if updated != nil {
+			return MutableList{m.rec, updated, valueType}
+		} else {
+			return ImmutableList{valueType, m.rec.original, orig}
+		}
+	}
+}
+
 func (m MutableMap) Keys() []uint64 {
-	panic("not implemented")
+	return m.obj.MapKeys()
 }
 
 func (m MutableMap) UpdateMap(key uint64) MutableMap {
@@ -280,6 +375,65 @@ func (m MutableMap) UpdateMap(key uint64) MutableMap {
 	}
 }
 
+func (m MutableMap) UpdateList(key uint64) MutableList {
+	var valueType AnyType
+	if m.typ != nil {
+		valueType = m.typ.MapValueType(key)
+		if valueType == nil {
+			reportCannotAccessKey(m.typ, key)
+		}
+	}
+	value := m.obj.MapGet(key)
+	if value == 0 {
+		child := m.rec.addList(valueType)
+		m.obj.MapSet(key, child.obj.Ref())
+		return child
+	} else {
+		child, isNew := m.rec.lookupObject(int(value))
+		if isNew {
+			if child.kind != objectKindArray {
+				panic("object is not a list")
+			}
+			// lists are rewritten as a whole, so data holds the full contents
+			child.data = append([]uint64(nil), child.orig...)
+			child.orig = nil
+			if m.obj.MapSet(key, child.Ref()) {
+				m.rec.markModified()
+			}
+		}
+		return MutableList{m.rec, child, valueType}
+	}
+}
+
+// MutableList allows mutating a list within a MutableRecord. Unlike maps,
+// mutable lists keep a full copy of their items, and are packed as is.
+type MutableList struct {
+	rec *MutableRecord
+	obj *mutableObjectData
+	typ AnyType
+}
+
+func (l MutableList) IsMissing() bool        { return l.rec == nil }
+func (l MutableList) Record() *MutableRecord { return l.rec }
+func (l MutableList) Type() AnyType          { return l.typ }
+func (l MutableList) Packable() Packable     { return l.rec }
+func (l MutableList) Len() int               { return len(l.obj.data) }
+func (l MutableList) At(i int) uint64        { return l.obj.data[i] }
+
+func (l MutableList) Set(i int, value uint64) {
+	if l.obj.data[i] != value {
+		l.obj.data[i] = value
+		l.rec.markModified()
+	}
+}
+
+func (l MutableList) Append(values ...uint64) {
+	if len(values) > 0 {
+		l.obj.data = append(l.obj.data, values...)
+		l.rec.markModified()
+	}
+}
+
 // mutableObjectData holds the actual mutable data for an object of any kind
 // within a MutableRecord.
 type mutableObjectData struct {
@@ -300,6 +454,35 @@ func (o *mutableObjectData) MapGet(key uint64) uint64 {
 	return o.orig.MapGet(key)
 }
 
+// MapKeys returns the sorted keys with non-zero values, combining the updated
+// keys with the ones from the original object that haven't been overridden.
+func (o *mutableObjectData) MapKeys() []uint64 {
+	n := len(o.data) / 2
+	origKeys := o.orig.MapKeys()
+	keys := make([]uint64, 0, n+len(origKeys))
+	for i := range n {
+		if o.data[i*2+1] != 0 {
+			keys = append(keys, o.data[i*2])
+		}
+	}
+	for _, key := range origKeys {
+		if !o.hasUpdatedKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (o *mutableObjectData) hasUpdatedKey(key uint64) bool {
+	for i, n := 0, len(o.data); i < n; i += 2 {
+		if o.data[i] == key {
+			return true
+		}
+	}
+	return false
+}
+
 func (o *mutableObjectData) MapSet(key uint64, value uint64) bool {
 	n := len(o.data) / 2
 	for i := range n {
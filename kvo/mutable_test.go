@@ -21,3 +21,107 @@ func TestMutableRecord_zero_fields_updating_existing_record(t *testing.T) {
 	l.Set(0x42, 0)
 	eq(t, l.rec.Pack().HexString(), "01 02:10 10 01")
 }
+
+var ListSchema = NewSchema()
+var TListHolder = NewEntityType(ListSchema, "list_holder")
+var KItems = NewProp(ListSchema, 100, "items", List(TUint64), nil)
+var KCount = NewProp(ListSchema, 101, "count", TUint64, nil)
+
+var MListHolder = NewModel(ListSchema, TListHolder, func(b *ModelBuilder) {
+	b.Prop(KItems)
+	b.Prop(KCount)
+})
+
+func TestMutableRecord_list(t *testing.T) {
+	m := NewRecord(TListHolder)
+	m.Set(KCount, 3)
+	l := m.UpdateList(KItems)
+	l.Append(10, 20)
+	l.Append(30)
+	eq(t, l.Len(), 3)
+
+	p := m.rec.PackedRecord()
+	eq(t, p.Data().HexString(), "02 03:20 10000007:18 64 65 01 03 0a 14 1e")
+	eq(t, p.Root().Dump(), "{items: [10, 20, 30], count: 3} (80 bytes)")
+
+	il := p.Root().GetList(KItems)
+	eq(t, il.IsMissing(), false)
+	eq(t, il.Len(), 3)
+	eq(t, il.At(1), uint64(20))
+	var items []uint64
+	for i, v := range il.Items() {
+		eq(t, v, il.At(i))
+		items = append(items, v)
+	}
+	eq(t, HexString(items), "0a 14 1e")
+
+	m = UpdateRecord(p)
+	l = m.UpdateList(KItems)
+	l.Set(1, 21)
+	l.Append(40)
+	eq(t, m.rec.PackedRoot().Dump(), "{items: [10, 21, 30, 40], count: 3} (88 bytes)")
+	eq(t, p.Root().Dump(), "{items: [10, 20, 30], count: 3} (80 bytes)")
+}
+
+func TestMutableRecord_list_missing(t *testing.T) {
+	p := NewRecord(TListHolder).rec.PackedRecord()
+	il := p.Root().GetList(KItems)
+	eq(t, il.IsMissing(), true)
+	eq(t, il.Len(), 0)
+}
+
+func TestMutableMap_Keys(t *testing.T) {
+	l := NewRecord(nil)
+	eq(t, HexString(l.Keys()), "")
+	l.Set(0x42, 1)
+	l.Set(0x10, 2)
+	l.Set(0x20, 3)
+	l.Set(0x20, 0)
+	eq(t, HexString(l.Keys()), "10 42")
+	p := l.rec.PackedRecord()
+	eq(t, HexString(p.Root().Keys()), "10 42")
+
+	l = UpdateRecord(p)
+	eq(t, HexString(l.Keys()), "10 42")
+	l.Set(0x42, 5) // override
+	l.Set(0x10, 0) // delete
+	l.Set(0x30, 6)
+	l.Set(0x05, 7)
+	l.Set(0x05, 0) // add and delete
+	eq(t, HexString(l.Keys()), "30 42")
+	eq(t, HexString(l.rec.PackedRoot().Keys()), "30 42")
+}
+
+func TestMutableMap_strings(t *testing.T) {
+	strs := []string{"", "a", "1234567", "12345678", "hello, long world"}
+	l := NewRecord(nil)
+	for i, s := range strs {
+		l.SetString(uint64(i+1), s)
+	}
+	l.SetBytes(10, []byte{0, 1, 2, 0xFF})
+	l.SetBytes(11, []byte("binary data over 7 bytes"))
+	for i, s := range strs {
+		eq(t, l.GetString(uint64(i+1)), s)
+	}
+
+	p := l.rec.PackedRecord()
+	eq(t, p.Data().HexString(), "04 05:70 20000013:08 20000014:11 30000017:18 01 02 03 04 05 0a 0b 80000000:00 81000000:61 87373635:34333231 01 02 84000000:ff020100 03 38373635:34333231 6c202c6f:6c6c6568 6c726f77:20676e6f 64 64207972:616e6962 7265766f:20617461 73657479:62203720")
+	r := p.Root()
+	for i, s := range strs {
+		eq(t, r.GetString(uint64(i+1)), s)
+	}
+	eq(t, HexString(bytesToWords(r.GetBytes(10))), "ff020100")
+	eq(t, string(r.GetBytes(11)), "binary data over 7 bytes")
+	eq(t, r.GetBytes(12) == nil, true)
+	eq(t, r.GetBytes(1) == nil, false)
+
+	l = UpdateRecord(p)
+	eq(t, l.GetString(5), "hello, long world")
+	l.SetString(5, "replaced with another long string")
+	l.SetString(4, "short")
+	eq(t, l.GetString(5), "replaced with another long string")
+	r = l.rec.PackedRoot()
+	eq(t, r.GetString(4), "short")
+	eq(t, r.GetString(5), "replaced with another long string")
+	eq(t, string(r.GetBytes(11)), "binary data over 7 bytes")
+}
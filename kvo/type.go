@@ -13,6 +13,7 @@ var lastTypeCode uint16 = 9 // 0..9 are reserved
 const (
 	typeCodeNone = 0
 	typeCodeMap  = 1
+	typeCodeList = 2
 )
 
 func allocateTypeCode() typeCodeSet {
@@ -217,6 +218,39 @@ func Map(keyType AnyScalarType, itemType AnyType) *MapType {
 	}
 }
 
+type ListType struct {
+	name     string
+	itemType AnyType
+	codeSet  typeCodeSet
+}
+
+func (typ *ListType) Name() string                                    { return typ.name }
+func (typ *ListType) String() string                                  { return typ.name }
+func (typ *ListType) Schema() *Schema                                 { return typ.itemType.Schema() }
+func (typ *ListType) ValueKind() ValueKind                            { return ValueKindList }
+func (typ *ListType) ItemType() AnyType                               { return typ.itemType }
+func (typ *ListType) typeCodeSet() typeCodeSet                        { return typ.codeSet }
+func (typ *ListType) Model() *Model                                   { return nil }
+func (typ *ListType) MapKeyType() AnyType                             { return nil }
+func (typ *ListType) MapProp(key uint64) PropImpl                     { return nil }
+func (typ *ListType) MapValueType(key uint64) AnyType                 { return nil }
+func (typ *ListType) FormatValue(fc *FmtContext, value uint64) string { panic("unsupported") }
+
+// List returns a type of lists whose items are of the given type. Only word
+// items are supported for now.
+func List(itemType AnyType) *ListType {
+	if itemType.ValueKind() != ValueKindWord {
+		panic(fmt.Sprintf("list items must be words, got %s", itemType.Name()))
+	}
+	codeSet := typeCodeSet{typeCodeList}
+	codeSet.append(itemType.typeCodeSet())
+	return &ListType{
+		name:     "[]" + itemType.Name(),
+		itemType: itemType,
+		codeSet:  codeSet,
+	}
+}
+
 func ensureCanAccessKey(typ AnyType, key uint64) {
 	if typ == nil {
 		return
@@ -35,16 +35,42 @@ func (tx *Tx) TableStats(tbl *Table) TableStats {
 	}
 
 	for _, idx := range tbl.indices {
-		indexBuck := nonNil(tableBuck.Bucket(idx.buck.Raw()))
-		bs = indexBuck.Stats()
-		result.IndexRows += int64(bs.KeyN)
-		result.IndexSize += int64(bs.LeafInuse)
-		result.IndexAlloc += int64(bs.BranchAlloc + bs.LeafAlloc)
+		for shard := range idx.ShardCount() {
+			bs = idx.shardBucketIn(tableBuck, shard).Stats()
+			result.IndexRows += int64(bs.KeyN)
+			result.IndexSize += int64(bs.LeafInuse)
+			result.IndexAlloc += int64(bs.BranchAlloc + bs.LeafAlloc)
+		}
 	}
 
 	return result
 }
 
+// FastCount returns the number of rows stored in tbl, taken from the data
+// bucket's page statistics without decoding any rows. This counts raw keys,
+// so it includes rows that have expired but not been purged yet, which scans
+// and lookups skip on tables with TableBuilder.HideExpired; Count a full
+// TableScan to get only the visible rows. Note that bbolt still visits every
+// page of the bucket to compute the statistics.
+func (tx *Tx) FastCount(tbl *Table) int {
+	tableBuck := nonNil(tx.btx.Bucket(tbl.buck.Raw()))
+	dataBuck := nonNil(tableBuck.Bucket(dataBucket.Raw()))
+	return dataBuck.Stats().KeyN
+}
+
+// IndexKeyCount returns the number of raw entries in idx across all its
+// shards, like FastCount. A row can have any number of entries in an index,
+// so this is not a row count.
+func (tx *Tx) IndexKeyCount(idx *Index) int {
+	idx.requireTable()
+	tableBuck := nonNil(tx.btx.Bucket(idx.table.buck.Raw()))
+	var n int
+	for shard := range idx.ShardCount() {
+		n += idx.shardBucketIn(tableBuck, shard).Stats().KeyN
+	}
+	return n
+}
+
 func (tx *Tx) KVTableStats(tbl *KVTable) TableStats {
 	dataBuck := nonNil(tx.btx.Bucket(tbl.dataBuck.Raw()))
 
@@ -2,13 +2,22 @@ package edb
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
+	"time"
 )
 
+// DeleteAll deletes all rows returned by the cursor, along with their index
+// entries, and returns the number of deleted rows. If the scan stops early
+// because the transaction's context is canceled, nothing is deleted, and
+// DeleteAll panics with an error wrapping the context error.
 func DeleteAll(c RawCursor) int {
 	tbl, tx := c.Table(), c.Tx()
 	var count int
 	keys := AllRawKeys(c)
+	if err := c.Err(); err != nil {
+		panic(fmt.Errorf("%s: DeleteAll: %w", tbl.Name(), err))
+	}
 	for _, key := range keys {
 		if tx.DeleteByKeyRaw(tbl, key) {
 			count++
@@ -17,6 +26,50 @@ func DeleteAll(c RawCursor) int {
 	return count
 }
 
+// DeletePrefix deletes all rows whose composite key shares the first els
+// components with keyPrefix (which must be of the table's key type), along
+// with their index entries. Returns the number of deleted rows.
+func DeletePrefix[Row any](txh Txish, els int, keyPrefix any) int {
+	tx := txh.DBTx()
+	return tx.DeletePrefix(tableOf[Row](tx), els, keyPrefix)
+}
+
+func (tx *Tx) DeletePrefix(tbl *Table, els int, keyPrefix any) int {
+	return DeleteAll(tx.TableScan(tbl, ExactScan(keyPrefix).Prefix(els)))
+}
+
+// PurgeExpired deletes all rows of the table whose expiry time (see
+// TableBuilder.ExpireAfter) is not after now, along with their index
+// entries. Returns the number of deleted rows.
+func PurgeExpired[Row any](txh Txish, now time.Time) int {
+	tx := txh.DBTx()
+	return tx.PurgeExpired(tableOf[Row](tx), now)
+}
+
+func (tx *Tx) PurgeExpired(tbl *Table, now time.Time) int {
+	dataBuck := tbl.dataBucketIn(tbl.rootBucketIn(tx.btx))
+	nowMs := unixMilli(now)
+
+	var keys [][]byte
+	c := dataBuck.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var vle value
+		decodeTableValue(&vle, tbl, k, v)
+		if vle.isExpired(nowMs) {
+			keys = append(keys, bytes.Clone(k))
+		}
+	}
+
+	// bbolt cursors must not be used across mutations, so delete afterwards
+	var count int
+	for _, k := range keys {
+		if tx.DeleteByKeyRaw(tbl, k) {
+			count++
+		}
+	}
+	return count
+}
+
 func DeleteRow[Row any](txh Txish, row *Row) bool {
 	tx := txh.DBTx()
 	rowVal := reflect.ValueOf(row)
@@ -60,10 +113,13 @@ func (tx *Tx) DeleteByKeyRaw(tbl *Table, keyRaw []byte) bool {
 			tx.db.logf("db: DELETE.NOOP %s/%x", tbl.name, keyRaw)
 		}
 	}
-	return true
+	return ok
 }
 
 func (tx *Tx) deleteByKeyRaw(tbl *Table, keyRaw []byte, keyValIfKnown reflect.Value) bool {
+	if tx.timeOps {
+		defer tx.recordOp(time.Now(), "delete", tbl.name)
+	}
 	tableBuck := nonNil(tx.btx.Bucket(tbl.buck.Raw()))
 	dataBuck := nonNil(tableBuck.Bucket(dataBucket.Raw()))
 	ts := tx.db.tableState(tbl)
@@ -78,11 +134,13 @@ func (tx *Tx) deleteByKeyRaw(tbl *Table, keyRaw []byte, keyValIfKnown reflect.Va
 	decodeTableValue(&old, tbl, keyRaw, v)
 
 	tx.markWritten()
+	tx.rememberRow(tbl, keyRaw, v)
 
-	del := prepareToDeleteIndexEntries(tableBuck, ts)
+	del := prepareToDeleteIndexEntries(tableBuck, ts, keyRaw)
 	decodeIndexKeys(old.Index, del)
+	tx.invalidateCached(tbl, keyRaw)
 
-	if opts := tx.changeOptions[tbl]; opts.Contains(ChangeFlagNotify) && tx.changeHandler != nil {
+	if opts := tx.changeFlags(tbl); opts.Contains(ChangeFlagNotify) {
 		chg := Change{
 			table:  tbl,
 			op:     OpDelete,
@@ -102,7 +160,7 @@ func (tx *Tx) deleteByKeyRaw(tbl *Table, keyRaw []byte, keyValIfKnown reflect.Va
 				chg.keyVal = keyValIfKnown
 			}
 		}
-		tx.changeHandler(tx, &chg)
+		tx.notifyChange(&chg)
 	}
 
 	ensure(c.Delete())
@@ -118,7 +176,7 @@ func (tx *Tx) UnsafeDeleteByKeyRawSkippingIndex(tbl *Table, keyRaw []byte) bool
 			tx.db.logf("db: UNSAFE_DELETE_SKIPIDX.NOOP %s/%x", tbl.name, keyRaw)
 		}
 	}
-	return true
+	return ok
 }
 
 func (tx *Tx) unsafeDeleteByKeyRawSkippingIndex(tbl *Table, keyRaw []byte) bool {
@@ -126,12 +184,14 @@ func (tx *Tx) unsafeDeleteByKeyRawSkippingIndex(tbl *Table, keyRaw []byte) bool
 	dataBuck := nonNil(tableBuck.Bucket(dataBucket.Raw()))
 
 	c := dataBuck.Cursor()
-	k, _ := c.Seek(keyRaw)
+	k, v := c.Seek(keyRaw)
 	if !bytes.Equal(k, keyRaw) {
 		return false
 	}
 
 	tx.markWritten()
+	tx.rememberRow(tbl, keyRaw, v)
+	tx.invalidateCached(tbl, keyRaw)
 	ensure(c.Delete())
 	return true
 }
@@ -0,0 +1,110 @@
+package edb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// jsonExportLine is a single line of ExportJSON or ExportTable output. The key
+// is stored separately because key fields are usually excluded from row
+// encoding. Ver is the schema version of the row, only written by
+// ExportTable.
+type jsonExportLine struct {
+	Key json.RawMessage `json:"key"`
+	Ver uint64          `json:"ver,omitempty"`
+	Row json.RawMessage `json:"row"`
+}
+
+// ExportJSON writes all rows of tbl to w as JSON Lines, one
+// {"key": ..., "row": ...} object per row, in key order. Rows are encoded
+// via encoding/json and streamed one at a time.
+func (tx *Tx) ExportJSON(tbl *Table, w io.Writer) error {
+	return tx.exportJSON(tbl, w, false)
+}
+
+// ExportTable is like ExportJSON, but also records the schema version of
+// each row, so that ImportTable can migrate rows exported by older code.
+// Rows are migrated on export just like on reads, so their version is the
+// latest one unless the table has no migrator.
+func (tx *Tx) ExportTable(tbl *Table, w io.Writer) error {
+	return tx.exportJSON(tbl, w, true)
+}
+
+func (tx *Tx) exportJSON(tbl *Table, w io.Writer, withVer bool) error {
+	enc := json.NewEncoder(w)
+	c, err := tx.TryTableScan(tbl, FullScan())
+	if err != nil {
+		return err
+	}
+	for c.Next() {
+		rowVal, rowMeta, err := c.TryRowVal()
+		if err != nil {
+			return err
+		}
+		var line jsonExportLine
+		if withVer {
+			line.Ver = rowMeta.SchemaVer
+			if tbl.migrator != nil {
+				line.Ver = max(line.Ver, tbl.latestSchemaVer)
+			}
+		}
+		line.Key, err = json.Marshal(c.Key())
+		if err != nil {
+			return fmt.Errorf("%s/%v: %w", tbl.Name(), c.Key(), err)
+		}
+		line.Row, err = json.Marshal(rowVal.Interface())
+		if err != nil {
+			return fmt.Errorf("%s/%v: %w", tbl.Name(), c.Key(), err)
+		}
+		if err := enc.Encode(&line); err != nil {
+			return err
+		}
+	}
+	return c.Err()
+}
+
+// ImportJSON reads rows in the format produced by ExportJSON from r and puts
+// them into tbl, updating indices. Returns the number of rows imported.
+// Existing rows with the same keys are overwritten; other rows are kept.
+func (tx *Tx) ImportJSON(tbl *Table, r io.Reader) (int, error) {
+	return tx.ImportTable(tbl, r)
+}
+
+// ImportTable reads rows in the format produced by ExportTable or ExportJSON
+// from r and puts them into tbl, like ImportJSON. Rows exported with an older
+// schema version are passed through the table's migrator before being put;
+// rows with a newer version than the table's are rejected.
+func (tx *Tx) ImportTable(tbl *Table, r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	var n int
+	for {
+		var line jsonExportLine
+		err := dec.Decode(&line)
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		} else if err != nil {
+			return n, fmt.Errorf("%s: line %d: %w", tbl.Name(), n+1, err)
+		}
+
+		keyPtr := reflect.New(tbl.keyType)
+		if err := json.Unmarshal(line.Key, keyPtr.Interface()); err != nil {
+			return n, fmt.Errorf("%s: line %d: key: %w", tbl.Name(), n+1, err)
+		}
+		rowVal := tbl.newRow(tbl.latestSchemaVer)
+		if err := json.Unmarshal(line.Row, rowVal.Interface()); err != nil {
+			return n, fmt.Errorf("%s: line %d: row: %w", tbl.Name(), n+1, err)
+		}
+		if line.Ver > tbl.latestSchemaVer {
+			return n, fmt.Errorf("%s: line %d: row schema version %d is newer than %d", tbl.Name(), n+1, line.Ver, tbl.latestSchemaVer)
+		}
+		tbl.SetRowKeyVal(rowVal, keyPtr.Elem())
+		if line.Ver != 0 && line.Ver < tbl.latestSchemaVer && tbl.migrator != nil {
+			tbl.migrator(tx, rowVal.Interface(), line.Ver)
+		}
+		tx.PutVal(tbl, rowVal)
+		n++
+	}
+}
@@ -2,6 +2,7 @@ package edb
 
 import (
 	"reflect"
+	"time"
 )
 
 func Reload[Row any](txh Txish, row *Row) *Row {
@@ -19,6 +20,32 @@ func Reload[Row any](txh Txish, row *Row) *Row {
 	return newRowVal.Interface().(*Row)
 }
 
+// ReloadInto is like Reload, but decodes the stored version directly into
+// *row instead of allocating a new row. Returns false, leaving *row
+// untouched, if the row no longer exists.
+func ReloadInto[Row any](txh Txish, row *Row) bool {
+	tx := txh.DBTx()
+	tbl := tx.Schema().TableByRow((*Row)(nil))
+	if tx.timeOps {
+		defer tx.recordOp(time.Now(), "get", tbl.name)
+	}
+	rowVal := reflect.ValueOf(row)
+	keyVal, err := tbl.checkKeyType(tbl.RowKeyVal(rowVal))
+	if err != nil {
+		panic(err)
+	}
+	keyRaw := tbl.EncodeKeyVal(keyVal)
+	valueRaw := tx.getRawByRawKey(tbl, keyRaw)
+	if valueRaw == nil {
+		return false
+	}
+	_, err = decodeTableRowInto(rowVal, tbl, keyRaw, valueRaw, tx)
+	if err != nil {
+		panic(err)
+	}
+	return true
+}
+
 func Get[Row any](txh Txish, key any) *Row {
 	tx := txh.DBTx()
 	tbl := tx.Schema().TableByRow((*Row)(nil))
@@ -29,6 +56,18 @@ func Get[Row any](txh Txish, key any) *Row {
 	return row.(*Row)
 }
 
+// TryGet is like Get, but returns ErrWrongKeyType errors (and row decoding
+// errors) instead of panicking.
+func TryGet[Row any](txh Txish, key any) (*Row, error) {
+	tx := txh.DBTx()
+	tbl := tx.Schema().TableByRow((*Row)(nil))
+	row, _, err := tx.TryGet(tbl, key)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return row.(*Row), nil
+}
+
 func GetByKeyRaw[Row any](txh Txish, keyRaw []byte) *Row {
 	tx := txh.DBTx()
 	tbl := tx.Schema().TableByRow((*Row)(nil))
@@ -95,6 +134,33 @@ func (tx *Tx) GetByKeyRaw(tbl *Table, keyRaw []byte) (any, ValueMeta) {
 	return rowVal.Interface(), rowMeta
 }
 
+// GetAtVersion is like Get, but with skipMigration returns the row exactly as
+// stored, without running the table's migrator on rows saved with an older
+// schema version. Use ValueMeta.SchemaVer to tell which version you got.
+// This allows tests to check the stored state, and to compare rows before
+// and after migration.
+func (tx *Tx) GetAtVersion(tbl *Table, key any, skipMigration bool) (any, ValueMeta) {
+	if !skipMigration {
+		return tx.Get(tbl, key)
+	}
+	keyVal, err := tbl.checkKeyType(reflect.ValueOf(key))
+	if err != nil {
+		panic(err)
+	}
+	keyRaw := tbl.encodeKeyVal(nil, keyVal, true)
+	valueRaw := tx.getRawByRawKey(tbl, keyRaw)
+	if valueRaw == nil {
+		return nil, ValueMeta{}
+	}
+	var vle value
+	decodeTableValue(&vle, tbl, keyRaw, valueRaw)
+	rowVal, _, rowMeta, err := decodeUnmigratedTableRowFromValue(&vle, tbl, keyRaw)
+	if err != nil {
+		panic(err)
+	}
+	return rowVal.Interface(), rowMeta
+}
+
 func (tx *Tx) GetMeta(tbl *Table, key any) ValueMeta {
 	return tx.GetMetaByKeyVal(tbl, reflect.ValueOf(key))
 }
@@ -112,7 +178,10 @@ func (tx *Tx) Exists(tbl *Table, key any) bool {
 }
 
 func (tx *Tx) getRowValByKeyVal(tbl *Table, keyVal reflect.Value, includeRow bool) (reflect.Value, ValueMeta, error) {
-	keyVal = tbl.ensureCorrectKeyType(keyVal)
+	keyVal, err := tbl.checkKeyType(keyVal)
+	if err != nil {
+		return reflect.Value{}, ValueMeta{}, err
+	}
 	keyBuf := keyBytesPool.Get().([]byte)
 	keyRaw := tbl.encodeKeyVal(keyBuf, keyVal, true)
 	defer keyBytesPool.Put(keyBuf[:0])
@@ -120,6 +189,9 @@ func (tx *Tx) getRowValByKeyVal(tbl *Table, keyVal reflect.Value, includeRow boo
 }
 
 func (tx *Tx) getRowValByKeyRaw(tbl *Table, keyRaw []byte, includeRow bool, keyValueForLogging any) (reflect.Value, ValueMeta, error) {
+	if tx.timeOps {
+		defer tx.recordOp(time.Now(), "get", tbl.name)
+	}
 	val, valMeta, err := tx.getRowValByRawKey(tbl, keyRaw, includeRow)
 	if tx.db.verbose {
 		if includeRow {
@@ -177,5 +249,29 @@ func (tx *Tx) getRowValByRawKey(tbl *Table, keyRaw []byte, includeRow bool) (ref
 func (tx *Tx) getRawByRawKey(tbl *Table, keyRaw []byte) []byte {
 	tableBuck := nonNil(tx.btx.Bucket(tbl.buck.Raw()))
 	dataBuck := nonNil(tableBuck.Bucket(dataBucket.Raw()))
-	return dataBuck.Get(keyRaw)
+	valueRaw := dataBuck.Get(keyRaw)
+	if tx.isHiddenExpired(tbl, keyRaw, valueRaw) {
+		return nil
+	}
+	return valueRaw
+}
+
+// isHiddenExpiredKey is like isHiddenExpired, but looks up the value by key.
+func (tx *Tx) isHiddenExpiredKey(tbl *Table, keyRaw []byte) bool {
+	if !tbl.hideExpired {
+		return false
+	}
+	dataBuck := tbl.dataBucketIn(tbl.rootBucketIn(tx.btx))
+	return tx.isHiddenExpired(tbl, keyRaw, dataBuck.Get(keyRaw))
+}
+
+// isHiddenExpired returns whether the value belongs to an expired row that
+// reads should treat as missing (see TableBuilder.HideExpired).
+func (tx *Tx) isHiddenExpired(tbl *Table, keyRaw, valueRaw []byte) bool {
+	if !tbl.hideExpired || valueRaw == nil {
+		return false
+	}
+	var vle value
+	decodeTableValue(&vle, tbl, keyRaw, valueRaw)
+	return vle.isExpired(unixMilli(tx.db.now()))
 }
@@ -60,13 +60,45 @@ func decodeTableRow(tbl *Table, keyRaw, valueRaw []byte, migrationTx *Tx) (rowVa
 }
 
 func decodeTableRowFromValue(vle *value, tbl *Table, keyRaw []byte, migrationTx *Tx) (rowVal, keyVal reflect.Value, rowMeta ValueMeta, err error) {
+	rowVal, keyVal, rowMeta, err = decodeUnmigratedTableRowFromValue(vle, tbl, keyRaw)
+	if err != nil {
+		return
+	}
+	if rowMeta.SchemaVer < tbl.latestSchemaVer && tbl.migrator != nil {
+		tbl.migrator(migrationTx, rowVal.Interface(), rowMeta.SchemaVer)
+	}
+	return
+}
+
+// decodeTableRowInto decodes and migrates the row stored as valueRaw into
+// rowVal, a pointer to an existing row, which gets zeroed first.
+func decodeTableRowInto(rowVal reflect.Value, tbl *Table, keyRaw, valueRaw []byte, migrationTx *Tx) (rowMeta ValueMeta, err error) {
+	var vle value
+	decodeTableValue(&vle, tbl, keyRaw, valueRaw)
+	rowVal.Elem().SetZero()
+	_, rowMeta, err = decodeUnmigratedTableRowInto(rowVal, &vle, tbl, keyRaw)
+	if err != nil {
+		return
+	}
+	if rowMeta.SchemaVer < tbl.latestSchemaVer && tbl.migrator != nil {
+		tbl.migrator(migrationTx, rowVal.Interface(), rowMeta.SchemaVer)
+	}
+	return
+}
+
+func decodeUnmigratedTableRowFromValue(vle *value, tbl *Table, keyRaw []byte) (rowVal, keyVal reflect.Value, rowMeta ValueMeta, err error) {
 	rowVal = tbl.newRow(vle.SchemaVer)
+	keyVal, rowMeta, err = decodeUnmigratedTableRowInto(rowVal, vle, tbl, keyRaw)
+	return
+}
+
+func decodeUnmigratedTableRowInto(rowVal reflect.Value, vle *value, tbl *Table, keyRaw []byte) (keyVal reflect.Value, rowMeta ValueMeta, err error) {
 	keyVal = tbl.RowKeyVal(rowVal)
 	tbl.DecodeKeyValInto(keyVal, keyRaw)
 
 	keyVal = tbl.DecodeKeyVal(keyRaw)
 
-	err = vle.decodeRowInto(rowVal)
+	err = vle.decodeRowInto(rowVal, tbl.fieldRenamesAt(vle.SchemaVer))
 	if err != nil {
 		err = tableErrf(tbl, nil, keyRaw, err, "data")
 		return
@@ -74,10 +106,6 @@ func decodeTableRowFromValue(vle *value, tbl *Table, keyRaw []byte, migrationTx
 	tbl.rowInfo.keyValue(rowVal).Set(keyVal)
 
 	rowMeta = vle.ValueMeta()
-
-	if rowMeta.SchemaVer < tbl.latestSchemaVer && tbl.migrator != nil {
-		tbl.migrator(migrationTx, rowVal.Interface(), rowMeta.SchemaVer)
-	}
 	return
 }
 
@@ -90,8 +118,19 @@ func decodeTableValue(vle *value, tbl *Table, keyRaw, valueRaw []byte) {
 	}
 }
 
-func (vle *value) decodeRowInto(rowVal reflect.Value) error {
-	return vle.Flags.encoding().DecodeValue(vle.Data, rowVal)
+func (vle *value) decodeRowInto(rowVal reflect.Value, renames map[string]string) error {
+	data, err := vle.plainData()
+	if err != nil {
+		return err
+	}
+	enc := vle.Flags.encoding()
+	if renames != nil {
+		data, err = enc.RenameFields(data, renames)
+		if err != nil {
+			return err
+		}
+	}
+	return enc.DecodeValue(data, rowVal)
 }
 
 func decodeIndexTableKey(indexKeyRaw []byte, indexKeyTup tuple, indexVal []byte, idx *Index) ([]byte, tuple) {
@@ -110,8 +149,8 @@ func decodeUniqueIndexTableKey(indexKeyRaw, indexVal []byte, idx *Index) []byte
 	if err != nil {
 		panic(fmt.Errorf("%s: invalid index value tuple for key %x, value is %x: %w", idx.FullName(), indexKeyRaw, indexVal, err))
 	}
-	if len(indexValTup) != 1 {
-		panic(fmt.Errorf("%s: invalid index value tuple for key %x: got %d els, wanted %d, value is 0x%x", idx.FullName(), indexKeyRaw, len(indexValTup), 1, indexVal))
+	if n := idx.valueTupleLen(); len(indexValTup) != n {
+		panic(fmt.Errorf("%s: invalid index value tuple for key %x: got %d els, wanted %d, value is 0x%x", idx.FullName(), indexKeyRaw, len(indexValTup), n, indexVal))
 	}
 	return indexValTup[0]
 }
@@ -137,17 +176,13 @@ func extractUniqueIndexKey(indexKeyTup tuple) ([]byte, tuple) {
 func decodeIndexRow(idx *Index, indexKeyRaw, indexValRaw []byte) (indexKey tuple, keyRaw []byte) {
 	indexKeyTup := must(decodeTuple(indexKeyRaw))
 	indexValTup := must(decodeTuple(indexValRaw))
+	if n := idx.valueTupleLen(); len(indexValTup) != n {
+		panic(fmt.Errorf("%s: invalid index value tuple for key %x: got %d els, wanted %d, value is 0x%x", idx.FullName(), indexKeyRaw, len(indexValTup), n, indexValRaw))
+	}
 
 	if idx.isUnique {
-		if len(indexValTup) != 1 {
-			panic(fmt.Errorf("%s: invalid index value tuple for key %x: got %d els, wanted %d, value is 0x%x", idx.FullName(), indexKeyRaw, len(indexValTup), 1, indexValRaw))
-		}
 		return indexKeyTup, indexValTup[0]
 	} else {
-		if len(indexValTup) != 0 {
-			panic(fmt.Errorf("%s: invalid index value tuple for key %x: got %d els, wanted %d, value is 0x%x", idx.FullName(), indexKeyRaw, len(indexValTup), 0, indexValRaw))
-		}
-
 		n := len(indexKeyTup)
 		return indexKeyTup[:n-1], indexKeyTup[n-1]
 	}
@@ -1,6 +1,7 @@
 package edb
 
 import (
+	"bytes"
 	"log/slog"
 
 	"github.com/andreyvit/edb/kvo"
@@ -23,6 +24,8 @@ func (tx *Tx) KVGetRaw(tbl *KVTable, key []byte) []byte {
 	return dataBuck.Get(key)
 }
 
+// KVPut stores the packed value under key. A nil value deletes the key,
+// though KVDelete says that more clearly.
 func (tx *Tx) KVPut(tbl *KVTable, key []byte, value kvo.Packable) {
 	var data kvo.ImmutableRecordData
 	if value != nil {
@@ -35,15 +38,83 @@ func (tx *Tx) KVPut(tbl *KVTable, key []byte, value kvo.Packable) {
 	}
 }
 
+// KVPutRaw stores value under key, updating the indices. A nil value
+// deletes the key, like KVDeleteRaw.
 func (tx *Tx) KVPutRaw(tbl *KVTable, key, value []byte) {
 	if tx == nil {
 		panic("nil tx")
 	}
-	dataBuck := nonNil(tx.btx.Bucket(tbl.dataBuck.Raw()))
+	w := tx.newKVWriter(tbl)
+	w.put(key, value)
+}
+
+// KVDelete deletes key from the table along with its index entries. Does
+// nothing if the key does not exist.
+func (tx *Tx) KVDelete(tbl *KVTable, key []byte) {
+	tx.KVDeleteRaw(tbl, key)
+}
+
+// KVDeleteRaw is the same as KVDelete, for symmetry with KVPutRaw.
+func (tx *Tx) KVDeleteRaw(tbl *KVTable, key []byte) {
+	tx.KVPutRaw(tbl, key, nil)
+}
+
+// KVEntry is a key-value pair for KVPutAll. A nil Value deletes the key.
+type KVEntry struct {
+	Key, Value []byte
+}
+
+// KVPutAll is like calling KVPutRaw for each entry, but resolves the table
+// and index buckets only once.
+func (tx *Tx) KVPutAll(tbl *KVTable, entries []KVEntry) {
+	if tx == nil {
+		panic("nil tx")
+	}
+	w := tx.newKVWriter(tbl)
+	for _, e := range entries {
+		w.put(e.Key, e.Value)
+	}
+}
+
+// KVPutBatch is the same as KVPutAll.
+func (tx *Tx) KVPutBatch(tbl *KVTable, entries []KVEntry) {
+	tx.KVPutAll(tbl, entries)
+}
+
+type kvWriter struct {
+	tx       *Tx
+	tbl      *KVTable
+	dataBuck *bbolt.Bucket
+	idxBucks []*bbolt.Bucket // resolved lazily, parallel to tbl.indices
+}
+
+func (tx *Tx) newKVWriter(tbl *KVTable) *kvWriter {
+	return &kvWriter{
+		tx:       tx,
+		tbl:      tbl,
+		dataBuck: nonNil(tx.btx.Bucket(tbl.dataBuck.Raw())),
+		idxBucks: make([]*bbolt.Bucket, len(tbl.indices)),
+	}
+}
+
+func (w *kvWriter) indexBucket(i int) *bbolt.Bucket {
+	if w.idxBucks[i] == nil {
+		w.idxBucks[i] = nonNil(w.tx.btx.Bucket(w.tbl.indices[i].idxBuck.Raw()))
+	}
+	return w.idxBucks[i]
+}
+
+func (w *kvWriter) put(key, value []byte) {
+	tbl := w.tbl
+	if value != nil {
+		if err := w.tx.reserveSpace(len(key) + len(value)); err != nil {
+			panic(kvtableErrf(tbl, nil, key, err, "KVPut"))
+		}
+	}
 	if len(tbl.indices) > 0 {
-		oldValue := dataBuck.Get(key)
-		for _, idx := range tbl.indices {
-			var oldEntries, newEntries [][]byte
+		oldValue := w.dataBuck.Get(key)
+		for i, idx := range tbl.indices {
+			var oldEntries, newEntries []kvIndexEntry
 			if oldValue != nil {
 				oldEntries = idx.entries(key, oldValue)
 			}
@@ -51,32 +122,29 @@ func (tx *Tx) KVPutRaw(tbl *KVTable, key, value []byte) {
 				newEntries = idx.entries(key, value)
 			}
 
-			var idxBuck *bbolt.Bucket
-			for _, ik := range oldEntries {
-				if !containsBytes(newEntries, ik) {
-					if idxBuck == nil {
-						idxBuck = nonNil(tx.btx.Bucket(idx.idxBuck.Raw()))
-					}
-					idxBuck.Delete(ik)
+			for _, e := range oldEntries {
+				if _, found := findKVIndexEntry(newEntries, e.key); !found {
+					w.indexBucket(i).Delete(e.key)
 				}
 			}
-			for _, ik := range newEntries {
-				if !containsBytes(oldEntries, ik) {
-					if idxBuck == nil {
-						idxBuck = nonNil(tx.btx.Bucket(idx.idxBuck.Raw()))
+			for _, e := range newEntries {
+				if old, found := findKVIndexEntry(oldEntries, e.key); !found || !bytes.Equal(old.value, e.value) {
+					iv := e.value
+					if iv == nil {
+						iv = emptyIndexValue
 					}
-					idxBuck.Put(ik, emptyIndexValue)
+					w.indexBucket(i).Put(e.key, iv)
 				}
 			}
 		}
 	}
 	if value == nil {
-		err := dataBuck.Delete(key)
+		err := w.dataBuck.Delete(key)
 		if err != nil {
 			panic(kvtableErrf(tbl, nil, key, err, "KVDelete"))
 		}
 	} else {
-		err := dataBuck.Put(key, value)
+		err := w.dataBuck.Put(key, value)
 		if err != nil {
 			panic(kvtableErrf(tbl, nil, key, err, "KVPut"))
 		}
@@ -129,8 +197,12 @@ type KVCursor struct {
 
 func (c *KVCursor) Next() bool          { return c.impl.Next() }
 func (c *KVCursor) RawIndexKey() []byte { return c.impl.RawIndexKey() }
-func (c *KVCursor) RawKey() []byte      { return c.impl.RawTableKey() }
-func (c *KVCursor) RawValue() []byte    { return c.impl.RawTableValue() }
+
+// RawIndexValue returns the payload stored via KVIndexContentBuilder.AddWithValue,
+// or an empty slice if none. Always nil for table scans.
+func (c *KVCursor) RawIndexValue() []byte { return c.impl.RawIndexValue() }
+func (c *KVCursor) RawKey() []byte        { return c.impl.RawTableKey() }
+func (c *KVCursor) RawValue() []byte      { return c.impl.RawTableValue() }
 
 func (c *KVCursor) Object() kvo.ImmutableMap {
 	return c.tbl.decodeValue(c.impl.RawTableValue())
@@ -179,6 +251,7 @@ func (c *KVCursor) IndexKeys() func(yield func(k []byte) bool) {
 type kvCursorImpl interface {
 	Next() bool
 	RawIndexKey() []byte
+	RawIndexValue() []byte
 	RawTableKey() []byte
 	RawTableValue() []byte
 }
@@ -187,6 +260,7 @@ type kvTableCursorImpl RawRangeCursor
 
 func (ci *kvTableCursorImpl) Next() bool            { return (*RawRangeCursor)(ci).Next() }
 func (ci *kvTableCursorImpl) RawIndexKey() []byte   { return nil }
+func (ci *kvTableCursorImpl) RawIndexValue() []byte { return nil }
 func (ci *kvTableCursorImpl) RawTableKey() []byte   { return ci.k }
 func (ci *kvTableCursorImpl) RawTableValue() []byte { return ci.v }
 
@@ -196,8 +270,9 @@ type kvIndexCursorImpl struct {
 	dataBuck *bbolt.Bucket
 }
 
-func (ci *kvIndexCursorImpl) Next() bool          { return ci.idxCur.Next() }
-func (ci *kvIndexCursorImpl) RawIndexKey() []byte { return ci.idxCur.k }
+func (ci *kvIndexCursorImpl) Next() bool            { return ci.idxCur.Next() }
+func (ci *kvIndexCursorImpl) RawIndexKey() []byte   { return ci.idxCur.k }
+func (ci *kvIndexCursorImpl) RawIndexValue() []byte { return ci.idxCur.v }
 func (ci *kvIndexCursorImpl) RawTableKey() []byte {
 	return ci.idx.indexKeyToPrimaryKey(ci.idxCur.k)
 }
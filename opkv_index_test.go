@@ -9,8 +9,9 @@ import (
 )
 
 var (
-	wumpetsByB *KVIndex
-	wumpets    = DefineKVTable(basicSchema, "wumpets", nil, nil, func(b *KVTableBuilder) {
+	wumpetsByB  *KVIndex
+	wumpetsByBC *KVIndex
+	wumpets     = DefineKVTable(basicSchema, "wumpets", nil, nil, func(b *KVTableBuilder) {
 		wumpetsByB = b.DefineIndex("b", nil, func(ik []byte) []byte {
 			return ik[2:4]
 		}, func(b *KVIndexContentBuilder, pk []byte, v kvo.ImmutableRecord) {
@@ -19,6 +20,19 @@ var (
 			binary.BigEndian.PutUint16(ik[0:2], uint16(iv))
 			copy(ik[2:4], pk)
 			b.Add(ik)
+		}).WithKeyEncoder(func(buf []byte, values []any) []byte {
+			for _, v := range values {
+				buf = binary.BigEndian.AppendUint16(buf, v.(uint16))
+			}
+			return buf
+		})
+		wumpetsByBC = b.DefineIndex("bc", nil, func(ik []byte) []byte {
+			return ik[2:4]
+		}, func(b *KVIndexContentBuilder, pk []byte, v kvo.ImmutableRecord) {
+			ik := make([]byte, 4)
+			binary.BigEndian.PutUint16(ik[0:2], uint16(v.Root().Get(0x42)))
+			copy(ik[2:4], pk)
+			b.AddWithValue(ik, binary.BigEndian.AppendUint16(nil, uint16(v.Root().Get(0x43))))
 		})
 	})
 )
@@ -50,9 +64,141 @@ func TestKVIndexScan(t *testing.T) {
 		indexScan(t, tx, wumpetsByB, RawRange{Prefix: x("88 77")}, k1, k2)
 		indexScan(t, tx, wumpetsByB, RawRange{Prefix: x("00 55")}, k3)
 		indexScan(t, tx, wumpetsByB, RawRange{Prefix: x("88 99")}, k4)
+		indexScan(t, tx, wumpetsByB, wumpetsByB.PrefixRange(uint16(0x8877)), k1, k2)
+		indexScan(t, tx, wumpetsByB, wumpetsByB.PrefixRange(uint16(0x8877), uint16(0x1014)), k2)
+		indexScan(t, tx, wumpetsByB, wumpetsByB.PrefixRange(uint16(0x0055)).Reversed(), k3)
+		indexScan(t, tx, wumpetsByB, RawIE(wumpetsByB.EncodeKey(uint16(0x8877)), wumpetsByB.EncodeKey(uint16(0x8899))), k1, k2)
+		indexScan(t, tx, wumpetsByB, RawIO(wumpetsByB.EncodeKey(uint16(0x8877), uint16(0x1014))), k2, k4)
+		indexScan(t, tx, wumpetsByB, RawOI(wumpetsByB.EncodeKey(uint16(0x8877))), k3)
+	})
+}
+
+func TestKVIndexValues(t *testing.T) {
+	var (
+		k1 = x("10 12")
+		k2 = x("10 14")
+	)
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		tx.KVPutRaw(wumpets, k1, buildKV(0x42, 0x0055, 0x43, 0x0001).Bytes())
+		tx.KVPutRaw(wumpets, k2, buildKV(0x42, 0x0066, 0x43, 0x0002).Bytes())
+		indexScanIVs(t, tx, wumpetsByBC, RawRange{}, x("00 01"), x("00 02"))
+		indexScanIVs(t, tx, wumpetsByB, RawRange{}, x(""), x(""))
+
+		// same index key, different payload
+		tx.KVPutRaw(wumpets, k1, buildKV(0x42, 0x0055, 0x43, 0x0003).Bytes())
+		indexScanIVs(t, tx, wumpetsByBC, RawRange{}, x("00 03"), x("00 02"))
+
+		tx.KVPutRaw(wumpets, k2, nil)
+		indexScanIVs(t, tx, wumpetsByBC, RawRange{}, x("00 03"))
+	})
+}
+
+func TestKVPutAll(t *testing.T) {
+	var (
+		k1 = x("10 12")
+		k2 = x("10 14")
+		k3 = x("10 16")
+	)
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		tx.KVPutAll(wumpets, []KVEntry{
+			{k1, buildKV(0x42, 0x0055).Bytes()},
+			{k2, buildKV(0x42, 0x8877).Bytes()},
+			{k3, buildKV(0x42, 0x0055).Bytes()},
+		})
+		indexScan(t, tx, wumpetsByB, RawRange{}, k1, k3, k2)
+
+		tx.KVPutAll(wumpets, []KVEntry{
+			{k1, nil},
+			{k3, buildKV(0x42, 0x8899).Bytes()},
+		})
+		indexScan(t, tx, wumpetsByB, RawRange{}, k2, k3)
+		deepEqual(t, tx.KVGetRaw(wumpets, k1), nil)
+
+		// later entries for the same key see the earlier ones
+		tx.KVPutBatch(wumpets, []KVEntry{
+			{k2, buildKV(0x42, 0x0055, 0x43, 0x0001).Bytes()},
+			{k1, buildKV(0x42, 0x8877).Bytes()},
+			{k2, buildKV(0x42, 0x8899, 0x43, 0x0002).Bytes()},
+			{k1, nil},
+			{k3, nil},
+			{k3, buildKV(0x42, 0x0055, 0x43, 0x0003).Bytes()},
+		})
+		indexScan(t, tx, wumpetsByB, RawRange{}, k3, k2)
+		indexScanIKs(t, tx, wumpetsByB, RawRange{}, x("00 55 10 16"), x("88 99 10 14"))
+		indexScanIVs(t, tx, wumpetsByBC, RawRange{}, x("00 03"), x("00 02"))
+		deepEqual(t, tx.KVGetRaw(wumpets, k1), nil)
+		deepEqual(t, tx.KVGetRaw(wumpets, k2), buildKV(0x42, 0x8899, 0x43, 0x0002).Bytes())
+	})
+}
+
+func BenchmarkKVPutAll(b *testing.B) {
+	const n = 10000
+	entries := make([]KVEntry, n)
+	for i := range entries {
+		entries[i] = KVEntry{
+			Key:   binary.BigEndian.AppendUint16(nil, uint16(i)),
+			Value: buildKV(0x42, uint64(i%100), 0x43, uint64(i)).Bytes(),
+		}
+	}
+	b.Run("per-key", func(b *testing.B) {
+		db := setup(b, basicSchema)
+		for i := 0; i < b.N; i++ {
+			db.Write(func(tx *Tx) {
+				for _, e := range entries {
+					tx.KVPutRaw(wumpets, e.Key, e.Value)
+				}
+			})
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		db := setup(b, basicSchema)
+		for i := 0; i < b.N; i++ {
+			db.Write(func(tx *Tx) {
+				tx.KVPutBatch(wumpets, entries)
+			})
+		}
+	})
+}
+
+func TestKVDelete(t *testing.T) {
+	var (
+		k1 = x("10 12")
+		k2 = x("10 14")
+	)
+	db := setup(t, basicSchema)
+	db.Write(func(tx *Tx) {
+		tx.KVPut(wumpets, k1, buildKV(0x42, 0x0055, 0x43, 0x0001))
+		tx.KVPutRaw(wumpets, k2, buildKV(0x42, 0x8877, 0x43, 0x0002).Bytes())
+
+		tx.KVDelete(wumpets, k1)
+		deepEqual(t, tx.KVGetRaw(wumpets, k1), nil)
+		indexScan(t, tx, wumpetsByB, RawRange{}, k2)
+		indexScanIVs(t, tx, wumpetsByBC, RawRange{}, x("00 02"))
+
+		tx.KVDeleteRaw(wumpets, k2)
+		tx.KVDelete(wumpets, k2) // no-op
+		indexScan(t, tx, wumpetsByB, RawRange{})
+		indexScan(t, tx, wumpetsByBC, RawRange{})
+		deepEqual(t, tx.KVGetRaw(wumpets, k2), nil)
 	})
 }
 
+func indexScanIVs(t testing.TB, tx *Tx, idx *KVIndex, rang RawRange, exp ...[]byte) {
+	t.Helper()
+	var out []string
+	c := tx.KVIndexScan(idx, rang)
+	for c.Next() {
+		out = append(out, hex.EncodeToString(c.RawIndexValue()))
+	}
+	var expstr []string
+	for _, v := range exp {
+		expstr = append(expstr, hex.EncodeToString(v))
+	}
+	deepEqual(t, out, expstr)
+}
+
 func indexScanIKs(t testing.TB, tx *Tx, idx *KVIndex, rang RawRange, exp ...[]byte) {
 	t.Helper()
 	var out []string
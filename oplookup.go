@@ -4,22 +4,39 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"time"
+
+	"go.etcd.io/bbolt"
 )
 
 func Lookup[Row any](txh Txish, idx *Index, indexKey any) *Row {
 	tx := txh.DBTx()
 	if tbl := tx.Schema().TableByRow((*Row)(nil)); idx.table != tbl {
-		panic(fmt.Errorf("invalid index %v for table %v", idx.FullName(), tbl.Name()))
+		panic(fmt.Errorf("invalid index %v for table %v: %w", idx.FullName(), tbl.Name(), ErrIndexNotOnTable))
 	}
 
 	rowVal, _ := tx.LookupVal(idx, reflect.ValueOf(indexKey))
 	return valToRow[Row](rowVal)
 }
 
+// TryLookup is like Lookup, but returns ErrIndexNotOnTable and
+// ErrWrongKeyType errors (and row decoding errors) instead of panicking.
+func TryLookup[Row any](txh Txish, idx *Index, indexKey any) (*Row, error) {
+	tx := txh.DBTx()
+	if tbl := tx.Schema().TableByRow((*Row)(nil)); idx.table != tbl {
+		return nil, fmt.Errorf("invalid index %v for table %v: %w", idx.FullName(), tbl.Name(), ErrIndexNotOnTable)
+	}
+	rowVal, _, err := tx.TryLookupVal(idx, reflect.ValueOf(indexKey))
+	if err != nil {
+		return nil, err
+	}
+	return valToRow[Row](rowVal), nil
+}
+
 func LookupKey[Key any](txh Txish, idx *Index, indexKey any) (Key, bool) {
 	tx := txh.DBTx()
 	if at, et := reflect.TypeOf((*Key)(nil)).Elem(), idx.table.KeyType(); at != et {
-		panic(fmt.Errorf("%s: LookupKey has incorrect return type %v, expected %v", idx.FullName(), at, et))
+		panic(fmt.Errorf("%s: LookupKey has incorrect return type %v, expected %v: %w", idx.FullName(), at, et, ErrWrongKeyType))
 	}
 
 	keyVal := tx.LookupKeyVal(idx, reflect.ValueOf(indexKey))
@@ -31,6 +48,23 @@ func LookupKey[Key any](txh Txish, idx *Index, indexKey any) (Key, bool) {
 	}
 }
 
+// ExistsBy is like LookupExists, but verifies that idx belongs to Row's table.
+func ExistsBy[Row any](txh Txish, idx *Index, indexKey any) bool {
+	tx := txh.DBTx()
+	if tbl := tx.Schema().TableByRow((*Row)(nil)); idx.table != tbl {
+		panic(fmt.Errorf("invalid index %v for table %v: %w", idx.FullName(), tbl.Name(), ErrIndexNotOnTable))
+	}
+	return tx.LookupExists(idx, reflect.ValueOf(indexKey))
+}
+
+// IndexExists reports whether idx has an entry for indexKey, verifying that
+// idx belongs to Row's table. Only the index is consulted; the row itself is
+// neither read nor decoded (except to skip expired rows of tables with
+// HideExpired). Same as ExistsBy.
+func IndexExists[Row any](txh Txish, idx *Index, indexKey any) bool {
+	return ExistsBy[Row](txh, idx, indexKey)
+}
+
 func LookupExists(txh Txish, idx *Index, indexKey any) bool {
 	tx := txh.DBTx()
 	return tx.LookupExists(idx, reflect.ValueOf(indexKey))
@@ -45,6 +79,9 @@ func (tx *Tx) LookupKey(idx *Index, indexKey any) any {
 	return valToAny(tx.LookupKeyVal(idx, reflect.ValueOf(indexKey)))
 }
 func (tx *Tx) LookupKeyVal(idx *Index, indexKeyVal reflect.Value) reflect.Value {
+	if tx.timeOps {
+		defer tx.recordOp(time.Now(), "lookup", idx.FullName())
+	}
 	keyRaw := tx.lookupRawKeyByVal(idx, indexKeyVal)
 	result := keyRawToVal(keyRaw, idx.table)
 	if tx.isVerboseLoggingEnabled() {
@@ -57,6 +94,9 @@ func (tx *Tx) LookupKeyVal(idx *Index, indexKeyVal reflect.Value) reflect.Value
 	return result
 }
 func (tx *Tx) LookupExists(idx *Index, indexKeyVal reflect.Value) bool {
+	if tx.timeOps {
+		defer tx.recordOp(time.Now(), "lookup", idx.FullName())
+	}
 	keyRaw := tx.lookupRawKeyByVal(idx, indexKeyVal)
 	if tx.isVerboseLoggingEnabled() {
 		if keyRaw != nil {
@@ -69,13 +109,25 @@ func (tx *Tx) LookupExists(idx *Index, indexKeyVal reflect.Value) bool {
 }
 
 func (tx *Tx) LookupVal(idx *Index, indexKeyVal reflect.Value) (reflect.Value, ValueMeta) {
-	keyRaw := tx.lookupRawKeyByVal(idx, indexKeyVal)
+	return must2(tx.TryLookupVal(idx, indexKeyVal))
+}
+
+// TryLookupVal is like LookupVal, but returns ErrWrongKeyType errors (and row
+// decoding errors) instead of panicking.
+func (tx *Tx) TryLookupVal(idx *Index, indexKeyVal reflect.Value) (reflect.Value, ValueMeta, error) {
+	if tx.timeOps {
+		defer tx.recordOp(time.Now(), "lookup", idx.FullName())
+	}
+	keyRaw, err := tx.tryLookupRawKeyByVal(idx, indexKeyVal)
+	if err != nil {
+		return reflect.Value{}, ValueMeta{}, err
+	}
 	if keyRaw == nil {
-		return reflect.Value{}, ValueMeta{}
+		return reflect.Value{}, ValueMeta{}, nil
 	}
 	row, rowMeta, err := tx.getRowValByRawKey(idx.table, keyRaw, true)
 	if err != nil {
-		panic(err)
+		return reflect.Value{}, ValueMeta{}, err
 	}
 	if rowMeta.IsMissing() && tx.db.strict {
 		panic(fmt.Errorf("data error in %s: index entry points to missing record %x", idx.FullName(), keyRaw))
@@ -87,51 +139,75 @@ func (tx *Tx) LookupVal(idx *Index, indexKeyVal reflect.Value) (reflect.Value, V
 			tx.db.logf("db: LOOKUP.NOTFOUND %s/%v", idx.FullName(), loggableVal(indexKeyVal))
 		}
 	}
-	return row, rowMeta
+	return row, rowMeta, nil
 }
 
 func (tx *Tx) lookupRawKeyByVal(idx *Index, indexKeyVal reflect.Value) []byte {
+	return must(tx.tryLookupRawKeyByVal(idx, indexKeyVal))
+}
+
+func (tx *Tx) tryLookupRawKeyByVal(idx *Index, indexKeyVal reflect.Value) ([]byte, error) {
+	if !indexKeyVal.IsValid() {
+		return nil, fmt.Errorf("%s: attempted to index by nil, expected %v: %w", idx.FullName(), idx.keyType(), ErrWrongKeyType)
+	}
 	if at, et := indexKeyVal.Type(), idx.keyType(); at != et {
-		panic(fmt.Errorf("%s: attempted to index by incorrect type %v, expected %v", idx.FullName(), at, et))
+		return nil, fmt.Errorf("%s: attempted to index by incorrect type %v, expected %v: %w", idx.FullName(), at, et, ErrWrongKeyType)
 	}
 
 	indexKeyBuf := keyBytesPool.Get().([]byte)
 	defer releaseKeyBytes(indexKeyBuf)
 
 	tableBuck := nonNil(tx.btx.Bucket(idx.table.buck.Raw()))
-	idxBuck := nonNil(tableBuck.Bucket(idx.buck.Raw()))
 
 	fe := flatEncoder{buf: indexKeyBuf}
 	idx.keyEnc.encodeInto(&fe, indexKeyVal)
 	if idx.isUnique {
 		indexKeyRaw := fe.finalize()
-		indexVal := idxBuck.Get(indexKeyRaw)
+		indexVal := idx.shardBucketIn(tableBuck, 0).Get(indexKeyRaw)
 		if indexVal == nil {
-			return nil
+			return nil, nil
 		}
 
-		return decodeUniqueIndexTableKey(indexKeyRaw, indexVal, idx)
+		keyRaw := decodeUniqueIndexTableKey(indexKeyRaw, indexVal, idx)
+		if tx.isHiddenExpiredKey(idx.table, keyRaw) {
+			return nil, nil
+		}
+		return keyRaw, nil
 	} else {
-		scanPrefix, scanPrefixEls := fe.buf, fe.count()
-
-		c := idxBuck.Cursor()
-		for k, _ := c.Seek(scanPrefix); k != nil; k, _ = c.Next() {
-			if !bytes.HasPrefix(k, scanPrefix) {
-				break
-			}
-			indexKeyTup := decodeIndexKey(k, idx)
-			if len(indexKeyTup) != scanPrefixEls+1 {
-				panic(fmt.Errorf("%s: invalid index key %x: got %d els, wanted %d", idx.FullName(), k, len(indexKeyTup), scanPrefixEls+1))
+		// with several shards, pick the first match in index order, as if
+		// the index was not sharded
+		var bestK, bestDK []byte
+		for shard := range idx.ShardCount() {
+			k, dk := tx.lookupNonUniqueIndexEntry(idx.shardBucketIn(tableBuck, shard), idx, fe.buf, fe.count())
+			if k != nil && (bestK == nil || bytes.Compare(k, bestK) < 0) {
+				bestK, bestDK = k, dk
 			}
+		}
+		return bestDK, nil
+	}
+}
 
-			actualPrefix := indexKeyTup.rawData(k, scanPrefixEls)
-			if !bytes.Equal(actualPrefix, scanPrefix) {
-				continue
-			}
+func (tx *Tx) lookupNonUniqueIndexEntry(idxBuck *bbolt.Bucket, idx *Index, scanPrefix []byte, scanPrefixEls int) (k, dk []byte) {
+	c := idxBuck.Cursor()
+	for k, _ := c.Seek(scanPrefix); k != nil; k, _ = c.Next() {
+		if !bytes.HasPrefix(k, scanPrefix) {
+			break
+		}
+		indexKeyTup := decodeIndexKey(k, idx)
+		if len(indexKeyTup) != scanPrefixEls+1 {
+			panic(fmt.Errorf("%s: invalid index key %x: got %d els, wanted %d", idx.FullName(), k, len(indexKeyTup), scanPrefixEls+1))
+		}
+
+		actualPrefix := indexKeyTup.rawData(k, scanPrefixEls)
+		if !bytes.Equal(actualPrefix, scanPrefix) {
+			continue
+		}
 
-			dk, _ := extractUniqueIndexKey(indexKeyTup)
-			return dk
+		dk, _ := extractUniqueIndexKey(indexKeyTup)
+		if tx.isHiddenExpiredKey(idx.table, dk) {
+			continue
 		}
-		return nil
+		return k, dk
 	}
+	return nil, nil
 }
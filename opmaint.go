@@ -1,8 +1,24 @@
 package edb
 
-import "go.etcd.io/bbolt"
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const valueFormatUpgradeBatchSize = 1000
+
+const archiveBatchSize = 1000
+
+const reindexProgressInterval = 1000
 
 func (tx *Tx) Reindex(tbl *Table, idx *Index) {
+	tx.ensureNoSavepoint("Reindex")
 	tableBuck := nonNil(tx.btx.Bucket(tbl.buck.Raw()))
 	ts := tx.db.tableState(tbl)
 
@@ -15,18 +31,393 @@ func (tx *Tx) Reindex(tbl *Table, idx *Index) {
 		if idx != nil && idx != is.index {
 			continue
 		}
-		err := tableBuck.DeleteBucket(is.index.buck.Raw())
-		if err != nil && err != bbolt.ErrBucketNotFound {
-			panic(err)
+		for shard := range is.index.ShardCount() {
+			buck := is.index.shardBucketName(shard)
+			err := tableBuck.DeleteBucket(buck.Raw())
+			if err != nil && err != bbolt.ErrBucketNotFound {
+				panic(err)
+			}
+			_ = must(tableBuck.CreateBucketIfNotExists(buck.Raw()))
 		}
-		_ = must(tableBuck.CreateBucketIfNotExists(is.index.buck.Raw()))
-		is.Built = true
+		tx.db.setIndexBuilt(is)
 	}
+	tx.markWritten()
 
-	for c := tx.TableScan(tbl, FullScan()); c.Next(); {
+	c := tx.TableScan(tbl, FullScan())
+	for c.Next() {
 		rowVal, _ := c.RowVal()
 		tx.PutVal(tbl, rowVal)
 	}
+	if err := c.Err(); err != nil {
+		// the scan stops when the context is canceled
+		panic(fmt.Errorf("%s: reindexing stopped: %w", tbl.Name(), err))
+	}
 
 	ts.save(tx)
 }
+
+// ReindexWithProgress rebuilds a single index of tbl from scratch, writing
+// only that index's entries instead of re-putting every row like Reindex.
+// Calls progress (if not nil) with the number of processed rows every
+// reindexProgressInterval rows and once more at the end.
+//
+// Stops with the context error if the transaction's context (see
+// SetContext) gets canceled, leaving the index partially built, so
+// the caller should roll back the transaction in that case.
+//
+// Rows whose recorded keys for idx differ from the recomputed ones (e.g.
+// because the indexer has changed) get their records updated, so that later
+// updates remove the right entries; row data is never rewritten.
+func (tx *Tx) ReindexWithProgress(tbl *Table, idx *Index, progress func(done int64)) error {
+	if idx.table != tbl {
+		return fmt.Errorf("%s: cannot reindex %s: %w", tbl.Name(), idx.FullName(), ErrIndexNotOnTable)
+	}
+	tx.ensureNoSavepoint("ReindexWithProgress")
+	pb := tx.preparePut(tbl)
+	ctx := tx.Context()
+
+	for shard := range idx.ShardCount() {
+		buck := idx.shardBucketName(shard)
+		err := pb.tableBuck.DeleteBucket(buck.Raw())
+		if err != nil && err != bbolt.ErrBucketNotFound {
+			panic(err)
+		}
+		_ = must(pb.tableBuck.CreateBucketIfNotExists(buck.Raw()))
+	}
+	tx.markWritten()
+
+	var done int64
+	var keys, values [][]byte
+	c := pb.dataBuck.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if newValue := tx.reindexRow(tbl, idx, &pb, k, v); newValue != nil {
+			keys = append(keys, bytes.Clone(k))
+			values = append(values, newValue)
+		}
+		done++
+		if progress != nil && done%reindexProgressInterval == 0 {
+			progress(done)
+		}
+	}
+
+	// bbolt cursors must not be used across mutations, so write afterwards
+	for i, k := range keys {
+		ensure(pb.dataBuck.Put(k, values[i]))
+	}
+
+	tx.db.setIndexBuilt(pb.ts.indexStates[idx.pos])
+	pb.ts.save(tx)
+	if progress != nil {
+		progress(done)
+	}
+	return nil
+}
+
+// reindexRow puts the entries of idx for the given row. Returns the value
+// to store for the row if its recorded index keys need updating, or nil.
+func (tx *Tx) reindexRow(tbl *Table, idx *Index, pb *putBuckets, keyRaw, valueRaw []byte) []byte {
+	var vle value
+	decodeTableValue(&vle, tbl, keyRaw, valueRaw)
+	rowVal, _, _, err := decodeTableRowFromValue(&vle, tbl, keyRaw, tx)
+	if err != nil {
+		panic(err)
+	}
+
+	ib := makeIndexBuilder(pb.ts, keyRaw)
+	defer ib.release(tx)
+	ib.row = rowVal.Interface()
+	tbl.indexer(ib.row, &ib)
+	ib.finalize()
+
+	ord := pb.ts.indexOrdinal(idx)
+	var recorded indexRows
+	decodeIndexKeys(vle.Index, func(o uint64, key []byte) {
+		if o != ord {
+			recorded = append(recorded, IndexRow{IndexOrd: o, KeyRaw: key})
+		}
+	})
+	for _, ir := range ib.rows {
+		if ir.Index != idx {
+			continue
+		}
+		ensure(pb.indexBucket(idx, idx.shardFor(keyRaw)).Put(ir.KeyRaw, ir.ValueRaw))
+		recorded = append(recorded, IndexRow{IndexOrd: ord, KeyRaw: ir.KeyRaw})
+	}
+	sort.Sort(recorded)
+
+	index := appendIndexKeys(nil, recorded)
+	if bytes.Equal(index, vle.Index) {
+		return nil
+	}
+	return appendValue(nil, vle.Flags, vle.SchemaVer, vle.ModCount, vle.Expiry, vle.Data, index)
+}
+
+// UpgradeValueFormat rewrites all rows stored in an outdated value format
+// using the current one: rows written before CompressValues was enabled get
+// compressed (and vice versa), and rows written with another ValueCodec get
+// re-encoded. Row data, schema versions and mod counts are preserved.
+//
+// Rows stored under field names changed by TableBuilder.RenameField are put
+// again instead, going through Migrate, so they end up at the latest schema
+// version under the new names.
+//
+// Rows are processed in batches, one write transaction per batch, so this is
+// safe to run in background; it stops early once Close is called.
+// Returns the number of rewritten rows.
+func (db *DB) UpgradeValueFormat() int {
+	var total int
+	start := time.Now()
+	for _, tbl := range db.schema.tables {
+		var after []byte
+		for !db.isStopping() {
+			var n int
+			var done bool
+			db.Write(func(tx *Tx) {
+				n, after, done = tx.upgradeValueFormatBatch(tbl, after, valueFormatUpgradeBatchSize)
+			})
+			total += n
+			if done {
+				break
+			}
+		}
+	}
+	if total > 0 {
+		logf := db.logf
+		if logf == nil {
+			logf = log.Printf
+		}
+		logf("db: upgraded value format of %d rows in %d ms", total, time.Since(start).Milliseconds())
+	}
+	return total
+}
+
+// ArchiveOlderThan moves rows of tbl whose idx key sorts before cutoff
+// (e.g. an index by creation time) into the same-named table of dst, then
+// deletes them from db. Returns the number of moved rows.
+//
+// Rows are moved in batches: each batch is first committed to dst, then
+// deleted from db. If interrupted, a row may end up in both databases,
+// and calling ArchiveOlderThan again completes the move, because rows
+// already archived are simply overwritten in dst. A row modified after being
+// copied is not deleted, so that its new version isn't lost; it is archived
+// again by a later batch if it still sorts before cutoff, and otherwise
+// stays in db (with its old version in dst).
+func (db *DB) ArchiveOlderThan(tbl *Table, idx *Index, cutoff any, dst *DB) (int, error) {
+	if idx.table != tbl {
+		return 0, fmt.Errorf("%s: cannot archive using index %s: %w", tbl.Name(), idx.FullName(), ErrIndexNotOnTable)
+	}
+	dstTbl := dst.schema.TableNamed(tbl.Name())
+	if dstTbl == nil {
+		return 0, fmt.Errorf("%s: archive database has no such table", tbl.Name())
+	}
+	if dstTbl.rowType != tbl.rowType {
+		return 0, fmt.Errorf("%s: archive table stores %v, expected %v", tbl.Name(), dstTbl.rowType, tbl.rowType)
+	}
+
+	opt := RangeScan(nil, cutoff, false, false)
+	opt.Limit = archiveBatchSize
+	var total int
+	for !db.IsClosed() && !dst.IsClosed() {
+		var keys, values [][]byte
+		var rows []reflect.Value
+		var err error
+		db.Read(func(tx *Tx) {
+			var c *RawIndexCursor
+			c, err = tx.TryIndexScan(idx, opt)
+			if err != nil {
+				return
+			}
+			for c.Next() {
+				rowVal, _ := c.RowVal()
+				keys = append(keys, bytes.Clone(c.RawKey()))
+				values = append(values, bytes.Clone(tx.getRawByRawKey(tbl, c.RawKey())))
+				rows = append(rows, rowVal)
+			}
+		})
+		if err != nil {
+			return total, err
+		}
+		if len(keys) == 0 {
+			break
+		}
+		dst.Write(func(tx *Tx) {
+			for _, rowVal := range rows {
+				tx.PutVal(dstTbl, rowVal)
+			}
+		})
+		var n int
+		db.Write(func(tx *Tx) {
+			n = tx.deleteUnchangedRows(tbl, keys, values)
+		})
+		total += n
+	}
+	return total, nil
+}
+
+// deleteUnchangedRows deletes the rows whose stored values are still equal to
+// the given ones, returning the number of deleted rows.
+func (tx *Tx) deleteUnchangedRows(tbl *Table, keys, values [][]byte) int {
+	var n int
+	for i, k := range keys {
+		if bytes.Equal(tx.getRawByRawKey(tbl, k), values[i]) && tx.DeleteByKeyRaw(tbl, k) {
+			n++
+		}
+	}
+	return n
+}
+
+// WarmStats describes the work done by Warm.
+type WarmStats struct {
+	Tables  int
+	Bytes   int64 // keys and values read
+	Elapsed time.Duration
+}
+
+// Warm reads every page of the data and index buckets of the given tables
+// (or of all tables if none are given), so that the OS page cache is primed
+// before serving traffic. Logs and returns the number of bytes touched.
+func (db *DB) Warm(tables ...*Table) (WarmStats, error) {
+	if len(tables) == 0 {
+		tables = db.schema.tables
+	}
+	start := time.Now()
+	stats := WarmStats{Tables: len(tables)}
+	err := db.bdb.View(func(btx *bbolt.Tx) error {
+		for _, tbl := range tables {
+			stats.Bytes += warmBucket(tbl.rootBucketIn(btx))
+		}
+		return nil
+	})
+	stats.Elapsed = time.Since(start)
+	if err != nil {
+		return stats, err
+	}
+	logf := db.logf
+	if logf == nil {
+		logf = log.Printf
+	}
+	logf("db: warmed up %d tables (%d bytes) in %d ms", stats.Tables, stats.Bytes, stats.Elapsed.Milliseconds())
+	return stats, nil
+}
+
+// warmBucket touches every page of the bucket and its nested buckets,
+// returning the number of bytes of keys and values found.
+func warmBucket(buck *bbolt.Bucket) int64 {
+	const pageSize = 4096
+	var total int64
+	var sink byte
+	c := buck.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil {
+			if nested := buck.Bucket(k); nested != nil {
+				total += warmBucket(nested)
+				continue
+			}
+		}
+		for i := 0; i < len(v); i += pageSize {
+			sink ^= v[i]
+		}
+		total += int64(len(k) + len(v))
+	}
+	_ = sink
+	return total
+}
+
+// upgradeValueFormatBatch rewrites up to limit rows following the given key
+// (or starting from the first row if after is nil). Returns the number of
+// rewritten rows, the last examined key, and whether the end of the table
+// has been reached.
+func (tx *Tx) upgradeValueFormatBatch(tbl *Table, after []byte, limit int) (upgraded int, last []byte, done bool) {
+	dataBuck := tbl.dataBucketIn(tbl.rootBucketIn(tx.btx))
+
+	var keys, values, renamedKeys, renamedValues [][]byte
+	c := dataBuck.Cursor()
+	var k, v []byte
+	if after == nil {
+		k, v = c.First()
+	} else {
+		k, v = c.Seek(after)
+		if k != nil && bytes.Equal(k, after) {
+			k, v = c.Next()
+		}
+	}
+	var scanned int
+	for ; k != nil; k, v = c.Next() {
+		var vle value
+		decodeTableValue(&vle, tbl, k, v)
+		if vle.SchemaVer < tbl.latestSchemaVer && tbl.fieldRenamesAt(vle.SchemaVer) != nil {
+			renamedKeys = append(renamedKeys, bytes.Clone(k))
+			renamedValues = append(renamedValues, bytes.Clone(v))
+		} else if newValue := upgradedValue(tbl, k, &vle); newValue != nil {
+			tx.rememberRow(tbl, k, v)
+			keys = append(keys, bytes.Clone(k))
+			values = append(values, newValue)
+		}
+		last = k
+		scanned++
+		if scanned >= limit {
+			break
+		}
+	}
+	last = bytes.Clone(last)
+	done = (scanned < limit)
+
+	// bbolt cursors must not be used across mutations, so write afterwards
+	for i, k := range keys {
+		ensure(dataBuck.Put(k, values[i]))
+	}
+	if len(keys) > 0 {
+		tx.markWritten()
+		if tx.isVerboseLoggingEnabled() {
+			tx.db.logf("db: UPGRADE_FORMAT %s: %d rows", tbl.name, len(keys))
+		}
+	}
+	for i, k := range renamedKeys {
+		rowVal, _, err := decodeTableRow(tbl, k, renamedValues[i], tx)
+		if err != nil {
+			panic(err)
+		}
+		tx.PutVal(tbl, rowVal)
+	}
+	return len(keys) + len(renamedKeys), last, done
+}
+
+// upgradedValue returns the value to store instead of vle if the row is
+// stored with outdated format flags, compression or encoding, or nil if it's
+// up to date. Schema version, mod count, expiry and recorded index keys are
+// kept as is.
+func upgradedValue(tbl *Table, keyRaw []byte, vle *value) []byte {
+	flags := tbl.valueFlags() | (vle.Flags & vfExpires)
+	transcode := (vle.Flags & vfJSON) != (flags & vfJSON)
+	gzipped := (vle.Flags & vfGzip) != 0
+	if !transcode && gzipped == tbl.compressValues && vle.Flags.ver() == flags.ver() {
+		return nil
+	}
+
+	var data []byte
+	if transcode {
+		rowVal, _, _, err := decodeUnmigratedTableRowFromValue(vle, tbl, keyRaw)
+		if err != nil {
+			panic(err)
+		}
+		data = tbl.encodeRowVal(nil, rowVal)
+	} else {
+		var err error
+		data, err = vle.plainData()
+		if err != nil {
+			panic(tableErrf(tbl, nil, keyRaw, err, "data"))
+		}
+	}
+	if tbl.compressValues {
+		if compressed := compressValueData(data); compressed != nil {
+			data = compressed
+			flags |= vfGzip
+		} else if !transcode && !gzipped && vle.Flags.ver() == flags.ver() {
+			return nil // doesn't get smaller, keep it
+		}
+	}
+	return appendValue(nil, flags, vle.SchemaVer, vle.ModCount, vle.Expiry, data, vle.Index)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
@@ -21,6 +22,157 @@ func Put(txh Txish, rows ...any) bool {
 	return isModified
 }
 
+// PutAllValidated checks every row using validate before writing any of them,
+// and writes nothing if validation fails. This is the Check phase of
+// the transaction (see DB.Tx): if no other mutations have been made yet,
+// returning the validation error from a DB.Tx callback does not fail
+// the whole batch.
+func PutAllValidated[Row any](txh Txish, rows []*Row, validate func(*Row) error) (bool, error) {
+	tx := txh.DBTx()
+	tbl := tableOf[Row](tx)
+	for i, row := range rows {
+		if err := validate(row); err != nil {
+			return false, fmt.Errorf("%s: row %d: %w", tbl.Name(), i, err)
+		}
+	}
+
+	var isModified bool
+	for _, row := range rows {
+		oldMeta, newMeta := tx.Put(tbl, row)
+		if newMeta.IsModified(oldMeta) {
+			isModified = true
+		}
+	}
+	return isModified, nil
+}
+
+// SwapKeys exchanges the keys of two existing rows, so that the row stored
+// under keyA ends up under keyB and vice versa, updating index entries
+// of both. Both rows are deleted before being re-inserted, so unique index
+// entries never collide midway.
+func (tx *Tx) SwapKeys(tbl *Table, keyA, keyB any) error {
+	keyValA, err := tbl.checkKeyType(reflect.ValueOf(keyA))
+	if err != nil {
+		return err
+	}
+	keyValB, err := tbl.checkKeyType(reflect.ValueOf(keyB))
+	if err != nil {
+		return err
+	}
+
+	rowA, _, err := tx.getRowValByKeyVal(tbl, keyValA, true)
+	if err != nil {
+		return err
+	}
+	if !rowA.IsValid() {
+		return fmt.Errorf("%s/%v: %w", tbl.Name(), keyA, ErrNotFound)
+	}
+	rowB, _, err := tx.getRowValByKeyVal(tbl, keyValB, true)
+	if err != nil {
+		return err
+	}
+	if !rowB.IsValid() {
+		return fmt.Errorf("%s/%v: %w", tbl.Name(), keyB, ErrNotFound)
+	}
+	if bytes.Equal(tbl.EncodeKeyVal(keyValA), tbl.EncodeKeyVal(keyValB)) {
+		return nil
+	}
+
+	tx.DeleteByKeyVal(tbl, keyValA)
+	tx.DeleteByKeyVal(tbl, keyValB)
+	tbl.SetRowKeyVal(rowA, keyValB)
+	tbl.SetRowKeyVal(rowB, keyValA)
+	tx.PutVal(tbl, rowA)
+	tx.PutVal(tbl, rowB)
+	return nil
+}
+
+// Insert sets the key of row to the next value of the table's sequence and
+// puts it, returning the assigned key. The table must be defined with
+// TableBuilder.AutoKey. Sequence values are never reused, even if the rows
+// get deleted; when AutoKey is added to a table that already has rows, the
+// sequence continues after the largest existing key. Sequence values taken
+// by rows put explicitly under those keys are skipped, so Insert never
+// overwrites an existing row.
+func Insert[Row any](txh Txish, row *Row) uint64 {
+	tx := txh.DBTx()
+	tbl := tableOf[Row](tx)
+	keyVal := tbl.rowInfo.keyValue(reflect.ValueOf(row))
+	dataBuck := tbl.dataBucketIn(tbl.rootBucketIn(tx.btx))
+	for {
+		seq := tx.nextKeySequence(tbl)
+		if keyVal.CanInt() {
+			if keyVal.OverflowInt(int64(seq)) || int64(seq) < 0 {
+				panic(fmt.Errorf("%s: key sequence overflows %v", tbl.name, tbl.keyType))
+			}
+			keyVal.SetInt(int64(seq))
+		} else {
+			if keyVal.OverflowUint(seq) {
+				panic(fmt.Errorf("%s: key sequence overflows %v", tbl.name, tbl.keyType))
+			}
+			keyVal.SetUint(seq)
+		}
+		if dataBuck.Get(tbl.EncodeKeyVal(keyVal)) == nil {
+			tx.Put(tbl, row)
+			return seq
+		}
+	}
+}
+
+// Upsert loads the row stored under key (nil if there is none), passes it to
+// update and puts the returned row under the same key, setting its key field.
+// Returns the stored row and whether it has been newly created. If update
+// returns nil, nothing is written and Upsert returns (nil, false).
+//
+// The row passed to update is a fresh copy, so update may modify and return it.
+func Upsert[Row any](txh Txish, key any, update func(existing *Row) *Row) (*Row, bool) {
+	tx := txh.DBTx()
+	tbl := tableOf[Row](tx)
+	keyVal, err := tbl.checkKeyType(reflect.ValueOf(key))
+	if err != nil {
+		panic(err)
+	}
+	existingVal, _, err := tx.getRowValByKeyVal(tbl, keyVal, true)
+	if err != nil {
+		panic(err)
+	}
+	var existing *Row
+	if existingVal.IsValid() {
+		existing = existingVal.Interface().(*Row)
+	}
+	row := update(existing)
+	if row == nil {
+		return nil, false
+	}
+	rowVal := reflect.ValueOf(row)
+	tbl.SetRowKeyVal(rowVal, keyVal)
+	tx.PutVal(tbl, rowVal)
+	return row, existing == nil
+}
+
+func (tx *Tx) nextKeySequence(tbl *Table) uint64 {
+	if !tbl.autoKey {
+		panic(fmt.Errorf("%s: Insert requires TableBuilder.AutoKey", tbl.name))
+	}
+	tableBuck := tbl.rootBucketIn(tx.btx)
+	if tableBuck.Sequence() == 0 {
+		if k, _ := tbl.dataBucketIn(tableBuck).Cursor().Last(); k != nil {
+			keyVal := reflect.New(tbl.keyType).Elem()
+			if err := tbl.keyEnc.decodeVal(k, keyVal); err != nil {
+				panic(tableErrf(tbl, nil, k, err, "decoding last key"))
+			}
+			var last uint64
+			if keyVal.CanInt() {
+				last = uint64(max(keyVal.Int(), 0))
+			} else {
+				last = keyVal.Uint()
+			}
+			ensure(tableBuck.SetSequence(last))
+		}
+	}
+	return must(tableBuck.NextSequence())
+}
+
 func (tx *Tx) Put(tbl *Table, row any) (oldMeta, newMeta ValueMeta) {
 	return tx.PutVal(tbl, reflect.ValueOf(row))
 }
@@ -29,18 +181,101 @@ func (tx *Tx) PutVal(tbl *Table, rowVal reflect.Value) (oldMeta, newMeta ValueMe
 	if tx == nil {
 		panic("nil tx")
 	}
+	if tx.timeOps {
+		defer tx.recordOp(time.Now(), "put", tbl.name)
+	}
+	pb := tx.preparePut(tbl)
+	return tx.putVal(tbl, rowVal, &pb)
+}
+
+// PutIfUnchanged puts the row only if its stored ModCount still matches
+// expected, as returned by an earlier read; a zero expected ValueMeta requires
+// the row to not exist yet. Returns the new ValueMeta on success. If the row
+// has been modified (or created, or deleted) since, returns the current
+// ValueMeta and an error wrapping ErrConflict.
+//
+// A recreated row starts counting from 1 again, so if the row gets deleted,
+// recreated and then modified until its ModCount reaches expected again, the
+// conflict goes unnoticed (the ABA problem). When
+// rows of a table can be deleted and recreated under the same key, keep
+// a version or nonce in the row itself and compare that too.
+func (tx *Tx) PutIfUnchanged(tbl *Table, rowVal reflect.Value, expected ValueMeta) (ValueMeta, error) {
+	keyVal := tbl.RowKeyVal(rowVal)
+	current := tx.GetMetaByKeyVal(tbl, keyVal)
+	if current.ModCount != expected.ModCount {
+		return current, fmt.Errorf("%s/%v: expected mod count %d, found %d: %w", tbl.Name(), keyVal, expected.ModCount, current.ModCount, ErrConflict)
+	}
+	_, newMeta := tx.PutVal(tbl, rowVal)
+	return newMeta, nil
+}
+
+// PutBatch puts all rows into tbl, like calling PutVal for each of them, but
+// resolves the table state and buckets once, and keeps index bucket handles
+// across rows. Change notifications are delivered per row, same as PutVal.
+// Returns true if any of the rows has been modified.
+func (tx *Tx) PutBatch(tbl *Table, rows []reflect.Value) bool {
+	if tx == nil {
+		panic("nil tx")
+	}
+	if tx.timeOps {
+		defer tx.recordOp(time.Now(), "put_batch", tbl.name)
+	}
+	pb := tx.preparePut(tbl)
+	pb.idxBucks = make(map[string]*bbolt.Bucket)
+	var isModified bool
+	for _, rowVal := range rows {
+		oldMeta, newMeta := tx.putVal(tbl, rowVal, &pb)
+		if newMeta.IsModified(oldMeta) {
+			isModified = true
+		}
+	}
+	return isModified
+}
+
+// putBuckets holds the state resolved once per PutVal or PutBatch call.
+type putBuckets struct {
+	tableBuck *bbolt.Bucket
+	dataBuck  *bbolt.Bucket
+	ts        *tableState
+	idxBucks  map[string]*bbolt.Bucket // by bucket name; nil disables caching
+}
+
+func (tx *Tx) preparePut(tbl *Table) putBuckets {
 	tableBuck := nonNil(tx.btx.Bucket(tbl.buck.Raw()))
-	dataBuck := nonNil(tableBuck.Bucket(dataBucket.Raw()))
+	return putBuckets{
+		tableBuck: tableBuck,
+		dataBuck:  nonNil(tableBuck.Bucket(dataBucket.Raw())),
+		ts:        tx.db.tableState(tbl),
+	}
+}
+
+func (pb *putBuckets) indexBucket(idx *Index, shard int) *bbolt.Bucket {
+	name := idx.shardBucketName(shard)
+	if b := pb.idxBucks[string(name)]; b != nil {
+		return b
+	}
+	b := pb.tableBuck.Bucket(name.Raw())
+	if b == nil {
+		panic(fmt.Errorf("missing bucket for index %v", idx.FullName()))
+	}
+	if pb.idxBucks != nil {
+		pb.idxBucks[string(name)] = b
+	}
+	return b
+}
+
+func (tx *Tx) putVal(tbl *Table, rowVal reflect.Value, pb *putBuckets) (oldMeta, newMeta ValueMeta) {
+	tableBuck, dataBuck, ts := pb.tableBuck, pb.dataBuck, pb.ts
 
 	keyBuf := keyBytesPool.Get().([]byte)
 	keyVal := tbl.RowKeyVal(rowVal)
 	keyRaw := tbl.encodeKeyVal(keyBuf, keyVal, false)
 	defer keyBytesPool.Put(keyBuf[:0])
 
-	ts := tx.db.tableState(tbl)
 	ib := makeIndexBuilder(ts, keyRaw)
 	defer ib.release(tx)
-	tbl.indexer(rowVal.Interface(), &ib)
+	ib.row = rowVal.Interface()
+	tbl.indexer(ib.row, &ib)
 	ib.finalize()
 
 	oldValueRaw := dataBuck.Get(keyRaw)
@@ -61,6 +296,17 @@ func (tx *Tx) PutVal(tbl *Table, rowVal reflect.Value) (oldMeta, newMeta ValueMe
 	valueRaw := reserveValueHeader(valueBuf)
 	dataOff := len(valueRaw)
 	valueRaw = tbl.encodeRowVal(valueRaw, rowVal)
+	flags := tbl.valueFlags()
+	if tbl.compressValues {
+		if compressed := compressValueData(valueRaw[dataOff:]); compressed != nil {
+			valueRaw = append(valueRaw[:dataOff], compressed...)
+			flags |= vfGzip
+		}
+	}
+	expiry, expires := tbl.rowExpiry(ib.row)
+	if expires {
+		flags |= vfExpires
+	}
 	dataBytes := valueRaw[dataOff:]
 	indexOff := len(valueRaw)
 	valueRaw = appendIndexKeys(valueRaw, ib.rows)
@@ -69,7 +315,7 @@ func (tx *Tx) PutVal(tbl *Table, rowVal reflect.Value) (oldMeta, newMeta ValueMe
 	isDataUnchanged := bytes.Equal(dataBytes, old.Data)
 	isIndexKeySetUnchanged := bytes.Equal(indexBytes, old.Index)
 
-	if oldValueRaw != nil && (old.SchemaVer == newSchemaVer) && isDataUnchanged && isIndexKeySetUnchanged && !tx.reindexing {
+	if oldValueRaw != nil && (old.SchemaVer == newSchemaVer) && (old.Flags == flags) && (old.Expiry == expiry) && isDataUnchanged && isIndexKeySetUnchanged && !tx.reindexing {
 		// Likely nothing changed. Ignore possible index value changes; if data is
 		// unchanged, a no-op save is much more likely than a change to indexing algorithm.
 		if tx.isVerboseLoggingEnabled() {
@@ -80,8 +326,17 @@ func (tx *Tx) PutVal(tbl *Table, rowVal reflect.Value) (oldMeta, newMeta ValueMe
 	if !isDataUnchanged {
 		newModCount++
 	}
-	valueRaw = putValueHeader(valueRaw, vfDefault, newSchemaVer, newModCount, indexOff)
+	valueRaw = putValueHeader(valueRaw, flags, newSchemaVer, newModCount, expiry, indexOff)
+
+	writeSize := len(keyRaw) + len(valueRaw)
+	for _, ir := range ib.rows {
+		writeSize += len(ir.KeyRaw) + len(ir.ValueRaw)
+	}
+	if err := tx.reserveSpace(writeSize); err != nil {
+		panic(tableErrf(tbl, nil, keyRaw, err, "put"))
+	}
 	tx.markWritten()
+	tx.rememberRow(tbl, keyRaw, oldValueRaw)
 
 	// log.Printf("PUT into %s: %x => %x (%s)", tbl.Name(), keyRaw, valueRaw, valueRaw)
 	ensure(dataBuck.Put(keyRaw, valueRaw))
@@ -92,7 +347,7 @@ func (tx *Tx) PutVal(tbl *Table, rowVal reflect.Value) (oldMeta, newMeta ValueMe
 
 	if oldValueRaw != nil && !isIndexKeySetUnchanged && !tx.reindexing {
 		// delete removed index entries
-		del := prepareToDeleteIndexEntries(tableBuck, ts)
+		del := prepareToDeleteIndexEntries(tableBuck, ts, keyRaw)
 		findRemovedIndexKeys(old.Index, ib.rows, del)
 	}
 
@@ -102,16 +357,15 @@ func (tx *Tx) PutVal(tbl *Table, rowVal reflect.Value) (oldMeta, newMeta ValueMe
 	for _, ir := range ib.rows {
 		if ir.Index != idx {
 			idx = ir.Index
-			idxBuck = tableBuck.Bucket(idx.buck.Raw())
-			if idxBuck == nil {
-				panic(fmt.Errorf("missing bucket for index %v", idx.FullName()))
-			}
+			idxBuck = pb.indexBucket(idx, idx.shardFor(keyRaw))
 		}
+		tx.rememberIndexEntry(idx, idx.shardFor(keyRaw), idxBuck, ir.KeyRaw, ir.ValueRaw)
 		// log.Printf("PUT into %s: %x => %x", idx.FullName(), ir.KeyRaw, ir.ValueRaw)
 		ensure(idxBuck.Put(ir.KeyRaw, ir.ValueRaw))
 	}
+	tx.invalidateCached(tbl, keyRaw)
 
-	if opts := tx.changeOptions[tbl]; opts.Contains(ChangeFlagNotify) && tx.changeHandler != nil {
+	if opts := tx.changeFlags(tbl); opts.Contains(ChangeFlagNotify) {
 		chg := Change{
 			table:  tbl,
 			op:     OpPut,
@@ -140,7 +394,7 @@ func (tx *Tx) PutVal(tbl *Table, rowVal reflect.Value) (oldMeta, newMeta ValueMe
 				tx.db.logf("db: PUT %s/%v: cannot decode old row value: %v", tbl.name, keyRaw, err)
 			}
 		}
-		tx.changeHandler(tx, &chg)
+		tx.notifyChange(&chg)
 	}
 
 	return ValueMeta{oldSchemaVer, oldModCount}, ValueMeta{newSchemaVer, newModCount}
@@ -49,9 +49,30 @@ func SPut[T any](txh Txish, sk *SKey, v *T) {
 	SPutRaw(tx, sk, valueRaw)
 }
 
-func CountAll(txh Txish, tbl *Table) int {
+// SGetOr returns the value of the singleton key, or def if it is unset.
+func SGetOr[T any](txh Txish, sk *SKey, def T) T {
+	var v T
+	if !SGet(txh, sk, &v) {
+		return def
+	}
+	return v
+}
+
+// SUpdate reads the singleton key, passes its value (nil if unset) to f and
+// stores the value f returns. If f returns nil, the key is left unchanged.
+// The read and the write happen within the same transaction, so in a write
+// transaction the update is atomic.
+func SUpdate[T any](txh Txish, sk *SKey, f func(cur *T) *T) {
 	tx := txh.DBTx()
-	tableBuck := nonNil(tx.btx.Bucket(tbl.buck.Raw()))
-	dataBuck := nonNil(tableBuck.Bucket(dataBucket.Raw()))
-	return dataBuck.Stats().KeyN
+	var cur *T
+	if v := new(T); SGet(tx, sk, v) {
+		cur = v
+	}
+	if v := f(cur); v != nil {
+		SPut(tx, sk, v)
+	}
+}
+
+func CountAll(txh Txish, tbl *Table) int {
+	return txh.DBTx().FastCount(tbl)
 }
@@ -0,0 +1,182 @@
+package edb
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+type savepoint struct {
+	rows       []savedRow
+	rowsByK    map[savedRowKey]struct{}
+	entries    []savedIndexEntry
+	entriesByK map[savedIndexEntryKey]struct{}
+}
+
+type savedRowKey struct {
+	tbl    *Table
+	keyRaw string
+}
+
+type savedRow struct {
+	tbl      *Table
+	keyRaw   []byte
+	valueRaw []byte // nil if the row did not exist
+}
+
+type savedIndexEntryKey struct {
+	idx    *Index
+	keyRaw string
+}
+
+// savedIndexEntry is a unique index entry that belonged to another row
+// before being overwritten.
+type savedIndexEntry struct {
+	idx      *Index
+	shard    int
+	keyRaw   []byte
+	valueRaw []byte
+}
+
+// Savepoint runs f within the current write transaction. If f returns an
+// error, the table rows f has put or deleted are restored to their state
+// before the call, and the error is returned; the rest of the transaction
+// is unaffected and can still be committed.
+//
+// The previous value of each row is copied the first time f modifies it,
+// along with unique index entries that f takes over from other rows, so
+// the memory cost grows with the number and size of rows touched. Only
+// table rows and their indices are restored: KV tables, key sequences used
+// by Insert, and change notifications already delivered are not rolled
+// back. Savepoints can be nested; a failed inner savepoint only undoes its
+// own changes. Reindexing is not allowed within a savepoint.
+//
+// If f panics, nothing is restored, and the panic propagates (usually
+// aborting the whole transaction).
+func (tx *Tx) Savepoint(f func(tx *Tx) error) error {
+	sp := &savepoint{
+		rowsByK:    make(map[savedRowKey]struct{}),
+		entriesByK: make(map[savedIndexEntryKey]struct{}),
+	}
+	tx.savepoints = append(tx.savepoints, sp)
+	err := func() error {
+		defer func() {
+			tx.savepoints = tx.savepoints[:len(tx.savepoints)-1]
+		}()
+		return f(tx)
+	}()
+	if err != nil {
+		tx.rollbackSavepoint(sp)
+	}
+	return err
+}
+
+// rememberRow records the value of the row about to be modified in every
+// active savepoint that hasn't seen the row yet.
+func (tx *Tx) rememberRow(tbl *Table, keyRaw, oldValueRaw []byte) {
+	if len(tx.savepoints) == 0 {
+		return
+	}
+	k := savedRowKey{tbl, string(keyRaw)}
+	for _, sp := range tx.savepoints {
+		if _, found := sp.rowsByK[k]; found {
+			continue
+		}
+		sp.rowsByK[k] = struct{}{}
+		sp.rows = append(sp.rows, savedRow{
+			tbl:      tbl,
+			keyRaw:   bytes.Clone(keyRaw),
+			valueRaw: bytes.Clone(oldValueRaw),
+		})
+	}
+}
+
+// rememberIndexEntry records the unique index entry about to be overwritten
+// with a different value, if it exists, in every active savepoint that hasn't
+// seen the entry yet.
+func (tx *Tx) rememberIndexEntry(idx *Index, shard int, buck *bbolt.Bucket, keyRaw, newValueRaw []byte) {
+	if len(tx.savepoints) == 0 || !idx.isUnique {
+		return
+	}
+	oldValueRaw := buck.Get(keyRaw)
+	if oldValueRaw == nil || bytes.Equal(oldValueRaw, newValueRaw) {
+		return
+	}
+	k := savedIndexEntryKey{idx, string(keyRaw)}
+	for _, sp := range tx.savepoints {
+		if _, found := sp.entriesByK[k]; found {
+			continue
+		}
+		sp.entriesByK[k] = struct{}{}
+		sp.entries = append(sp.entries, savedIndexEntry{
+			idx:      idx,
+			shard:    shard,
+			keyRaw:   bytes.Clone(keyRaw),
+			valueRaw: bytes.Clone(oldValueRaw),
+		})
+	}
+}
+
+// ensureNoSavepoint panics if called within a savepoint, for operations whose
+// effects a savepoint cannot undo.
+func (tx *Tx) ensureNoSavepoint(op string) {
+	if len(tx.savepoints) > 0 {
+		panic(fmt.Errorf("%s cannot be used within a savepoint", op))
+	}
+}
+
+func (tx *Tx) rollbackSavepoint(sp *savepoint) {
+	// remove all current versions before restoring anything, so that
+	// restored rows can take back unique index entries from each other
+	for _, r := range sp.rows {
+		pb := tx.preparePut(r.tbl)
+		cur := pb.dataBuck.Get(r.keyRaw)
+		if cur == nil {
+			continue
+		}
+		var vle value
+		decodeTableValue(&vle, r.tbl, r.keyRaw, cur)
+		del := prepareToDeleteIndexEntries(pb.tableBuck, pb.ts, r.keyRaw)
+		decodeIndexKeys(vle.Index, del)
+		ensure(pb.dataBuck.Delete(r.keyRaw))
+	}
+
+	for _, r := range sp.rows {
+		if r.valueRaw != nil {
+			pb := tx.preparePut(r.tbl)
+			ensure(pb.dataBuck.Put(r.keyRaw, r.valueRaw))
+			tx.putIndexEntries(r.tbl, &pb, r.keyRaw, r.valueRaw)
+		}
+		tx.invalidateCached(r.tbl, r.keyRaw)
+	}
+
+	// give back unique index entries to the rows they were taken from
+	for _, e := range sp.entries {
+		pb := tx.preparePut(e.idx.table)
+		ensure(pb.indexBucket(e.idx, e.shard).Put(e.keyRaw, e.valueRaw))
+	}
+	if tx.isVerboseLoggingEnabled() {
+		tx.db.logf("db: SAVEPOINT.ROLLBACK restored %d rows, %d index entries", len(sp.rows), len(sp.entries))
+	}
+}
+
+// putIndexEntries puts the entries of all indices for the given stored row.
+func (tx *Tx) putIndexEntries(tbl *Table, pb *putBuckets, keyRaw, valueRaw []byte) {
+	var vle value
+	decodeTableValue(&vle, tbl, keyRaw, valueRaw)
+	rowVal, _, _, err := decodeTableRowFromValue(&vle, tbl, keyRaw, tx)
+	if err != nil {
+		panic(err)
+	}
+
+	ib := makeIndexBuilder(pb.ts, keyRaw)
+	defer ib.release(tx)
+	ib.row = rowVal.Interface()
+	tbl.indexer(ib.row, &ib)
+	ib.finalize()
+
+	for _, ir := range ib.rows {
+		ensure(pb.indexBucket(ir.Index, ir.Index.shardFor(keyRaw)).Put(ir.KeyRaw, ir.ValueRaw))
+	}
+}
@@ -2,12 +2,17 @@ package edb
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"fmt"
+	"iter"
 	"log"
 	"log/slog"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
@@ -46,16 +51,69 @@ func (c Cursor[Row]) Meta() ValueMeta {
 	return c.RawCursor.Meta()
 }
 
+// Skip advances the cursor past the next n rows, so that the following call
+// to Next returns the row after them. Rows are skipped in scan order, so on
+// a reversed scan Skip drops the last rows of the range. Returns the cursor
+// for chaining, e.g. AllLimited(c.Skip(offset), limit).
+//
+// Skipped rows are counted by ScanOptions.Limit just like returned ones, and
+// Reset rewinds to the very beginning. When creating a new scan, prefer
+// ScanOptions.Offset, which does not have these caveats.
+func (c Cursor[Row]) Skip(n int) Cursor[Row] {
+	Skip(c.RawCursor, n)
+	return c
+}
+
+// Seq returns an iterator over the remaining rows of the cursor, for use
+// with range. Breaking out of the loop leaves the cursor on the last
+// returned row.
+func (c Cursor[Row]) Seq() iter.Seq[*Row] {
+	return func(yield func(*Row) bool) {
+		for c.Next() {
+			if !yield(c.Row()) {
+				break
+			}
+		}
+	}
+}
+
+// Seq2 is like Seq, but also yields the primary key of each row (even for
+// index scans).
+func (c Cursor[Row]) Seq2() iter.Seq2[any, *Row] {
+	return func(yield func(any, *Row) bool) {
+		for c.Next() {
+			if !yield(c.Key(), c.Row()) {
+				break
+			}
+		}
+	}
+}
+
 func TableScan[Row any](txh Txish, opt ScanOptions) Cursor[Row] {
+	return must(TryTableScan[Row](txh, opt))
+}
+
+// TryTableScan is like TableScan, but returns ErrWrongKeyType and
+// ErrUnsupportedScan errors instead of panicking.
+func TryTableScan[Row any](txh Txish, opt ScanOptions) (Cursor[Row], error) {
 	tx := txh.DBTx()
 	tbl := tableOf[Row](tx)
-	return Cursor[Row]{tx.TableScan(tbl, opt)}
+	c, err := tx.TryTableScan(tbl, opt)
+	if err != nil {
+		return Cursor[Row]{}, err
+	}
+	c.window.inheritContext(txh)
+	return Cursor[Row]{c}, nil
 }
 
 func (tx *Tx) TableScan(tbl *Table, opt ScanOptions) *RawTableCursor {
 	return tx.newTableCursor(tbl, opt)
 }
 
+func (tx *Tx) TryTableScan(tbl *Table, opt ScanOptions) (*RawTableCursor, error) {
+	return tx.tryNewTableCursor(tbl, opt)
+}
+
 func FullTableScan[Row any](txh Txish) Cursor[Row] {
 	return TableScan[Row](txh, FullScan())
 }
@@ -75,16 +133,36 @@ func ExactTableScan[Row any](txh Txish, value any) Cursor[Row] {
 	return TableScan[Row](txh, RangeScan(value, value, true, true))
 }
 
+// PrefixTableScan scans the rows whose composite keys share the first els
+// components with keyValue, which must be of the table's key type.
+func PrefixTableScan[Row any](txh Txish, els int, keyValue any) Cursor[Row] {
+	return TableScan[Row](txh, ExactScan(keyValue).Prefix(els))
+}
+func ReversePrefixTableScan[Row any](txh Txish, els int, keyValue any) Cursor[Row] {
+	return TableScan[Row](txh, ExactScan(keyValue).Prefix(els).Reversed())
+}
+
 func IndexScan[Row any](txh Txish, idx *Index, opt ScanOptions) Cursor[Row] {
+	return must(TryIndexScan[Row](txh, idx, opt))
+}
+
+// TryIndexScan is like IndexScan, but returns ErrIndexNotOnTable,
+// ErrWrongKeyType and ErrUnsupportedScan errors instead of panicking.
+func TryIndexScan[Row any](txh Txish, idx *Index, opt ScanOptions) (Cursor[Row], error) {
 	tx := txh.DBTx()
 	tbl := tableOf[Row](tx)
 	if tbl != idx.table {
 		if idx.table == nil {
-			panic(fmt.Errorf("index %v has not been added to table %v", idx.ShortName(), tbl.Name()))
+			return Cursor[Row]{}, fmt.Errorf("index %v has not been added to table %v: %w", idx.ShortName(), tbl.Name(), ErrIndexNotOnTable)
 		}
-		panic(fmt.Errorf("row refers to table %v, but index is on table %v", tbl.Name(), idx.table.Name()))
+		return Cursor[Row]{}, fmt.Errorf("row refers to table %v, but index is on table %v: %w", tbl.Name(), idx.table.Name(), ErrIndexNotOnTable)
+	}
+	c, err := tx.TryIndexScan(idx, opt)
+	if err != nil {
+		return Cursor[Row]{}, err
 	}
-	return Cursor[Row]{tx.IndexScan(idx, opt)}
+	c.window.inheritContext(txh)
+	return Cursor[Row]{c}, nil
 }
 
 func FullIndexScan[Row any](txh Txish, idx *Index) Cursor[Row] {
@@ -112,10 +190,26 @@ func ReversePrefixIndexScan[Row any](txh Txish, idx *Index, els int, indexValue
 	return IndexScan[Row](txh, idx, ExactScan(indexValue).Prefix(els).Reversed())
 }
 
+// RangeWithinPrefixIndexScan scans the rows whose index keys share the first
+// els components with prefixValue, bounded by lowerValue and upperValue
+// on the remaining components. Non-nil bounds must have the same leading
+// components as prefixValue. For example, with an (entityID, timestamp)
+// index, this returns the events of a single entity within a time range.
+func RangeWithinPrefixIndexScan[Row any](txh Txish, idx *Index, els int, prefixValue, lowerValue, upperValue any, lowerInc, upperInc bool) Cursor[Row] {
+	return IndexScan[Row](txh, idx, RangeWithinPrefixScan(els, prefixValue, lowerValue, upperValue, lowerInc, upperInc))
+}
+func ReverseRangeWithinPrefixIndexScan[Row any](txh Txish, idx *Index, els int, prefixValue, lowerValue, upperValue any, lowerInc, upperInc bool) Cursor[Row] {
+	return IndexScan[Row](txh, idx, RangeWithinPrefixScan(els, prefixValue, lowerValue, upperValue, lowerInc, upperInc).Reversed())
+}
+
 func (tx *Tx) IndexScan(idx *Index, opt ScanOptions) *RawIndexCursor {
 	return tx.newIndexCursor(idx, opt)
 }
 
+func (tx *Tx) TryIndexScan(idx *Index, opt ScanOptions) (*RawIndexCursor, error) {
+	return tx.tryNewIndexCursor(idx, opt)
+}
+
 func AllTableRows[Row any](txh Txish) []*Row {
 	return All(TableScan[Row](txh, FullScan()))
 }
@@ -180,6 +274,15 @@ func Select[Row any](c Cursor[Row], f func(*Row) bool) *Row {
 	return nil
 }
 
+// FindFirst scans idx between lower and upper (both inclusive, nil meaning
+// unbounded) and returns the first row satisfying pred, or nil.
+func FindFirst[Row any](txh Txish, idx *Index, lower, upper any, pred func(*Row) bool) *Row {
+	return Select(RangeIndexScan[Row](txh, idx, lower, upper, true, true), pred)
+}
+
+// Filter returns all rows of c for which f returns true. f is called for
+// every row in the cursor; use Select to stop at the first match, or a scan
+// limit to bound the work.
 func Filter[Row any](c Cursor[Row], f func(*Row) bool) []*Row {
 	var result []*Row
 	for c.Next() {
@@ -191,6 +294,17 @@ func Filter[Row any](c Cursor[Row], f func(*Row) bool) []*Row {
 	return result
 }
 
+// Skip advances c past the next n rows without decoding them, and returns
+// the number of rows skipped, which is less than n if the cursor runs out.
+// See Cursor.Skip.
+func Skip(c RawCursor, n int) int {
+	var skipped int
+	for skipped < n && c.Next() {
+		skipped++
+	}
+	return skipped
+}
+
 func Count(c RawCursor) int {
 	var count int
 	for c.Next() {
@@ -199,6 +313,171 @@ func Count(c RawCursor) int {
 	return count
 }
 
+// Number is a constraint satisfied by integer and floating-point types,
+// used by Sum.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Sum returns the sum of f over the remaining rows of c.
+func Sum[Row any, N Number](c Cursor[Row], f func(*Row) N) N {
+	var sum N
+	for c.Next() {
+		sum += f(c.Row())
+	}
+	return sum
+}
+
+// MinBy returns the remaining row of c with the smallest value of f (the
+// first one on ties), or nil if there are no rows.
+func MinBy[Row any, K cmp.Ordered](c Cursor[Row], f func(*Row) K) *Row {
+	return extremeBy(c, f, -1)
+}
+
+// MaxBy returns the remaining row of c with the largest value of f (the
+// first one on ties), or nil if there are no rows.
+func MaxBy[Row any, K cmp.Ordered](c Cursor[Row], f func(*Row) K) *Row {
+	return extremeBy(c, f, 1)
+}
+
+func extremeBy[Row any, K cmp.Ordered](c Cursor[Row], f func(*Row) K, sign int) *Row {
+	var best *Row
+	var bestK K
+	for c.Next() {
+		row := c.Row()
+		k := f(row)
+		if best == nil || cmp.Compare(k, bestK) == sign {
+			best, bestK = row, k
+		}
+	}
+	return best
+}
+
+// GroupCount returns the number of remaining rows of c for each value of key.
+// Unlike GroupScan, rows don't need to be ordered by key.
+func GroupCount[Row any, K comparable](c Cursor[Row], key func(*Row) K) map[K]int {
+	counts := make(map[K]int)
+	for c.Next() {
+		counts[key(c.Row())]++
+	}
+	return counts
+}
+
+// GroupScan scans idx in order and folds each contiguous run of rows with
+// the same keyOf value into an accumulator using agg, calling emit once per
+// group. Only one group is held in memory at a time, so keyOf must align
+// with the index sort order (e.g. be a prefix of the index key); otherwise
+// rows with equal keys end up in several groups.
+func GroupScan[Row any, K comparable, A any](txh Txish, idx *Index, opt ScanOptions, keyOf func(*Row) K, agg func(acc *A, row *Row), emit func(key K, acc A)) {
+	var key K
+	var acc A
+	var started bool
+	for c := IndexScan[Row](txh, idx, opt); c.Next(); {
+		row := c.Row()
+		k := keyOf(row)
+		if !started || k != key {
+			if started {
+				emit(key, acc)
+			}
+			key, started = k, true
+			var zero A
+			acc = zero
+		}
+		agg(&acc, row)
+	}
+	if started {
+		emit(key, acc)
+	}
+}
+
+// IndexGroups scans idx in order and yields each distinct index key
+// together with all rows sharing it, in a single pass. Each group is
+// collected into a slice; use IndexGroupsLazy if a single group can be
+// too large to hold in memory.
+func IndexGroups[Row any](txh Txish, idx *Index, opt ScanOptions) iter.Seq2[any, []*Row] {
+	return func(yield func(any, []*Row) bool) {
+		IndexGroupsLazy(txh, idx, opt, func(key any, rows iter.Seq[*Row]) bool {
+			return yield(key, slices.Collect(rows))
+		})
+	}
+}
+
+// IndexGroupsLazy is like IndexGroups, but calls f with an iterator over
+// the rows of each group, so that only one row is decoded at a time.
+// The iterator is only valid during the call to f; rows that f does not
+// consume are skipped. Stops when f returns false.
+func IndexGroupsLazy[Row any](txh Txish, idx *Index, opt ScanOptions, f func(key any, rows iter.Seq[*Row]) bool) {
+	c := IndexScan[Row](txh, idx, opt)
+	ic := c.RawCursor.(*RawIndexCursor)
+	ok := c.Next()
+	for ok {
+		groupTup := ic.itup
+		rows := func(yield func(*Row) bool) {
+			for ok && ic.itup.Equal(groupTup) {
+				if !yield(c.Row()) {
+					return
+				}
+				ok = c.Next()
+			}
+		}
+		if !f(ic.IndexKey(), rows) {
+			return
+		}
+		for ok && ic.itup.Equal(groupTup) {
+			ok = c.Next()
+		}
+	}
+}
+
+// MultiTableScan merges scans of several tables that share a key type into
+// a single stream ordered by key, as if they were partitions of one table.
+// Keys are compared in their encoded form, which sorts the same way as
+// table scans do. Rows with equal keys are yielded in the order of tables.
+// Stops early when yield returns false. Offset and limit apply to each
+// table individually.
+func MultiTableScan(txh Txish, tables []*Table, opt ScanOptions, yield func(tbl *Table, key any, row any) bool) {
+	tx := txh.DBTx()
+	cursors := make([]*RawTableCursor, len(tables))
+	live := make([]bool, len(tables))
+	for i, tbl := range tables {
+		if at, et := tbl.KeyType(), tables[0].KeyType(); at != et {
+			panic(fmt.Errorf("%s: cannot merge scan with %s: key type %v differs from %v: %w", tbl.Name(), tables[0].Name(), at, et, ErrWrongKeyType))
+		}
+		cursors[i] = tx.TableScan(tbl, opt)
+		live[i] = cursors[i].Next()
+	}
+	for {
+		best := -1
+		for i, c := range cursors {
+			if !live[i] {
+				continue
+			}
+			if best < 0 {
+				best = i
+				continue
+			}
+			cmp := bytes.Compare(c.RawKey(), cursors[best].RawKey())
+			if opt.Reverse {
+				cmp = -cmp
+			}
+			if cmp < 0 {
+				best = i
+			}
+		}
+		if best < 0 {
+			return
+		}
+		c := cursors[best]
+		row, _ := c.Row()
+		if !yield(tables[best], c.Key(), row) {
+			return
+		}
+		live[best] = c.Next()
+	}
+}
+
 type ScanMethod int
 
 const (
@@ -217,6 +496,96 @@ type ScanOptions struct {
 	UpperInc bool
 	Els      int
 	Extra    reflect.Value
+	Limit    int       // max number of rows to return, 0 means no limit
+	Offset   int       // number of leading rows to skip
+	Until    time.Time // scan deadline, zero means no deadline; see Deadline
+	After    []byte    // resume token to continue strictly after; see ResumeAfter
+}
+
+func (so ScanOptions) window(tx *Tx) scanWindow {
+	if so.Limit < 0 || so.Offset < 0 {
+		panic(fmt.Errorf("invalid scan limit %d / offset %d", so.Limit, so.Offset))
+	}
+	return scanWindow{offset: so.Offset, limit: so.Limit, deadline: so.Until, now: tx.db.now, ctx: tx.ctx}
+}
+
+// scanDeadlineCheckInterval is how many cursor steps are taken between
+// clock and context checks when a scan has a deadline or a context.
+const scanDeadlineCheckInterval = 64
+
+// scanWindow applies ScanOptions.Offset, ScanOptions.Limit and
+// ScanOptions.Until to a cursor.
+type scanWindow struct {
+	offset, limit, seen int
+	deadline            time.Time
+	now                 func() time.Time
+	ctx                 context.Context
+	steps               int
+	err                 error
+}
+
+func (w *scanWindow) next(next func() bool) bool {
+	if w.err != nil {
+		return false
+	}
+	if w.limit > 0 && w.seen >= w.offset+w.limit {
+		return false
+	}
+	for w.seen < w.offset {
+		if !w.step(next) {
+			return false
+		}
+		w.seen++
+	}
+	if !w.step(next) {
+		return false
+	}
+	w.seen++
+	return true
+}
+
+func (w *scanWindow) step(next func() bool) bool {
+	if !w.deadline.IsZero() || w.ctx != nil {
+		if w.steps%scanDeadlineCheckInterval == 0 {
+			if !w.deadline.IsZero() && !w.now().Before(w.deadline) {
+				w.err = context.DeadlineExceeded
+				return false
+			}
+			if w.ctx != nil {
+				if err := w.ctx.Err(); err != nil {
+					w.err = err
+					return false
+				}
+			}
+		}
+		w.steps++
+	}
+	return next()
+}
+
+// inheritContext makes the scan stop when the context of txh is canceled.
+func (w *scanWindow) inheritContext(txh Txish) {
+	if ctx := TxContext(txh); ctx != context.Background() {
+		w.ctx = ctx
+	}
+}
+
+func (w *scanWindow) reset() {
+	w.seen, w.steps, w.err = 0, 0, nil
+}
+
+// ResumeAfter makes the scan start strictly after the row or index entry
+// the token was obtained from via ResumeToken, in scan direction. The rest of
+// the options must match the scan that produced the token. The position
+// does not depend on the offset of the row, so rows inserted or deleted
+// before it don't cause the next page to skip or repeat rows. A nil token
+// starts from the beginning.
+//
+// Scans fail with ErrStaleBookmark if the token was made with a different
+// key format.
+func (so ScanOptions) ResumeAfter(token []byte) ScanOptions {
+	so.After = token
+	return so
 }
 
 func (so ScanOptions) Reversed() ScanOptions {
@@ -229,6 +598,14 @@ func (so ScanOptions) Prefix(els int) ScanOptions {
 	return so
 }
 
+// Deadline makes the cursor stop once t passes, with Err returning
+// context.DeadlineExceeded. The clock is only checked periodically, so
+// a few more rows may be returned after the deadline.
+func (so ScanOptions) Deadline(t time.Time) ScanOptions {
+	so.Until = t
+	return so
+}
+
 func (so ScanOptions) LogString() string {
 	var buf strings.Builder
 	if so.Reverse {
@@ -255,6 +632,10 @@ func (so ScanOptions) LogString() string {
 		} else {
 			buf.WriteByte(')')
 		}
+		if so.Extra.IsValid() {
+			buf.WriteString(":within=")
+			buf.WriteString(loggableVal(so.Extra))
+		}
 	default:
 		buf.WriteString("unknown")
 	}
@@ -262,9 +643,125 @@ func (so ScanOptions) LogString() string {
 		buf.WriteByte(':')
 		buf.WriteString(strconv.Itoa(so.Els))
 	}
+	if so.Offset != 0 {
+		buf.WriteString(":offset=")
+		buf.WriteString(strconv.Itoa(so.Offset))
+	}
+	if so.Limit != 0 {
+		buf.WriteString(":limit=")
+		buf.WriteString(strconv.Itoa(so.Limit))
+	}
+	if so.After != nil {
+		buf.WriteString(":after=")
+		buf.Write(so.After)
+	}
+	if !so.Until.IsZero() {
+		buf.WriteString(":until=")
+		buf.WriteString(so.Until.Format(time.RFC3339Nano))
+	}
 	return buf.String()
 }
 
+// ScanBuilder builds ScanOptions fluently, rejecting conflicting options
+// up front:
+//
+//	NewScan().Range(lo, hi).ExcUpper().Reverse().Limit(10).Offset(5).Options()
+type ScanBuilder struct {
+	so ScanOptions
+}
+
+func NewScan() ScanBuilder {
+	return ScanBuilder{FullScan()}
+}
+
+func (b ScanBuilder) Exact(v any) ScanBuilder {
+	b.requireMethodUnset("Exact")
+	b.so.Method, b.so.Lower = ScanMethodExact, reflect.ValueOf(v)
+	return b
+}
+
+// Range scans between lower and upper, both inclusive unless changed via
+// ExcLower and ExcUpper. A nil bound means the range is open on that side.
+func (b ScanBuilder) Range(lower, upper any) ScanBuilder {
+	b.requireMethodUnset("Range")
+	b.so = RangeScan(lower, upper, true, true)
+	return b
+}
+
+func (b ScanBuilder) IncLower() ScanBuilder {
+	b.requireMethod(ScanMethodRange, "IncLower")
+	b.so.LowerInc = true
+	return b
+}
+func (b ScanBuilder) ExcLower() ScanBuilder {
+	b.requireMethod(ScanMethodRange, "ExcLower")
+	b.so.LowerInc = false
+	return b
+}
+func (b ScanBuilder) IncUpper() ScanBuilder {
+	b.requireMethod(ScanMethodRange, "IncUpper")
+	b.so.UpperInc = true
+	return b
+}
+func (b ScanBuilder) ExcUpper() ScanBuilder {
+	b.requireMethod(ScanMethodRange, "ExcUpper")
+	b.so.UpperInc = false
+	return b
+}
+
+func (b ScanBuilder) Prefix(els int) ScanBuilder {
+	b.requireMethod(ScanMethodExact, "Prefix")
+	b.so.Els = els
+	return b
+}
+
+func (b ScanBuilder) Reverse() ScanBuilder {
+	b.so.Reverse = true
+	return b
+}
+
+func (b ScanBuilder) Limit(n int) ScanBuilder {
+	if n < 0 {
+		panic(fmt.Errorf("invalid scan limit %d", n))
+	}
+	b.so.Limit = n
+	return b
+}
+
+func (b ScanBuilder) Offset(n int) ScanBuilder {
+	if n < 0 {
+		panic(fmt.Errorf("invalid scan offset %d", n))
+	}
+	b.so.Offset = n
+	return b
+}
+
+func (b ScanBuilder) ResumeAfter(token []byte) ScanBuilder {
+	b.so.After = token
+	return b
+}
+
+func (b ScanBuilder) Deadline(t time.Time) ScanBuilder {
+	b.so.Until = t
+	return b
+}
+
+func (b ScanBuilder) Options() ScanOptions {
+	return b.so
+}
+
+func (b ScanBuilder) requireMethodUnset(op string) {
+	if b.so.Method != ScanMethodFull {
+		panic(fmt.Errorf("scan builder: %s conflicts with already configured %s", op, b.so.LogString()))
+	}
+}
+
+func (b ScanBuilder) requireMethod(m ScanMethod, op string) {
+	if b.so.Method != m {
+		panic(fmt.Errorf("scan builder: %s not applicable to %s", op, b.so.LogString()))
+	}
+}
+
 func FullScan() ScanOptions {
 	return ScanOptions{Method: ScanMethodFull}
 }
@@ -296,6 +793,16 @@ func RangeScanVal(lower, upper reflect.Value, lowerInc, upperInc bool) ScanOptio
 	return ScanOptions{Method: ScanMethodRange, Lower: lower, Upper: upper, LowerInc: lowerInc, UpperInc: upperInc}
 }
 
+// RangeWithinPrefixScan is like RangeScan, but additionally limits an index
+// scan to keys sharing the first els components with prefix. Only
+// supported for index scans.
+func RangeWithinPrefixScan(els int, prefix, lower, upper any, lowerInc, upperInc bool) ScanOptions {
+	so := RangeScan(lower, upper, lowerInc, upperInc)
+	so.Extra = reflect.ValueOf(prefix)
+	so.Els = els
+	return so
+}
+
 func ExactIDRangeScan(exact, lower, upper any, lowerInc, upperInc bool) ScanOptions {
 	var lowerVal, upperVal reflect.Value
 	if lower != nil {
@@ -322,6 +829,9 @@ type RawCursor interface {
 	Row() (any, ValueMeta)
 	TryRow() (any, ValueMeta, error)
 	RawRow() []byte
+	ResumeToken() []byte
+	Reset()
+	Err() error
 }
 
 type RawTableCursor struct {
@@ -335,6 +845,8 @@ type RawTableCursor struct {
 	upperInc bool
 	init     bool
 	reverse  bool
+	window   scanWindow
+	timing   int
 	k, v     []byte
 }
 
@@ -347,6 +859,25 @@ func (c *RawTableCursor) Table() *Table {
 }
 
 func (c *RawTableCursor) Next() bool {
+	if c.timing != 0 {
+		start := time.Now()
+		ok := c.window.next(c.next)
+		c.tx.addScanTime(c.timing, start, ok)
+		return ok
+	}
+	return c.window.next(c.next)
+}
+
+func (c *RawTableCursor) next() bool {
+	for c.nextEntry() {
+		if !c.tx.isHiddenExpired(c.table, c.k, c.v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *RawTableCursor) nextEntry() bool {
 	var k, v []byte
 	if c.init {
 		if c.reverse {
@@ -361,7 +892,21 @@ func (c *RawTableCursor) Next() bool {
 		c.init = true
 		if c.reverse {
 			if c.upper != nil {
-				panic("reverse range scan not supported yet")
+				// Seek finds the first key >= upper, step back unless it's a match
+				k, v = c.dcur.Seek(c.upper)
+				if k == nil {
+					k, v = c.dcur.Last()
+				} else if cmp := bytes.Compare(k, c.upper); cmp > 0 || (cmp == 0 && !c.upperInc) {
+					k, v = c.dcur.Prev()
+				}
+				if debugLogTableScans {
+					log.Printf("%s::TableScan: SEEK_REV to upper = %x: prefix = %x, reverse = %v => k = %x, v = %x", c.table.name, c.upper, c.prefix, c.reverse, k, v)
+				}
+			} else if len(c.prefix) > 0 {
+				k, v = boltSeekLast(c.dcur, c.prefix)
+				if debugLogTableScans {
+					log.Printf("%s::TableScan: SEEK_REV to prefix: prefix = %x, reverse = %v => k = %x, v = %x", c.table.name, c.prefix, c.reverse, k, v)
+				}
 			} else {
 				k, v = c.dcur.Last()
 				if debugLogTableScans {
@@ -375,6 +920,9 @@ func (c *RawTableCursor) Next() bool {
 			}
 			if lower != nil {
 				k, v = c.dcur.Seek(lower)
+				if c.lower != nil && !c.lowerInc && bytes.Equal(k, c.lower) {
+					k, v = c.dcur.Next()
+				}
 				if debugLogTableScans {
 					log.Printf("%s::TableScan: SEEK to lower = %x: prefix = %x, reverse = %v => k = %x, v = %x", c.table.name, lower, c.prefix, c.reverse, k, v)
 				}
@@ -428,6 +976,21 @@ func (c *RawTableCursor) Next() bool {
 	return true
 }
 
+// Err returns context.DeadlineExceeded if the scan was stopped by
+// ScanOptions.Deadline, the context error if it was stopped because
+// the transaction's context got canceled (see TxContext), or nil otherwise.
+func (c *RawTableCursor) Err() error {
+	return c.window.err
+}
+
+// Reset rewinds the cursor, so that the next call to Next returns the first
+// row of the range again (or the last one, if reversed).
+func (c *RawTableCursor) Reset() {
+	c.window.reset()
+	c.init = false
+	c.k, c.v = nil, nil
+}
+
 func (c *RawTableCursor) RawKey() []byte {
 	return c.k
 }
@@ -468,6 +1031,14 @@ func (c *RawTableCursor) TryRow() (any, ValueMeta, error) {
 }
 
 func (tx *Tx) newTableCursor(tbl *Table, opt ScanOptions) *RawTableCursor {
+	return must(tx.tryNewTableCursor(tbl, opt))
+}
+
+func (tx *Tx) tryNewTableCursor(tbl *Table, opt ScanOptions) (*RawTableCursor, error) {
+	var start time.Time
+	if tx.timeOps {
+		start = time.Now()
+	}
 	tableBuck := nonNil(tx.btx.Bucket(tbl.buck.Raw()))
 	buck := nonNil(tableBuck.Bucket(dataBucket.Raw()))
 	c := &RawTableCursor{
@@ -475,6 +1046,8 @@ func (tx *Tx) newTableCursor(tbl *Table, opt ScanOptions) *RawTableCursor {
 		table:   tbl,
 		dcur:    buck.Cursor(),
 		reverse: opt.Reverse,
+		window:  opt.window(tx),
+		timing:  tx.startScanTiming(start, tbl.name),
 	}
 	switch opt.Method {
 	case ScanMethodFull:
@@ -484,7 +1057,7 @@ func (tx *Tx) newTableCursor(tbl *Table, opt ScanOptions) *RawTableCursor {
 			panic(fmt.Errorf("Lower must be specified for ScanMethodExact"))
 		}
 		if at, et := opt.Lower.Type(), tbl.KeyType(); at != et {
-			panic(fmt.Errorf("%s: attempted to scan table using lower bound of incorrect type %v, expected %v", tbl.Name(), at, et))
+			return nil, fmt.Errorf("%s: attempted to scan table using lower bound of incorrect type %v, expected %v: %w", tbl.Name(), at, et, ErrWrongKeyType)
 		}
 
 		keyPrefix, _, isFull := encodeTableBoundaryKey(opt.Lower, tbl, opt.Els)
@@ -502,26 +1075,39 @@ func (tx *Tx) newTableCursor(tbl *Table, opt ScanOptions) *RawTableCursor {
 	case ScanMethodRange:
 		if opt.Lower.IsValid() {
 			if at, et := opt.Lower.Type(), tbl.KeyType(); at != et {
-				panic(fmt.Errorf("%s: attempted to scan table using lower bound of incorrect type %v, expected %v", tbl.Name(), at, et))
-			}
-			if !opt.LowerInc {
-				panic("LowerInc=false not supported")
+				return nil, fmt.Errorf("%s: attempted to scan table using lower bound of incorrect type %v, expected %v: %w", tbl.Name(), at, et, ErrWrongKeyType)
 			}
 			c.lower = tbl.EncodeKeyVal(opt.Lower)
 			c.lowerInc = opt.LowerInc
 		}
 		if opt.Upper.IsValid() {
 			if at, et := opt.Upper.Type(), tbl.KeyType(); at != et {
-				panic(fmt.Errorf("%s: attempted to scan table using upper bound of incorrect type %v, expected %v", tbl.Name(), at, et))
+				return nil, fmt.Errorf("%s: attempted to scan table using upper bound of incorrect type %v, expected %v: %w", tbl.Name(), at, et, ErrWrongKeyType)
 			}
 			c.upper = tbl.EncodeKeyVal(opt.Upper)
 			c.upperInc = opt.UpperInc
 		}
 
 	default:
-		panic(fmt.Errorf("unsupported scan method %v", opt.Method))
+		return nil, fmt.Errorf("scan method %v: %w", opt.Method, ErrUnsupportedScan)
 	}
-	return c
+	if opt.After != nil {
+		after, err := resumeKey(opt.After)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", tbl.Name(), err)
+		}
+		// resuming is just a tighter exclusive bound
+		if c.reverse {
+			if c.upper == nil || bytes.Compare(after, c.upper) <= 0 {
+				c.upper, c.upperInc = after, false
+			}
+		} else {
+			if c.lower == nil || bytes.Compare(after, c.lower) >= 0 {
+				c.lower, c.lowerInc = after, false
+			}
+		}
+	}
+	return c, nil
 }
 
 type RawIndexCursor struct {
@@ -534,6 +1120,19 @@ type RawIndexCursor struct {
 	prefix     []byte
 	resetDone  bool
 	reverse    bool
+	window     scanWindow
+	timing     int
+	ik, iv, dk []byte
+	itup       tuple
+
+	shards []indexShardCursor // for sharded indices, icur is unused
+	shard  int                // shard that produced the current row
+}
+
+// indexShardCursor holds the upcoming row of a single shard while scanning
+// a sharded index.
+type indexShardCursor struct {
+	icur       *bbolt.Cursor
 	ik, iv, dk []byte
 	itup       tuple
 }
@@ -547,11 +1146,89 @@ func (c *RawIndexCursor) Tx() *Tx {
 }
 
 func (c *RawIndexCursor) Next() bool {
+	if c.timing != 0 {
+		start := time.Now()
+		ok := c.window.next(c.next)
+		c.tx.addScanTime(c.timing, start, ok)
+		return ok
+	}
+	return c.window.next(c.next)
+}
+
+func (c *RawIndexCursor) next() bool {
+	for c.nextEntry() {
+		if !c.tx.isHiddenExpiredKey(c.table, c.dk) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *RawIndexCursor) nextEntry() bool {
+	if c.shards != nil {
+		return c.nextSharded()
+	}
 	c.ik, c.iv, c.itup, c.dk = c.strat.Next(c.icur, !c.resetDone, c.reverse, c.index)
 	c.resetDone = true
 	return (c.ik != nil)
 }
 
+// nextSharded merges the shards of a sharded index, yielding entries in
+// the same order as a scan of an unsharded index would.
+func (c *RawIndexCursor) nextSharded() bool {
+	if !c.resetDone {
+		for i := range c.shards {
+			s := &c.shards[i]
+			s.ik, s.iv, s.itup, s.dk = c.strat.Next(s.icur, true, c.reverse, c.index)
+		}
+		c.resetDone = true
+	} else if c.ik != nil {
+		s := &c.shards[c.shard]
+		s.ik, s.iv, s.itup, s.dk = c.strat.Next(s.icur, false, c.reverse, c.index)
+	}
+
+	best := -1
+	for i := range c.shards {
+		s := &c.shards[i]
+		if s.ik == nil {
+			continue
+		}
+		if best >= 0 {
+			cmp := bytes.Compare(s.ik, c.shards[best].ik)
+			if c.reverse {
+				cmp = -cmp
+			}
+			if cmp >= 0 {
+				continue
+			}
+		}
+		best = i
+	}
+	if best < 0 {
+		c.ik, c.iv, c.itup, c.dk = nil, nil, nil, nil
+		return false
+	}
+	s := &c.shards[best]
+	c.shard = best
+	c.ik, c.iv, c.itup, c.dk = s.ik, s.iv, s.itup, s.dk
+	return true
+}
+
+// Err returns context.DeadlineExceeded if the scan was stopped by
+// ScanOptions.Deadline, the context error if it was stopped because
+// the transaction's context got canceled (see TxContext), or nil otherwise.
+func (c *RawIndexCursor) Err() error {
+	return c.window.err
+}
+
+// Reset rewinds the cursor, so that the next call to Next returns the first
+// row of the range again (or the last one, if reversed).
+func (c *RawIndexCursor) Reset() {
+	c.window.reset()
+	c.resetDone = false
+	c.ik, c.iv, c.dk, c.itup = nil, nil, nil, nil
+}
+
 func (c *RawIndexCursor) RawKey() []byte {
 	return c.dk
 }
@@ -573,6 +1250,38 @@ func (c *RawIndexCursor) TryRowVal() (reflect.Value, ValueMeta, error) {
 	return decodeTableRow(c.table, c.dk, dv, c.tx)
 }
 
+// CoveredValue returns the bytes stored in the current index entry by
+// the index's Covering function, without reading the row itself. Returns nil
+// if the index has no Covering option.
+func (c *RawIndexCursor) CoveredValue() []byte {
+	if c.index.covering == nil {
+		return nil
+	}
+	tup, err := decodeTuple(c.iv)
+	if err != nil || len(tup) != c.index.valueTupleLen() {
+		panic(fmt.Errorf("%s: invalid index value tuple for key %x, value is %x: %v", c.index.FullName(), c.ik, c.iv, err))
+	}
+	return tup[len(tup)-1]
+}
+
+// Bookmark returns a bookmark positioned at the current entry, for use with
+// ScanBookmark.ResumeScan. It records the raw index key, which identifies
+// the entry even in non-unique indices.
+func (c *RawIndexCursor) Bookmark() ScanBookmark {
+	return ScanBookmark{
+		Key:       bytes.Clone(c.ik),
+		Reverse:   c.reverse,
+		KeyFormat: currentKeyFormat,
+	}
+}
+
+// ResumeToken returns an opaque token for ScanOptions.ResumeAfter that
+// continues the scan after the current entry. It is the text form of
+// Bookmark, so it can be put into a "next page" link as is.
+func (c *RawIndexCursor) ResumeToken() []byte {
+	return c.Bookmark().token()
+}
+
 func (c *RawIndexCursor) RawRow() []byte {
 	return c.dbuck.Get(c.dk)
 }
@@ -598,12 +1307,22 @@ func (c *RawIndexCursor) TryRow() (any, ValueMeta, error) {
 }
 
 func (tx *Tx) newIndexCursor(idx *Index, opt ScanOptions) *RawIndexCursor {
-	idx.requireTable()
+	return must(tx.tryNewIndexCursor(idx, opt))
+}
+
+func (tx *Tx) tryNewIndexCursor(idx *Index, opt ScanOptions) (*RawIndexCursor, error) {
+	var start time.Time
+	if tx.timeOps {
+		start = time.Now()
+	}
+	if err := idx.checkTable(); err != nil {
+		return nil, err
+	}
 	if tx.isVerboseLoggingEnabled() {
 		tx.db.logf("db: INDEX_SCAN %s/%v", idx.FullName(), opt.LogString())
 	}
 	tableBuck := nonNil(tx.btx.Bucket(idx.table.buck.Raw()))
-	ibuck := nonNil(tableBuck.Bucket(idx.buck.Raw()))
+	ibuck := idx.shardBucketIn(tableBuck, 0)
 	dbuck := nonNil(tableBuck.Bucket(dataBucket.Raw()))
 	var strat indexScanStrategy
 	switch opt.Method {
@@ -614,7 +1333,7 @@ func (tx *Tx) newIndexCursor(idx *Index, opt ScanOptions) *RawIndexCursor {
 			panic(fmt.Errorf("Lower must be specified for ScanMethodExact"))
 		}
 		if at, et := opt.Lower.Type(), idx.keyType(); at != et {
-			panic(fmt.Errorf("%s: attempted to scan index using lower bound of incorrect type %v, expected %v", idx.FullName(), at, et))
+			return nil, fmt.Errorf("%s: attempted to scan index using lower bound of incorrect type %v, expected %v: %w", idx.FullName(), at, et, ErrWrongKeyType)
 		}
 
 		keyPrefix, keyEls, isFull := encodeIndexBoundaryKey(opt.Lower, idx, opt.Els, false)
@@ -626,7 +1345,18 @@ func (tx *Tx) newIndexCursor(idx *Index, opt ScanOptions) *RawIndexCursor {
 			strat = &prefixIndexScanStrategy{keyPrefix, keyEls}
 		}
 	case ScanMethodRange:
-		if !opt.Lower.IsValid() && !opt.Upper.IsValid() {
+		var prefix []byte
+		boundEls := opt.Els
+		if opt.Extra.IsValid() {
+			if at, et := opt.Extra.Type(), idx.keyType(); at != et {
+				return nil, fmt.Errorf("%s: attempted to scan index using prefix of incorrect type %v, expected %v: %w", idx.FullName(), at, et, ErrWrongKeyType)
+			}
+			prefix, _, _ = encodeIndexBoundaryKey(opt.Extra, idx, opt.Els, true)
+			tx.addIndexKeyBuf(prefix)
+			boundEls = 0
+		}
+
+		if prefix == nil && !opt.Lower.IsValid() && !opt.Upper.IsValid() {
 			strat = fullIndexScanStrategy{}
 		} else {
 			var lower, upper []byte
@@ -634,19 +1364,25 @@ func (tx *Tx) newIndexCursor(idx *Index, opt ScanOptions) *RawIndexCursor {
 
 			if opt.Lower.IsValid() {
 				if at, et := opt.Lower.Type(), idx.keyType(); at != et {
-					panic(fmt.Errorf("%s: attempted to scan index using lower bound of incorrect type %v, expected %v", idx.FullName(), at, et))
+					return nil, fmt.Errorf("%s: attempted to scan index using lower bound of incorrect type %v, expected %v: %w", idx.FullName(), at, et, ErrWrongKeyType)
 				}
 
-				lower, els, _ = encodeIndexBoundaryKey(opt.Lower, idx, opt.Els, true)
+				lower, els, _ = encodeIndexBoundaryKey(opt.Lower, idx, boundEls, true)
 				tx.addIndexKeyBuf(lower)
+				if prefix != nil && !bytes.HasPrefix(lower, prefix) {
+					panic(fmt.Errorf("%s: lower bound %v does not match scan prefix %v", idx.FullName(), loggableVal(opt.Lower), loggableVal(opt.Extra)))
+				}
 			}
 			if opt.Upper.IsValid() {
 				if at, et := opt.Upper.Type(), idx.keyType(); at != et {
-					panic(fmt.Errorf("%s: attempted to scan index using lower bound of incorrect type %v, expected %v", idx.FullName(), at, et))
+					return nil, fmt.Errorf("%s: attempted to scan index using lower bound of incorrect type %v, expected %v: %w", idx.FullName(), at, et, ErrWrongKeyType)
 				}
 
 				var upperEls int
-				upper, upperEls, _ = encodeIndexBoundaryKey(opt.Upper, idx, opt.Els, true)
+				upper, upperEls, _ = encodeIndexBoundaryKey(opt.Upper, idx, boundEls, true)
+				if prefix != nil && !bytes.HasPrefix(upper, prefix) {
+					panic(fmt.Errorf("%s: upper bound %v does not match scan prefix %v", idx.FullName(), loggableVal(opt.Upper), loggableVal(opt.Extra)))
+				}
 				if !opt.Lower.IsValid() {
 					els = upperEls
 				} else if els != upperEls {
@@ -655,12 +1391,12 @@ func (tx *Tx) newIndexCursor(idx *Index, opt ScanOptions) *RawIndexCursor {
 				tx.addIndexKeyBuf(upper)
 			}
 
-			strat = &rangeIndexScanStrategy{els, lower, upper, opt.LowerInc, opt.UpperInc, idx.debugScans}
+			strat = &rangeIndexScanStrategy{els, prefix, lower, upper, opt.LowerInc, opt.UpperInc, idx.debugScans}
 		}
 
 	case ScanMethodExactIndexWithIDRange:
 		if idx.isUnique {
-			panic("exact-index-id-range method not supported for deprecated unique indices")
+			return nil, fmt.Errorf("%s: exact-index-id-range method on deprecated unique index: %w", idx.FullName(), ErrUnsupportedScan)
 		}
 		tbl := idx.Table()
 
@@ -669,14 +1405,14 @@ func (tx *Tx) newIndexCursor(idx *Index, opt ScanOptions) *RawIndexCursor {
 
 		if opt.Lower.IsValid() {
 			if at, et := opt.Lower.Type(), tbl.KeyType(); at != et {
-				panic(fmt.Errorf("%s: attempted to scan table using lower bound of incorrect type %v, expected %v", tbl.Name(), at, et))
+				return nil, fmt.Errorf("%s: attempted to scan table using lower bound of incorrect type %v, expected %v: %w", tbl.Name(), at, et, ErrWrongKeyType)
 			}
 			rang.Lower = encodeIndexFullKey(opt.Extra, opt.Lower, idx)
 			rang.LowerInc = opt.LowerInc
 		}
 		if opt.Upper.IsValid() {
 			if at, et := opt.Upper.Type(), tbl.KeyType(); at != et {
-				panic(fmt.Errorf("%s: attempted to scan table using upper bound of incorrect type %v, expected %v", tbl.Name(), at, et))
+				return nil, fmt.Errorf("%s: attempted to scan table using upper bound of incorrect type %v, expected %v: %w", tbl.Name(), at, et, ErrWrongKeyType)
 			}
 			rang.Upper = encodeIndexFullKey(opt.Extra, opt.Upper, idx)
 			rang.UpperInc = opt.UpperInc
@@ -685,17 +1421,36 @@ func (tx *Tx) newIndexCursor(idx *Index, opt ScanOptions) *RawIndexCursor {
 		strat = &rawRangeIndexScanStrategy{rang, tx.logger}
 
 	default:
-		panic(fmt.Errorf("unsupported scan method %v", opt.Method))
+		return nil, fmt.Errorf("scan method %v: %w", opt.Method, ErrUnsupportedScan)
+	}
+	if opt.After != nil {
+		after, err := resumeKey(opt.After)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", idx.FullName(), err)
+		}
+		if after != nil {
+			strat = &resumeIndexScanStrategy{strat, after}
+		}
 	}
-	return &RawIndexCursor{
+	c := &RawIndexCursor{
 		table:   idx.table,
 		index:   idx,
 		tx:      tx,
 		icur:    ibuck.Cursor(),
 		dbuck:   dbuck,
 		reverse: opt.Reverse,
+		window:  opt.window(tx),
+		timing:  tx.startScanTiming(start, idx.FullName()),
 		strat:   strat,
 	}
+	if n := idx.ShardCount(); n > 1 {
+		c.shards = make([]indexShardCursor, n)
+		c.shards[0].icur = c.icur
+		for shard := 1; shard < n; shard++ {
+			c.shards[shard].icur = idx.shardBucketIn(tableBuck, shard).Cursor()
+		}
+	}
+	return c, nil
 }
 
 func encodeTableBoundaryKey(keyVal reflect.Value, tbl *Table, cutoffEls int) ([]byte, int, bool) {
@@ -759,6 +1514,38 @@ type indexScanStrategy interface {
 	Next(c *bbolt.Cursor, reset, reverse bool, idx *Index) ([]byte, []byte, tuple, []byte)
 }
 
+// resumeIndexScanStrategy positions the cursor right after a resume token,
+// then lets the wrapped strategy advance from there, so that its checks
+// apply to every returned entry.
+type resumeIndexScanStrategy struct {
+	inner indexScanStrategy
+	after []byte
+}
+
+func (s *resumeIndexScanStrategy) Next(c *bbolt.Cursor, reset, reverse bool, idx *Index) ([]byte, []byte, tuple, []byte) {
+	if !reset {
+		return s.inner.Next(c, false, reverse, idx)
+	}
+	// Place the cursor on the last key at or before the token (in scan
+	// direction), so that advancing yields the first key after it.
+	k, _ := c.Seek(s.after)
+	if reverse {
+		if k == nil {
+			return s.inner.Next(c, true, reverse, idx) // all keys come before the token
+		}
+	} else {
+		if k == nil {
+			return nil, nil, nil, nil // all keys come before the token
+		}
+		if !bytes.Equal(k, s.after) {
+			if k, _ = c.Prev(); k == nil {
+				return s.inner.Next(c, true, reverse, idx) // all keys come after the token
+			}
+		}
+	}
+	return s.inner.Next(c, false, reverse, idx)
+}
+
 type fullIndexScanStrategy struct{}
 
 func (_ fullIndexScanStrategy) Next(c *bbolt.Cursor, reset, reverse bool, idx *Index) ([]byte, []byte, tuple, []byte) {
@@ -846,6 +1633,7 @@ func (s *prefixIndexScanStrategy) Next(c *bbolt.Cursor, reset, reverse bool, idx
 
 type rangeIndexScanStrategy struct {
 	els      int
+	prefix   []byte // if non-nil, only keys with this prefix are returned
 	lower    []byte
 	upper    []byte
 	lowerInc bool
@@ -858,7 +1646,12 @@ func (s *rangeIndexScanStrategy) Next(c *bbolt.Cursor, reset, reverse bool, idx
 	var skippingInitial bool
 	if reset {
 		if reverse {
-			if s.upper == nil {
+			if s.upper == nil && s.prefix != nil {
+				if s.verbose {
+					log.Printf("range index scan step: SEEK_REV: prefix = %x", s.prefix)
+				}
+				ik, iv = boltSeekLast(c, s.prefix)
+			} else if s.upper == nil {
 				if s.verbose {
 					log.Printf("range index scan step: SEEK_LAST")
 				}
@@ -873,7 +1666,12 @@ func (s *rangeIndexScanStrategy) Next(c *bbolt.Cursor, reset, reverse bool, idx
 				}
 			}
 		} else {
-			if s.lower == nil {
+			if s.lower == nil && s.prefix != nil {
+				if s.verbose {
+					log.Printf("range index scan step: SEEK_FWD: prefix = %x", s.prefix)
+				}
+				ik, iv = c.Seek(s.prefix)
+			} else if s.lower == nil {
 				if s.verbose {
 					log.Printf("range index scan step: SEEK_FIRST")
 				}
@@ -897,6 +1695,12 @@ func (s *rangeIndexScanStrategy) Next(c *bbolt.Cursor, reset, reverse bool, idx
 
 	lower, upper := s.lower, s.upper
 	for ik != nil {
+		if s.prefix != nil && !bytes.HasPrefix(ik, s.prefix) {
+			if s.verbose {
+				log.Printf("range index scan step: BAIL: outside prefix: ik = %x, prefix = %x", ik, s.prefix)
+			}
+			return nil, nil, nil, nil
+		}
 		ikTup := decodeIndexKey(ik, idx)
 		if len(ikTup) < s.els {
 			panic(fmt.Errorf("%s: invalid index key %x: got %d els, wanted at least %d", idx.FullName(), ik, len(ikTup), s.els+1))
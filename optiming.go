@@ -0,0 +1,51 @@
+package edb
+
+import (
+	"time"
+)
+
+// OpTiming is the wall-clock duration of a single operation performed
+// within a transaction, recorded after Tx.TimeOps is called.
+type OpTiming struct {
+	Op       string // get, lookup, put, delete, scan
+	Target   string // table or index name
+	Duration time.Duration
+	Rows     int // number of rows returned by a scan
+}
+
+// TimeOps enables recording of per-operation timings in this transaction,
+// retrievable via OpTimings. Scans are accounted for as they are iterated.
+func (tx *Tx) TimeOps() {
+	tx.timeOps = true
+}
+
+// OpTimings returns the operations timed since TimeOps was called.
+func (tx *Tx) OpTimings() []OpTiming {
+	return tx.opTimings
+}
+
+func (tx *Tx) recordOp(start time.Time, op, target string) {
+	tx.opTimings = append(tx.opTimings, OpTiming{
+		Op:       op,
+		Target:   target,
+		Duration: time.Since(start),
+	})
+}
+
+// startScanTiming records a scan, returning its position plus one to be
+// passed to addScanTime as the cursor advances, or 0 if timing is disabled.
+func (tx *Tx) startScanTiming(start time.Time, target string) int {
+	if !tx.timeOps {
+		return 0
+	}
+	tx.recordOp(start, "scan", target)
+	return len(tx.opTimings)
+}
+
+func (tx *Tx) addScanTime(slot int, start time.Time, found bool) {
+	t := &tx.opTimings[slot-1]
+	t.Duration += time.Since(start)
+	if found {
+		t.Rows++
+	}
+}
@@ -1,6 +1,9 @@
 package edb
 
-import "sync"
+import (
+	"compress/gzip"
+	"sync"
+)
 
 var indexRowsPool = &sync.Pool{
 	New: func() any {
@@ -36,4 +39,10 @@ var indexValueBytesPool = &sync.Pool{
 	},
 }
 
+var gzipWriterPool = &sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(nil)
+	},
+}
+
 var emptyIndexValue = []byte{}
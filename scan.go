@@ -68,7 +68,7 @@ func (r *RawRange) start(bcur *bbolt.Cursor, logger *slog.Logger) ([]byte, []byt
 			upper = r.Prefix
 		}
 		if upper != nil {
-			k, v = boltSeekLast2(bcur, upper)
+			k, v = boltSeekLast(bcur, upper)
 			if debugLogRawScans {
 				logger.LogAttrs(context.Background(), slog.LevelDebug, "SEEK to upper", hexAttr("upper", upper), hexAttr("key", k), hexAttr("val", v))
 			}
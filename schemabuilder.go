@@ -1,8 +1,11 @@
 package edb
 
 import (
+	"cmp"
 	"fmt"
 	"reflect"
+	"slices"
+	"time"
 )
 
 type TableBuilder[Row, Key any] struct {
@@ -37,6 +40,7 @@ func DefineTable[Row, Key any](scm *Schema, name string, f func(b *TableBuilder[
 		tbl: tbl,
 	}
 	f(&b)
+	tbl.prepareFieldRenames()
 	return tbl
 }
 
@@ -64,6 +68,89 @@ func (b *TableBuilder[Row, Key]) SetSchemaVersion(ver uint64) {
 	b.tbl.latestSchemaVer = ver
 }
 
+// RenameField makes rows stored with a schema version below sinceVer decode
+// their oldTag field into the field now tagged newTag, so that renaming
+// a field does not lose its data. Rows get the new tag once rewritten at
+// the latest schema version, which must be at least sinceVer. Only top-level
+// fields can be renamed.
+func (b *TableBuilder[Row, Key]) RenameField(oldTag, newTag string, sinceVer uint64) {
+	if oldTag == "" || newTag == "" || oldTag == newTag {
+		panic(fmt.Sprintf("DefineTable(%s): invalid field rename %q => %q", b.tbl.name, oldTag, newTag))
+	}
+	b.tbl.fieldRenames = append(b.tbl.fieldRenames, fieldRename{oldTag, newTag, sinceVer})
+	slices.SortStableFunc(b.tbl.fieldRenames, func(a, b fieldRename) int {
+		return cmp.Compare(a.sinceVer, b.sinceVer)
+	})
+}
+
+// NotifyChanges makes every transaction report changes to this table with
+// the given flags (ChangeFlagNotify is implied), in addition to any flags
+// passed to Tx.OnChange. Changes go to Options.OnChange as well as to
+// the handlers added by the transaction.
+func (b *TableBuilder[Row, Key]) NotifyChanges(flags ChangeFlags) {
+	b.tbl.changeFlags |= flags | ChangeFlagNotify
+}
+
+// AutoKey allows adding rows via Insert, which assigns keys from a per-table
+// sequence. The key must be an integer type.
+func (b *TableBuilder[Row, Key]) AutoKey() {
+	switch b.tbl.keyType.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8,
+		reflect.Uint, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8:
+		b.tbl.autoKey = true
+	default:
+		panic(fmt.Sprintf("DefineTable(%s): AutoKey requires an integer key, got %v", b.tbl.name, b.tbl.keyType))
+	}
+}
+
+// CompressValues makes the table store row data gzipped whenever that makes
+// it smaller. Rows written before are decoded as usual and get compressed
+// when next written.
+func (b *TableBuilder[Row, Key]) CompressValues() {
+	b.tbl.compressValues = true
+}
+
+// ValueCodec sets the encoding of row data, MsgPack by default. JSON makes
+// values human-readable, which can be handy for small config tables. The
+// codec is recorded in each value, so rows written with a different codec
+// are still decoded, and get converted when next written.
+func (b *TableBuilder[Row, Key]) ValueCodec(enc encodingMethod) {
+	switch enc {
+	case MsgPack, JSON:
+		b.tbl.valueEnc = enc
+	default:
+		panic(fmt.Sprintf("DefineTable(%s): unsupported value codec %d", b.tbl.name, enc))
+	}
+}
+
+// ExpireAfter makes the table store an expiry time for each row, as
+// returned by f when the row is written; a zero time means the row never
+// expires. Expired rows stay in the table until removed by PurgeExpired,
+// and are still returned by reads unless HideExpired is also set.
+func (b *TableBuilder[Row, Key]) ExpireAfter(f func(row *Row) time.Time) {
+	b.tbl.expireAfter = func(row any) time.Time {
+		return f(row.(*Row))
+	}
+}
+
+// HideExpired makes gets and scans treat expired rows as missing, as per
+// Options.Now. Rows are only checked against their stored expiry time, so
+// this has no effect without ExpireAfter.
+func (b *TableBuilder[Row, Key]) HideExpired() {
+	b.tbl.hideExpired = true
+}
+
+// KeyStringSeparator sets the separator between the components of
+// composite keys in KeyString and ParseKey, "|" by default. Pick one that
+// cannot occur in string components: ParseKey fails on keys whose
+// components contain the separator.
+func (b *TableBuilder[Row, Key]) KeyStringSeparator(sep string) {
+	if sep == "" {
+		panic(fmt.Sprintf("DefineTable(%s): empty key string separator", b.tbl.name))
+	}
+	b.tbl.keyStringSep = sep
+}
+
 func (b *TableBuilder[Row, Key]) SuppressContentWhenLogging() {
 	b.tbl.suppressContent = true
 }
@@ -0,0 +1,121 @@
+package edb
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SchemaDescriptor is a serializable description of a schema, suitable for
+// generating documentation or for diffing across versions to catch
+// accidental schema changes.
+type SchemaDescriptor struct {
+	Name     string              `json:"name,omitempty"`
+	Tables   []TableDescriptor   `json:"tables"`
+	KVTables []KVTableDescriptor `json:"kv_tables,omitempty"`
+	Maps     []string            `json:"maps,omitempty"`
+}
+
+type TableDescriptor struct {
+	Name               string                  `json:"name"`
+	RowType            string                  `json:"row_type"`
+	KeyType            string                  `json:"key_type"`
+	KeyStringSeparator string                  `json:"key_string_separator"`
+	SchemaVersion      uint64                  `json:"schema_version"`
+	ValueCodec         string                  `json:"value_codec"`
+	CompressValues     bool                    `json:"compress_values,omitempty"`
+	AutoKey            bool                    `json:"auto_key,omitempty"`
+	Expires            bool                    `json:"expires,omitempty"` // has ExpireAfter
+	HideExpired        bool                    `json:"hide_expired,omitempty"`
+	FieldRenames       []FieldRenameDescriptor `json:"field_renames,omitempty"`
+	Indices            []IndexDescriptor       `json:"indices,omitempty"`
+}
+
+type FieldRenameDescriptor struct {
+	OldName      string `json:"old_name"`
+	NewName      string `json:"new_name"`
+	SinceVersion uint64 `json:"since_version"`
+}
+
+type IndexDescriptor struct {
+	Name     string `json:"name"`
+	KeyType  string `json:"key_type"`
+	Unique   bool   `json:"unique,omitempty"`
+	Covering bool   `json:"covering,omitempty"`
+	Shards   int    `json:"shards,omitempty"`
+}
+
+type KVTableDescriptor struct {
+	Name    string              `json:"name"`
+	KeyType string              `json:"key_type,omitempty"`
+	Raw     bool                `json:"raw,omitempty"`
+	Indices []KVIndexDescriptor `json:"indices,omitempty"`
+}
+
+type KVIndexDescriptor struct {
+	Name    string `json:"name"`
+	KeyType string `json:"key_type,omitempty"`
+}
+
+func (scm *Schema) Describe() SchemaDescriptor {
+	desc := SchemaDescriptor{
+		Name:   scm.Name,
+		Tables: make([]TableDescriptor, 0, len(scm.tables)),
+	}
+	for _, tbl := range scm.tables {
+		td := TableDescriptor{
+			Name:               tbl.name,
+			RowType:            tbl.rowType.String(),
+			KeyType:            tbl.keyType.String(),
+			KeyStringSeparator: tbl.keyStringSep,
+			SchemaVersion:      tbl.latestSchemaVer,
+			ValueCodec:         "msgpack",
+			CompressValues:     tbl.compressValues,
+			AutoKey:            tbl.autoKey,
+			Expires:            tbl.expireAfter != nil,
+			HideExpired:        tbl.hideExpired,
+		}
+		if tbl.valueEnc == JSON {
+			td.ValueCodec = "json"
+		}
+		for _, r := range tbl.fieldRenames {
+			td.FieldRenames = append(td.FieldRenames, FieldRenameDescriptor{r.oldName, r.newName, r.sinceVer})
+		}
+		for _, idx := range tbl.indices {
+			td.Indices = append(td.Indices, IndexDescriptor{
+				Name:     idx.name,
+				KeyType:  idx.recType.String(),
+				Unique:   idx.isUnique,
+				Covering: idx.covering != nil,
+				Shards:   len(idx.shardBucks),
+			})
+		}
+		desc.Tables = append(desc.Tables, td)
+	}
+	for _, tbl := range scm.kvtables {
+		td := KVTableDescriptor{
+			Name: tbl.name,
+			Raw:  tbl.isRaw,
+		}
+		if tbl.keySample != nil {
+			td.KeyType = fmt.Sprintf("%T", tbl.keySample)
+		}
+		for _, idx := range tbl.indices {
+			id := KVIndexDescriptor{Name: idx.name}
+			if idx.keySample != nil {
+				id.KeyType = fmt.Sprintf("%T", idx.keySample)
+			}
+			td.Indices = append(td.Indices, id)
+		}
+		desc.KVTables = append(desc.KVTables, td)
+	}
+	for _, mp := range scm.maps {
+		desc.Maps = append(desc.Maps, mp.buck.String())
+	}
+	return desc
+}
+
+// JSON returns an indented JSON representation of the descriptor, stable
+// across runs so that it can be committed and diffed.
+func (desc SchemaDescriptor) JSON() []byte {
+	return must(json.MarshalIndent(desc, "", "  "))
+}
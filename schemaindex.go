@@ -3,8 +3,10 @@ package edb
 import (
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 
+	"github.com/cespare/xxhash/v2"
 	"go.etcd.io/bbolt"
 )
 
@@ -16,16 +18,45 @@ type Index struct {
 	recType  reflect.Type
 	keyEnc   *flatEncoding
 	isUnique bool
+	covering Covering
 	filler   func(row any, ib *IndexBuilder)
 
 	skipInitialFill bool
 	debugScans      bool
+
+	shardBucks []bucketName // nil for unsharded indices, shardBucks[0] == buck
 }
 
 func makeIndexBucketName(name string) bucketName {
 	return makeBucketName("i_" + name)
 }
 
+func makeIndexShardBucketName(name string, shard int) bucketName {
+	if shard == 0 {
+		return makeIndexBucketName(name)
+	}
+	return makeIndexBucketName(name + "#" + strconv.Itoa(shard))
+}
+
+// IndexShards is an AddIndex option that spreads the entries of a non-unique
+// index across the given number of buckets by a hash of the table key.
+// This keeps buckets small for write-heavy indices with few distinct keys,
+// at the cost of scans and lookups having to merge all shards.
+//
+// Changing the number of shards rebuilds the index on the next Open.
+type IndexShards int
+
+// Covering is an AddIndex option that stores the bytes returned for each
+// row in the values of its index entries, so that RawIndexCursor.CoveredValue
+// can return them without reading the row from the data bucket. The function
+// is given the same row as the table's indexer and should only depend on
+// row data, since index entries are not rewritten by no-op puts.
+//
+// Adding or removing the option rebuilds the index on the next Open; changing
+// the function does not, so bump the index name or rebuild it if the format
+// of covered values changes.
+type Covering func(row any) []byte
+
 type IndexOpt int
 
 const (
@@ -54,6 +85,18 @@ func AddIndex[T any](name string, opts ...any) *Index {
 			default:
 				panic(fmt.Errorf("invalid option %T %v", opt, opt))
 			}
+		case Covering:
+			idx.covering = opt
+		case IndexShards:
+			if opt < 1 {
+				panic(fmt.Errorf("index %s: invalid number of shards %d", name, opt))
+			}
+			idx.shardBucks = nil
+			if opt > 1 {
+				for i := range int(opt) {
+					idx.shardBucks = append(idx.shardBucks, makeIndexShardBucketName(name, i))
+				}
+			}
 		default:
 			panic(fmt.Errorf("invalid option %T %v", opt, opt))
 		}
@@ -62,10 +105,28 @@ func AddIndex[T any](name string, opts ...any) *Index {
 	return idx
 }
 
+// valueTupleLen returns the number of elements in index entry values: the table
+// key for unique indices, plus the covered value for covering ones.
+func (idx *Index) valueTupleLen() int {
+	var n int
+	if idx.isUnique {
+		n++
+	}
+	if idx.covering != nil {
+		n++
+	}
+	return n
+}
+
 func (idx *Index) requireTable() {
+	ensure(idx.checkTable())
+}
+
+func (idx *Index) checkTable() error {
 	if idx.table == nil {
-		panic(fmt.Errorf("index %q was not added to a table", idx.name))
+		return fmt.Errorf("index %q was not added to a table: %w", idx.name, ErrIndexNotOnTable)
 	}
+	return nil
 }
 
 func (idx *Index) Table() *Table {
@@ -81,12 +142,40 @@ func (idx *Index) FullName() string {
 }
 
 func (idx *Index) Unique() *Index {
+	if idx.shardBucks != nil {
+		panic(fmt.Errorf("index %s: unique indices cannot be sharded", idx.name))
+	}
 	idx.isUnique = true
 	return idx
 }
 
-func (idx *Index) bucketIn(tableRootB *bbolt.Bucket) *bbolt.Bucket {
-	return nonNil(tableRootB.Bucket(idx.buck.Raw()))
+// ShardCount returns the number of buckets the index is split into,
+// 1 unless the index was defined with IndexShards.
+func (idx *Index) ShardCount() int {
+	if idx.shardBucks == nil {
+		return 1
+	}
+	return len(idx.shardBucks)
+}
+
+func (idx *Index) shardBucketName(shard int) bucketName {
+	if idx.shardBucks == nil {
+		return idx.buck
+	}
+	return idx.shardBucks[shard]
+}
+
+// shardFor returns the shard holding the entries of the row with the given
+// table key.
+func (idx *Index) shardFor(tableKeyRaw []byte) int {
+	if idx.shardBucks == nil {
+		return 0
+	}
+	return int(xxhash.Sum64(tableKeyRaw) % uint64(len(idx.shardBucks)))
+}
+
+func (idx *Index) shardBucketIn(tableRootB *bbolt.Bucket, shard int) *bbolt.Bucket {
+	return nonNil(tableRootB.Bucket(idx.shardBucketName(shard).Raw()))
 }
 
 func (idx *Index) keyTupleToString(indexKeyTup tuple) string {
@@ -110,6 +199,29 @@ func (idx *Index) DecodeIndexKeyValInto(keyVal reflect.Value, tup tuple) {
 	}
 }
 
+// DecodeIndexKeyBytes decodes a raw index key given only the index key type,
+// without a schema or a database. Keys of non-unique indices end with
+// the table key, which is dropped; pass unique to indicate that there is
+// no such trailing element.
+func DecodeIndexKeyBytes(keyType reflect.Type, raw []byte, unique bool) (any, error) {
+	tup, err := decodeTuple(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid index key tuple %x: %w", raw, err)
+	}
+	if !unique {
+		if len(tup) == 0 {
+			return nil, fmt.Errorf("invalid index key tuple %x: missing table key", raw)
+		}
+		_, tup = extractUniqueIndexKey(tup)
+	}
+	keyVal := reflect.New(keyType).Elem()
+	err = flatEncodingOf(keyType).decodeTup(tup, keyVal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %v index key %x: %w", keyType, raw, err)
+	}
+	return keyVal.Interface(), nil
+}
+
 func (idx *Index) parseRawKeyFrom(buf []byte, s string) ([]byte, error) {
 	return idx.keyEnc.stringsToRawKey(buf, strings.Split(s, idx.table.keyStringSep))
 }
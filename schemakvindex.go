@@ -1,10 +1,20 @@
 package edb
 
-import "github.com/andreyvit/edb/kvo"
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/andreyvit/edb/kvo"
+)
 
 type (
 	KVIndexer              = func(b *KVIndexContentBuilder, pk []byte, v kvo.ImmutableRecord)
 	KVIndexKeyToPrimaryKey = func(ik []byte) []byte
+
+	// KVIndexKeyEncoder appends the index key components for the given values
+	// to buf. Called with a prefix of the components when building prefix
+	// ranges, so each value must be encoded independently of the following ones.
+	KVIndexKeyEncoder = func(buf []byte, values []any) []byte
 )
 
 type KVIndex struct {
@@ -14,6 +24,7 @@ type KVIndex struct {
 	keySample            KVIndexKey
 	indexKeyToPrimaryKey KVIndexKeyToPrimaryKey
 	indexer              KVIndexer
+	keyEncoder           KVIndexKeyEncoder
 }
 
 type KVIndexKey interface {
@@ -34,23 +45,67 @@ func (idx *KVIndex) FullName() string {
 	return idx.table.name + "." + idx.name
 }
 
-func (idx *KVIndex) enumEntries(k, v []byte, f func(ik []byte)) {
+// WithKeyEncoder declares how typed values map onto the index key layout,
+// enabling PrefixRange and EncodeKey.
+func (idx *KVIndex) WithKeyEncoder(enc KVIndexKeyEncoder) *KVIndex {
+	idx.keyEncoder = enc
+	return idx
+}
+
+// PrefixRange returns a range covering all index entries whose keys start
+// with the encoding of the given values.
+func (idx *KVIndex) PrefixRange(values ...any) RawRange {
+	return RawPrefix(idx.EncodeKey(values...))
+}
+
+// EncodeKey encodes the given leading index key components, for use as
+// RawRange bounds. Note that a bound sorts before all longer keys it
+// prefixes, so an inclusive upper bound of (a) does not include (a, b).
+func (idx *KVIndex) EncodeKey(values ...any) []byte {
+	if idx.keyEncoder == nil {
+		panic(fmt.Errorf("%s: index key encoding requires a key encoder", idx.FullName()))
+	}
+	return idx.keyEncoder(nil, values)
+}
+
+func (idx *KVIndex) enumEntries(k, v []byte, f func(ik, iv []byte)) {
 	b := KVIndexContentBuilder{f}
 	idx.indexer(&b, k, kvo.LoadRecord(v, idx.table.RootType()))
 }
 
-func (idx *KVIndex) entries(k, v []byte) [][]byte {
-	var result [][]byte
-	idx.enumEntries(k, v, func(ik []byte) {
-		result = append(result, ik)
+func (idx *KVIndex) entries(k, v []byte) []kvIndexEntry {
+	var result []kvIndexEntry
+	idx.enumEntries(k, v, func(ik, iv []byte) {
+		result = append(result, kvIndexEntry{ik, iv})
 	})
 	return result
 }
 
+type kvIndexEntry struct {
+	key   []byte
+	value []byte
+}
+
+func findKVIndexEntry(list []kvIndexEntry, ik []byte) (kvIndexEntry, bool) {
+	for _, e := range list {
+		if bytes.Equal(e.key, ik) {
+			return e, true
+		}
+	}
+	return kvIndexEntry{}, false
+}
+
 type KVIndexContentBuilder struct {
-	f func(ik []byte)
+	f func(ik, iv []byte)
 }
 
 func (b *KVIndexContentBuilder) Add(ik []byte) {
-	b.f(ik)
+	b.f(ik, nil)
+}
+
+// AddWithValue adds an index entry that carries a small payload (for example,
+// a sort weight or a denormalized field), available via KVCursor.RawIndexValue
+// when scanning the index.
+func (b *KVIndexContentBuilder) AddWithValue(ik, iv []byte) {
+	b.f(ik, iv)
 }
@@ -1,6 +1,8 @@
 package edb
 
 import (
+	"bytes"
+	"fmt"
 	"log"
 	"reflect"
 	"time"
@@ -12,12 +14,30 @@ func (db *DB) tableState(tbl *Table) *tableState {
 	return db.tableStates[tbl.pos]
 }
 
+// PendingIndexes returns the indexes that have not been built yet, in schema
+// order. Open builds all indexes eagerly, so a non-empty result after Open
+// means that reindexing was skipped or a background build is still pending.
+func (db *DB) PendingIndexes() []*Index {
+	db.indexStatesLock.Lock()
+	defer db.indexStatesLock.Unlock()
+	var result []*Index
+	for _, ts := range db.tableStates {
+		for _, is := range ts.indexStates {
+			if !is.Built {
+				result = append(result, is.index)
+			}
+		}
+	}
+	return result
+}
+
 type tableState struct {
 	MinSchemaVer     uint64                 `msgpack:"s"`
 	LastIndexOrdinal uint64                 `msgpack:"li"`
 	Indices          map[string]*indexState `msgpack:"i"`
 	LastSeen         time.Time              `msgpack:"t"`
 	DeletionCounter  int                    `msgpack:"delcnt,omitempty"`
+	KeyFormat        int                    `msgpack:"kf,omitempty"`
 
 	table            *Table                 `msgpack:"-"`
 	indexStates      []*indexState          `msgpack:"-"`
@@ -36,6 +56,29 @@ func (ts *tableState) indexByOrdinal(ord uint64) *Index {
 	return is.index
 }
 
+func (ts *tableState) hasRebuiltIndices() bool {
+	for _, is := range ts.Indices {
+		if is.rebuild {
+			return true
+		}
+	}
+	return false
+}
+
+// setIndexBuilt marks an index as built by a reindexing transaction, which
+// can run concurrently with PendingIndexes and VerifyIndexes.
+func (db *DB) setIndexBuilt(is *indexState) {
+	db.indexStatesLock.Lock()
+	defer db.indexStatesLock.Unlock()
+	is.Built = true
+}
+
+func (db *DB) isIndexBuilt(is *indexState) bool {
+	db.indexStatesLock.Lock()
+	defer db.indexStatesLock.Unlock()
+	return is.Built
+}
+
 func (ts *tableState) hasPendingIndices() bool {
 	for _, is := range ts.Indices {
 		if !is.Built {
@@ -49,21 +92,34 @@ type indexState struct {
 	index        *Index `msgpack:"-"`
 	IndexOrdinal uint64 `msgpack:"o"`
 	Built        bool   `msgpack:"f"`
+	Shards       int    `msgpack:"sh,omitempty"` // 0 means unsharded
+	Unique       *bool  `msgpack:"u,omitempty"`  // nil if saved before uniqueness was tracked
+	Covering     bool   `msgpack:"cv,omitempty"`
+	rebuild      bool   `msgpack:"-"` // buckets were dropped, refill from scratch
+}
+
+func (is *indexState) shardCount() int {
+	return max(is.Shards, 1)
 }
 
 var tableStateKey = []byte("_state")
 
+// currentKeyFormat is the version of flat key encoding used for table and
+// index keys. Format 0 encoded signed integers without flipping the sign bit,
+// so negative values sorted after positive ones.
+const currentKeyFormat = 1
+
 const tableStateEncoding = MsgPack
 
 func prepareTable(tx *Tx, tbl *Table, now time.Time) *tableState {
 	tableRootB := must(tx.btx.CreateBucketIfNotExists(tbl.buck.Raw()))
 	_ = must(tableRootB.CreateBucketIfNotExists(dataBucket.Raw()))
-	for _, idx := range tbl.indices {
-		_ = must(tableRootB.CreateBucketIfNotExists(idx.buck.Raw()))
-	}
 
 	ts := new(tableState)
-	if rawTS := tableRootB.Get(tableStateKey); rawTS != nil {
+	rawTS := tableRootB.Get(tableStateKey)
+	if rawTS == nil {
+		ts.KeyFormat = currentKeyFormat
+	} else {
 		err := tableStateEncoding.DecodeValue(rawTS, reflect.ValueOf(ts))
 		if err != nil {
 			panic(tableErrf(tbl, nil, nil, err, "failed to decode table state"))
@@ -78,6 +134,12 @@ func prepareTable(tx *Tx, tbl *Table, now time.Time) *tableState {
 	ts.indexStates = make([]*indexState, len(tbl.indices))
 	ts.indexStatesByOrd = make(map[uint64]*indexState)
 
+	legacyKeys := ts.KeyFormat < currentKeyFormat
+	rekey := legacyKeys && tbl.keyEnc.hasSignedInts()
+	if rekey {
+		upgradeLegacyTableKeys(tbl, tableRootB)
+	}
+
 	for i, idx := range tbl.indices {
 		is := ts.Indices[idx.name]
 		if is == nil {
@@ -86,6 +148,40 @@ func prepareTable(tx *Tx, tbl *Table, now time.Time) *tableState {
 				IndexOrdinal: ts.LastIndexOrdinal,
 			}
 			ts.Indices[idx.name] = is
+		} else if is.shardCount() != idx.ShardCount() {
+			dropIndexBuckets(tableRootB, idx.name, is.shardCount())
+			is.Built, is.rebuild = false, true
+			log.Printf("resharding index %s.%s from %d to %d shards", tbl.Name(), idx.name, is.shardCount(), idx.ShardCount())
+		} else if legacyKeys && (rekey || idx.keyEnc.hasSignedInts()) {
+			dropIndexBuckets(tableRootB, idx.name, is.shardCount())
+			is.Built, is.rebuild = false, true
+			log.Printf("rebuilding index %s.%s to upgrade signed integer keys", tbl.Name(), idx.name)
+		} else if is.Unique != nil && *is.Unique != idx.isUnique {
+			// unique and non-unique indices use different key formats
+			if idx.isUnique {
+				if dups := findUniqueViolations(tx.btx, idx, is.IndexOrdinal, false); len(dups) > 0 {
+					log.Printf("WARNING: index %s.%s made unique, but %d keys are shared by multiple rows (first is %x); only one row per key will be indexed, use FindUniqueViolations to list them", tbl.Name(), idx.name, len(dups), dups[0])
+				}
+			}
+			dropIndexBuckets(tableRootB, idx.name, is.shardCount())
+			is.Built, is.rebuild = false, true
+			log.Printf("rebuilding index %s.%s after change of uniqueness (unique = %v)", tbl.Name(), idx.name, idx.isUnique)
+		} else if is.Covering != (idx.covering != nil) {
+			// entry values need to gain or lose covered data
+			dropIndexBuckets(tableRootB, idx.name, is.shardCount())
+			is.Built, is.rebuild = false, true
+			log.Printf("rebuilding index %s.%s after change of covering (covering = %v)", tbl.Name(), idx.name, idx.covering != nil)
+		}
+		unique := idx.isUnique
+		is.Unique = &unique
+		is.Covering = idx.covering != nil
+		if n := idx.ShardCount(); n > 1 {
+			is.Shards = n
+		} else {
+			is.Shards = 0
+		}
+		for shard := range idx.ShardCount() {
+			_ = must(tableRootB.CreateBucketIfNotExists(idx.shardBucketName(shard).Raw()))
 		}
 		is.index = idx
 		ts.indexStates[i] = is
@@ -93,25 +189,67 @@ func prepareTable(tx *Tx, tbl *Table, now time.Time) *tableState {
 	}
 	for k, is := range ts.Indices {
 		if is.index == nil {
-			dropDeletedIndex(tbl, tableRootB, k)
+			dropDeletedIndex(tbl, tableRootB, k, is.shardCount())
 			delete(ts.Indices, k)
 		}
 	}
+	ts.KeyFormat = currentKeyFormat
 	return ts
 }
 
+// upgradeLegacyTableKeys re-encodes signed integer components of all keys
+// in the data bucket to sort properly. The new keys may collide with old
+// ones, so the bucket is recreated from scratch.
+func upgradeLegacyTableKeys(tbl *Table, tableRootB *bbolt.Bucket) {
+	type entry struct {
+		k, v []byte
+	}
+	var entries []entry
+	dataB := nonNil(tableRootB.Bucket(dataBucket.Raw()))
+	c := dataB.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		nk, err := tbl.keyEnc.upgradeLegacyKey(k)
+		if err != nil {
+			panic(tableErrf(tbl, nil, k, err, "upgrading legacy key"))
+		}
+		entries = append(entries, entry{nk, bytes.Clone(v)})
+	}
+	if len(entries) == 0 {
+		return
+	}
+	ensure(tableRootB.DeleteBucket(dataBucket.Raw()))
+	dataB = must(tableRootB.CreateBucket(dataBucket.Raw()))
+	for _, e := range entries {
+		ensure(dataB.Put(e.k, e.v))
+	}
+	log.Printf("upgraded keys of %d rows in %s to sort signed integers properly", len(entries), tbl.Name())
+}
+
 func (ts *tableState) migrate(tx *Tx) {
 	tbl := ts.table
 	for _, is := range ts.Indices {
-		if !is.Built && is.index.skipInitialFill {
+		if !is.Built && is.index.skipInitialFill && !is.rebuild {
 			is.Built = true
 		}
 	}
 	if ts.hasPendingIndices() {
+		if ts.hasRebuiltIndices() {
+			// index key sets are unchanged, so force PutVal to write them
+			tx.reindexing = true
+			defer func() {
+				tx.reindexing = false
+			}()
+		}
 		// log.Printf("Re-indexing table %s...", tbl.Name())
 		start := time.Now()
 		var rows, failed int64
-		for c := tx.TableScan(tbl, FullScan()); c.Next(); {
+		ctx := tx.Context()
+		c := tx.TableScan(tbl, FullScan())
+		var err error
+		for c.Next() {
+			if err = ctx.Err(); err != nil {
+				break
+			}
 			ok := upgradeRow(c, tx, tbl)
 			rows++
 			if !ok {
@@ -121,8 +259,15 @@ func (ts *tableState) migrate(tx *Tx) {
 				log.Printf("Still re-indexing %s, so far updated %d rows in %d ms", tbl.Name(), rows, time.Since(start).Milliseconds())
 			}
 		}
+		if err == nil {
+			err = c.Err()
+		}
+		if err != nil {
+			// stopped because the context got canceled; don't mark the indices built
+			panic(fmt.Errorf("%s: re-indexing stopped after %d rows: %w", tbl.Name(), rows, err))
+		}
 		for _, is := range ts.Indices {
-			is.Built = true
+			is.Built, is.rebuild = true, false
 		}
 		dur := time.Since(start).Milliseconds()
 		if dur > 1 {
@@ -153,13 +298,23 @@ func (ts *tableState) save(tx *Tx) {
 	ensure(tableRootB.Put(tableStateKey, rawTS))
 }
 
-func dropDeletedIndex(tbl *Table, tableRootB *bbolt.Bucket, name string) {
-	err := tableRootB.DeleteBucket(makeIndexBucketName(name).Raw())
-	if err == bbolt.ErrBucketNotFound {
-		return
+func dropDeletedIndex(tbl *Table, tableRootB *bbolt.Bucket, name string, shards int) {
+	if dropIndexBuckets(tableRootB, name, shards) {
+		log.Printf("deleted index %s.%s", tbl.Name(), name)
+	}
+}
+
+func dropIndexBuckets(tableRootB *bbolt.Bucket, name string, shards int) bool {
+	var found bool
+	for shard := range shards {
+		err := tableRootB.DeleteBucket(makeIndexShardBucketName(name, shard).Raw())
+		if err == bbolt.ErrBucketNotFound {
+			continue
+		}
+		ensure(err)
+		found = true
 	}
-	ensure(err)
-	log.Printf("deleted index %s.%s", tbl.Name(), name)
+	return found
 }
 
 func prepareMap(tx *Tx, mp *KVMap) {
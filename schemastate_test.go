@@ -0,0 +1,35 @@
+package edb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func sampleTableState(indices int) *tableState {
+	ts := &tableState{
+		MinSchemaVer:     3,
+		LastIndexOrdinal: uint64(indices),
+		Indices:          make(map[string]*indexState),
+		LastSeen:         time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		DeletionCounter:  42,
+	}
+	for i := 1; i <= indices; i++ {
+		ts.Indices[fmt.Sprintf("by_field_%d", i)] = &indexState{IndexOrdinal: uint64(i), Built: true}
+	}
+	return ts
+}
+
+func BenchmarkTableStateDecode(b *testing.B) {
+	raw := tableStateEncoding.EncodeValue(nil, reflect.ValueOf(sampleTableState(5)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ts := new(tableState)
+		err := tableStateEncoding.DecodeValue(raw, reflect.ValueOf(ts))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
@@ -28,10 +29,30 @@ type Table struct {
 	zeroKey         []byte
 	migrator        func(tx *Tx, row any, oldVer uint64)
 	suppressContent bool
+	autoKey         bool
+	compressValues  bool
+	expireAfter     func(row any) time.Time
+	hideExpired     bool
+	changeFlags     ChangeFlags
+	fieldRenames    []fieldRename    // ordered by sinceVer
+	fieldRenameSets []fieldRenameSet // computed from fieldRenames by DefineTable
 
 	TaggableImpl
 }
 
+type fieldRename struct {
+	oldName  string
+	newName  string
+	sinceVer uint64
+}
+
+// fieldRenameSet holds the renames for rows stored below belowVer, and at
+// or above belowVer of the previous set.
+type fieldRenameSet struct {
+	belowVer uint64
+	renames  map[string]string
+}
+
 func (tbl *Table) Name() string {
 	return tbl.name
 }
@@ -40,6 +61,7 @@ type tableOpt int
 
 const (
 	SuppressContentWhenLogging = tableOpt(1)
+	CompressValues             = tableOpt(2)
 )
 
 func AddTable[Row any](scm *Schema, name string, latestSchemaVer uint64, indexer func(row *Row, ib *IndexBuilder), migrator func(tx *Tx, row *Row, oldVer uint64), indices []*Index, opts ...any) *Table {
@@ -57,8 +79,11 @@ func AddTable[Row any](scm *Schema, name string, latestSchemaVer uint64, indexer
 		for _, opt := range opts {
 			switch opt := opt.(type) {
 			case tableOpt:
-				if opt == SuppressContentWhenLogging {
+				switch opt {
+				case SuppressContentWhenLogging:
 					b.SuppressContentWhenLogging()
+				case CompressValues:
+					b.CompressValues()
 				}
 			case *Tag:
 				b.Tag(opt)
@@ -102,13 +127,20 @@ func (tbl *Table) dataBucketIn(tableRootB *bbolt.Bucket) *bbolt.Bucket {
 }
 
 func (tbl *Table) ensureCorrectKeyType(keyVal reflect.Value) reflect.Value {
+	return must(tbl.checkKeyType(keyVal))
+}
+
+func (tbl *Table) checkKeyType(keyVal reflect.Value) (reflect.Value, error) {
+	if !keyVal.IsValid() {
+		return reflect.Value{}, fmt.Errorf("%s: key must be %v, got nil: %w", tbl.name, tbl.keyType, ErrWrongKeyType)
+	}
 	if keyVal.Type() != tbl.keyType {
 		if keyVal.CanConvert(tbl.keyType) {
-			return keyVal.Convert(tbl.keyType)
+			return keyVal.Convert(tbl.keyType), nil
 		}
-		panic(fmt.Errorf("%s: key must be %v, got %v %v", tbl.name, tbl.keyType, keyVal.Type(), keyVal.Interface()))
+		return reflect.Value{}, fmt.Errorf("%s: key must be %v, got %v %v: %w", tbl.name, tbl.keyType, keyVal.Type(), keyVal.Interface(), ErrWrongKeyType)
 	}
-	return keyVal
+	return keyVal, nil
 }
 
 func (tbl *Table) RowKeyVal(rowVal reflect.Value) reflect.Value {
@@ -149,6 +181,27 @@ func (tbl *Table) RowValHasZeroKey(rowVal reflect.Value) bool {
 	return tbl.RowKeyVal(rowVal).IsZero()
 }
 
+// valueFlags returns the flags to use when writing new values to the table.
+func (tbl *Table) valueFlags() valueFlags {
+	if tbl.valueEnc == JSON {
+		return vfDefault | vfJSON
+	}
+	return vfDefault
+}
+
+// rowExpiry returns the expiry timestamp to store for the row, in Unix
+// milliseconds, and whether the row expires at all.
+func (tbl *Table) rowExpiry(row any) (uint64, bool) {
+	if tbl.expireAfter == nil {
+		return 0, false
+	}
+	t := tbl.expireAfter(row)
+	if t.IsZero() {
+		return 0, false
+	}
+	return unixMilli(t), true
+}
+
 func (tbl *Table) encodeRowVal(buf []byte, rowVal reflect.Value) []byte {
 	return tbl.valueEnc.EncodeValue(buf, rowVal)
 }
@@ -181,7 +234,12 @@ func (tbl *Table) KeyString(key any) string {
 }
 
 func (tbl *Table) parseRawKeyFrom(buf []byte, s string) ([]byte, error) {
-	return tbl.keyEnc.stringsToRawKey(buf, strings.Split(s, tbl.keyStringSep))
+	strs := strings.Split(s, tbl.keyStringSep)
+	if n := len(tbl.keyEnc.components); len(strs) != n {
+		// a component containing the separator would otherwise shift the rest
+		return nil, fmt.Errorf("%s key %q: got %d components separated by %q, wanted %d (components must not contain the separator)", tbl.name, s, len(strs), tbl.keyStringSep, n)
+	}
+	return tbl.keyEnc.stringsToRawKey(buf, strs)
 }
 
 func (tbl *Table) ParseKeyVal(s string) (reflect.Value, error) {
@@ -209,6 +267,49 @@ func (tbl *Table) NewRow() any {
 	return tbl.NewRowVal().Interface()
 }
 
+// fieldRenamesAt returns the field renames (old name to final name) to apply
+// to rows stored at the given schema version, or nil if there are none.
+func (tbl *Table) fieldRenamesAt(ver uint64) map[string]string {
+	for _, set := range tbl.fieldRenameSets {
+		if ver < set.belowVer {
+			return set.renames
+		}
+	}
+	return nil
+}
+
+// prepareFieldRenames precomputes the rename maps returned by fieldRenamesAt,
+// one per distinct sinceVer.
+func (tbl *Table) prepareFieldRenames() {
+	tbl.fieldRenameSets = nil
+	for _, r := range tbl.fieldRenames {
+		n := len(tbl.fieldRenameSets)
+		if r.sinceVer == 0 || (n > 0 && tbl.fieldRenameSets[n-1].belowVer == r.sinceVer) {
+			continue
+		}
+		tbl.fieldRenameSets = append(tbl.fieldRenameSets, fieldRenameSet{r.sinceVer, tbl.computeFieldRenames(r.sinceVer - 1)})
+	}
+}
+
+func (tbl *Table) computeFieldRenames(ver uint64) map[string]string {
+	var m map[string]string
+	for _, r := range tbl.fieldRenames {
+		if ver >= r.sinceVer {
+			continue
+		}
+		if m == nil {
+			m = make(map[string]string)
+		}
+		for k, v := range m {
+			if v == r.oldName {
+				m[k] = r.newName
+			}
+		}
+		m[r.oldName] = r.newName
+	}
+	return m
+}
+
 func (tbl *Table) decodeRow(buf []byte) (reflect.Value, error) {
 	rowVal := reflect.New(tbl.rowType)
 	err := tbl.valueEnc.DecodeValue(buf, rowVal)
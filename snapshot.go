@@ -0,0 +1,105 @@
+package edb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Snapshot is a read-only view of the database as of the time it was
+// opened, backed by a Bolt read transaction. It implements Txish, so the
+// generic helpers (Get, Lookup, TableScan and friends) accept it, and scans
+// observe its context.
+//
+// A snapshot can be handed over to other goroutines, but must not be used
+// by several goroutines at once. Once its context is done, scans stop with
+// the context error, but the snapshot stays open (so that a scan in progress
+// never reads released pages) until the owner calls Close. DBTx panics with
+// ErrSnapshotClosed afterwards.
+type Snapshot struct {
+	tx     *Tx
+	ctx    context.Context
+	done   chan struct{}
+	warn   *time.Timer
+	mu     sync.Mutex
+	closed bool
+}
+
+// Snapshot opens a read-only snapshot that stays open until Close is
+// called. Fails if ctx is already done.
+func (db *DB) Snapshot(ctx context.Context) (*Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if db.IsClosed() {
+		panic("database closed")
+	}
+	btx, err := db.bdb.Begin(false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start reading: %w", err)
+	}
+	var stack []byte
+	if db.snapshotWarnAfter > 0 || db.trackTxns {
+		stack = debug.Stack()
+	}
+
+	s := &Snapshot{
+		tx:   db.newTx(btx, false, nil, stack),
+		ctx:  ctx,
+		done: make(chan struct{}),
+	}
+	s.tx.SetContext(ctx)
+
+	if db.snapshotWarnAfter > 0 {
+		s.warn = time.AfterFunc(db.snapshotWarnAfter, func() {
+			logf := db.logf
+			if logf == nil {
+				logf = log.Printf
+			}
+			logf("** WARNING: db: snapshot open for over %v, opened at:\n%s", db.snapshotWarnAfter, stack)
+		})
+	}
+	return s, nil
+}
+
+// DBTx returns the read transaction behind the snapshot, panicking with
+// ErrSnapshotClosed if the snapshot has been closed.
+func (s *Snapshot) DBTx() *Tx {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		panic(ErrSnapshotClosed)
+	}
+	return s.tx
+}
+
+// Context returns the context the snapshot was opened with.
+func (s *Snapshot) Context() context.Context {
+	return s.ctx
+}
+
+// Done returns a channel that's closed once the snapshot is closed.
+func (s *Snapshot) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the read transaction. It must not be called while the snapshot
+// is in use, but it's safe to call Close multiple times.
+func (s *Snapshot) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.warn != nil {
+		s.warn.Stop()
+	}
+	s.tx.Close()
+	close(s.done)
+}
+
+var _ Txish = (*Snapshot)(nil)
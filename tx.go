@@ -1,8 +1,11 @@
 package edb
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"log/slog"
+	"runtime"
 	"runtime/debug"
 	"sync/atomic"
 	"time"
@@ -19,6 +22,31 @@ type Txish interface {
 	DBTx() *Tx
 }
 
+// TxContext returns the context of txh: the result of its Context method
+// if it has one (like Tx and the result of WithContext), or
+// context.Background otherwise. Scans started via the generic functions
+// (TableScan, IndexScan and friends) stop once this context is canceled.
+func TxContext(txh Txish) context.Context {
+	if c, ok := txh.(interface{ Context() context.Context }); ok {
+		return c.Context()
+	}
+	return txh.DBTx().Context()
+}
+
+// WithContext returns a Txish for the same transaction that carries ctx,
+// so that helpers receiving it can observe cancellation.
+func WithContext(txh Txish, ctx context.Context) Txish {
+	return contextTx{txh.DBTx(), ctx}
+}
+
+type contextTx struct {
+	tx  *Tx
+	ctx context.Context
+}
+
+func (ct contextTx) DBTx() *Tx                { return ct.tx }
+func (ct contextTx) Context() context.Context { return ct.ctx }
+
 type Tx struct {
 	db        *DB
 	btx       *bbolt.Tx
@@ -26,12 +54,15 @@ type Tx struct {
 	closed    bool
 	startTime time.Time
 	stack     []byte
+	tracked   *openTx
 	verbosity int
 	logger    *slog.Logger
 
 	written          bool
 	commitDespiteErr bool
 	reindexing       bool
+	usedSize         int64 // -1 until reserveSpace needs it
+	pendingSize      int64
 
 	memo map[string]any
 
@@ -39,21 +70,32 @@ type Tx struct {
 	valueBufs      [][]byte
 	indexValueBufs [][]byte
 
-	changeHandler func(tx *Tx, chg *Change)
-	changeOptions map[*Table]ChangeFlags
+	ctx context.Context
+
+	changeHandlers []changeHandler
+	changeBuf      []Change
+	afterCommit    []func()
+
+	caches         []*Cache
+	cacheEvictions []cacheKey
+
+	savepoints []*savepoint
+
+	timeOps   bool
+	opTimings []OpTiming
 }
 
 func (db *DB) newTx(btx *bbolt.Tx, managed bool, memo map[string]any, stack []byte) *Tx {
 	if db.IsClosed() {
 		panic("database closed")
 	}
-	db.lastSize.Store(btx.Size())
+	db.updateSize(btx.Size())
 	if btx.Writable() {
 		WriterCount.Add(1)
 	} else {
 		ReaderCount.Add(1)
 	}
-	if debugTrackTxns && stack == nil {
+	if (db.trackTxns || (db.detectTxLeaks && !managed)) && stack == nil {
 		stack = debug.Stack()
 	}
 	tx := &Tx{
@@ -61,19 +103,44 @@ func (db *DB) newTx(btx *bbolt.Tx, managed bool, memo map[string]any, stack []by
 		btx:       btx,
 		managed:   managed,
 		memo:      memo,
-		startTime: time.Now(),
+		startTime: db.now(),
 		stack:     stack,
 		logger:    slog.Default(),
+		usedSize:  -1,
+	}
+	if btx.Writable() {
+		db.cachesLock.Lock()
+		tx.caches = db.caches
+		db.cachesLock.Unlock()
 	}
 	if db.verbose {
 		tx.verbosity = 1
 	}
-	if debugTrackTxns {
-		db.addTx(tx)
+	if db.trackTxns {
+		tx.tracked = &openTx{startTime: tx.startTime, stack: stack}
+		db.addTx(tx.tracked)
+	}
+	if db.detectTxLeaks && !managed {
+		runtime.SetFinalizer(tx, (*Tx).reportLeak)
 	}
 	return tx
 }
 
+func (tx *Tx) reportLeak() {
+	if tx.closed {
+		return
+	}
+	kind := "read"
+	if tx.btx.Writable() {
+		kind = "write"
+	}
+	logf := tx.db.logf
+	if logf == nil {
+		logf = log.Printf
+	}
+	logf("** ERROR: db: leaked %s transaction was never closed, started %s at:\n%s", kind, tx.startTime.Format("2006-01-02 15:04:05.000"), tx.stack)
+}
+
 // DBTx implements Txish
 func (tx *Tx) DBTx() *Tx {
 	return tx
@@ -86,6 +153,20 @@ func (tx *Tx) DB() *DB {
 	return tx.db
 }
 
+// Context returns the context set by SetContext, or context.Background.
+func (tx *Tx) Context() context.Context {
+	if tx.ctx == nil {
+		return context.Background()
+	}
+	return tx.ctx
+}
+
+// SetContext sets the context of the transaction. Scans stop once it is
+// canceled, with their Err returning the context error.
+func (tx *Tx) SetContext(ctx context.Context) {
+	tx.ctx = ctx
+}
+
 func (tx *Tx) SetLogger(logger *slog.Logger) {
 	tx.logger = logger
 }
@@ -114,9 +195,32 @@ func (tx *Tx) Schema() *Schema {
 	return tx.db.schema
 }
 
+// OnChange adds a change handler for this transaction. Options.OnChange and
+// any handlers added earlier keep receiving their changes. The given per-table
+// flags are merged with the defaults declared via TableBuilder.NotifyChanges,
+// and only apply to f.
 func (tx *Tx) OnChange(opts map[*Table]ChangeFlags, f func(tx *Tx, chg *Change)) {
-	tx.changeOptions = opts
-	tx.changeHandler = f
+	tx.changeHandlers = append(tx.changeHandlers, changeHandler{opts, f})
+}
+
+// AfterCommit registers f to be called once the transaction has been
+// committed, outside of it, which makes it a safe place for side effects like
+// publishing to a queue. Callbacks run in registration order, and don't run
+// at all if the transaction is rolled back. When DB.Tx retries the function,
+// only the callbacks registered by the final attempt are called.
+func (tx *Tx) AfterCommit(f func()) {
+	if !tx.IsWritable() {
+		panic("AfterCommit requires a writable transaction")
+	}
+	tx.afterCommit = append(tx.afterCommit, f)
+}
+
+func (tx *Tx) runAfterCommit() {
+	callbacks := tx.afterCommit
+	tx.afterCommit = nil
+	for _, f := range callbacks {
+		f()
+	}
 }
 
 // Tx currently implements Check-Mutate phases for writable transactions:
@@ -148,14 +252,14 @@ func (db *DB) Tx(writable bool, f func(tx *Tx) error) error {
 	if writable {
 		var funcErr error
 		var tx *Tx
-		// var calls int
+		var calls int
 		var memo map[string]any
 		// debug.PrintStack()
 		// log.Printf("Tx.BATCH.BEGIN")
 		pending := true
 		db.PendingWriterCount.Add(1)
 		var stack []byte
-		if debugTrackTxns {
+		if db.trackTxns {
 			stack = debug.Stack()
 		}
 		err := db.bdb.Batch(func(btx *bbolt.Tx) error {
@@ -163,13 +267,16 @@ func (db *DB) Tx(writable bool, f func(tx *Tx) error) error {
 				pending = false
 				db.PendingWriterCount.Add(-1)
 			}
+			calls++
 
 			if funcErr != nil {
 				// don't retry failed transactions
 				return funcErr
 			}
+			if calls > 1 {
+				db.BatchRetries.Add(1)
+			}
 
-			// calls++
 			// if calls > 1 {
 			// 	log.Printf("Tx.REPEAT: calls = %d, memo = %v, prev err = %v", calls, memo, funcErr)
 			// } else {
@@ -188,6 +295,9 @@ func (db *DB) Tx(writable bool, f func(tx *Tx) error) error {
 		})
 		// log.Printf("Tx.BATCH.END")
 		tx.Close()
+		if err == nil && (funcErr == nil || tx.commitDespiteErr) {
+			tx.runAfterCommit()
+		}
 		if err == nil && funcErr != nil {
 			err = funcErr
 		}
@@ -201,6 +311,22 @@ func (db *DB) Tx(writable bool, f func(tx *Tx) error) error {
 	}
 }
 
+// TxContext is like Tx, but sets ctx as the context of the transaction
+// (see Tx.SetContext), so that scans, Reindex, ReindexWithProgress, bulk
+// deletions like DeleteAll and the re-indexing done by schema migration stop
+// once ctx is canceled. Fails with ctx.Err() without running f if ctx is already
+// done. A write transaction is rolled back if f returns an error after
+// writing, including a cancellation error.
+func (db *DB) TxContext(ctx context.Context, writable bool, f func(tx *Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.Tx(writable, func(tx *Tx) error {
+		tx.SetContext(ctx)
+		return f(tx)
+	})
+}
+
 type panicked struct {
 	reason interface{}
 	stack  string
@@ -210,6 +336,13 @@ func (p panicked) Error() string {
 	return fmt.Sprintf("panic: %v\n\n%s", p.reason, p.stack)
 }
 
+// Unwrap returns the panic value if it is an error, so that errors.Is can
+// see through panics like the ones raised on context cancellation.
+func (p panicked) Unwrap() error {
+	err, _ := p.reason.(error)
+	return err
+}
+
 func safelyCall(fn func(*Tx) error, tx *Tx) (err error) {
 	defer func() {
 		if p := recover(); p != nil {
@@ -304,8 +437,11 @@ func (tx *Tx) Close() {
 		return
 	}
 	tx.closed = true
-	if debugTrackTxns {
-		tx.db.removeTx(tx)
+	if tx.db.detectTxLeaks && !tx.managed {
+		runtime.SetFinalizer(tx, nil)
+	}
+	if tx.tracked != nil {
+		tx.db.removeTx(tx.tracked)
 	}
 	if tx.btx.Writable() {
 		WriterCount.Add(-1)
@@ -356,7 +492,31 @@ func (tx *Tx) release() {
 }
 
 func (tx *Tx) Commit() error {
-	return tx.btx.Commit()
+	err := tx.btx.Commit()
+	if err == nil {
+		tx.runAfterCommit()
+	}
+	return err
+}
+
+// reserveSpace accounts for n bytes about to be written, returning
+// an ErrDatabaseFull error if that would exceed Options.MaxSize.
+func (tx *Tx) reserveSpace(n int) error {
+	maxSize := tx.db.maxSize
+	if maxSize <= 0 {
+		return nil
+	}
+	if tx.usedSize < 0 {
+		st := tx.db.bdb.Stats()
+		free := int64(st.FreePageN+st.PendingPageN) * int64(tx.db.bdb.Info().PageSize)
+		tx.usedSize = max(tx.btx.Size()-free, 0)
+	}
+	size := tx.usedSize + tx.pendingSize + int64(n)
+	if size > maxSize {
+		return fmt.Errorf("writing %d bytes would bring database usage to %d bytes, exceeding max size %d: %w", n, size, maxSize, ErrDatabaseFull)
+	}
+	tx.pendingSize += int64(n)
+	return nil
 }
 
 func (tx *Tx) GetMemo(key string) (any, bool) {
@@ -1,7 +1,6 @@
 package edb
 
 import (
-	"bytes"
 	"encoding/hex"
 	"log/slog"
 	"strings"
@@ -61,61 +60,31 @@ func boltSeek(c *bbolt.Cursor, prefix []byte, reverse bool) ([]byte, []byte) {
 	}
 }
 
+// boltSeekLast positions the cursor on the last key that starts with prefix,
+// or, if there are no such keys, on the last key sorting before prefix.
 func boltSeekLast(c *bbolt.Cursor, prefix []byte) ([]byte, []byte) {
-	// NOTE: this could be made much faster by incrementing the prefix temporarily, but then we'd need to deal with overflow
-	k, _ := c.Seek(prefix)
-	if k == nil {
-		return c.Last()
-	}
-	for k != nil && bytes.HasPrefix(k, prefix) {
-		k, _ = c.Next()
+	// The smallest key sorting after all keys with the prefix is the prefix
+	// without its trailing 0xFF bytes and with the last remaining byte
+	// incremented. For example, 01 FF 05 sorts before 02, but after 02 00,
+	// which is why simply incrementing the prefix with carry doesn't work.
+	i := len(prefix) - 1
+	for i >= 0 && prefix[i] == 0xFF {
+		i--
 	}
-	if k == nil {
+	if i < 0 {
+		// An all-0xFF prefix cannot be incremented, but keys with such
+		// a prefix sort after all other keys, so the last key is the answer.
 		return c.Last()
-	} else {
-		return c.Prev()
 	}
-}
+	bound := make([]byte, i+1)
+	copy(bound, prefix)
+	bound[i]++
 
-func boltSeekLast2(c *bbolt.Cursor, prefix []byte) ([]byte, []byte) {
-	if inc(prefix) {
-		//slog.Debug("actually seeking to", "prefix", prefix)
-		k, _ := c.Seek(prefix)
-		dec(prefix)
-		if k == nil {
-			return c.Last()
-		} else {
-			return c.Prev()
-		}
-	} else {
-		return boltSeekLast(c, prefix)
-	}
-}
-
-func inc(data []byte) bool {
-	n := len(data)
-	for i := n - 1; i >= 0; i-- {
-		if data[i] != 0xFF {
-			for j := i; j < n; j++ {
-				data[j]++
-			}
-			return true
-		}
-	}
-	return false
-}
-
-func dec(data []byte) bool {
-	n := len(data)
-	for i := n - 1; i >= 0; i-- {
-		if data[i] != 0 {
-			for j := i; j < n; j++ {
-				data[j]--
-			}
-			return true
-		}
+	k, _ := c.Seek(bound)
+	if k == nil {
+		return c.Last()
 	}
-	return false
+	return c.Prev()
 }
 
 func boltFirstLast(c *bbolt.Cursor, reverse bool) ([]byte, []byte) {
@@ -153,12 +122,3 @@ func hexstr(b []byte) string {
 func hexAttr(key string, b []byte) slog.Attr {
 	return slog.String(key, hexstr(b))
 }
-
-func containsBytes(list [][]byte, item []byte) bool {
-	for _, b := range list {
-		if bytes.Equal(b, item) {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,174 @@
+package edb
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// ZOrder2D returns the Morton code (Z-order curve value) of the point (x, y),
+// interleaving the bits of x (even bit positions) and y (odd bit positions).
+//
+// Points that are close in 2D tend to have close Morton codes, so an index
+// keyed by ZOrder2D turns bounding box queries into a handful of 1D range
+// scans. To use it, define an index with a uint64 key:
+//
+//	placesByZ = edb.AddIndex[uint64]("z")
+//
+//	func (p *Place) IndexRows(b *edb.IndexBuilder) {
+//		b.Add(placesByZ, edb.ZOrder2D(p.X, p.Y))
+//	}
+//
+// and query it via ZOrderRangeScan. Signed or floating-point coordinates
+// need to be mapped onto uint32 preserving order first.
+func ZOrder2D(x, y uint32) uint64 {
+	return zorderSpread(x) | zorderSpread(y)<<1
+}
+
+// ZOrderUnpack2D is the inverse of ZOrder2D.
+func ZOrderUnpack2D(z uint64) (x, y uint32) {
+	return zorderCompact(z), zorderCompact(z >> 1)
+}
+
+func zorderSpread(v uint32) uint64 {
+	z := uint64(v)
+	z = (z | z<<16) & 0x0000ffff0000ffff
+	z = (z | z<<8) & 0x00ff00ff00ff00ff
+	z = (z | z<<4) & 0x0f0f0f0f0f0f0f0f
+	z = (z | z<<2) & 0x3333333333333333
+	z = (z | z<<1) & 0x5555555555555555
+	return z
+}
+
+func zorderCompact(z uint64) uint32 {
+	z &= 0x5555555555555555
+	z = (z | z>>1) & 0x3333333333333333
+	z = (z | z>>2) & 0x0f0f0f0f0f0f0f0f
+	z = (z | z>>4) & 0x00ff00ff00ff00ff
+	z = (z | z>>8) & 0x0000ffff0000ffff
+	z = (z | z>>16) & 0x00000000ffffffff
+	return uint32(z)
+}
+
+// ZRange is an inclusive range of Morton codes.
+type ZRange struct {
+	Lower, Upper uint64
+}
+
+// ZOrderMaxRanges is the maximum number of ranges returned by ZOrderRanges.
+const ZOrderMaxRanges = 256
+
+// ZOrderRanges decomposes the bounding box [minX, maxX] × [minY, maxY]
+// (inclusive) into contiguous Morton code ranges that cover every point
+// inside the box, in ascending order. It is ZOrderRangesLimit with
+// ZOrderMaxRanges.
+func ZOrderRanges(minX, minY, maxX, maxY uint32) []ZRange {
+	return ZOrderRangesLimit(minX, minY, maxX, maxY, ZOrderMaxRanges)
+}
+
+// ZOrderRangesLimit decomposes the bounding box [minX, maxX] × [minY, maxY]
+// (inclusive) into at most maxRanges contiguous Morton code ranges that
+// cover every point inside the box, in ascending order.
+//
+// The exact decomposition grows with the perimeter of the box, and a thin
+// or diagonal box over the full 32-bit space can need millions of ranges.
+// When the exact set would not fit, ranges are bridged across the smallest
+// gaps, and the result also covers some points outside the box; callers
+// must filter those out by coordinates.
+func ZOrderRangesLimit(minX, minY, maxX, maxY uint32, maxRanges int) []ZRange {
+	if minX > maxX || minY > maxY {
+		return nil
+	}
+	maxRanges = max(maxRanges, 1)
+	bx0, by0, bx1, by1 := uint64(minX), uint64(minY), uint64(maxX), uint64(maxY)
+
+	// Refine level by level, and stop splitting partially covered quadrants
+	// once there are too many of them, which bounds the work and memory.
+	var ranges []ZRange
+	quads := []zorderQuad{{0, 0, 32}}
+	for len(quads) > 0 {
+		refine := len(quads) <= 4*maxRanges
+		var next []zorderQuad
+		for _, q := range quads {
+			side := uint64(1)<<q.level - 1
+			x1, y1 := q.x0+side, q.y0+side
+			if x1 < bx0 || q.x0 > bx1 || y1 < by0 || q.y0 > by1 {
+				continue
+			}
+			inside := q.x0 >= bx0 && x1 <= bx1 && q.y0 >= by0 && y1 <= by1
+			if !inside && refine {
+				level := q.level - 1
+				half := uint64(1) << level
+				next = append(next, zorderQuad{q.x0, q.y0, level}, zorderQuad{q.x0 + half, q.y0, level}, zorderQuad{q.x0, q.y0 + half, level}, zorderQuad{q.x0 + half, q.y0 + half, level})
+				continue
+			}
+			lower := ZOrder2D(uint32(q.x0), uint32(q.y0))
+			ranges = append(ranges, ZRange{lower, lower + (uint64(1)<<(2*q.level) - 1)})
+		}
+		quads = next
+	}
+
+	slices.SortFunc(ranges, func(a, b ZRange) int { return cmp.Compare(a.Lower, b.Lower) })
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && merged[n-1].Upper+1 == r.Lower {
+			merged[n-1].Upper = r.Upper
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	ranges = merged
+	if len(ranges) <= maxRanges {
+		return ranges
+	}
+
+	// Keep the maxRanges-1 largest gaps and bridge all the others.
+	gaps := make([]int, len(ranges)-1)
+	for i := range gaps {
+		gaps[i] = i
+	}
+	gapSize := func(i int) uint64 { return ranges[i+1].Lower - ranges[i].Upper }
+	slices.SortFunc(gaps, func(a, b int) int { return cmp.Compare(gapSize(b), gapSize(a)) })
+	kept := gaps[:maxRanges-1]
+	slices.Sort(kept)
+	result := make([]ZRange, 0, maxRanges)
+	lower := ranges[0].Lower
+	for _, i := range kept {
+		result = append(result, ZRange{lower, ranges[i].Upper})
+		lower = ranges[i+1].Lower
+	}
+	return append(result, ZRange{lower, ranges[len(ranges)-1].Upper})
+}
+
+type zorderQuad struct {
+	x0, y0 uint64
+	level  uint // side is 2^level
+}
+
+// ZOrderRangeScan runs a range scan of idx for each range returned by
+// ZOrderRanges, calling f for every row inside the bounding box until
+// f returns false. Rows whose index key falls outside the box (which
+// happens when the ranges had to be capped) are skipped. The key type of idx must have uint64 as its underlying
+// type, and index keys must be computed via ZOrder2D.
+//
+// Rows come out ordered by Morton code, not by X or Y.
+func ZOrderRangeScan[Row any](txh Txish, idx *Index, minX, minY, maxX, maxY uint32, f func(row *Row) bool) {
+	kt := idx.keyType()
+	if kt.Kind() != reflect.Uint64 {
+		panic(fmt.Errorf("%s: Z-order scan requires uint64 index key, got %v: %w", idx.FullName(), kt, ErrWrongKeyType))
+	}
+	for _, r := range ZOrderRanges(minX, minY, maxX, maxY) {
+		lower := reflect.ValueOf(r.Lower).Convert(kt)
+		upper := reflect.ValueOf(r.Upper).Convert(kt)
+		for c := IndexScan[Row](txh, idx, RangeScanVal(lower, upper, true, true)); c.Next(); {
+			z := reflect.ValueOf(c.RawCursor.(*RawIndexCursor).IndexKey()).Uint()
+			if x, y := ZOrderUnpack2D(z); x < minX || x > maxX || y < minY || y > maxY {
+				continue
+			}
+			if !f(c.Row()) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package edb
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestZOrder2D(t *testing.T) {
+	tests := []struct {
+		x, y     uint32
+		expected uint64
+	}{
+		{0, 0, 0},
+		{1, 0, 1},
+		{0, 1, 2},
+		{1, 1, 3},
+		{2, 0, 4},
+		{3, 3, 15},
+		{math.MaxUint32, 0, 0x5555555555555555},
+		{0, math.MaxUint32, 0xaaaaaaaaaaaaaaaa},
+		{math.MaxUint32, math.MaxUint32, math.MaxUint64},
+	}
+	for _, tt := range tests {
+		z := ZOrder2D(tt.x, tt.y)
+		if z != tt.expected {
+			t.Errorf("ZOrder2D(%d, %d) = %x, wanted %x", tt.x, tt.y, z, tt.expected)
+		}
+		x, y := ZOrderUnpack2D(z)
+		if x != tt.x || y != tt.y {
+			t.Errorf("ZOrderUnpack2D(%x) = %d, %d, wanted %d, %d", z, x, y, tt.x, tt.y)
+		}
+	}
+}
+
+func TestZOrderRanges(t *testing.T) {
+	deepEqual(t, ZOrderRanges(0, 0, 1, 1), []ZRange{{0, 3}})
+	deepEqual(t, ZOrderRanges(0, 0, 3, 1), []ZRange{{0, 7}})
+	deepEqual(t, ZOrderRanges(1, 0, 2, 0), []ZRange{{1, 1}, {4, 4}})
+	deepEqual(t, ZOrderRanges(0, 0, math.MaxUint32, math.MaxUint32), []ZRange{{0, math.MaxUint64}})
+	deepEqual(t, ZOrderRanges(5, 5, 5, 5), []ZRange{{ZOrder2D(5, 5), ZOrder2D(5, 5)}})
+	isempty(t, ZOrderRanges(2, 0, 1, 0))
+
+	const n = 16
+	boxes := [][4]uint32{{0, 0, 15, 15}, {3, 5, 9, 6}, {1, 1, 14, 14}, {7, 0, 8, 15}, {0, 7, 15, 8}, {5, 3, 5, 12}}
+	for _, box := range boxes {
+		ranges := ZOrderRanges(box[0], box[1], box[2], box[3])
+		var actual, expected []uint64
+		for i, r := range ranges {
+			if i > 0 && r.Lower <= ranges[i-1].Upper+1 {
+				t.Errorf("%v: ranges %v and %v overlap or are adjacent", box, ranges[i-1], r)
+			}
+			for z := r.Lower; z <= r.Upper; z++ {
+				actual = append(actual, z)
+			}
+		}
+		for x := uint32(0); x < n; x++ {
+			for y := uint32(0); y < n; y++ {
+				if x >= box[0] && x <= box[2] && y >= box[1] && y <= box[3] {
+					expected = append(expected, ZOrder2D(x, y))
+				}
+			}
+		}
+		slices.Sort(expected)
+		deepEqual(t, actual, expected)
+	}
+
+	covered := func(ranges []ZRange, x, y uint32) bool {
+		z := ZOrder2D(x, y)
+		i, _ := slices.BinarySearchFunc(ranges, z, func(r ZRange, z uint64) int { return cmp.Compare(r.Upper, z) })
+		return i < len(ranges) && ranges[i].Lower <= z
+	}
+	for _, box := range [][4]uint32{{0, 1000, math.MaxUint32, 1000}, {12345, 0, 12345, math.MaxUint32}, {1, 1, math.MaxUint32 - 1, math.MaxUint32 - 1}} {
+		ranges := ZOrderRanges(box[0], box[1], box[2], box[3])
+		if len(ranges) > ZOrderMaxRanges {
+			t.Errorf("%v: got %d ranges, wanted at most %d", box, len(ranges), ZOrderMaxRanges)
+		}
+		for i := uint32(0); i <= 1000; i++ {
+			x := box[0] + uint32(uint64(box[2]-box[0])*uint64(i)/1000)
+			y := box[1] + uint32(uint64(box[3]-box[1])*uint64(i)/1000)
+			if !covered(ranges, x, y) {
+				t.Fatalf("%v: point (%d, %d) not covered", box, x, y)
+			}
+		}
+	}
+
+	for _, box := range boxes {
+		for _, limit := range []int{1, 2, 3, 5} {
+			ranges := ZOrderRangesLimit(box[0], box[1], box[2], box[3], limit)
+			if len(ranges) > limit {
+				t.Errorf("%v: got %d ranges, wanted at most %d", box, len(ranges), limit)
+			}
+			for x := box[0]; x <= box[2]; x++ {
+				for y := box[1]; y <= box[3]; y++ {
+					if !covered(ranges, x, y) {
+						t.Errorf("%v: point (%d, %d) not covered by %v", box, x, y, ranges)
+					}
+				}
+			}
+		}
+	}
+	deepEqual(t, ZOrderRangesLimit(1, 0, 2, 0, 1), []ZRange{{1, 4}})
+}
+
+type Place struct {
+	ID ID     `msgpack:"-"`
+	X  uint32 `msgpack:"x"`
+	Y  uint32 `msgpack:"y"`
+}
+
+func TestZOrderRangeScan(t *testing.T) {
+	scm := &Schema{}
+	placesByZ := AddIndex[uint64]("z")
+	AddTable(scm, "places", 1, func(row *Place, ib *IndexBuilder) {
+		ib.Add(placesByZ, ZOrder2D(row.X, row.Y))
+	}, nil, []*Index{placesByZ})
+	db := setup(t, scm)
+
+	db.Write(func(tx *Tx) {
+		id := ID(1)
+		for x := uint32(0); x < 10; x++ {
+			for y := uint32(0); y < 10; y++ {
+				Put(tx, &Place{ID: id, X: x, Y: y})
+				id++
+			}
+		}
+	})
+	db.Read(func(tx *Tx) {
+		var found [][2]uint32
+		ZOrderRangeScan(tx, placesByZ, 2, 3, 4, 8, func(p *Place) bool {
+			found = append(found, [2]uint32{p.X, p.Y})
+			return true
+		})
+		deepEqual(t, len(found), 3*6)
+		for _, p := range found {
+			if p[0] < 2 || p[0] > 4 || p[1] < 3 || p[1] > 8 {
+				t.Errorf("point %v outside of the box", p)
+			}
+		}
+
+		found = nil
+		ZOrderRangeScan(tx, placesByZ, 0, 3, math.MaxUint32, 3, func(p *Place) bool {
+			found = append(found, [2]uint32{p.X, p.Y})
+			return true
+		})
+		deepEqual(t, len(found), 10)
+		for _, p := range found {
+			if p[1] != 3 {
+				t.Errorf("point %v outside of the box", p)
+			}
+		}
+
+		var count int
+		ZOrderRangeScan(tx, placesByZ, 0, 0, 9, 9, func(p *Place) bool {
+			count++
+			return count < 5
+		})
+		deepEqual(t, count, 5)
+	})
+}